@@ -2,6 +2,7 @@ package client_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
@@ -13,10 +14,14 @@ import (
 	"github.com/ipfs/boxo/bitswap/client/traceability"
 	testinstance "github.com/ipfs/boxo/bitswap/testinstance"
 	tn "github.com/ipfs/boxo/bitswap/testnet"
+	"github.com/ipfs/boxo/blockservice"
 	mockrouting "github.com/ipfs/boxo/routing/mock"
 	"github.com/ipfs/boxo/routing/providerquerymanager"
 	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
 	delay "github.com/ipfs/go-ipfs-delay"
 	"github.com/ipfs/go-test/random"
 	tu "github.com/libp2p/go-libp2p-testing/etc"
@@ -593,3 +598,181 @@ func TestDontHaveTimeoutConfig(t *testing.T) {
 	a.Exchange.Close()
 	bs.Close()
 }
+
+func TestWantlistPersistence(t *testing.T) {
+	vnet := getVirtualNetwork()
+	router := mockrouting.NewServer()
+	ig := testinstance.NewTestInstanceGenerator(vnet, router, nil, nil)
+	defer ig.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	blks := random.BlocksOfSize(2, blockSize)
+	a := ig.Next()
+	b := ig.Next()
+	wantlists := dssync.MutexWrap(ds.NewMapDatastore())
+
+	// Replace bitswap in the instance with one persisting its wantlists.
+	// Connect instances only after bitswap exists.
+	restart := func(inst *testinstance.Instance) {
+		inst.Exchange.Close()
+		inst.Exchange = bitswap.New(ctx, inst.Adapter, router.Client(inst.Identity), inst.Blockstore,
+			bitswap.ProviderSearchDelay(10*time.Millisecond),
+			bitswap.WithWantlistPersistence(wantlists))
+		testinstance.ConnectInstances([]testinstance.Instance{*inst, b})
+	}
+	restart(&a)
+
+	// Peer B only has the first block.
+	addBlock(t, ctx, b, blks[0])
+
+	ses := a.Exchange.NewSession(ctx)
+	out, err := ses.GetBlocks(ctx, []cid.Cid{blks[0].Cid(), blks[1].Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blk := <-out; blk.Cid() != blks[0].Cid() {
+		t.Fatal("got wrong block")
+	}
+	if err := a.Blockstore.Put(ctx, blks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the client writes the remaining want.
+	a.Exchange.Close()
+	results, err := wantlists.Query(ctx, query.Query{Prefix: "/bitswap/wantlist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 persisted wantlist, got %d", len(entries))
+	}
+	var stored struct {
+		Wants []cid.Cid `json:"wants"`
+	}
+	if err := json.Unmarshal(entries[0].Value, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Wants) != 1 || stored.Wants[0] != blks[1].Cid() {
+		t.Fatalf("expected only the missing block to be persisted, got %v", stored.Wants)
+	}
+
+	// Peer B gets the second block, and the restarted client fetches it
+	// without any new request.
+	addBlock(t, ctx, b, blks[1])
+	c := ig.Next()
+	restart(&c)
+	defer c.Exchange.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		has, err := c.Blockstore.Has(ctx, blks[1].Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("resumed wantlist was not fetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWantlistPersistenceRestartBeforeFetch(t *testing.T) {
+	vnet := getVirtualNetwork()
+	router := mockrouting.NewServer()
+	ig := testinstance.NewTestInstanceGenerator(vnet, router, nil, nil)
+	defer ig.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	blk := random.BlocksOfSize(1, blockSize)[0]
+	wantlists := dssync.MutexWrap(ds.NewMapDatastore())
+	storedWantlists := func() int {
+		results, err := wantlists.Query(ctx, query.Query{Prefix: "/bitswap/wantlist"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := results.Rest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	inst := ig.Next()
+	inst.Exchange.Close()
+	inst.Exchange = bitswap.New(ctx, inst.Adapter, router.Client(inst.Identity), inst.Blockstore,
+		bitswap.WithWantlistPersistence(wantlists))
+	if _, err := inst.Exchange.NewSession(ctx).GetBlocks(ctx, []cid.Cid{blk.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	for len(inst.Exchange.GetWantlist()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	inst.Exchange.Close()
+	if n := storedWantlists(); n != 1 {
+		t.Fatalf("expected 1 persisted wantlist, got %d", n)
+	}
+
+	// No peer has the block. Restarting the client several times must not
+	// lose the wantlist, even when it stops right after resuming it.
+	for range 3 {
+		inst.Exchange = bitswap.New(ctx, inst.Adapter, router.Client(inst.Identity), inst.Blockstore,
+			bitswap.WithWantlistPersistence(wantlists))
+		inst.Exchange.Close()
+		if n := storedWantlists(); n != 1 {
+			t.Fatalf("expected 1 persisted wantlist, got %d", n)
+		}
+	}
+}
+
+func TestBlockServiceSessionProgress(t *testing.T) {
+	vnet := getVirtualNetwork()
+	router := mockrouting.NewServer()
+	ig := testinstance.NewTestInstanceGenerator(vnet, router, nil, nil)
+	defer ig.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	inst := ig.Instances(2)
+	a, b := inst[0], inst[1]
+	blks := random.BlocksOfSize(64, blockSize)
+	cids := make([]cid.Cid, len(blks))
+	for i, blk := range blks {
+		addBlock(t, ctx, b, blk)
+		cids[i] = blk.Cid()
+	}
+
+	// The blockservice adds the fetched blocks to the exchange, which must
+	// not count them again.
+	ses := blockservice.NewSession(ctx, blockservice.New(a.Blockstore, a.Exchange))
+	var n int
+	for range ses.GetBlocks(ctx, cids) {
+		n++
+	}
+	if n != len(blks) {
+		t.Fatalf("expected %d blocks, got %d", len(blks), n)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	stats, ok := ses.Stats()
+	if !ok {
+		t.Fatal("expected the session to report its progress")
+	}
+	if stats.BlocksReceived != uint64(len(blks)) {
+		t.Fatalf("expected %d blocks received, got %d", len(blks), stats.BlocksReceived)
+	}
+	if stats.DupBlocksReceived != 0 {
+		t.Fatalf("expected no duplicate blocks, got %d", stats.DupBlocksReceived)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	rpqm "github.com/ipfs/boxo/routing/providerquerymanager"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
 	delay "github.com/ipfs/go-ipfs-delay"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipfs/go-metrics-interface"
@@ -170,6 +171,8 @@ func New(parent context.Context, network bsnet.BitSwapNetwork, providerFinder ro
 		rebroadcastDelay:            delay.Fixed(defaults.RebroadcastDelay),
 		simulateDontHavesOnTimeout:  true,
 		defaultProviderQueryManager: true,
+		wantlistFlushInterval:       defaults.WantlistFlushInterval,
+		wantlistResumeTimeout:       defaults.WantlistResumeTimeout,
 	}
 
 	// apply functional options before starting and running bitswap
@@ -250,6 +253,11 @@ func New(parent context.Context, network bsnet.BitSwapNetwork, providerFinder ro
 	bs.pm = pm
 	bs.sim = sim
 
+	if bs.wantlistDatastore != nil {
+		bs.wantlistDone = make(chan struct{})
+		go bs.persistWantlists(ctx)
+	}
+
 	return bs
 }
 
@@ -311,6 +319,17 @@ type Client struct {
 	skipDuplicatedBlocksStats bool
 
 	perPeerSendDelay time.Duration
+
+	// persistence of the session wantlists, see WithWantlistPersistence
+	wantlistDatastore     ds.Datastore
+	wantlistFlushInterval time.Duration
+	wantlistResumeTimeout time.Duration
+	wantlistDone          chan struct{}
+	// storedWantlists are the sessions whose wantlist is in the datastore,
+	// and resumedWantlists the keys of the wantlists of the previous run to
+	// delete after the next flush. Only accessed by persistWantlists.
+	storedWantlists  map[uint64]struct{}
+	resumedWantlists []ds.Key
 }
 
 type counters struct {
@@ -534,6 +553,10 @@ func (bs *Client) ReceiveError(err error) {
 func (bs *Client) Close() error {
 	bs.closeOnce.Do(func() {
 		close(bs.closing)
+		if bs.wantlistDone != nil {
+			// write the wantlists before the sessions shut down
+			<-bs.wantlistDone
+		}
 		bs.sm.Shutdown()
 		bs.cancel()
 		if bs.pqm != nil {
@@ -571,6 +594,9 @@ func (bs *Client) IsOnline() bool {
 // method, but the session will use the fact that the requests are related to
 // be more efficient in its requests to peers. If you are using a session
 // from blockservice, it will create a bitswap session automatically.
+//
+// The returned session implements [exchange.SessionProgress] to report the
+// peers in the session, the outstanding wants and the received blocks.
 func (bs *Client) NewSession(ctx context.Context) exchange.Fetcher {
 	ctx, span := internal.StartSpan(ctx, "NewSession")
 	defer span.End()
@@ -64,10 +64,10 @@ func SyncGetBlock(p context.Context, k cid.Cid, gb GetBlocksFunc) (blocks.Block,
 type WantFunc func(context.Context, []cid.Cid)
 
 // AsyncGetBlocks take a set of block cids, a pubsub channel for incoming
-// blocks, a want function, and a close function, and returns a channel of
-// incoming blocks.
+// blocks, a function called with each incoming block, a want function, and a
+// close function, and returns a channel of incoming blocks.
 func AsyncGetBlocks(ctx context.Context, sessctx context.Context, keys []cid.Cid, notif notifications.PubSub,
-	want WantFunc, cwants func([]cid.Cid),
+	received func(blocks.Block), want WantFunc, cwants func([]cid.Cid),
 ) (<-chan blocks.Block, error) {
 	ctx, span := internal.StartSpan(ctx, "Getter.AsyncGetBlocks")
 	defer span.End()
@@ -90,15 +90,15 @@ func AsyncGetBlocks(ctx context.Context, sessctx context.Context, keys []cid.Cid
 	want(ctx, keys)
 
 	out := make(chan blocks.Block)
-	go handleIncoming(ctx, sessctx, remaining, promise, out, cwants)
+	go handleIncoming(ctx, sessctx, remaining, promise, out, received, cwants)
 	return out, nil
 }
 
-// Listens for incoming blocks, passing them to the out channel.
-// If the context is cancelled or the incoming channel closes, calls cfun with
-// any keys corresponding to blocks that were never received.
+// Listens for incoming blocks, passing them to received and to the out
+// channel. If the context is cancelled or the incoming channel closes, calls
+// cfun with any keys corresponding to blocks that were never received.
 func handleIncoming(ctx context.Context, sessctx context.Context, remaining *cid.Set,
-	in <-chan blocks.Block, out chan blocks.Block, cfun func([]cid.Cid),
+	in <-chan blocks.Block, out chan blocks.Block, received func(blocks.Block), cfun func([]cid.Cid),
 ) {
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -121,6 +121,9 @@ func handleIncoming(ctx context.Context, sessctx context.Context, remaining *cid
 			}
 
 			remaining.Remove(blk.Cid())
+			if received != nil {
+				received(blk)
+			}
 			select {
 			case out <- blk:
 			case <-ctx.Done():
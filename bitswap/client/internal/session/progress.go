@@ -0,0 +1,222 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/boxo/bitswap/client/traceability"
+	exchange "github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Size of the buffer of each progress event subscriber
+const eventBufferSize = 64
+
+// progress keeps the statistics reported by Session.Stats and dispatches
+// progress events to the subscribers of Session.Events. It is safe for
+// concurrent use.
+type progress struct {
+	peers          atomic.Int64
+	wantsPending   atomic.Int64
+	wantsLive      atomic.Int64
+	blocksReceived atomic.Uint64
+	bytesReceived  atomic.Uint64
+	dupBlocks      atomic.Uint64
+	haves          atomic.Uint64
+	dontHaves      atomic.Uint64
+	avgLatency     atomic.Int64
+
+	subsLk sync.Mutex
+	subs   map[chan exchange.SessionEvent]struct{}
+
+	// Blocks are counted when the session receives them, but their size is
+	// only known when they are delivered to the callers of GetBlocks, which
+	// can happen first. received are the blocks counted by the session
+	// whose size is not known yet, and sizes the sizes of the blocks
+	// delivered before being counted. Entries which are never matched, such
+	// as the blocks delivered to several callers, are dropped by purge.
+	pendingLk  sync.Mutex
+	received   map[cid.Cid]pending[peer.ID]
+	sizes      map[cid.Cid]pending[int]
+	generation uint64
+}
+
+func (p *progress) stats() exchange.SessionStats {
+	return exchange.SessionStats{
+		Peers:             int(p.peers.Load()),
+		WantsPending:      int(p.wantsPending.Load()),
+		WantsLive:         int(p.wantsLive.Load()),
+		BlocksReceived:    p.blocksReceived.Load(),
+		BytesReceived:     p.bytesReceived.Load(),
+		DupBlocksReceived: p.dupBlocks.Load(),
+		HavesReceived:     p.haves.Load(),
+		DontHavesReceived: p.dontHaves.Load(),
+		AverageLatency:    time.Duration(p.avgLatency.Load()),
+	}
+}
+
+// subscribe returns a channel of events which is closed when ctx or sessCtx
+// is done.
+func (p *progress) subscribe(ctx, sessCtx context.Context) <-chan exchange.SessionEvent {
+	ch := make(chan exchange.SessionEvent, eventBufferSize)
+	if sessCtx.Err() != nil {
+		close(ch)
+		return ch
+	}
+
+	p.subsLk.Lock()
+	if p.subs == nil {
+		p.subs = make(map[chan exchange.SessionEvent]struct{})
+	}
+	p.subs[ch] = struct{}{}
+	p.subsLk.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sessCtx.Done():
+		}
+		p.subsLk.Lock()
+		delete(p.subs, ch)
+		close(ch)
+		p.subsLk.Unlock()
+	}()
+	return ch
+}
+
+// publish sends evt to the subscribers, dropping it for the ones whose
+// buffer is full.
+func (p *progress) publish(evt exchange.SessionEvent) {
+	p.subsLk.Lock()
+	defer p.subsLk.Unlock()
+
+	for ch := range p.subs {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// pending is a block waiting to be accounted for, since the given
+// generation of the pending blocks.
+type pending[T any] struct {
+	value      T
+	generation uint64
+}
+
+// wantedReceived is called with the blocks that the session wanted and
+// received from a peer, each block once.
+func (p *progress) wantedReceived(from peer.ID, ks []cid.Cid) {
+	p.blocksReceived.Add(uint64(len(ks)))
+
+	p.pendingLk.Lock()
+	defer p.pendingLk.Unlock()
+	if p.received == nil {
+		p.received = make(map[cid.Cid]pending[peer.ID])
+	}
+	for _, c := range ks {
+		if size, ok := p.sizes[c]; ok {
+			delete(p.sizes, c)
+			p.sized(from, c, size.value)
+			continue
+		}
+		p.received[c] = pending[peer.ID]{value: from, generation: p.generation}
+	}
+}
+
+// blockReceived is called for each block delivered to a caller of
+// Session.GetBlocks, which may deliver the same block to several callers.
+func (p *progress) blockReceived(blk blocks.Block) {
+	// Blocks added locally with NotifyNewBlocks have no sender, and are not
+	// counted by the session
+	tb, ok := blk.(traceability.Block)
+	if !ok || tb.From == "" {
+		return
+	}
+
+	p.pendingLk.Lock()
+	defer p.pendingLk.Unlock()
+	c := blk.Cid()
+	if from, ok := p.received[c]; ok {
+		delete(p.received, c)
+		p.sized(from.value, c, len(blk.RawData()))
+		return
+	}
+	if p.sizes == nil {
+		p.sizes = make(map[cid.Cid]pending[int])
+	}
+	p.sizes[c] = pending[int]{value: len(blk.RawData()), generation: p.generation}
+}
+
+// sized accounts for the size of a received block. The pending lock must be
+// held.
+func (p *progress) sized(from peer.ID, c cid.Cid, size int) {
+	p.bytesReceived.Add(uint64(size))
+	p.publish(exchange.SessionEvent{
+		Type: exchange.SessionBlockReceived,
+		Peer: from,
+		Cid:  c,
+		Size: size,
+	})
+}
+
+// purge drops the pending blocks which were not matched since the previous
+// call. It is called periodically, much less often than blocks are received.
+func (p *progress) purge() {
+	p.pendingLk.Lock()
+	defer p.pendingLk.Unlock()
+	for c, r := range p.received {
+		if r.generation < p.generation {
+			delete(p.received, c)
+		}
+	}
+	for c, s := range p.sizes {
+		if s.generation < p.generation {
+			delete(p.sizes, c)
+		}
+	}
+	p.generation++
+}
+
+// duplicatesReceived is called with the blocks that the session received
+// from a peer after it already got them.
+func (p *progress) duplicatesReceived(from peer.ID, ks []cid.Cid) {
+	p.dupBlocks.Add(uint64(len(ks)))
+	for _, c := range ks {
+		p.publish(exchange.SessionEvent{
+			Type: exchange.SessionDuplicateBlock,
+			Peer: from,
+			Cid:  c,
+		})
+	}
+}
+
+// progressPeerManager wraps the SessionPeerManager of a session to report
+// the peers joining and leaving it.
+type progressPeerManager struct {
+	SessionPeerManager
+	progress *progress
+}
+
+func (ppm *progressPeerManager) AddPeer(p peer.ID) bool {
+	if !ppm.SessionPeerManager.AddPeer(p) {
+		return false
+	}
+	ppm.progress.peers.Add(1)
+	ppm.progress.publish(exchange.SessionEvent{Type: exchange.SessionPeerAdded, Peer: p})
+	return true
+}
+
+func (ppm *progressPeerManager) RemovePeer(p peer.ID) bool {
+	if !ppm.SessionPeerManager.RemovePeer(p) {
+		return false
+	}
+	ppm.progress.peers.Add(-1)
+	ppm.progress.publish(exchange.SessionEvent{Type: exchange.SessionPeerRemoved, Peer: p})
+	return true
+}
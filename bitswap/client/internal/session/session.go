@@ -10,6 +10,7 @@ import (
 	notifications "github.com/ipfs/boxo/bitswap/client/internal/notifications"
 	bspm "github.com/ipfs/boxo/bitswap/client/internal/peermanager"
 	bssim "github.com/ipfs/boxo/bitswap/client/internal/sessioninterestmanager"
+	exchange "github.com/ipfs/boxo/exchange"
 	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
 	delay "github.com/ipfs/go-ipfs-delay"
@@ -84,11 +85,15 @@ const (
 	opBroadcast
 	// Wants sent to peers
 	opWantsSent
+	// Get the outstanding wants
+	opGetWants
 )
 
 type op struct {
-	op   opType
-	keys []cid.Cid
+	op    opType
+	keys  []cid.Cid
+	from  peer.ID
+	wants chan []cid.Cid
 }
 
 // Session holds state for an individual bitswap transfer operation.
@@ -108,6 +113,7 @@ type Session struct {
 	sws sessionWantSender
 
 	latencyTrkr latencyTracker
+	progress    *progress
 
 	// channels
 	incoming      chan op
@@ -144,6 +150,8 @@ func New(
 	self peer.ID,
 ) *Session {
 	ctx, cancel := context.WithCancel(ctx)
+	prog := &progress{}
+	sprm = &progressPeerManager{SessionPeerManager: sprm, progress: prog}
 	s := &Session{
 		sw:                  newSessionWants(broadcastLiveWantsLimit),
 		tickDelayReqs:       make(chan time.Duration),
@@ -156,6 +164,7 @@ func New(
 		sim:                 sim,
 		incoming:            make(chan op, 128),
 		latencyTrkr:         latencyTracker{},
+		progress:            prog,
 		notif:               notif,
 		baseTickDelay:       time.Millisecond * 500,
 		id:                  id,
@@ -178,6 +187,51 @@ func (s *Session) Shutdown() {
 	s.shutdown()
 }
 
+// Stats returns a snapshot of the progress of the session.
+func (s *Session) Stats() exchange.SessionStats {
+	return s.progress.stats()
+}
+
+// Events returns a channel of progress events for the session. It is closed
+// when ctx is done or the session shuts down. Events are dropped when the
+// receiver does not keep up.
+func (s *Session) Events(ctx context.Context) <-chan exchange.SessionEvent {
+	return s.progress.subscribe(ctx, s.ctx)
+}
+
+var _ exchange.SessionProgress = (*Session)(nil)
+
+// Peers returns the peers in the session.
+func (s *Session) Peers() []peer.ID {
+	return s.sprm.Peers()
+}
+
+// Wants returns the keys that the session is still waiting for, whether
+// they were sent to peers or not. It returns nil once the session is shut
+// down.
+func (s *Session) Wants() []cid.Cid {
+	wants := make(chan []cid.Cid, 1)
+	select {
+	case s.incoming <- op{op: opGetWants, wants: wants}:
+	case <-s.ctx.Done():
+		return nil
+	}
+	select {
+	case ks := <-wants:
+		return ks
+	case <-s.ctx.Done():
+		return nil
+	}
+}
+
+// AddProviders tells the session that the given peers have the blocks for
+// the keys, as if they were found by a provider search.
+func (s *Session) AddProviders(ks []cid.Cid, providers []peer.ID) {
+	for _, p := range providers {
+		s.sws.Update(p, nil, ks, nil)
+	}
+}
+
 // ReceiveFrom receives incoming blocks from the given peer.
 func (s *Session) ReceiveFrom(from peer.ID, ks []cid.Cid, haves []cid.Cid, dontHaves []cid.Cid) {
 	// The SessionManager tells each Session about all keys that it may be
@@ -188,6 +242,8 @@ func (s *Session) ReceiveFrom(from peer.ID, ks []cid.Cid, haves []cid.Cid, dontH
 	haves = interestedRes[1]
 	dontHaves = interestedRes[2]
 	s.logReceiveFrom(from, ks, haves, dontHaves)
+	s.progress.haves.Add(uint64(len(haves)))
+	s.progress.dontHaves.Add(uint64(len(dontHaves)))
 
 	// Inform the session want sender that a message has been received
 	s.sws.Update(from, ks, haves, dontHaves)
@@ -198,7 +254,7 @@ func (s *Session) ReceiveFrom(from peer.ID, ks []cid.Cid, haves []cid.Cid, dontH
 
 	// Inform the session that blocks have been received
 	select {
-	case s.incoming <- op{op: opReceive, keys: ks}:
+	case s.incoming <- op{op: opReceive, keys: ks, from: from}:
 	case <-s.ctx.Done():
 	}
 }
@@ -234,7 +290,7 @@ func (s *Session) GetBlocks(ctx context.Context, keys []cid.Cid) (<-chan blocks.
 	ctx, span := internal.StartSpan(ctx, "Session.GetBlocks")
 	defer span.End()
 
-	return bsgetter.AsyncGetBlocks(ctx, s.ctx, keys, s.notif,
+	return bsgetter.AsyncGetBlocks(ctx, s.ctx, keys, s.notif, s.progress.blockReceived,
 		func(ctx context.Context, keys []cid.Cid) {
 			select {
 			case s.incoming <- op{op: opWant, keys: keys}:
@@ -302,7 +358,7 @@ func (s *Session) run(ctx context.Context) {
 			case opReceive:
 				// Received blocks
 				sessionSpan.AddEvent("Session.ReceiveOp")
-				s.handleReceive(oper.keys)
+				s.handleReceive(oper.from, oper.keys)
 			case opWant:
 				// Client wants blocks
 				sessionSpan.AddEvent("Session.WantOp")
@@ -316,6 +372,9 @@ func (s *Session) run(ctx context.Context) {
 				// Wants were sent to a peer
 				sessionSpan.AddEvent("Session.WantsSentOp")
 				s.sw.WantsSent(oper.keys)
+			case opGetWants:
+				// Outstanding wants were requested
+				oper.wants <- s.sw.Wants()
 			case opBroadcast:
 				// Broadcast want-haves to all peers
 				opCtx, span := internal.StartSpan(ctx, "Session.BroadcastOp")
@@ -334,6 +393,7 @@ func (s *Session) run(ctx context.Context) {
 			opCtx, span := internal.StartSpan(ctx, "Session.PeriodicSearch")
 			s.handlePeriodicSearch(opCtx)
 			span.End()
+			s.progress.purge()
 		case baseTickDelay := <-s.tickDelayReqs:
 			// Set the base tick delay
 			s.baseTickDelay = baseTickDelay
@@ -342,6 +402,17 @@ func (s *Session) run(ctx context.Context) {
 			s.handleShutdown()
 			return
 		}
+		s.updateProgress()
+	}
+}
+
+// updateProgress publishes the state owned by the run loop to the progress
+// statistics
+func (s *Session) updateProgress() {
+	s.progress.wantsPending.Store(int64(s.sw.toFetch.len()))
+	s.progress.wantsLive.Store(int64(len(s.sw.liveWants)))
+	if s.latencyTrkr.hasLatency() {
+		s.progress.avgLatency.Store(int64(s.latencyTrkr.averageLatency()))
 	}
 }
 
@@ -426,10 +497,19 @@ func (s *Session) handleShutdown() {
 }
 
 // handleReceive is called when the session receives blocks from a peer
-func (s *Session) handleReceive(ks []cid.Cid) {
+func (s *Session) handleReceive(from peer.ID, ks []cid.Cid) {
 	// Record which blocks have been received and figure out the total latency
 	// for fetching the blocks
 	wanted, totalLatency := s.sw.BlocksReceived(ks)
+	// Blocks added locally with NotifyNewBlocks have no sender, and are not
+	// counted, for example when a blockservice adds the blocks fetched by
+	// the session
+	if from != "" {
+		if len(wanted) < len(ks) {
+			s.progress.duplicatesReceived(from, duplicates(ks, wanted))
+		}
+		s.progress.wantedReceived(from, wanted)
+	}
 	if len(wanted) == 0 {
 		return
 	}
@@ -478,6 +558,20 @@ func (s *Session) wantBlocks(ctx context.Context, newks []cid.Cid) {
 	}
 }
 
+// duplicates returns the keys of ks which are not in wanted. wanted must be
+// a subsequence of ks, as returned by sessionWants.BlocksReceived.
+func duplicates(ks []cid.Cid, wanted []cid.Cid) []cid.Cid {
+	dups := make([]cid.Cid, 0, len(ks)-len(wanted))
+	for _, c := range ks {
+		if len(wanted) > 0 && wanted[0] == c {
+			wanted = wanted[1:]
+			continue
+		}
+		dups = append(dups, c)
+	}
+	return dups
+}
+
 // Send want-haves to all connected peers
 func (s *Session) broadcastWantHaves(ctx context.Context, wants []cid.Cid) {
 	log.Debugw("broadcastWantHaves", "session", s.id, "cids", wants)
@@ -12,6 +12,7 @@ import (
 	bspm "github.com/ipfs/boxo/bitswap/client/internal/peermanager"
 	bssim "github.com/ipfs/boxo/bitswap/client/internal/sessioninterestmanager"
 	bsspm "github.com/ipfs/boxo/bitswap/client/internal/sessionpeermanager"
+	exchange "github.com/ipfs/boxo/exchange"
 	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
 	delay "github.com/ipfs/go-ipfs-delay"
@@ -545,3 +546,117 @@ func TestSessionReceiveMessageAfterCtxCancel(t *testing.T) {
 
 	// If we don't get a panic then the test is considered passing
 }
+
+func TestSessionProgress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fpm := newFakePeerManager()
+	fspm := newFakeSessionPeerManager()
+	fpf := newFakeProviderFinder()
+	sim := bssim.New()
+	bpm := bsbpm.New()
+	notif := notifications.New()
+	defer notif.Shutdown()
+	id := random.SequenceNext()
+	sm := newMockSessionMgr(sim)
+	session := New(ctx, sm, id, fspm, fpf, sim, fpm, bpm, notif, time.Second, delay.Fixed(time.Minute), "")
+	events := session.Events(ctx)
+
+	blks := random.BlocksOfSize(4, blockSize)
+	var cids []cid.Cid
+	for _, block := range blks {
+		cids = append(cids, block.Cid())
+	}
+	out, err := session.GetBlocks(ctx, cids)
+	require.NoError(t, err, "error getting blocks")
+
+	// Wait for initial want request
+	<-fpm.wantReqs
+	require.Eventually(t, func() bool {
+		return session.Stats().WantsLive == len(cids)
+	}, time.Second, time.Millisecond)
+
+	// Receive a HAVE then the block from a peer
+	p := random.Peers(1)[0]
+	session.ReceiveFrom(p, []cid.Cid{}, []cid.Cid{cids[0]}, []cid.Cid{})
+	require.Eventually(t, func() bool {
+		return session.Stats().Peers == 1
+	}, time.Second, time.Millisecond)
+	session.ReceiveFrom(p, []cid.Cid{cids[0]}, []cid.Cid{}, []cid.Cid{})
+	notif.Publish(p, blks[0])
+	require.Equal(t, blks[0].Cid(), (<-out).Cid())
+
+	require.Eventually(t, func() bool {
+		st := session.Stats()
+		return st.BlocksReceived == 1 && st.WantsLive == len(cids)-1
+	}, time.Second, time.Millisecond)
+	st := session.Stats()
+	require.Equal(t, uint64(blockSize), st.BytesReceived)
+	require.Equal(t, uint64(1), st.HavesReceived)
+	require.Zero(t, st.DupRate())
+
+	evt := <-events
+	require.Equal(t, exchange.SessionPeerAdded, evt.Type)
+	require.Equal(t, p, evt.Peer)
+	evt = <-events
+	require.Equal(t, exchange.SessionBlockReceived, evt.Type)
+	require.Equal(t, blks[0].Cid(), evt.Cid)
+	require.Equal(t, blockSize, evt.Size)
+
+	// The event channel is closed when the session shuts down
+	session.Shutdown()
+	for range events {
+	}
+}
+
+func TestSessionProgressCountsOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fpm := newFakePeerManager()
+	fspm := newFakeSessionPeerManager()
+	fpf := newFakeProviderFinder()
+	sim := bssim.New()
+	bpm := bsbpm.New()
+	notif := notifications.New()
+	defer notif.Shutdown()
+	id := random.SequenceNext()
+	sm := newMockSessionMgr(sim)
+	session := New(ctx, sm, id, fspm, fpf, sim, fpm, bpm, notif, time.Second, delay.Fixed(time.Minute), "")
+	defer session.Shutdown()
+
+	blks := random.BlocksOfSize(2, blockSize)
+	wanted, other := blks[0], blks[1]
+
+	// Two callers want the same block.
+	out1, err := session.GetBlocks(ctx, []cid.Cid{wanted.Cid()})
+	require.NoError(t, err)
+	out2, err := session.GetBlocks(ctx, []cid.Cid{wanted.Cid()})
+	require.NoError(t, err)
+	<-fpm.wantReqs
+
+	p := random.Peers(1)[0]
+	session.ReceiveFrom(p, []cid.Cid{wanted.Cid()}, []cid.Cid{}, []cid.Cid{})
+	// A blockservice adds the fetched block again, without sender.
+	session.ReceiveFrom("", []cid.Cid{wanted.Cid()}, []cid.Cid{}, []cid.Cid{})
+	notif.Publish(p, wanted)
+	require.Equal(t, wanted.Cid(), (<-out1).Cid())
+	require.Equal(t, wanted.Cid(), (<-out2).Cid())
+
+	// A block fetched by another session is not counted by this one.
+	notif.Publish(p, other)
+
+	require.Eventually(t, func() bool {
+		return session.Stats().BytesReceived != 0
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	st := session.Stats()
+	require.Equal(t, uint64(1), st.BlocksReceived)
+	require.Equal(t, uint64(blockSize), st.BytesReceived)
+	require.Zero(t, st.DupBlocksReceived)
+}
+
+func TestDuplicates(t *testing.T) {
+	cids := random.Cids(4)
+	require.Equal(t, []cid.Cid{cids[0], cids[2]}, duplicates(cids, []cid.Cid{cids[1], cids[3]}))
+	require.Empty(t, duplicates(cids, cids))
+}
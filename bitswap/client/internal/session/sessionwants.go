@@ -170,6 +170,16 @@ func (sw *sessionWants) LiveWants() []cid.Cid {
 	return live
 }
 
+// Wants returns the wants which are waiting to be sent out or for a block
+func (sw *sessionWants) Wants() []cid.Cid {
+	wants := make([]cid.Cid, 0, sw.toFetch.len()+len(sw.liveWants))
+	wants = append(wants, sw.toFetch.eset.Keys()...)
+	for c := range sw.liveWants {
+		wants = append(wants, c)
+	}
+	return wants
+}
+
 // RandomLiveWant returns a randomly selected live want
 func (sw *sessionWants) RandomLiveWant() cid.Cid {
 	if len(sw.liveWants) == 0 {
@@ -23,7 +23,9 @@ import (
 type Session interface {
 	exchange.Fetcher
 	ID() uint64
+	Wants() []cid.Cid
 	ReceiveFrom(peer.ID, []cid.Cid, []cid.Cid, []cid.Cid)
+	Peers() []peer.ID
 	Shutdown()
 }
 
@@ -142,6 +144,34 @@ func (sm *SessionManager) RemoveSession(sesid uint64) {
 	}
 }
 
+// SessionWants describes the wants of a session and the peers in it.
+type SessionWants struct {
+	ID    uint64
+	Wants []cid.Cid
+	Peers []peer.ID
+}
+
+// Wants returns the keys that each session is still waiting for, along with
+// the peers in the session. Sessions without wants are omitted.
+func (sm *SessionManager) Wants() []SessionWants {
+	sm.sessLk.Lock()
+	sessions := make([]Session, 0, len(sm.sessions))
+	for _, sess := range sm.sessions {
+		sessions = append(sessions, sess)
+	}
+	sm.sessLk.Unlock()
+
+	res := make([]SessionWants, 0, len(sessions))
+	for _, sess := range sessions {
+		ks := sess.Wants()
+		if len(ks) == 0 {
+			continue
+		}
+		res = append(res, SessionWants{ID: sess.ID(), Wants: ks, Peers: sess.Peers()})
+	}
+	return res
+}
+
 // GetNextSessionID returns the next sequential identifier for a session.
 func (sm *SessionManager) GetNextSessionID() uint64 {
 	sm.sessIDLk.Lock()
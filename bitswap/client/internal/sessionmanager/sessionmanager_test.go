@@ -15,11 +15,13 @@ import (
 	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
 	delay "github.com/ipfs/go-ipfs-delay"
+	"github.com/ipfs/go-test/random"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 	"github.com/stretchr/testify/require"
 )
 
 type fakeSession struct {
+	wants      []cid.Cid
 	ks         []cid.Cid
 	wantBlocks []cid.Cid
 	wantHaves  []cid.Cid
@@ -41,6 +43,10 @@ func (fs *fakeSession) ID() uint64 {
 	return fs.id
 }
 
+func (fs *fakeSession) Wants() []cid.Cid {
+	return fs.wants
+}
+
 func (fs *fakeSession) ReceiveFrom(p peer.ID, ks []cid.Cid, wantBlocks []cid.Cid, wantHaves []cid.Cid) {
 	fs.ks = append(fs.ks, ks...)
 	fs.wantBlocks = append(fs.wantBlocks, wantBlocks...)
@@ -52,6 +58,10 @@ func (fs *fakeSession) Shutdown() {
 	fs.sm.RemoveSession(fs.id)
 }
 
+func (fs *fakeSession) Peers() []peer.ID {
+	return fs.pm.Peers()
+}
+
 type fakeSesPeerManager struct{}
 
 func (*fakeSesPeerManager) Peers() []peer.ID          { return nil }
@@ -255,3 +265,35 @@ func TestShutdown(t *testing.T) {
 	require.False(t, bpm.HasKey(block.Cid()), "expected cid to be removed from block presence manager")
 	require.ElementsMatch(t, pm.cancelled(), cids, "expected cancels to be sent")
 }
+
+func TestWants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notif := notifications.New()
+	defer notif.Shutdown()
+	sim := bssim.New()
+	bpm := bsbpm.New()
+	pm := &fakePeerManager{}
+	sm := New(ctx, sessionFactory, sim, peerManagerFactory, bpm, pm, notif, "")
+
+	firstSession := sm.NewSession(ctx, time.Second, delay.Fixed(time.Minute)).(*fakeSession)
+	secondSession := sm.NewSession(ctx, time.Second, delay.Fixed(time.Minute)).(*fakeSession)
+	_ = sm.NewSession(ctx, time.Second, delay.Fixed(time.Minute))
+
+	cids := random.Cids(3)
+	firstSession.wants = cids[:1]
+	secondSession.wants = cids[1:]
+
+	wants := sm.Wants()
+	require.Len(t, wants, 2, "sessions without wants should be omitted")
+	for _, sw := range wants {
+		switch sw.ID {
+		case firstSession.ID():
+			require.ElementsMatch(t, cids[:1], sw.Wants)
+		case secondSession.ID():
+			require.ElementsMatch(t, cids[1:], sw.Wants)
+		default:
+			t.Fatalf("unexpected session %d", sw.ID)
+		}
+	}
+}
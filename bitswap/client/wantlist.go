@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bssession "github.com/ipfs/boxo/bitswap/client/internal/session"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// wantlistPrefix is the datastore key prefix of the persisted wantlists.
+var wantlistPrefix = ds.NewKey("/bitswap/wantlist")
+
+// storedWantlist is the datastore encoding of the wantlist of a session.
+type storedWantlist struct {
+	Wants     []cid.Cid `json:"wants"`
+	Providers []peer.ID `json:"providers,omitempty"`
+}
+
+// WithWantlistPersistence makes the client write the wantlists of its
+// sessions, and the peers which provide the blocks, to the given datastore.
+// When a client starts with a datastore holding the wantlists of a previous
+// run, it resumes them in new sessions which ask the stored providers first,
+// and writes the blocks they fetch to the blockstore. A traversal restarted
+// after a crash then finds the blocks locally instead of rediscovering the
+// DAG. Wantlists are written every few seconds and when the client is closed.
+func WithWantlistPersistence(d ds.Datastore) Option {
+	return func(bs *Client) {
+		bs.wantlistDatastore = d
+	}
+}
+
+// WithWantlistResumeTimeout sets how long the sessions resumed from a
+// persisted wantlist look for their blocks. See [defaults.WantlistResumeTimeout]
+// for the default.
+func WithWantlistResumeTimeout(timeout time.Duration) Option {
+	return func(bs *Client) {
+		bs.wantlistResumeTimeout = timeout
+	}
+}
+
+// persistWantlists resumes the wantlists of the previous run, then writes
+// the wantlists of the sessions to the datastore until the client closes.
+func (bs *Client) persistWantlists(ctx context.Context) {
+	defer close(bs.wantlistDone)
+
+	if err := bs.resumeWantlists(ctx); err != nil {
+		log.Errorw("failed to resume wantlists", "error", err)
+	}
+
+	ticker := time.NewTicker(bs.wantlistFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := bs.flushWantlists(context.Background()); err != nil {
+				log.Errorw("failed to write wantlists", "error", err)
+			}
+		case <-bs.closing:
+			if err := bs.flushWantlists(context.Background()); err != nil {
+				log.Errorw("failed to write wantlists", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// resumeWantlists loads the wantlists stored by the previous run and fetches
+// them in new sessions. They are deleted from the datastore once the
+// wantlists of the new sessions are written, so that they are not lost if
+// the client stops before.
+func (bs *Client) resumeWantlists(ctx context.Context) error {
+	results, err := bs.wantlistDatastore.Query(ctx, query.Query{Prefix: wantlistPrefix.String()})
+	if err != nil {
+		return fmt.Errorf("cannot query wantlists: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return fmt.Errorf("cannot load wantlists: %w", err)
+	}
+
+	for _, e := range entries {
+		bs.resumedWantlists = append(bs.resumedWantlists, ds.RawKey(e.Key))
+		var rec storedWantlist
+		if err := json.Unmarshal(e.Value, &rec); err != nil {
+			log.Warnw("ignoring invalid wantlist", "key", e.Key, "error", err)
+			continue
+		}
+		if len(rec.Wants) == 0 {
+			continue
+		}
+		bs.resumeWantlist(ctx, rec)
+	}
+	return nil
+}
+
+// resumeWantlist fetches the blocks of a stored wantlist in a new session
+// and writes them to the blockstore.
+func (bs *Client) resumeWantlist(ctx context.Context, rec storedWantlist) {
+	ctx, cancel := context.WithTimeout(ctx, bs.wantlistResumeTimeout)
+	ses := bs.sm.NewSession(ctx, bs.provSearchDelay, bs.rebroadcastDelay)
+	if s, ok := ses.(*bssession.Session); ok {
+		s.AddProviders(rec.Wants, rec.Providers)
+	}
+
+	blks, err := ses.GetBlocks(ctx, rec.Wants)
+	if err != nil {
+		cancel()
+		log.Warnw("failed to resume wantlist", "error", err)
+		return
+	}
+	log.Debugw("resuming wantlist", "wants", len(rec.Wants), "providers", len(rec.Providers))
+
+	go func() {
+		defer cancel()
+		for blk := range blks {
+			if err := bs.blockstore.Put(ctx, blk); err != nil {
+				log.Warnw("failed to store resumed block", "cid", blk.Cid(), "error", err)
+			}
+		}
+	}()
+}
+
+// flushWantlists writes the wantlists of the sessions to the datastore, and
+// deletes those of the sessions which have no wants anymore, and the resumed
+// ones once written.
+func (bs *Client) flushWantlists(ctx context.Context) error {
+	stored := make(map[uint64]struct{})
+	for _, sw := range bs.sm.Wants() {
+		data, err := json.Marshal(storedWantlist{Wants: sw.Wants, Providers: sw.Peers})
+		if err != nil {
+			return err
+		}
+		if err := bs.wantlistDatastore.Put(ctx, wantlistKey(sw.ID), data); err != nil {
+			return fmt.Errorf("cannot store wantlist of session %d: %w", sw.ID, err)
+		}
+		stored[sw.ID] = struct{}{}
+	}
+	for id := range bs.storedWantlists {
+		if _, ok := stored[id]; ok {
+			continue
+		}
+		if err := bs.wantlistDatastore.Delete(ctx, wantlistKey(id)); err != nil {
+			return fmt.Errorf("cannot delete wantlist of session %d: %w", id, err)
+		}
+	}
+	bs.storedWantlists = stored
+	if err := bs.wantlistDatastore.Sync(ctx, wantlistPrefix); err != nil {
+		return err
+	}
+	if len(bs.resumedWantlists) == 0 {
+		return nil
+	}
+
+	// The resumed wantlists are now written by their sessions, unless they
+	// have the key of a current session.
+	for _, k := range bs.resumedWantlists {
+		if id, err := strconv.ParseUint(k.BaseNamespace(), 10, 64); err == nil {
+			if _, ok := stored[id]; ok {
+				continue
+			}
+		}
+		if err := bs.wantlistDatastore.Delete(ctx, k); err != nil {
+			return fmt.Errorf("cannot delete wantlist %s: %w", k, err)
+		}
+	}
+	bs.resumedWantlists = nil
+	return bs.wantlistDatastore.Sync(ctx, wantlistPrefix)
+}
+
+func wantlistKey(id uint64) ds.Key {
+	return wantlistPrefix.ChildString(strconv.FormatUint(id, 10))
+}
@@ -38,4 +38,12 @@ const (
 
 	// DefaultWantHaveReplaceSize controls the implicit behavior of WithWantHaveReplaceSize.
 	DefaultWantHaveReplaceSize = 1024
+
+	// WantlistFlushInterval is how often the client writes the wantlists of
+	// its sessions to the datastore when wantlist persistence is enabled.
+	WantlistFlushInterval = 10 * time.Second
+
+	// WantlistResumeTimeout is how long the sessions resumed from a
+	// persisted wantlist keep looking for their blocks.
+	WantlistResumeTimeout = 10 * time.Minute
 )
@@ -0,0 +1,334 @@
+package network
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// DefaultBandwidthPriorityWeight is the default weight of priority peers
+	// relative to other peers.
+	DefaultBandwidthPriorityWeight = 4
+
+	// DefaultBandwidthMaxPeers is the default number of peers whose per-peer
+	// limits are tracked at once.
+	DefaultBandwidthMaxPeers = 4096
+
+	// bandwidthActiveWindow is how long a class of peers keeps its share of
+	// the global rates after its last transfer.
+	bandwidthActiveWindow = time.Second
+)
+
+// PeerClass is the prioritisation class of a peer.
+type PeerClass int
+
+const (
+	// PeerClassDefault is the class of most peers.
+	PeerClassDefault PeerClass = iota
+	// PeerClassPriority is the class of the peers which get a larger share of
+	// the bandwidth, such as the peers we are peered with.
+	PeerClassPriority
+
+	numPeerClasses
+)
+
+// BandwidthLimits configures a [BandwidthLimiter]. Rates are in bytes per
+// second, and a zero rate disables the corresponding limit. Limits allow
+// bursts of one second worth of bytes.
+type BandwidthLimits struct {
+	// Upload and Download limit the transfers with all peers.
+	Upload   float64
+	Download float64
+
+	// PeerUpload and PeerDownload limit the transfers with each peer.
+	PeerUpload   float64
+	PeerDownload float64
+
+	// PriorityWeight is how much more bandwidth priority peers get: their
+	// per-peer rates are multiplied by it, and when peers of both classes
+	// transfer data, priority peers share PriorityWeight times more of the
+	// global rates. Defaults to [DefaultBandwidthPriorityWeight].
+	PriorityWeight float64
+
+	// Classify returns the class of a peer. By default, all peers are in
+	// [PeerClassDefault]. See [PeeringClassifier] to prioritise the peers of a
+	// peering service.
+	Classify func(peer.ID) PeerClass
+
+	// MaxPeers is the number of peers whose per-peer limits are tracked at
+	// once. The least recently seen peers are forgotten beyond. Defaults to
+	// [DefaultBandwidthMaxPeers].
+	MaxPeers int
+}
+
+// PeeringClassifier returns a classifier putting the peers for which
+// isPeered returns true, typically
+// [github.com/ipfs/boxo/peering.PeeringService.HasPeer], in
+// [PeerClassPriority].
+func PeeringClassifier(isPeered func(peer.ID) bool) func(peer.ID) PeerClass {
+	return func(p peer.ID) PeerClass {
+		if isPeered(p) {
+			return PeerClassPriority
+		}
+		return PeerClassDefault
+	}
+}
+
+// BandwidthLimiter shapes the bitswap traffic with token buckets, globally and
+// per peer. Networks wait for upload tokens before sending messages, which
+// limits the blocks sent by the bitswap server, and for download tokens while
+// reading messages, which limits the blocks received by the bitswap client.
+// A single limiter can be shared by several networks, such as bsnet and
+// httpnet, so that the global limits apply to their combined traffic.
+//
+// A nil *BandwidthLimiter does not limit anything.
+type BandwidthLimiter struct {
+	limits   BandwidthLimits
+	upload   *classBuckets
+	download *classBuckets
+	peers    *lru.Cache[peer.ID, *peerBuckets]
+}
+
+// NewBandwidthLimiter creates a [BandwidthLimiter] with the given limits.
+func NewBandwidthLimiter(limits BandwidthLimits) *BandwidthLimiter {
+	if limits.PriorityWeight <= 0 {
+		limits.PriorityWeight = DefaultBandwidthPriorityWeight
+	}
+	if limits.MaxPeers <= 0 {
+		limits.MaxPeers = DefaultBandwidthMaxPeers
+	}
+
+	// The size is positive, so creating the cache cannot fail.
+	peers, _ := lru.New[peer.ID, *peerBuckets](limits.MaxPeers)
+	return &BandwidthLimiter{
+		limits:   limits,
+		upload:   newClassBuckets(limits.Upload, limits.PriorityWeight),
+		download: newClassBuckets(limits.Download, limits.PriorityWeight),
+		peers:    peers,
+	}
+}
+
+// WaitUpload waits until n bytes can be sent to p, or ctx is done.
+func (l *BandwidthLimiter) WaitUpload(ctx context.Context, p peer.ID, n int) error {
+	if l == nil {
+		return nil
+	}
+	return l.wait(ctx, p, n, true)
+}
+
+// WaitDownload waits until n bytes can be received from p, or ctx is done.
+func (l *BandwidthLimiter) WaitDownload(ctx context.Context, p peer.ID, n int) error {
+	if l == nil {
+		return nil
+	}
+	return l.wait(ctx, p, n, false)
+}
+
+// NewReader returns a reader which waits for download tokens for the bytes
+// read from p with r.
+func (l *BandwidthLimiter) NewReader(ctx context.Context, p peer.ID, r io.Reader) io.Reader {
+	if l == nil || (l.limits.Download <= 0 && l.limits.PeerDownload <= 0) {
+		return r
+	}
+	return &bandwidthReader{ctx: ctx, r: r, limiter: l, peer: p}
+}
+
+func (l *BandwidthLimiter) wait(ctx context.Context, p peer.ID, n int, upload bool) error {
+	global, perPeer := l.download, l.limits.PeerDownload
+	if upload {
+		global, perPeer = l.upload, l.limits.PeerUpload
+	}
+	if global == nil && perPeer <= 0 {
+		return nil
+	}
+
+	class := PeerClassDefault
+	if l.limits.Classify != nil {
+		if c := l.limits.Classify(p); c >= 0 && c < numPeerClasses {
+			class = c
+		}
+	}
+	now := time.Now()
+
+	var buckets []*byteBucket
+	if global != nil {
+		buckets = append(buckets, global.bucket(now, class))
+	}
+	if perPeer > 0 {
+		pb, ok := l.peers.Get(p)
+		if !ok {
+			pb = &peerBuckets{}
+			if prev, ok, _ := l.peers.PeekOrAdd(p, pb); ok {
+				pb = prev
+			}
+		}
+		rate := perPeer
+		if class == PeerClassPriority {
+			rate *= l.limits.PriorityWeight
+		}
+		buckets = append(buckets, pb.bucket(upload, now, rate))
+	}
+
+	var delay time.Duration
+	for _, b := range buckets {
+		delay = max(delay, b.reserve(now, n))
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Uploads are not sent when the wait is aborted, so their tokens are
+		// given back. Downloads have already been received.
+		if upload {
+			for _, b := range buckets {
+				b.refund(n)
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// classBuckets shares a global rate between the classes of peers which
+// recently transferred data, in proportion of their weights.
+type classBuckets struct {
+	rate    float64
+	weights [numPeerClasses]float64
+
+	mu       sync.Mutex
+	buckets  [numPeerClasses]*byteBucket
+	lastUsed [numPeerClasses]time.Time
+}
+
+func newClassBuckets(rate, priorityWeight float64) *classBuckets {
+	if rate <= 0 {
+		return nil
+	}
+	cb := &classBuckets{rate: rate}
+	cb.weights[PeerClassDefault] = 1
+	cb.weights[PeerClassPriority] = priorityWeight
+	for i := range cb.buckets {
+		cb.buckets[i] = newByteBucket(rate)
+	}
+	return cb
+}
+
+// bucket returns the bucket of class, after updating the shares of the
+// classes.
+func (cb *classBuckets) bucket(now time.Time, class PeerClass) *byteBucket {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.lastUsed[class] = now
+	var total float64
+	for c, last := range cb.lastUsed {
+		if now.Sub(last) < bandwidthActiveWindow {
+			total += cb.weights[c]
+		}
+	}
+	for c, last := range cb.lastUsed {
+		if now.Sub(last) < bandwidthActiveWindow {
+			cb.buckets[c].setRate(now, cb.rate*cb.weights[c]/total)
+		}
+	}
+	return cb.buckets[class]
+}
+
+type peerBuckets struct {
+	mu       sync.Mutex
+	upload   *byteBucket
+	download *byteBucket
+}
+
+// bucket returns the upload or download bucket of the peer, with the given
+// rate, which changes with the class of the peer.
+func (pb *peerBuckets) bucket(upload bool, now time.Time, rate float64) *byteBucket {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	b := &pb.download
+	if upload {
+		b = &pb.upload
+	}
+	if *b == nil {
+		*b = newByteBucket(rate)
+	} else {
+		(*b).setRate(now, rate)
+	}
+	return *b
+}
+
+// byteBucket is a token bucket which can go into debt, so that transfers
+// larger than the burst are allowed, and delay the following ones.
+type byteBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteBucket(rate float64) *byteBucket {
+	return &byteBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last update. The burst is one
+// second worth of tokens. The lock must be held.
+func (b *byteBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.rate, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (b *byteBucket) setRate(now time.Time, rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.rate = rate
+}
+
+// reserve takes n tokens, and returns how long to wait until the bucket is out
+// of debt.
+func (b *byteBucket) reserve(now time.Time, n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *byteBucket) refund(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.rate, b.tokens+float64(n))
+}
+
+type bandwidthReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *BandwidthLimiter
+	peer    peer.ID
+}
+
+func (br *bandwidthReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	if n > 0 {
+		if werr := br.limiter.WaitDownload(br.ctx, br.peer, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
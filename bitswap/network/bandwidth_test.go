@@ -0,0 +1,103 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-test/random"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	ctx := context.Background()
+	peers := random.Peers(2)
+
+	t.Run("Nil limiter", func(t *testing.T) {
+		var l *BandwidthLimiter
+		require.NoError(t, l.WaitUpload(ctx, peers[0], 1<<30))
+		require.NoError(t, l.WaitDownload(ctx, peers[0], 1<<30))
+		r := bytes.NewReader(nil)
+		require.Equal(t, io.Reader(r), l.NewReader(ctx, peers[0], r))
+	})
+
+	t.Run("Per-peer limits", func(t *testing.T) {
+		l := NewBandwidthLimiter(BandwidthLimits{PeerUpload: 10000})
+
+		// The burst is sent right away, and the following bytes are
+		// delayed.
+		start := time.Now()
+		require.NoError(t, l.WaitUpload(ctx, peers[0], 10000))
+		require.Less(t, time.Since(start), 100*time.Millisecond)
+		require.NoError(t, l.WaitUpload(ctx, peers[0], 2000))
+		require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+		// Other peers and downloads have their own budget.
+		start = time.Now()
+		require.NoError(t, l.WaitUpload(ctx, peers[1], 10000))
+		require.NoError(t, l.WaitDownload(ctx, peers[0], 1<<20))
+		require.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("Global limits", func(t *testing.T) {
+		l := NewBandwidthLimiter(BandwidthLimits{Download: 10000})
+
+		start := time.Now()
+		require.NoError(t, l.WaitDownload(ctx, peers[0], 10000))
+		require.NoError(t, l.WaitDownload(ctx, peers[1], 2000))
+		require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	})
+
+	t.Run("Aborted uploads are refunded", func(t *testing.T) {
+		l := NewBandwidthLimiter(BandwidthLimits{Upload: 10000})
+		require.NoError(t, l.WaitUpload(ctx, peers[0], 10000))
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		require.ErrorIs(t, l.WaitUpload(cctx, peers[0], 1<<20), context.Canceled)
+
+		start := time.Now()
+		require.NoError(t, l.WaitUpload(ctx, peers[0], 1000))
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("Priority peers", func(t *testing.T) {
+		l := NewBandwidthLimiter(BandwidthLimits{
+			Upload:     10000,
+			PeerUpload: 1000,
+			Classify: PeeringClassifier(func(p peer.ID) bool {
+				return p == peers[1]
+			}),
+		})
+
+		// Priority peers have a larger per-peer budget.
+		start := time.Now()
+		require.NoError(t, l.WaitUpload(ctx, peers[1], 4000))
+		require.Less(t, time.Since(start), 100*time.Millisecond)
+
+		// When both classes are active, priority peers get a larger share
+		// of the global rate.
+		now := time.Now()
+		require.Equal(t, 10000.0, l.upload.bucket(now, PeerClassPriority).rate)
+		require.Equal(t, 2000.0, l.upload.bucket(now, PeerClassDefault).rate)
+		require.Equal(t, 8000.0, l.upload.buckets[PeerClassPriority].rate)
+
+		// Inactive classes lose their share.
+		later := now.Add(2 * bandwidthActiveWindow)
+		require.Equal(t, 10000.0, l.upload.bucket(later, PeerClassDefault).rate)
+	})
+
+	t.Run("Reader", func(t *testing.T) {
+		l := NewBandwidthLimiter(BandwidthLimits{PeerDownload: 10000})
+		data := random.Bytes(12000)
+
+		start := time.Now()
+		read, err := io.ReadAll(l.NewReader(ctx, peers[0], bytes.NewReader(data)))
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+		require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	})
+}
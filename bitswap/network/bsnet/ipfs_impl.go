@@ -45,6 +45,7 @@ func NewFromIpfsHost(host host.Host, opts ...NetOpt) iface.BitSwapNetwork {
 		protocolBitswap:        s.ProtocolPrefix + ProtocolBitswap,
 
 		supportedProtocols: s.SupportedProtocols,
+		bandwidth:          s.BandwidthLimiter,
 
 		metrics: newMetrics(),
 	}
@@ -83,6 +84,8 @@ type impl struct {
 	// inbound messages from the network are forwarded to the receiver
 	receivers []iface.Receiver
 
+	bandwidth *iface.BandwidthLimiter
+
 	metrics *metrics
 }
 
@@ -284,6 +287,11 @@ func (bsnet *impl) SupportsHave(proto protocol.ID) bool {
 }
 
 func (bsnet *impl) msgToStream(ctx context.Context, s network.Stream, msg bsmsg.BitSwapMessage, timeout time.Duration) error {
+	// The send timeout only starts once the bandwidth allows the message.
+	if err := bsnet.bandwidth.WaitUpload(ctx, s.Conn().RemotePeer(), msg.Size()); err != nil {
+		return err
+	}
+
 	deadline := time.Now().Add(timeout)
 	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
 		deadline = dl
@@ -428,7 +436,7 @@ func (bsnet *impl) handleNewStream(s network.Stream) {
 		return
 	}
 
-	reader := msgio.NewVarintReaderSize(s, network.MessageSizeMax)
+	reader := msgio.NewVarintReaderSize(bsnet.bandwidth.NewReader(context.Background(), s.Conn().RemotePeer(), s), network.MessageSizeMax)
 	for {
 		received, size, err := bsmsg.FromMsgReader(reader)
 		if err != nil {
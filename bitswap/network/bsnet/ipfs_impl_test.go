@@ -671,3 +671,57 @@ func TestNetworkCounters(t *testing.T) {
 		testNetworkCounters(t, 10-n, n)
 	}
 }
+
+func TestBandwidthLimiter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New()
+	defer mn.Close()
+
+	p1 := tnet.RandIdentityOrFatal(t)
+	p2 := tnet.RandIdentityOrFatal(t)
+	h1, err := mn.AddPeer(p1.PrivateKey(), p1.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := mn.AddPeer(p2.PrivateKey(), p2.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := network.NewBandwidthLimiter(network.BandwidthLimits{PeerUpload: 10000})
+	bsnet1 := bsnet.NewFromIpfsHost(h1, bsnet.BandwidthLimiter(limiter))
+	bsnet2 := bsnet.NewFromIpfsHost(h2)
+	r1 := newReceiver()
+	r2 := newReceiver()
+	bsnet1.Start(r1)
+	t.Cleanup(bsnet1.Stop)
+	bsnet2.Start(r2)
+	t.Cleanup(bsnet2.Stop)
+
+	if err = mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err = bsnet1.Connect(ctx, peer.AddrInfo{ID: p2.ID()}); err != nil {
+		t.Fatal(err)
+	}
+	<-r1.connectionEvent
+
+	// The first message fits in the burst, and the second one waits for the
+	// bandwidth to be available.
+	start := time.Now()
+	for _, blk := range random.BlocksOfSize(2, 8000) {
+		msg := bsmsg.New(false)
+		msg.AddBlock(blk)
+		if err = bsnet1.SendMessage(ctx, p2.ID(), msg); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("did not receive message sent")
+		case <-r2.messageReceived:
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("messages were sent in %s, faster than the bandwidth limit", elapsed)
+	}
+}
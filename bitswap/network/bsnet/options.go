@@ -1,12 +1,16 @@
 package bsnet
 
-import "github.com/libp2p/go-libp2p/core/protocol"
+import (
+	iface "github.com/ipfs/boxo/bitswap/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
 
 type NetOpt func(*Settings)
 
 type Settings struct {
 	ProtocolPrefix     protocol.ID
 	SupportedProtocols []protocol.ID
+	BandwidthLimiter   *iface.BandwidthLimiter
 }
 
 func Prefix(prefix protocol.ID) NetOpt {
@@ -20,3 +24,11 @@ func SupportedProtocols(protos []protocol.ID) NetOpt {
 		settings.SupportedProtocols = protos
 	}
 }
+
+// BandwidthLimiter limits the rate of the messages sent to and received from
+// peers with the given limiter.
+func BandwidthLimiter(l *iface.BandwidthLimiter) NetOpt {
+	return func(settings *Settings) {
+		settings.BandwidthLimiter = l
+	}
+}
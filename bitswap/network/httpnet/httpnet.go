@@ -155,6 +155,14 @@ func WithHTTPWorkers(n int) Option {
 	}
 }
 
+// WithBandwidthLimiter limits the rate at which blocks are downloaded from
+// peers with the given limiter.
+func WithBandwidthLimiter(l *network.BandwidthLimiter) Option {
+	return func(net *Network) {
+		net.bandwidth = l
+	}
+}
+
 type Network struct {
 	// NOTE: Stats must be at the top of the heap allocation to ensure 64bit
 	// alignment.
@@ -183,6 +191,7 @@ type Network struct {
 	httpWorkers             int
 	allowlist               map[string]struct{}
 	denylist                map[string]struct{}
+	bandwidth               *network.BandwidthLimiter
 
 	metrics      *metrics
 	httpRequests chan httpRequestInfo
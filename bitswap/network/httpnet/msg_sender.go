@@ -255,7 +255,7 @@ func (sender *httpMsgSender) tryURL(ctx context.Context, u *senderURL, entry bsm
 
 	// Handle responses
 	limReader := &io.LimitedReader{
-		R: resp.Body,
+		R: sender.ht.bandwidth.NewReader(ctx, sender.peer, resp.Body),
 		N: sender.ht.maxBlockSize,
 	}
 
@@ -6,6 +6,7 @@ import (
 	"github.com/ipfs/boxo/bitswap/client"
 	"github.com/ipfs/boxo/bitswap/server"
 	"github.com/ipfs/boxo/bitswap/tracer"
+	ds "github.com/ipfs/go-datastore"
 	delay "github.com/ipfs/go-ipfs-delay"
 )
 
@@ -51,6 +52,12 @@ func WithPeerBlockRequestFilter(pbrf server.PeerBlockRequestFilter) Option {
 	return Option{server.WithPeerBlockRequestFilter(pbrf)}
 }
 
+// WithCreditStrategy prioritizes peers by debt ratio and throttles peers
+// which owe more than maxDebt bytes. See [server.WithCreditStrategy].
+func WithCreditStrategy(maxDebt uint64) Option {
+	return Option{server.WithCreditStrategy(maxDebt)}
+}
+
 func WithScoreLedger(scoreLedger server.ScoreLedger) Option {
 	return Option{server.WithScoreLedger(scoreLedger)}
 }
@@ -94,6 +101,17 @@ func WithoutDuplicatedBlockStats() Option {
 	return Option{client.WithoutDuplicatedBlockStats()}
 }
 
+// WithWantlistPersistence makes the client persist the wantlists of its
+// sessions and resume them after a restart. See
+// [client.WithWantlistPersistence].
+func WithWantlistPersistence(d ds.Datastore) Option {
+	return Option{client.WithWantlistPersistence(d)}
+}
+
+func WithWantlistResumeTimeout(timeout time.Duration) Option {
+	return Option{client.WithWantlistResumeTimeout(timeout)}
+}
+
 func WithTracer(tap tracer.Tracer) Option {
 	// Only trace the server, both receive the same messages anyway
 	return Option{
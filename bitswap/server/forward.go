@@ -13,4 +13,10 @@ type (
 	ScorePeerFunc          = decision.ScorePeerFunc
 	PeerLedger             = decision.PeerLedger
 	PeerEntry              = decision.PeerEntry
+	PersistentScoreLedger  = decision.PersistentScoreLedger
+	LedgerRecord           = decision.LedgerRecord
 )
+
+// DefaultLedgerHalfLife is the default time after which the accounting
+// persisted by a [PersistentScoreLedger] is halved.
+const DefaultLedgerHalfLife = decision.DefaultLedgerHalfLife
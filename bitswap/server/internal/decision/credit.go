@@ -0,0 +1,110 @@
+package decision
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-peertaskqueue/peertask"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// WithCreditStrategy enables the credit-based strategy of the original
+// bitswap paper: the engine serves first the peers with the lowest debt
+// ratio, that is the peers which uploaded the most to us relative to what we
+// sent them, and denies the requests of peers which downloaded more than
+// maxDebt bytes beyond what they uploaded to us. Denied requests get a
+// DONT_HAVE response when the peer asked for one. Throttled peers are served
+// again as soon as they upload enough blocks. A maxDebt of zero disables
+// throttling and only keeps the prioritization.
+//
+// The debt is read from the receipts of the [ScoreLedger]. Pair it with a
+// [PersistentScoreLedger] so that peers cannot reset their debt by
+// reconnecting. It is combined with the [TaskComparator] and
+// [PeerBlockRequestFilter] options: the comparator orders the tasks of
+// peers with the same debt ratio, and requests must pass both the filter and
+// the debt check.
+func WithCreditStrategy(maxDebt uint64) Option {
+	return func(e *Engine) {
+		e.creditStrategy = true
+		e.maxDebt = maxDebt
+	}
+}
+
+// creditRatios keeps the debt ratios of the peers, as they were when tasks
+// were last pushed for them. The task queue only re-sorts a peer when its
+// tasks change, so the ratios used to order it must not change in between,
+// and reading them must not lock the ledger while the queue is locked.
+type creditRatios struct {
+	ledger ScoreLedger
+
+	lock   sync.RWMutex
+	ratios map[peer.ID]float64
+}
+
+func newCreditRatios(ledger ScoreLedger) *creditRatios {
+	return &creditRatios{
+		ledger: ledger,
+		ratios: make(map[peer.ID]float64),
+	}
+}
+
+// update snapshots the debt ratio of p, before tasks are pushed for it.
+func (cr *creditRatios) update(p peer.ID) {
+	r := debtRatio(cr.ledger, p)
+	cr.lock.Lock()
+	cr.ratios[p] = r
+	cr.lock.Unlock()
+}
+
+// remove forgets the debt ratio of a disconnected peer.
+func (cr *creditRatios) remove(p peer.ID) {
+	cr.lock.Lock()
+	delete(cr.ratios, p)
+	cr.lock.Unlock()
+}
+
+func (cr *creditRatios) ratio(p peer.ID) float64 {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+	return cr.ratios[p]
+}
+
+// creditTaskComparator prioritizes the tasks of the peers with the lowest
+// debt ratio. Tasks of the same peer, or of peers with the same debt ratio,
+// are ordered by next.
+func creditTaskComparator(ratios *creditRatios, next peertask.QueueTaskComparator) peertask.QueueTaskComparator {
+	return func(a, b *peertask.QueueTask) bool {
+		if a.Target != b.Target {
+			ra := ratios.ratio(a.Target)
+			rb := ratios.ratio(b.Target)
+			if ra != rb {
+				return ra < rb
+			}
+		}
+		return next(a, b)
+	}
+}
+
+// creditRequestFilter denies the requests of peers which owe us more than
+// maxDebt bytes, and otherwise defers to next when it is not nil.
+func creditRequestFilter(ledger ScoreLedger, maxDebt uint64, next PeerBlockRequestFilter) PeerBlockRequestFilter {
+	return func(p peer.ID, c cid.Cid) bool {
+		if maxDebt != 0 {
+			r := ledger.GetReceipt(p)
+			if r != nil && r.Sent > r.Recv && r.Sent-r.Recv > maxDebt {
+				log.Debugw("Bitswap engine: peer exceeded its debt", "peer", p, "sent", r.Sent, "recv", r.Recv)
+				return false
+			}
+		}
+		return next == nil || next(p, c)
+	}
+}
+
+// debtRatio returns the debt ratio of p, or zero when the ledger has no
+// receipt for it.
+func debtRatio(ledger ScoreLedger, p peer.ID) float64 {
+	if r := ledger.GetReceipt(p); r != nil {
+		return r.Value
+	}
+	return 0
+}
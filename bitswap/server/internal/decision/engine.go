@@ -221,6 +221,12 @@ type Engine struct {
 
 	peerBlockRequestFilter PeerBlockRequestFilter
 
+	// creditStrategy enables the prioritization and throttling of peers
+	// based on their debt, see WithCreditStrategy.
+	creditStrategy bool
+	maxDebt        uint64
+	creditRatios   *creditRatios
+
 	bstoreWorkerCount          int
 	maxOutstandingBytesPerPeer int
 
@@ -428,8 +434,19 @@ func NewEngine(
 		peertaskqueue.MaxOutstandingWorkPerPeer(e.maxOutstandingBytesPerPeer),
 	}
 
+	var queueTaskComparator peertask.QueueTaskComparator
 	if e.taskComparator != nil {
-		queueTaskComparator := wrapTaskComparator(e.taskComparator)
+		queueTaskComparator = wrapTaskComparator(e.taskComparator)
+	}
+	if e.creditStrategy {
+		if queueTaskComparator == nil {
+			queueTaskComparator = peertask.PriorityCompare
+		}
+		e.creditRatios = newCreditRatios(e.scoreLedger)
+		queueTaskComparator = creditTaskComparator(e.creditRatios, queueTaskComparator)
+		e.peerBlockRequestFilter = creditRequestFilter(e.scoreLedger, e.maxDebt, e.peerBlockRequestFilter)
+	}
+	if queueTaskComparator != nil {
 		peerTaskQueueOpts = append(peerTaskQueueOpts, peertaskqueue.PeerComparator(peertracker.TaskPriorityPeerComparator(queueTaskComparator)))
 		peerTaskQueueOpts = append(peerTaskQueueOpts, peertaskqueue.TaskComparator(queueTaskComparator))
 	}
@@ -814,6 +831,7 @@ func (e *Engine) MessageReceived(ctx context.Context, p peer.ID, m bsmsg.BitSwap
 
 	// Push entries onto the request queue and signal network that new work is ready.
 	if len(activeEntries) != 0 {
+		e.updateCreditRatio(p)
 		e.peerRequestQueue.PushTasksTruncated(e.maxQueuedWantlistEntriesPerPeer, p, activeEntries...)
 		e.updateMetrics()
 		e.signalNewWork()
@@ -1017,6 +1035,7 @@ func (e *Engine) NotifyNewBlocks(blks []blocks.Block) {
 				entrySize = bsmsg.BlockPresenceSize(k)
 			}
 
+			e.updateCreditRatio(entry.Peer)
 			e.peerRequestQueue.PushTasksTruncated(e.maxQueuedWantlistEntriesPerPeer, entry.Peer, peertask.Task{
 				Topic:    k,
 				Priority: int(entry.Priority),
@@ -1082,6 +1101,17 @@ func (e *Engine) PeerDisconnected(p peer.ID) {
 
 	e.peerLedger.PeerDisconnected(p)
 	e.scoreLedger.PeerDisconnected(p)
+	if e.creditRatios != nil {
+		e.creditRatios.remove(p)
+	}
+}
+
+// updateCreditRatio snapshots the debt ratio of p used to order its tasks,
+// before tasks are pushed for it, see creditRatios.
+func (e *Engine) updateCreditRatio(p peer.ID) {
+	if e.creditRatios != nil {
+		e.creditRatios.update(p)
+	}
 }
 
 // If the want is a want-have, and it's below a certain size, send the full
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -22,6 +23,7 @@ import (
 	"github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-peertaskqueue/peertask"
 	"github.com/ipfs/go-test/random"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 	libp2ptest "github.com/libp2p/go-libp2p/core/test"
@@ -1935,3 +1937,120 @@ func findCid(c cid.Cid, wantList []wl.Entry) bool {
 	}
 	return false
 }
+
+func TestCreditStrategy(t *testing.T) {
+	keys := []string{"a", "b", "c", "d"}
+	blks := make([]blocks.Block, 0, len(keys))
+	for _, letter := range keys {
+		blks = append(blks, blocks.NewBlock([]byte(letter)))
+	}
+
+	fpt := &fakePeerTagger{}
+	sl := NewTestScoreLedger(shortTerm, nil, clock.New())
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	if err := bs.PutMany(ctx, blks); err != nil {
+		t.Fatal(err)
+	}
+
+	// use a single task worker so that the order of outgoing messages is deterministic
+	e := newEngineForTesting(bs, fpt, "localhost", 0, WithScoreLedger(sl), WithBlockstoreWorkerCount(4), WithTaskWorkerCount(1),
+		WithCreditStrategy(5000),
+	)
+	defer e.Close()
+
+	// Peers are listed from the lowest to the highest debt ratio. The last
+	// one owes more than the maximum debt.
+	peerIDs := make([]peer.ID, len(keys))
+	for i := range peerIDs {
+		peerIDs[i] = libp2ptest.RandPeerIDFatal(t)
+	}
+	sl.AddToSentBytes(peerIDs[0], 1000)
+	sl.AddToReceivedBytes(peerIDs[0], 4000)
+	sl.AddToSentBytes(peerIDs[1], 1000)
+	sl.AddToReceivedBytes(peerIDs[1], 1000)
+	sl.AddToSentBytes(peerIDs[2], 1000)
+	sl.AddToSentBytes(peerIDs[3], 6000)
+
+	// Add the wants in reverse order of expected service.
+	for i := len(keys) - 1; i >= 0; i-- {
+		partnerWantBlocks(e, keys[i:i+1], peerIDs[i])
+	}
+
+	for i, peerID := range peerIDs {
+		next := <-e.Outbox()
+		envelope := <-next
+		if peerID != envelope.Peer {
+			t.Fatalf("expected message for peer %d but instead got message for peer %s", i, envelope.Peer)
+		}
+		if i < len(keys)-1 {
+			responseBlocks := envelope.Message.Blocks()
+			if len(responseBlocks) != 1 || responseBlocks[0].Cid() != blks[i].Cid() {
+				t.Fatalf("expected block %s for peer %d", keys[i], i)
+			}
+			envelope.Sent()
+			continue
+		}
+
+		// The freeloader is denied.
+		if len(envelope.Message.Blocks()) != 0 || len(envelope.Message.DontHaves()) != 1 {
+			t.Fatal("expected the peer above the maximum debt to be denied")
+		}
+		envelope.Sent()
+	}
+
+	// It is served again after uploading enough data.
+	sl.AddToReceivedBytes(peerIDs[3], 2000)
+	partnerWantBlocks(e, keys[3:], peerIDs[3])
+	next := <-e.Outbox()
+	envelope := <-next
+	if envelope.Peer != peerIDs[3] || len(envelope.Message.Blocks()) != 1 {
+		t.Fatal("expected the peer to be served after repaying its debt")
+	}
+}
+
+// receiptCountingLedger counts the calls to GetReceipt.
+type receiptCountingLedger struct {
+	*DefaultScoreLedger
+	receipts atomic.Int32
+}
+
+func (l *receiptCountingLedger) GetReceipt(p peer.ID) *Receipt {
+	l.receipts.Add(1)
+	return l.DefaultScoreLedger.GetReceipt(p)
+}
+
+func TestCreditTaskComparator(t *testing.T) {
+	sl := &receiptCountingLedger{DefaultScoreLedger: NewTestScoreLedger(shortTerm, nil, clock.New())}
+	ratios := newCreditRatios(sl)
+	less := creditTaskComparator(ratios, peertask.PriorityCompare)
+
+	peers := []peer.ID{libp2ptest.RandPeerIDFatal(t), libp2ptest.RandPeerIDFatal(t)}
+	sl.AddToSentBytes(peers[0], 1000)
+	sl.AddToSentBytes(peers[1], 500)
+	for _, p := range peers {
+		ratios.update(p)
+	}
+	a := &peertask.QueueTask{Task: peertask.Task{Priority: 1}, Target: peers[0]}
+	b := &peertask.QueueTask{Task: peertask.Task{Priority: 1}, Target: peers[1]}
+	sl.receipts.Store(0)
+	if less(a, b) || !less(b, a) {
+		t.Fatal("expected the peer with the lowest debt to come first")
+	}
+
+	// The ledger is not read while comparing, and the order only changes
+	// when the ratios are updated.
+	sl.AddToReceivedBytes(peers[0], 10000)
+	if less(a, b) {
+		t.Fatal("expected the order to change only with updates")
+	}
+	if n := sl.receipts.Load(); n != 0 {
+		t.Fatalf("expected no receipt to be read by the comparator, got %d", n)
+	}
+	ratios.update(peers[0])
+	if !less(a, b) {
+		t.Fatal("expected the peer which repaid its debt to come first")
+	}
+}
@@ -0,0 +1,540 @@
+package decision
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// DefaultLedgerHalfLife is the default time after which the persisted
+	// accounting of a peer is halved.
+	DefaultLedgerHalfLife = 7 * 24 * time.Hour
+
+	// how frequently the ledgers are written to the datastore
+	ledgerFlushInterval = time.Minute
+
+	// how frequently the ledgers are decayed
+	ledgerDecayInterval = time.Hour
+
+	// maximum number of ledgers of disconnected peers kept. The ledgers of
+	// the peers without exchanges for the longest time are forgotten first.
+	maxLedgerRecords = 100_000
+)
+
+// ledgerPrefix is the datastore key prefix of the persisted ledgers.
+var ledgerPrefix = ds.NewKey("/bitswap/ledger")
+
+// LedgerRecord is the accounting of a peer kept by a [PersistentScoreLedger].
+type LedgerRecord struct {
+	Peer peer.ID
+	// Sent and Recv are the bytes sent to and received from the peer, and
+	// Exchanged the number of exchanges with it.
+	Sent      uint64
+	Recv      uint64
+	Exchanged uint64
+	// LastExchange is the time of the last exchange with the peer.
+	LastExchange time.Time
+	// Reputation is the long-term usefulness of the peer, between 0 and 10.
+	Reputation float64
+	// Adjustment is added to the score of the peer, see
+	// [PersistentScoreLedger.Adjust].
+	Adjustment int
+	// Connected is whether the peer is currently connected.
+	Connected bool
+}
+
+// storedLedger is the datastore encoding of a ledger. The counters are not
+// rounded, so that small ones keep decaying.
+type storedLedger struct {
+	Sent         float64   `json:"sent"`
+	Recv         float64   `json:"recv"`
+	Exchanged    float64   `json:"exchanged"`
+	LastExchange time.Time `json:"last_exchange"`
+	Reputation   float64   `json:"reputation"`
+	Adjustment   int       `json:"adjustment,omitempty"`
+	DecayedAt    time.Time `json:"decayed_at"`
+}
+
+// PersistentScoreLedger is a [ScoreLedger] which persists the accounting of
+// peers in a datastore, so that the peers which were good partners are still
+// known after a restart. The accounting is reloaded when peers reconnect, and
+// decays over time with a configurable half-life, so that old exchanges weigh
+// less than recent ones. Operators can inspect the ledgers with Records and
+// adjust the score of peers with Adjust.
+type PersistentScoreLedger struct {
+	*DefaultScoreLedger
+
+	ds       ds.Datastore
+	halfLife time.Duration
+
+	flushInterval time.Duration
+	decayInterval time.Duration
+	maxRecords    int
+	done          chan struct{}
+
+	// The following fields are protected by the lock of the
+	// DefaultScoreLedger.
+	//
+	// records are the ledgers of the peers which are not connected.
+	records map[peer.ID]*storedLedger
+	// dirty and deleted are the peers whose ledger must be written to or
+	// deleted from the datastore.
+	dirty   map[peer.ID]struct{}
+	deleted map[peer.ID]struct{}
+	started bool
+}
+
+// NewPersistentScoreLedger creates a [PersistentScoreLedger] storing the
+// ledgers in the given datastore, and loads those stored by previous runs. A
+// zero halfLife defaults to [DefaultLedgerHalfLife].
+func NewPersistentScoreLedger(ctx context.Context, d ds.Datastore, halfLife time.Duration) (*PersistentScoreLedger, error) {
+	if d == nil {
+		return nil, errors.New("missing ledger datastore")
+	}
+	if halfLife <= 0 {
+		halfLife = DefaultLedgerHalfLife
+	}
+
+	psl := &PersistentScoreLedger{
+		DefaultScoreLedger: NewDefaultScoreLedger(),
+		ds:                 d,
+		halfLife:           halfLife,
+		flushInterval:      ledgerFlushInterval,
+		decayInterval:      ledgerDecayInterval,
+		maxRecords:         maxLedgerRecords,
+		done:               make(chan struct{}),
+		records:            make(map[peer.ID]*storedLedger),
+		dirty:              make(map[peer.ID]struct{}),
+		deleted:            make(map[peer.ID]struct{}),
+	}
+	psl.onNew = psl.restore
+	psl.onRemove = psl.store
+
+	if err := psl.load(ctx); err != nil {
+		return nil, err
+	}
+	psl.lock.Lock()
+	psl.decay(psl.clock.Now())
+	psl.prune()
+	psl.lock.Unlock()
+	return psl, nil
+}
+
+func (psl *PersistentScoreLedger) load(ctx context.Context) error {
+	results, err := psl.ds.Query(ctx, query.Query{Prefix: ledgerPrefix.String()})
+	if err != nil {
+		return fmt.Errorf("cannot query ledgers: %w", err)
+	}
+	defer results.Close()
+
+	for res := range results.Next() {
+		if res.Error != nil {
+			return fmt.Errorf("cannot load ledgers: %w", res.Error)
+		}
+		p, err := peer.Decode(ds.RawKey(res.Key).BaseNamespace())
+		if err != nil {
+			log.Warnw("ignoring ledger with invalid peer ID", "key", res.Key, "error", err)
+			continue
+		}
+		var rec storedLedger
+		if err := json.Unmarshal(res.Value, &rec); err != nil {
+			log.Warnw("ignoring invalid ledger", "peer", p, "error", err)
+			continue
+		}
+		psl.records[p] = &rec
+	}
+	return nil
+}
+
+// Start starts the sampling process, and the periodic flushes of the ledgers
+// to the datastore.
+func (psl *PersistentScoreLedger) Start(scorePeer ScorePeerFunc) {
+	psl.lock.Lock()
+	psl.started = true
+	psl.lock.Unlock()
+
+	psl.DefaultScoreLedger.Start(scorePeer)
+	go psl.flushWorker()
+}
+
+// Stop stops the sampling process and writes the ledgers to the datastore.
+func (psl *PersistentScoreLedger) Stop() {
+	psl.DefaultScoreLedger.Stop()
+
+	psl.lock.RLock()
+	started := psl.started
+	psl.lock.RUnlock()
+	if started {
+		<-psl.done
+	}
+}
+
+func (psl *PersistentScoreLedger) flushWorker() {
+	defer close(psl.done)
+
+	ticker := psl.clock.Ticker(psl.flushInterval)
+	defer ticker.Stop()
+	lastDecay := psl.clock.Now()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if now.Sub(lastDecay) >= psl.decayInterval {
+				psl.lock.Lock()
+				psl.decay(now)
+				psl.lock.Unlock()
+				lastDecay = now
+			}
+			if err := psl.Flush(context.Background()); err != nil {
+				log.Errorw("failed to flush ledgers", "error", err)
+			}
+		case <-psl.closing:
+			if err := psl.Flush(context.Background()); err != nil {
+				log.Errorw("failed to flush ledgers", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// Flush writes the ledgers which changed since the last flush to the
+// datastore.
+func (psl *PersistentScoreLedger) Flush(ctx context.Context) error {
+	puts := make(map[peer.ID]*storedLedger)
+	psl.lock.Lock()
+	for p, l := range psl.ledgerMap {
+		l.lock.Lock()
+		if l.dirty {
+			puts[p] = l.stored()
+			l.dirty = false
+			delete(psl.deleted, p)
+		}
+		l.lock.Unlock()
+	}
+	for p := range psl.dirty {
+		if rec, ok := psl.records[p]; ok {
+			puts[p] = rec
+		}
+	}
+	deletes := psl.deleted
+	psl.dirty = make(map[peer.ID]struct{})
+	psl.deleted = make(map[peer.ID]struct{})
+	psl.lock.Unlock()
+
+	err := psl.write(ctx, puts, deletes)
+	if err == nil {
+		err = psl.ds.Sync(ctx, ledgerPrefix)
+	}
+	if err != nil {
+		// The ledgers are written again with the next flush, which is
+		// harmless for those which were written.
+		psl.requeue(puts, deletes)
+	}
+	return err
+}
+
+// write writes puts and deletes to the datastore.
+func (psl *PersistentScoreLedger) write(ctx context.Context, puts map[peer.ID]*storedLedger, deletes map[peer.ID]struct{}) error {
+	for p, rec := range puts {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err := psl.ds.Put(ctx, ledgerKey(p), data); err != nil {
+			return fmt.Errorf("cannot store ledger of %s: %w", p, err)
+		}
+	}
+	for p := range deletes {
+		if err := psl.ds.Delete(ctx, ledgerKey(p)); err != nil {
+			return fmt.Errorf("cannot delete ledger of %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// requeue marks the ledgers of a failed flush to be written again, unless
+// they changed since.
+func (psl *PersistentScoreLedger) requeue(puts map[peer.ID]*storedLedger, deletes map[peer.ID]struct{}) {
+	psl.lock.Lock()
+	defer psl.lock.Unlock()
+
+	for p := range puts {
+		if l, ok := psl.ledgerMap[p]; ok {
+			l.lock.Lock()
+			l.dirty = true
+			l.lock.Unlock()
+		} else if _, ok := psl.records[p]; ok {
+			psl.dirty[p] = struct{}{}
+		}
+	}
+	for p := range deletes {
+		_, connected := psl.ledgerMap[p]
+		if _, ok := psl.records[p]; !ok && !connected {
+			psl.deleted[p] = struct{}{}
+		}
+	}
+}
+
+// GetReceipt returns aggregated data communication with a given peer,
+// including past connections.
+func (psl *PersistentScoreLedger) GetReceipt(p peer.ID) *Receipt {
+	psl.lock.RLock()
+	rec, ok := psl.records[p]
+	psl.lock.RUnlock()
+	if !ok {
+		return psl.DefaultScoreLedger.GetReceipt(p)
+	}
+	return &Receipt{
+		Peer:      p.String(),
+		Value:     rec.Sent / (rec.Recv + 1),
+		Sent:      roundCounter(rec.Sent),
+		Recv:      roundCounter(rec.Recv),
+		Exchanged: roundCounter(rec.Exchanged),
+	}
+}
+
+// Records returns the ledgers of all the known peers, connected or not,
+// sorted by peer ID.
+func (psl *PersistentScoreLedger) Records() []LedgerRecord {
+	psl.lock.RLock()
+	records := make([]LedgerRecord, 0, len(psl.ledgerMap)+len(psl.records))
+	for p, l := range psl.ledgerMap {
+		l.lock.RLock()
+		records = append(records, l.stored().record(p, true))
+		l.lock.RUnlock()
+	}
+	for p, rec := range psl.records {
+		records = append(records, rec.record(p, false))
+	}
+	psl.lock.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Peer < records[j].Peer
+	})
+	return records
+}
+
+// Record returns the ledger of a peer, and whether the peer is known.
+func (psl *PersistentScoreLedger) Record(p peer.ID) (LedgerRecord, bool) {
+	psl.lock.RLock()
+	defer psl.lock.RUnlock()
+
+	if l, ok := psl.ledgerMap[p]; ok {
+		l.lock.RLock()
+		defer l.lock.RUnlock()
+		return l.stored().record(p, true), true
+	}
+	if rec, ok := psl.records[p]; ok {
+		return rec.record(p, false), true
+	}
+	return LedgerRecord{Peer: p}, false
+}
+
+// Adjust sets an adjustment added to the score of a peer, to favour or
+// disfavour it manually. The adjustment does not decay, and is persisted with
+// the next flush.
+func (psl *PersistentScoreLedger) Adjust(p peer.ID, adjustment int) {
+	psl.lock.Lock()
+	defer psl.lock.Unlock()
+
+	delete(psl.deleted, p)
+	if l, ok := psl.ledgerMap[p]; ok {
+		l.lock.Lock()
+		l.adjustment = adjustment
+		l.dirty = true
+		l.lock.Unlock()
+		return
+	}
+	rec, ok := psl.records[p]
+	if !ok {
+		rec = &storedLedger{DecayedAt: psl.clock.Now()}
+		psl.records[p] = rec
+	}
+	rec.Adjustment = adjustment
+	psl.dirty[p] = struct{}{}
+}
+
+// Forget resets the accounting and the adjustment of a peer, and deletes its
+// ledger from the datastore with the next flush.
+func (psl *PersistentScoreLedger) Forget(p peer.ID) {
+	psl.lock.Lock()
+	defer psl.lock.Unlock()
+
+	if l, ok := psl.ledgerMap[p]; ok {
+		l.lock.Lock()
+		l.bytesSent, l.bytesRecv, l.exchangeCount = 0, 0, 0
+		l.sentFrac, l.recvFrac, l.exchangedFrac = 0, 0, 0
+		l.lastExchange = time.Time{}
+		l.shortScore, l.longScore = 0, 0
+		l.adjustment = 0
+		// The ledger is deleted, and written again once it changes.
+		l.dirty = false
+		l.lock.Unlock()
+	}
+	delete(psl.records, p)
+	delete(psl.dirty, p)
+	psl.deleted[p] = struct{}{}
+}
+
+// restore loads the stored accounting of a peer into its new ledger. The lock
+// must be held.
+func (psl *PersistentScoreLedger) restore(l *scoreledger) {
+	rec, ok := psl.records[l.partner]
+	if !ok {
+		l.decayedAt = psl.clock.Now()
+		return
+	}
+	_, dirty := psl.dirty[l.partner]
+	delete(psl.records, l.partner)
+	delete(psl.dirty, l.partner)
+
+	l.setCounters(rec)
+	l.lastExchange = rec.LastExchange
+	l.longScore = rec.Reputation
+	l.adjustment = rec.Adjustment
+	l.decayedAt = rec.DecayedAt
+	l.dirty = dirty
+}
+
+// store keeps the accounting of a disconnected peer. The lock must be held.
+func (psl *PersistentScoreLedger) store(l *scoreledger) {
+	l.lock.RLock()
+	psl.records[l.partner] = l.stored()
+	psl.dirty[l.partner] = struct{}{}
+	l.lock.RUnlock()
+
+	// Pruning sorts the ledgers, so it is done once they exceed the limit
+	// by a tenth.
+	if len(psl.records) > psl.maxRecords+psl.maxRecords/10 {
+		psl.prune()
+	}
+}
+
+// prune forgets the ledgers of the disconnected peers without exchanges for
+// the longest time, down to maxRecords. Adjusted peers are kept. The lock
+// must be held.
+func (psl *PersistentScoreLedger) prune() {
+	excess := len(psl.records) - psl.maxRecords
+	if excess <= 0 {
+		return
+	}
+	peers := make([]peer.ID, 0, len(psl.records))
+	for p, rec := range psl.records {
+		if rec.Adjustment == 0 {
+			peers = append(peers, p)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return psl.records[peers[i]].LastExchange.Before(psl.records[peers[j]].LastExchange)
+	})
+	for _, p := range peers[:min(excess, len(peers))] {
+		delete(psl.records, p)
+		delete(psl.dirty, p)
+		psl.deleted[p] = struct{}{}
+	}
+}
+
+// decay decays the accounting of all the peers, and forgets those which have
+// decayed entirely. The lock must be held.
+func (psl *PersistentScoreLedger) decay(now time.Time) {
+	for _, l := range psl.ledgerMap {
+		l.lock.Lock()
+		rec := l.stored()
+		if rec.decay(now, psl.halfLife) {
+			l.setCounters(rec)
+			l.longScore = rec.Reputation
+			l.decayedAt = rec.DecayedAt
+			l.dirty = true
+		}
+		l.lock.Unlock()
+	}
+	for p, rec := range psl.records {
+		if !rec.decay(now, psl.halfLife) {
+			continue
+		}
+		if rec.Sent < 1 && rec.Recv < 1 && rec.Exchanged < 1 && rec.Reputation < 0.01 && rec.Adjustment == 0 {
+			delete(psl.records, p)
+			delete(psl.dirty, p)
+			psl.deleted[p] = struct{}{}
+			continue
+		}
+		psl.dirty[p] = struct{}{}
+	}
+}
+
+// stored returns the accounting of the ledger to persist. The ledger lock
+// must be held.
+func (l *scoreledger) stored() *storedLedger {
+	return &storedLedger{
+		Sent:         float64(l.bytesSent) + l.sentFrac,
+		Recv:         float64(l.bytesRecv) + l.recvFrac,
+		Exchanged:    float64(l.exchangeCount) + l.exchangedFrac,
+		LastExchange: l.lastExchange,
+		Reputation:   l.longScore,
+		Adjustment:   l.adjustment,
+		DecayedAt:    l.decayedAt,
+	}
+}
+
+// setCounters sets the counters of the ledger to the decayed ones of rec,
+// keeping their fractional parts. The ledger lock must be held.
+func (l *scoreledger) setCounters(rec *storedLedger) {
+	l.bytesSent, l.sentFrac = splitCounter(rec.Sent)
+	l.bytesRecv, l.recvFrac = splitCounter(rec.Recv)
+	l.exchangeCount, l.exchangedFrac = splitCounter(rec.Exchanged)
+}
+
+func splitCounter(c float64) (uint64, float64) {
+	i := math.Floor(c)
+	return uint64(i), c - i
+}
+
+func roundCounter(c float64) uint64 {
+	return uint64(math.Round(c))
+}
+
+// decay halves the accounting every halfLife since it was last decayed, and
+// returns whether it changed.
+func (rec *storedLedger) decay(now time.Time, halfLife time.Duration) bool {
+	if rec.DecayedAt.IsZero() {
+		rec.DecayedAt = now
+		return true
+	}
+	elapsed := now.Sub(rec.DecayedAt)
+	if elapsed <= 0 {
+		return false
+	}
+	factor := math.Pow(0.5, float64(elapsed)/float64(halfLife))
+	rec.Sent *= factor
+	rec.Recv *= factor
+	rec.Exchanged *= factor
+	rec.Reputation *= factor
+	rec.DecayedAt = now
+	return true
+}
+
+func (rec *storedLedger) record(p peer.ID, connected bool) LedgerRecord {
+	return LedgerRecord{
+		Peer:         p,
+		Sent:         roundCounter(rec.Sent),
+		Recv:         roundCounter(rec.Recv),
+		Exchanged:    roundCounter(rec.Exchanged),
+		LastExchange: rec.LastExchange,
+		Reputation:   rec.Reputation,
+		Adjustment:   rec.Adjustment,
+		Connected:    connected,
+	}
+}
+
+func ledgerKey(p peer.ID) ds.Key {
+	return ledgerPrefix.ChildString(p.String())
+}
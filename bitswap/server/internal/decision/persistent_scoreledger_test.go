@@ -0,0 +1,312 @@
+package decision
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-clock"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/failstore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-test/random"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPersistentScoreLedger(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	peers := random.Peers(2)
+	p := peers[0]
+
+	psl, err := NewPersistentScoreLedger(ctx, d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psl.PeerConnected(p)
+	psl.AddToSentBytes(p, 100)
+	psl.AddToReceivedBytes(p, 300)
+	psl.PeerDisconnected(p)
+
+	// Disconnected peers are still known.
+	if r := psl.GetReceipt(p); r.Sent != 100 || r.Recv != 300 || r.Exchanged != 2 {
+		t.Fatalf("unexpected receipt after disconnection: %+v", r)
+	}
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ledgers are reloaded after a restart.
+	psl, err = NewPersistentScoreLedger(ctx, d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := psl.GetReceipt(p); r.Sent != 100 || r.Recv != 300 {
+		t.Fatalf("unexpected receipt after restart: %+v", r)
+	}
+	rec, ok := psl.Record(p)
+	if !ok || rec.Connected {
+		t.Fatalf("unexpected record after restart: %+v", rec)
+	}
+
+	// And restored when the peer reconnects.
+	psl.PeerConnected(p)
+	psl.AddToSentBytes(p, 50)
+	rec, ok = psl.Record(p)
+	if !ok || !rec.Connected || rec.Sent != 150 || rec.Exchanged != 3 {
+		t.Fatalf("unexpected record after reconnection: %+v", rec)
+	}
+	if records := psl.Records(); len(records) != 1 || records[0].Peer != p {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// Forgotten peers are deleted from the datastore.
+	psl.Forget(p)
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	psl.PeerDisconnected(p)
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r := psl.GetReceipt(p); r.Sent != 0 || r.Exchanged != 0 {
+		t.Fatalf("unexpected receipt after forgetting: %+v", r)
+	}
+}
+
+func TestPersistentScoreLedgerDecay(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	peers := random.Peers(2)
+
+	store := func(p peer.ID, rec storedLedger) {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Put(ctx, ledgerKey(p), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	halfLife := time.Hour
+	store(peers[0], storedLedger{Sent: 1000, Recv: 400, Exchanged: 10, Reputation: 8, DecayedAt: time.Now().Add(-halfLife)})
+	store(peers[1], storedLedger{Sent: 1, DecayedAt: time.Now().Add(-100 * halfLife)})
+
+	psl, err := NewPersistentScoreLedger(ctx, d, halfLife)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := psl.Record(peers[0])
+	if !ok || rec.Sent != 500 || rec.Recv != 200 || rec.Exchanged != 5 || rec.Reputation < 3.99 || rec.Reputation > 4.01 {
+		t.Fatalf("unexpected decayed record: %+v", rec)
+	}
+
+	// Ledgers which decayed entirely are deleted.
+	if _, ok := psl.Record(peers[1]); ok {
+		t.Fatal("expected ledger to decay entirely")
+	}
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := d.Has(ctx, ledgerKey(peers[1])); has {
+		t.Fatal("expected decayed ledger to be deleted")
+	}
+
+	// Small counters keep decaying when decayed often, and are eventually
+	// deleted.
+	psl, err = NewPersistentScoreLedger(ctx, dssync.MutexWrap(ds.NewMapDatastore()), 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	psl.PeerConnected(peers[0])
+	psl.AddToSentBytes(peers[0], 100)
+	psl.PeerDisconnected(peers[0])
+	psl.PeerConnected(peers[1])
+	psl.AddToSentBytes(peers[1], 100)
+	decayHourly := func(hours int) {
+		for range hours {
+			now = now.Add(time.Hour)
+			psl.lock.Lock()
+			psl.decay(now)
+			psl.lock.Unlock()
+		}
+	}
+	decayHourly(7 * 24)
+	for _, p := range peers {
+		if rec, _ := psl.Record(p); rec.Sent != 50 {
+			t.Fatalf("unexpected decayed record: %+v", rec)
+		}
+	}
+	decayHourly(7 * 24 * 10)
+	if _, ok := psl.Record(peers[0]); ok {
+		t.Fatal("expected ledger to decay entirely")
+	}
+}
+
+func TestPersistentScoreLedgerMaxRecords(t *testing.T) {
+	ctx := context.Background()
+	peers := random.Peers(30)
+
+	psl, err := NewPersistentScoreLedger(ctx, dssync.MutexWrap(ds.NewMapDatastore()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewMock()
+	psl.clock = clk
+	psl.maxRecords = 10
+	psl.Adjust(peers[0], 1)
+	for _, p := range peers[1:] {
+		clk.Add(time.Second)
+		psl.PeerConnected(p)
+		psl.AddToSentBytes(p, 100)
+		psl.PeerDisconnected(p)
+	}
+
+	records := psl.Records()
+	if len(records) > 11 {
+		t.Fatalf("expected at most 11 records, got %d", len(records))
+	}
+	// Adjusted peers and the peers with the latest exchanges are kept.
+	for _, p := range []peer.ID{peers[0], peers[len(peers)-1]} {
+		if _, ok := psl.Record(p); !ok {
+			t.Fatalf("expected record of %s to be kept", p)
+		}
+	}
+	if _, ok := psl.Record(peers[1]); ok {
+		t.Fatal("expected oldest record to be pruned")
+	}
+}
+
+func TestPersistentScoreLedgerAdjust(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	peers := random.Peers(2)
+
+	psl, err := NewPersistentScoreLedger(ctx, d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psl.peerSampleInterval = 10 * time.Millisecond
+
+	// Adjustments of unknown peers are kept until they connect.
+	psl.Adjust(peers[1], -5)
+	psl.PeerConnected(peers[0])
+	psl.Adjust(peers[0], 20)
+
+	scores := make(chan int, 1)
+	psl.Start(func(p peer.ID, score int) {
+		if p == peers[0] {
+			scores <- score
+		}
+	})
+	select {
+	case score := <-scores:
+		if score != 20 {
+			t.Fatalf("expected adjusted score of 20, got %d", score)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer was not scored")
+	}
+
+	// Stopping the ledger persists it.
+	psl.Stop()
+	psl, err = NewPersistentScoreLedger(ctx, d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, adjustment := range map[peer.ID]int{peers[0]: 20, peers[1]: -5} {
+		if rec, ok := psl.Record(p); !ok || rec.Adjustment != adjustment {
+			t.Fatalf("unexpected record after restart: %+v", rec)
+		}
+	}
+}
+
+func TestPersistentScoreLedgerFlushError(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	var failing bool
+	d := failstore.NewFailstore(child, func(op string) error {
+		if failing && (op == "put" || op == "delete") {
+			return errors.New("failing")
+		}
+		return nil
+	})
+	peers := random.Peers(3)
+
+	psl, err := NewPersistentScoreLedger(ctx, d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psl.Adjust(peers[0], 1)
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	psl.Forget(peers[0])
+	for _, p := range peers[1:] {
+		psl.Adjust(p, 2)
+	}
+
+	// The ledgers which were not written are written with the next flush.
+	failing = true
+	if err := psl.Flush(ctx); err == nil {
+		t.Fatal("expected flush to fail")
+	}
+	failing = false
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := child.Has(ctx, ledgerKey(peers[0])); has {
+		t.Fatal("expected forgotten ledger to be deleted")
+	}
+	for _, p := range peers[1:] {
+		if has, _ := child.Has(ctx, ledgerKey(p)); !has {
+			t.Fatalf("expected ledger of %s to be stored", p)
+		}
+	}
+}
+
+func TestPersistentScoreLedgerFlushChanged(t *testing.T) {
+	ctx := context.Background()
+	var puts int
+	d := failstore.NewFailstore(dssync.MutexWrap(ds.NewMapDatastore()), func(op string) error {
+		if op == "put" {
+			puts++
+		}
+		return nil
+	})
+	peers := random.Peers(2)
+
+	psl, err := NewPersistentScoreLedger(ctx, d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range peers {
+		psl.PeerConnected(p)
+		psl.AddToSentBytes(p, 100)
+	}
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if puts != 2 {
+		t.Fatalf("expected 2 ledgers to be written, got %d", puts)
+	}
+
+	// Only the connected ledgers which changed are written again.
+	puts = 0
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if puts != 0 {
+		t.Fatalf("expected no ledger to be written, got %d", puts)
+	}
+	psl.AddToReceivedBytes(peers[1], 50)
+	if err := psl.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if puts != 1 {
+		t.Fatalf("expected 1 ledger to be written, got %d", puts)
+	}
+}
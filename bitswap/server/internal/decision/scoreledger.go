@@ -54,6 +54,20 @@ type scoreledger struct {
 	// exchangeCount is the number of exchanges with this peer
 	exchangeCount uint64
 
+	// adjustment is added to the score of the peer. It is set by operators
+	// through a [PersistentScoreLedger].
+	adjustment int
+
+	// decayedAt is the last time the persisted accounting was decayed.
+	decayedAt time.Time
+
+	// The fractional parts of the decayed counters.
+	sentFrac, recvFrac, exchangedFrac float64
+
+	// dirty is set when the accounting changed since it was last written
+	// by a [PersistentScoreLedger].
+	dirty bool
+
 	// the record lock
 	lock sync.RWMutex
 
@@ -78,6 +92,7 @@ func (l *scoreledger) AddToSentBytes(n int) {
 	l.exchangeCount++
 	l.lastExchange = l.clock.Now()
 	l.bytesSent += uint64(n)
+	l.dirty = true
 }
 
 // Increments the received counter.
@@ -87,6 +102,7 @@ func (l *scoreledger) AddToReceivedBytes(n int) {
 	l.exchangeCount++
 	l.lastExchange = l.clock.Now()
 	l.bytesRecv += uint64(n)
+	l.dirty = true
 }
 
 // Returns the Receipt for this ledger record.
@@ -118,6 +134,10 @@ type DefaultScoreLedger struct {
 	// used by the tests to detect when a sample is taken
 	sampleCh chan struct{}
 	clock    clock.Clock
+	// onNew and onRemove are called with the lock held when the ledger of
+	// a peer is created and removed. They are set by PersistentScoreLedger.
+	onNew    func(*scoreledger)
+	onRemove func(*scoreledger)
 }
 
 // scoreWorker keeps track of how "useful" our peers are, updating scores in the
@@ -193,7 +213,7 @@ func (dsl *DefaultScoreLedger) scoreWorker() {
 			} else {
 				lscore = float64(l.bytesRecv) / float64(l.bytesRecv+l.bytesSent)
 			}
-			score := int((l.shortScore + l.longScore) * (lscore*.5 + .75))
+			score := int((l.shortScore+l.longScore)*(lscore*.5+.75)) + l.adjustment
 
 			// Avoid updating the connection manager unless there's a change. This can be expensive.
 			if l.score != score {
@@ -260,12 +280,21 @@ func (dsl *DefaultScoreLedger) findOrCreate(p peer.ID) *scoreledger {
 	defer dsl.lock.Unlock()
 	l, ok := dsl.ledgerMap[p]
 	if !ok {
-		l = newScoreLedger(p, dsl.clock)
-		dsl.ledgerMap[p] = l
+		l = dsl.newLedger(p)
 	}
 	return l
 }
 
+// Creates and registers the ledger of a peer. The lock must be held.
+func (dsl *DefaultScoreLedger) newLedger(p peer.ID) *scoreledger {
+	l := newScoreLedger(p, dsl.clock)
+	if dsl.onNew != nil {
+		dsl.onNew(l)
+	}
+	dsl.ledgerMap[p] = l
+	return l
+}
+
 // GetReceipt returns aggregated data communication with a given peer.
 func (dsl *DefaultScoreLedger) GetReceipt(p peer.ID) *Receipt {
 	l := dsl.find(p)
@@ -320,7 +349,7 @@ func (dsl *DefaultScoreLedger) PeerConnected(p peer.ID) {
 	defer dsl.lock.Unlock()
 	_, ok := dsl.ledgerMap[p]
 	if !ok {
-		dsl.ledgerMap[p] = newScoreLedger(p, dsl.clock)
+		dsl.newLedger(p)
 	}
 }
 
@@ -329,6 +358,9 @@ func (dsl *DefaultScoreLedger) PeerConnected(p peer.ID) {
 func (dsl *DefaultScoreLedger) PeerDisconnected(p peer.ID) {
 	dsl.lock.Lock()
 	defer dsl.lock.Unlock()
+	if l, ok := dsl.ledgerMap[p]; ok && dsl.onRemove != nil {
+		dsl.onRemove(l)
+	}
 	delete(dsl.ledgerMap, p)
 }
 
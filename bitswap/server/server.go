@@ -18,6 +18,7 @@ import (
 	blockstore "github.com/ipfs/boxo/blockstore"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipfs/go-metrics-interface"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -122,6 +123,17 @@ func WithTaskComparator(comparator decision.TaskComparator) Option {
 	}
 }
 
+// WithCreditStrategy serves first the peers which uploaded the most to us
+// relative to what we sent them, and denies the requests of peers which
+// downloaded more than maxDebt bytes beyond what they uploaded. See
+// [decision.WithCreditStrategy] for details.
+func WithCreditStrategy(maxDebt uint64) Option {
+	o := decision.WithCreditStrategy(maxDebt)
+	return func(bs *Server) {
+		bs.engineOptions = append(bs.engineOptions, o)
+	}
+}
+
 // Configures the engine to use the given score decision logic.
 func WithScoreLedger(scoreLedger decision.ScoreLedger) Option {
 	o := decision.WithScoreLedger(scoreLedger)
@@ -130,6 +142,14 @@ func WithScoreLedger(scoreLedger decision.ScoreLedger) Option {
 	}
 }
 
+// NewPersistentScoreLedger creates a [ScoreLedger] which persists the
+// accounting of peers in the given datastore, so that it survives restarts,
+// and decays it with the given half-life. A zero halfLife defaults to
+// [DefaultLedgerHalfLife]. Use it with [WithScoreLedger].
+func NewPersistentScoreLedger(ctx context.Context, d datastore.Datastore, halfLife time.Duration) (*PersistentScoreLedger, error) {
+	return decision.NewPersistentScoreLedger(ctx, d, halfLife)
+}
+
 // WithPeerLedger configures the engine with a custom [decision.PeerLedger].
 func WithPeerLedger(peerLedger decision.PeerLedger) Option {
 	o := decision.WithPeerLedger(peerLedger)
@@ -71,8 +71,22 @@ type BoundedBlockService interface {
 
 var _ BoundedBlockService = (*blockService)(nil)
 
+// ContentBlocker returns an error for the CIDs which must not be provided.
+type ContentBlocker func(c cid.Cid) error
+
+// FilteredBlockService is a Blockservice refusing to provide the blocks
+// rejected by its ContentBlocker.
+type FilteredBlockService interface {
+	BlockService
+
+	ContentBlocker() ContentBlocker
+}
+
+var _ FilteredBlockService = (*blockService)(nil)
+
 type blockService struct {
 	allowlist  verifcid.Allowlist
+	blocker    ContentBlocker
 	blockstore blockstore.Blockstore
 	exchange   exchange.Interface
 	// If checkFirst is true then first check that a block doesn't
@@ -97,6 +111,18 @@ func WithAllowlist(allowlist verifcid.Allowlist) Option {
 	}
 }
 
+// WithContentBlocker sets a [ContentBlocker] called before reading blocks.
+// Blocked blocks are neither read from the blockstore nor fetched from the
+// exchange, and the error of the blocker is returned instead. It can be used
+// to enforce a denylist, see [denylist.Denylist.CheckCid].
+//
+// [denylist.Denylist.CheckCid]: https://pkg.go.dev/github.com/ipfs/boxo/denylist#Denylist.CheckCid
+func WithContentBlocker(blocker ContentBlocker) Option {
+	return func(bs *blockService) {
+		bs.blocker = blocker
+	}
+}
+
 // New creates a BlockService with given datastore instance.
 func New(bs blockstore.Blockstore, exchange exchange.Interface, opts ...Option) BlockService {
 	if exchange == nil {
@@ -131,6 +157,10 @@ func (s *blockService) Allowlist() verifcid.Allowlist {
 	return s.allowlist
 }
 
+func (s *blockService) ContentBlocker() ContentBlocker {
+	return s.blocker
+}
+
 // NewSession creates a new session that allows for
 // controlled exchange of wantlists to decrease the bandwidth overhead.
 // If the current exchange is a SessionExchange, a new exchange
@@ -250,6 +280,11 @@ func getBlock(ctx context.Context, c cid.Cid, bs BlockService, fetchFactory func
 	if err != nil {
 		return nil, err
 	}
+	if blocker := grabContentBlockerFromBlockservice(bs); blocker != nil {
+		if err := blocker(c); err != nil {
+			return nil, err
+		}
+	}
 
 	blockstore := bs.Blockstore()
 
@@ -334,6 +369,18 @@ func getBlocks(ctx context.Context, ks []cid.Cid, blockservice BlockService, fet
 			ks = ks2
 		}
 
+		if blocker := grabContentBlockerFromBlockservice(blockservice); blocker != nil {
+			ks2 := make([]cid.Cid, 0, len(ks))
+			for _, c := range ks {
+				if err := blocker(c); err != nil {
+					logger.Debugf("blocked CID (%s) passed to blockService.GetBlocks: %s", c, err)
+					continue
+				}
+				ks2 = append(ks2, c)
+			}
+			ks = ks2
+		}
+
 		bs := blockservice.Blockstore()
 
 		var misses []cid.Cid
@@ -470,6 +517,30 @@ func (s *Session) GetBlocks(ctx context.Context, ks []cid.Cid) <-chan blocks.Blo
 	return getBlocks(ctx, ks, s.bs, s.grabSession)
 }
 
+// Stats returns a snapshot of the progress of the exchange session. It
+// returns false when the exchange does not report session progress. Blocks
+// found in the local blockstore are not counted.
+func (s *Session) Stats() (exchange.SessionStats, bool) {
+	sp, ok := s.grabSession().(exchange.SessionProgress)
+	if !ok {
+		return exchange.SessionStats{}, false
+	}
+	return sp.Stats(), true
+}
+
+// Events returns a channel of progress events of the exchange session, see
+// [exchange.SessionProgress]. The channel is closed right away when the
+// exchange does not report session progress.
+func (s *Session) Events(ctx context.Context) <-chan exchange.SessionEvent {
+	sp, ok := s.grabSession().(exchange.SessionProgress)
+	if !ok {
+		ch := make(chan exchange.SessionEvent)
+		close(ch)
+		return ch
+	}
+	return sp.Events(ctx)
+}
+
 var _ BlockGetter = (*Session)(nil)
 
 // ContextWithSession is a helper which creates a context with an embded session,
@@ -516,3 +587,11 @@ func grabAllowlistFromBlockservice(bs BlockService) verifcid.Allowlist {
 	}
 	return verifcid.DefaultAllowlist
 }
+
+// grabContentBlockerFromBlockservice returns nil when no content is blocked.
+func grabContentBlockerFromBlockservice(bs BlockService) ContentBlocker {
+	if fbs, ok := bs.(FilteredBlockService); ok {
+		return fbs.ContentBlocker()
+	}
+	return nil
+}
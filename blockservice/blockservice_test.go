@@ -2,6 +2,7 @@ package blockservice
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	blockstore "github.com/ipfs/boxo/blockstore"
@@ -352,3 +353,80 @@ func TestContextSession(t *testing.T) {
 		"session must be deduped in all invocations on the same context",
 	)
 }
+
+func TestContentBlocker(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	ctx := context.Background()
+
+	blks := random.BlocksOfSize(2, blockSize)
+	blocked, allowed := blks[0], blks[1]
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	a.NoError(bs.PutMany(ctx, blks))
+
+	errBlocked := errors.New("blocked")
+	blockservice := New(bs, nil, WithContentBlocker(func(c cid.Cid) error {
+		if c.Equals(blocked.Cid()) {
+			return errBlocked
+		}
+		return nil
+	}))
+
+	for _, getter := range []BlockGetter{blockservice, NewSession(ctx, blockservice)} {
+		_, err := getter.GetBlock(ctx, blocked.Cid())
+		a.ErrorIs(err, errBlocked)
+		_, err = getter.GetBlock(ctx, allowed.Cid())
+		a.NoError(err)
+
+		var got []cid.Cid
+		for blk := range getter.GetBlocks(ctx, []cid.Cid{blocked.Cid(), allowed.Cid()}) {
+			got = append(got, blk.Cid())
+		}
+		a.Equal([]cid.Cid{allowed.Cid()}, got)
+	}
+}
+
+type fakeProgressSession struct {
+	exchange.Fetcher
+	stats exchange.SessionStats
+}
+
+func (fps *fakeProgressSession) Stats() exchange.SessionStats {
+	return fps.stats
+}
+
+func (fps *fakeProgressSession) Events(ctx context.Context) <-chan exchange.SessionEvent {
+	ch := make(chan exchange.SessionEvent, 1)
+	ch <- exchange.SessionEvent{Type: exchange.SessionPeerAdded}
+	close(ch)
+	return ch
+}
+
+func TestSessionProgress(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(ds.NewMapDatastore())
+	exch := offline.Exchange(bs)
+
+	// The offline exchange has no sessions, so there is no progress to report
+	ses := NewSession(ctx, New(bs, exch))
+	_, ok := ses.Stats()
+	a.False(ok)
+	for range ses.Events(ctx) {
+		t.Fatal("expected no events")
+	}
+
+	stats := exchange.SessionStats{Peers: 2, BlocksReceived: 3}
+	sesEx := &fakeSessionExchange{Interface: exch, session: &fakeProgressSession{Fetcher: exch, stats: stats}}
+	ses = NewSession(ctx, New(bs, sesEx))
+	st, ok := ses.Stats()
+	a.True(ok)
+	a.Equal(stats, st)
+	evt, ok := <-ses.Events(ctx)
+	a.True(ok)
+	a.Equal(exchange.SessionPeerAdded, evt.Type)
+}
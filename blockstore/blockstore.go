@@ -105,15 +105,87 @@ type GCBlockstore interface {
 	GCLocker
 }
 
+// GCTracker is notified of the blocks accessed through a GCBlockstore while
+// a concurrent garbage collection is in progress, so that they are not
+// removed by it.
+type GCTracker interface {
+	// Written is called with the CIDs of blocks about to be written.
+	Written(context.Context, ...cid.Cid)
+
+	// Read is called with the CID of a block about to be read.
+	Read(context.Context, cid.Cid)
+}
+
+// TrackingGCBlockstore is a GCBlockstore that reports block accesses to a
+// GCTracker. This lets a garbage collector keep track of new writes without
+// holding the GC lock for the whole collection.
+type TrackingGCBlockstore interface {
+	GCBlockstore
+
+	// SetGCTracker registers the GCTracker notified of block accesses. A nil
+	// tracker disables tracking.
+	SetGCTracker(GCTracker)
+}
+
 // NewGCBlockstore returns a default implementation of GCBlockstore
-// using the given Blockstore and GCLocker.
+// using the given Blockstore and GCLocker. The returned blockstore also
+// implements TrackingGCBlockstore.
 func NewGCBlockstore(bs Blockstore, gcl GCLocker) GCBlockstore {
-	return gcBlockstore{bs, gcl}
+	return &gcBlockstore{Blockstore: bs, GCLocker: gcl}
 }
 
 type gcBlockstore struct {
 	Blockstore
 	GCLocker
+
+	tracker atomic.Pointer[GCTracker]
+}
+
+func (bs *gcBlockstore) SetGCTracker(t GCTracker) {
+	if t == nil {
+		bs.tracker.Store(nil)
+		return
+	}
+	bs.tracker.Store(&t)
+}
+
+func (bs *gcBlockstore) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	if t := bs.tracker.Load(); t != nil {
+		(*t).Read(ctx, k)
+	}
+	return bs.Blockstore.Get(ctx, k)
+}
+
+func (bs *gcBlockstore) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	if t := bs.tracker.Load(); t != nil {
+		(*t).Read(ctx, k)
+	}
+	return bs.Blockstore.Has(ctx, k)
+}
+
+func (bs *gcBlockstore) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	if t := bs.tracker.Load(); t != nil {
+		(*t).Read(ctx, k)
+	}
+	return bs.Blockstore.GetSize(ctx, k)
+}
+
+func (bs *gcBlockstore) Put(ctx context.Context, block blocks.Block) error {
+	if t := bs.tracker.Load(); t != nil {
+		(*t).Written(ctx, block.Cid())
+	}
+	return bs.Blockstore.Put(ctx, block)
+}
+
+func (bs *gcBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if t := bs.tracker.Load(); t != nil {
+		cids := make([]cid.Cid, len(blks))
+		for i, b := range blks {
+			cids[i] = b.Cid()
+		}
+		(*t).Written(ctx, cids...)
+	}
+	return bs.Blockstore.PutMany(ctx, blks)
 }
 
 // Option is a default implementation Blockstore option
@@ -0,0 +1,602 @@
+package blockstore
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsns "github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	ipld "github.com/ipfs/go-ipld-format"
+	metrics "github.com/ipfs/go-metrics-interface"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// EvictionPrefix namespaces the access metadata kept by the evicting
+// blockstore in its datastore.
+var EvictionPrefix = ds.NewKey("evict")
+
+const (
+	// pinRefreshInterval is how often the pins of the blocks found pinned
+	// are checked again, so that unpinned blocks count towards the maximum
+	// size even when no eviction happens.
+	pinRefreshInterval = 10 * time.Minute
+
+	// accessMetaSize is the size of the fixed part of the access metadata:
+	// last access time and block size, followed by the CID bytes.
+	accessMetaSize = 16
+)
+
+// EvictionOpts wraps options for NewEvictingBlockstore().
+type EvictionOpts struct {
+	// MaxSize is the maximum number of bytes of unpinned blocks to keep.
+	MaxSize uint64
+	// LowWaterMark is the ratio of MaxSize down to which blocks are evicted
+	// once MaxSize is exceeded.
+	LowWaterMark float64
+	// FlushInterval is how often the access metadata is written to the
+	// datastore.
+	FlushInterval time.Duration
+}
+
+// DefaultEvictionOpts returns an EvictionOpts initialized with default
+// values and the given maximum size.
+func DefaultEvictionOpts(maxSize uint64) EvictionOpts {
+	return EvictionOpts{
+		MaxSize:       maxSize,
+		LowWaterMark:  0.9,
+		FlushInterval: time.Minute,
+	}
+}
+
+type accessEntry struct {
+	c     cid.Cid
+	size  int
+	atime int64
+	elem  *list.Element
+	// pinned is whether the block was pinned when the pins were last
+	// checked. Pinned blocks are not evicted and do not count towards the
+	// maximum size.
+	pinned bool
+}
+
+// evictingbs wraps a Blockstore so that it behaves like a size-bounded cache
+// of unpinned blocks: when the total size of the unpinned blocks exceeds
+// MaxSize, the least recently read or written ones are deleted.
+type evictingbs struct {
+	blockstore Blockstore
+	viewer     Viewer
+	meta       ds.Batching
+	pinner     pin.Pinner
+
+	maxSize  uint64
+	lowWater uint64
+
+	lk      sync.Mutex
+	entries map[string]*accessEntry
+	lru     *list.List // front is most recently used
+	size    uint64     // size of the unpinned blocks
+	pinned  int        // number of pinned blocks
+	dirty   map[string]struct{}
+
+	evictCh chan struct{}
+
+	evictions    metrics.Counter
+	evictedBytes metrics.Counter
+	sizeGauge    metrics.Gauge
+	pinnedGauge  metrics.Gauge
+}
+
+var (
+	_ Blockstore = (*evictingbs)(nil)
+	_ Viewer     = (*evictingbs)(nil)
+)
+
+// NewEvictingBlockstore returns a Blockstore that keeps at most opts.MaxSize
+// bytes of unpinned blocks in bs, evicting the least recently used ones.
+//
+// Access times and sizes are persisted in dstore (under EvictionPrefix) so
+// that the eviction order survives restarts. Blocks already in bs without
+// metadata are sized with GetSize and considered the least recently used.
+// This scan happens synchronously.
+//
+// Blocks for which pinner reports a pin (of any kind) are never evicted and
+// do not count towards MaxSize. pinner may be nil. Checking indirect pins
+// requires walking all the recursive pins, so the pins are checked once per
+// eviction, and every few minutes when some blocks were found pinned.
+//
+// The given context controls the background eviction and flushing of the
+// metadata.
+func NewEvictingBlockstore(ctx context.Context, bs Blockstore, dstore ds.Batching, pinner pin.Pinner, opts EvictionOpts) (Blockstore, error) {
+	if opts.MaxSize == 0 {
+		return nil, errors.New("eviction MaxSize must be greater than zero")
+	}
+	if opts.LowWaterMark <= 0 || opts.LowWaterMark > 1 {
+		return nil, errors.New("eviction LowWaterMark must be in (0, 1]")
+	}
+	if opts.FlushInterval <= 0 {
+		return nil, errors.New("eviction FlushInterval must be greater than zero")
+	}
+
+	ctx = metrics.CtxSubScope(ctx, "bs.evict")
+
+	ebs := &evictingbs{
+		blockstore: bs,
+		meta:       dsns.Wrap(dstore, EvictionPrefix),
+		pinner:     pinner,
+		maxSize:    opts.MaxSize,
+		lowWater:   uint64(float64(opts.MaxSize) * opts.LowWaterMark),
+		entries:    make(map[string]*accessEntry),
+		lru:        list.New(),
+		dirty:      make(map[string]struct{}),
+		evictCh:    make(chan struct{}, 1),
+
+		evictions:    metrics.NewCtx(ctx, "evictions_total", "Number of blocks evicted").Counter(),
+		evictedBytes: metrics.NewCtx(ctx, "evicted_bytes_total", "Number of bytes evicted").Counter(),
+		sizeGauge:    metrics.NewCtx(ctx, "size_bytes", "Size of the unpinned blocks subject to eviction").Gauge(),
+		pinnedGauge:  metrics.NewCtx(ctx, "pinned_blocks", "Number of blocks skipped by eviction because they are pinned").Gauge(),
+	}
+	if v, ok := bs.(Viewer); ok {
+		ebs.viewer = v
+	}
+
+	if err := ebs.load(ctx); err != nil {
+		return nil, err
+	}
+
+	go ebs.run(ctx, opts.FlushInterval)
+	ebs.signalEvict()
+
+	return ebs, nil
+}
+
+// load reads the access metadata and reconciles it with the content of the
+// blockstore.
+func (b *evictingbs) load(ctx context.Context) error {
+	res, err := b.meta.Query(ctx, dsq.Query{})
+	if err != nil {
+		return err
+	}
+	var loaded []*accessEntry
+	for r := range res.Next() {
+		if r.Error != nil {
+			res.Close()
+			return r.Error
+		}
+		e, err := decodeAccessMeta(r.Value)
+		if err != nil {
+			logger.Warnf("evicting blockstore: ignoring invalid access metadata at %s: %s", r.Key, err)
+			continue
+		}
+		loaded = append(loaded, e)
+	}
+	res.Close()
+
+	metaKeys := make(map[string]*accessEntry, len(loaded))
+	for _, e := range loaded {
+		metaKeys[string(e.c.Hash())] = e
+	}
+
+	keys, err := b.blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+	var present []*accessEntry
+	for k := range keys {
+		key := string(k.Hash())
+		if e, ok := metaKeys[key]; ok {
+			present = append(present, e)
+			delete(metaKeys, key)
+			continue
+		}
+		size, err := b.blockstore.GetSize(ctx, k)
+		if err != nil {
+			if ipld.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		e := &accessEntry{c: k, size: size}
+		present = append(present, e)
+		b.dirty[key] = struct{}{}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Metadata left is for blocks deleted while we were not tracking them.
+	for key := range metaKeys {
+		b.dirty[key] = struct{}{}
+	}
+
+	sort.Slice(present, func(i, j int) bool {
+		return present[i].atime > present[j].atime
+	})
+	for _, e := range present {
+		e.elem = b.lru.PushBack(e)
+		b.entries[string(e.c.Hash())] = e
+		b.size += uint64(e.size)
+	}
+	b.sizeGauge.Set(float64(b.size))
+	return nil
+}
+
+func (b *evictingbs) run(ctx context.Context, flushInterval time.Duration) {
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	pt := time.NewTicker(pinRefreshInterval)
+	defer pt.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Use a fresh context so that the last accesses are not lost.
+			if err := b.flush(context.Background()); err != nil {
+				logger.Errorf("evicting blockstore: failed to flush access metadata: %s", err)
+			}
+			return
+		case <-t.C:
+			if err := b.flush(ctx); err != nil {
+				logger.Errorf("evicting blockstore: failed to flush access metadata: %s", err)
+			}
+		case <-pt.C:
+			b.lk.Lock()
+			hasPinned := b.pinned > 0
+			b.lk.Unlock()
+			if !hasPinned {
+				continue
+			}
+			if err := b.refreshPins(ctx); err != nil {
+				logger.Errorf("evicting blockstore: failed to check pins: %s", err)
+				continue
+			}
+			b.lk.Lock()
+			if b.size > b.maxSize {
+				b.signalEvict()
+			}
+			b.lk.Unlock()
+		case <-b.evictCh:
+			if err := b.evict(ctx); err != nil && ctx.Err() == nil {
+				logger.Errorf("evicting blockstore: eviction failed: %s", err)
+			}
+		}
+	}
+}
+
+// flush writes the metadata of the entries accessed since the last flush.
+func (b *evictingbs) flush(ctx context.Context) error {
+	b.lk.Lock()
+	dirty := b.dirty
+	b.dirty = make(map[string]struct{})
+	values := make(map[string][]byte, len(dirty))
+	for key := range dirty {
+		if e, ok := b.entries[key]; ok {
+			values[key] = encodeAccessMeta(e)
+		} else {
+			values[key] = nil
+		}
+	}
+	b.lk.Unlock()
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	batch, err := b.meta.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for key, v := range values {
+		dsk := dshelp.MultihashToDsKey(mh.Multihash(key))
+		if v == nil {
+			err = batch.Delete(ctx, dsk)
+		} else {
+			err = batch.Put(ctx, dsk, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Commit(ctx)
+}
+
+func (b *evictingbs) signalEvict() {
+	select {
+	case b.evictCh <- struct{}{}:
+	default:
+	}
+}
+
+// evict removes the least recently used unpinned blocks until the tracked
+// size is below the low water mark. Pinned blocks are moved to the front of
+// the LRU list, so each block is considered at most once per eviction.
+func (b *evictingbs) evict(ctx context.Context) error {
+	b.lk.Lock()
+	over := b.size > b.maxSize
+	b.lk.Unlock()
+	if !over {
+		return nil
+	}
+
+	if err := b.refreshPins(ctx); err != nil {
+		return err
+	}
+
+	b.lk.Lock()
+	remaining := b.lru.Len()
+	b.lk.Unlock()
+
+	var tried, failed int
+	for ; remaining > 0; remaining-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b.lk.Lock()
+		el := b.lru.Back()
+		if el == nil || b.size <= b.lowWater {
+			b.lk.Unlock()
+			return nil
+		}
+		e := el.Value.(*accessEntry)
+		if e.pinned {
+			b.lru.MoveToFront(el)
+			b.lk.Unlock()
+			continue
+		}
+		tried++
+		// The lock is held while deleting so that a concurrent Put of the
+		// same block is not lost.
+		if err := b.evictLocked(ctx, e); err != nil {
+			failed++
+		}
+		b.lk.Unlock()
+	}
+	if tried > 0 && failed == tried {
+		return errors.New("could not delete any eviction candidate")
+	}
+	return nil
+}
+
+// evictLocked deletes the block of e. b.lk must be held.
+func (b *evictingbs) evictLocked(ctx context.Context, e *accessEntry) error {
+	if err := b.blockstore.DeleteBlock(ctx, e.c); err != nil {
+		logger.Warnf("evicting blockstore: failed to delete %s: %s", e.c, err)
+		b.lru.MoveToFront(e.elem)
+		return err
+	}
+	b.forgetLocked(string(e.c.Hash()), e)
+	b.evictions.Inc()
+	b.evictedBytes.Add(float64(e.size))
+	return nil
+}
+
+// refreshPins checks which of the tracked blocks are pinned, with a single
+// call to the pinner, and updates the size accounting accordingly.
+func (b *evictingbs) refreshPins(ctx context.Context) error {
+	if b.pinner == nil {
+		return nil
+	}
+
+	b.lk.Lock()
+	cids := make([]cid.Cid, 0, len(b.entries))
+	for _, e := range b.entries {
+		cids = append(cids, pinCheckCids(e.c)...)
+	}
+	b.lk.Unlock()
+
+	res, err := b.pinner.CheckIfPinned(ctx, cids...)
+	if err != nil {
+		return fmt.Errorf("checking pins of eviction candidates: %w", err)
+	}
+	pinned := make(map[string]struct{})
+	for _, p := range res {
+		if p.Pinned() {
+			pinned[string(p.Key.Hash())] = struct{}{}
+		}
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	for key, e := range b.entries {
+		_, isPinned := pinned[key]
+		if isPinned == e.pinned {
+			continue
+		}
+		e.pinned = isPinned
+		if isPinned {
+			b.size -= uint64(e.size)
+			b.pinned++
+		} else {
+			b.size += uint64(e.size)
+			b.pinned--
+		}
+	}
+	b.sizeGauge.Set(float64(b.size))
+	b.pinnedGauge.Set(float64(b.pinned))
+	return nil
+}
+
+// pinCheckCids returns the CIDs to check against the pinner for c. Pinners
+// index pins by CID, but blocks listed from the blockstore only come with a
+// Raw CID, so the most common codecs are checked too.
+func pinCheckCids(c cid.Cid) []cid.Cid {
+	if c.Type() != cid.Raw {
+		return []cid.Cid{c}
+	}
+	h := c.Hash()
+	out := []cid.Cid{c, cid.NewCidV1(cid.DagProtobuf, h), cid.NewCidV1(cid.DagCBOR, h)}
+	if dh, err := mh.Decode(h); err == nil && dh.Code == mh.SHA2_256 && dh.Length == 32 {
+		out = append(out, cid.NewCidV0(h))
+	}
+	return out
+}
+
+// touch records an access to c. Blocks which are not tracked yet are only
+// added when create is true. On writes, touch must be called before the
+// underlying blockstore is accessed, so that eviction does not remove a block
+// being written, and again with create once the write succeeded.
+func (b *evictingbs) touch(c cid.Cid, size int, create bool) {
+	key := string(c.Hash())
+	now := time.Now().UnixNano()
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	e, ok := b.entries[key]
+	switch {
+	case ok:
+		b.lru.MoveToFront(e.elem)
+	case create:
+		e = &accessEntry{c: c, size: size}
+		e.elem = b.lru.PushFront(e)
+		b.entries[key] = e
+		b.size += uint64(size)
+		b.sizeGauge.Set(float64(b.size))
+	default:
+		return
+	}
+	// Prefer CIDs with a codec over the Raw CIDs found when scanning.
+	if e.c.Type() == cid.Raw && c.Type() != cid.Raw {
+		e.c = c
+	}
+	e.atime = now
+	b.dirty[key] = struct{}{}
+
+	if b.size > b.maxSize {
+		b.signalEvict()
+	}
+}
+
+func (b *evictingbs) forget(c cid.Cid) {
+	key := string(c.Hash())
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if e, ok := b.entries[key]; ok {
+		b.forgetLocked(key, e)
+	}
+}
+
+func (b *evictingbs) forgetLocked(key string, e *accessEntry) {
+	b.lru.Remove(e.elem)
+	if e.pinned {
+		b.pinned--
+		b.pinnedGauge.Set(float64(b.pinned))
+	} else {
+		b.size -= uint64(e.size)
+		b.sizeGauge.Set(float64(b.size))
+	}
+	delete(b.entries, key)
+	b.dirty[key] = struct{}{}
+}
+
+func (b *evictingbs) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	blk, err := b.blockstore.Get(ctx, k)
+	switch {
+	case err == nil:
+		b.touch(k, len(blk.RawData()), true)
+	case ipld.IsNotFound(err):
+		b.forget(k)
+	}
+	return blk, err
+}
+
+func (b *evictingbs) View(ctx context.Context, k cid.Cid, callback func([]byte) error) error {
+	if b.viewer == nil {
+		blk, err := b.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		return callback(blk.RawData())
+	}
+	size := -1
+	err := b.viewer.View(ctx, k, func(data []byte) error {
+		size = len(data)
+		return callback(data)
+	})
+	switch {
+	case size >= 0:
+		b.touch(k, size, true)
+	case ipld.IsNotFound(err):
+		b.forget(k)
+	}
+	return err
+}
+
+func (b *evictingbs) Put(ctx context.Context, bl blocks.Block) error {
+	b.touch(bl.Cid(), len(bl.RawData()), false)
+	if err := b.blockstore.Put(ctx, bl); err != nil {
+		return err
+	}
+	b.touch(bl.Cid(), len(bl.RawData()), true)
+	return nil
+}
+
+func (b *evictingbs) PutMany(ctx context.Context, bs []blocks.Block) error {
+	for _, bl := range bs {
+		b.touch(bl.Cid(), len(bl.RawData()), false)
+	}
+	if err := b.blockstore.PutMany(ctx, bs); err != nil {
+		return err
+	}
+	for _, bl := range bs {
+		b.touch(bl.Cid(), len(bl.RawData()), true)
+	}
+	return nil
+}
+
+func (b *evictingbs) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	err := b.blockstore.DeleteBlock(ctx, k)
+	if err == nil {
+		b.forget(k)
+	}
+	return err
+}
+
+func (b *evictingbs) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	return b.blockstore.Has(ctx, k)
+}
+
+func (b *evictingbs) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	return b.blockstore.GetSize(ctx, k)
+}
+
+func (b *evictingbs) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return b.blockstore.AllKeysChan(ctx)
+}
+
+func (b *evictingbs) HashOnRead(enabled bool) {
+	b.blockstore.HashOnRead(enabled)
+}
+
+func encodeAccessMeta(e *accessEntry) []byte {
+	cb := e.c.Bytes()
+	buf := make([]byte, accessMetaSize+len(cb))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(e.atime))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(e.size))
+	copy(buf[accessMetaSize:], cb)
+	return buf
+}
+
+func decodeAccessMeta(buf []byte) (*accessEntry, error) {
+	if len(buf) <= accessMetaSize {
+		return nil, errors.New("access metadata too short")
+	}
+	c, err := cid.Cast(buf[accessMetaSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &accessEntry{
+		c:     c,
+		atime: int64(binary.BigEndian.Uint64(buf[0:8])),
+		size:  int(binary.BigEndian.Uint64(buf[8:16])),
+	}, nil
+}
@@ -0,0 +1,217 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	syncds "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePinner struct {
+	pin.Pinner
+	lk     sync.Mutex
+	pinned map[cid.Cid]bool
+	checks int
+}
+
+func (p *fakePinner) CheckIfPinned(_ context.Context, cids ...cid.Cid) ([]pin.Pinned, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.checks++
+	out := make([]pin.Pinned, len(cids))
+	for i, c := range cids {
+		mode := pin.NotPinned
+		if p.pinned[c] {
+			mode = pin.Direct
+		}
+		out[i] = pin.Pinned{Key: c, Mode: mode}
+	}
+	return out, nil
+}
+
+func sizedBlock(t *testing.T, size int, seed byte) blocks.Block {
+	t.Helper()
+	data := make([]byte, size)
+	data[0] = seed
+	b, err := blocks.NewBlockWithCid(data, mustPrefixSum(t, data))
+	require.NoError(t, err)
+	return b
+}
+
+func mustPrefixSum(t *testing.T, data []byte) cid.Cid {
+	t.Helper()
+	c, err := cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: 0x12, MhLength: -1}.Sum(data)
+	require.NoError(t, err)
+	return c
+}
+
+func testEvictOpts(maxSize uint64) EvictionOpts {
+	opts := DefaultEvictionOpts(maxSize)
+	opts.LowWaterMark = 0.5
+	return opts
+}
+
+func TestEvictingBlockstoreEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backing := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	meta := syncds.MutexWrap(ds.NewMapDatastore())
+
+	var blks []blocks.Block
+	for i := range 4 {
+		blks = append(blks, sizedBlock(t, 100, byte(i)))
+	}
+	pinned := sizedBlock(t, 100, 42)
+	pinner := &fakePinner{pinned: map[cid.Cid]bool{pinned.Cid(): true}}
+
+	ebs, err := NewEvictingBlockstore(ctx, backing, meta, pinner, testEvictOpts(550))
+	require.NoError(t, err)
+
+	require.NoError(t, ebs.Put(ctx, pinned))
+	for _, b := range blks {
+		require.NoError(t, ebs.Put(ctx, b))
+	}
+	// Make the first block the most recently used one.
+	_, err = ebs.Get(ctx, blks[0].Cid())
+	require.NoError(t, err)
+
+	// Over the limit: eviction should go down to 275 bytes of unpinned
+	// blocks, keeping the pinned one whatever its age.
+	require.NoError(t, ebs.Put(ctx, sizedBlock(t, 100, 5)))
+
+	require.Eventually(t, func() bool {
+		has, err := backing.Has(ctx, blks[1].Cid())
+		require.NoError(t, err)
+		return !has
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, c := range []cid.Cid{pinned.Cid(), blks[0].Cid()} {
+		has, err := backing.Has(ctx, c)
+		require.NoError(t, err)
+		require.True(t, has, "%s should not have been evicted", c)
+	}
+	for _, c := range []cid.Cid{blks[1].Cid(), blks[2].Cid(), blks[3].Cid()} {
+		has, err := backing.Has(ctx, c)
+		require.NoError(t, err)
+		require.False(t, has, "%s should have been evicted", c)
+	}
+}
+
+func TestEvictingBlockstorePersistsAccessTimes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	backing := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	meta := syncds.MutexWrap(ds.NewMapDatastore())
+
+	older := sizedBlock(t, 100, 1)
+	newer := sizedBlock(t, 100, 2)
+	// Blocks already in the store are picked up when starting.
+	require.NoError(t, backing.Put(ctx, newer))
+	require.NoError(t, backing.Put(ctx, older))
+
+	ebs, err := NewEvictingBlockstore(ctx, backing, meta, nil, testEvictOpts(1000))
+	require.NoError(t, err)
+	_, err = ebs.Get(ctx, older.Cid())
+	require.NoError(t, err)
+	_, err = ebs.Get(ctx, newer.Cid())
+	require.NoError(t, err)
+
+	// Stopping flushes the access metadata.
+	cancel()
+	require.Eventually(t, func() bool {
+		res, err := meta.Query(context.Background(), dsq.Query{Prefix: EvictionPrefix.String(), KeysOnly: true})
+		require.NoError(t, err)
+		entries, err := res.Rest()
+		require.NoError(t, err)
+		return len(entries) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ebs, err = NewEvictingBlockstore(ctx, backing, meta, nil, DefaultEvictionOpts(150))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		has, err := backing.Has(ctx, older.Cid())
+		require.NoError(t, err)
+		return !has
+	}, 5*time.Second, 10*time.Millisecond)
+	has, err := ebs.Has(ctx, newer.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+}
+
+func TestEvictingBlockstoreEvictsUnpinnedBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backing := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	meta := syncds.MutexWrap(ds.NewMapDatastore())
+
+	pinned := sizedBlock(t, 100, 42)
+	pinner := &fakePinner{pinned: map[cid.Cid]bool{pinned.Cid(): true}}
+	ebs, err := NewEvictingBlockstore(ctx, backing, meta, pinner, testEvictOpts(250))
+	require.NoError(t, err)
+
+	// The pinned block is the least recently used one: it is skipped and
+	// the next block is evicted instead.
+	blks := []blocks.Block{pinned, sizedBlock(t, 100, 1), sizedBlock(t, 100, 2)}
+	for _, b := range blks {
+		require.NoError(t, ebs.Put(ctx, b))
+	}
+	require.Eventually(t, func() bool {
+		has, err := backing.Has(ctx, blks[1].Cid())
+		require.NoError(t, err)
+		return !has
+	}, 5*time.Second, 10*time.Millisecond)
+	pinner.lk.Lock()
+	require.Equal(t, 1, pinner.checks, "pins should be checked once per eviction")
+	pinner.lk.Unlock()
+
+	// Once unpinned, the block can be evicted again.
+	pinner.lk.Lock()
+	delete(pinner.pinned, pinned.Cid())
+	pinner.lk.Unlock()
+	require.NoError(t, ebs.Put(ctx, sizedBlock(t, 100, 3)))
+	require.NoError(t, ebs.Put(ctx, sizedBlock(t, 100, 4)))
+	require.Eventually(t, func() bool {
+		has, err := backing.Has(ctx, pinned.Cid())
+		require.NoError(t, err)
+		return !has
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+type failingPutBlockstore struct {
+	Blockstore
+}
+
+func (failingPutBlockstore) Put(context.Context, blocks.Block) error {
+	return errors.New("put failed")
+}
+
+func TestEvictingBlockstoreFailedPut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backing := failingPutBlockstore{NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))}
+	meta := syncds.MutexWrap(ds.NewMapDatastore())
+	bs, err := NewEvictingBlockstore(ctx, backing, meta, nil, testEvictOpts(1000))
+	require.NoError(t, err)
+
+	require.Error(t, bs.Put(ctx, sizedBlock(t, 100, 1)))
+	ebs := bs.(*evictingbs)
+	ebs.lk.Lock()
+	defer ebs.lk.Unlock()
+	require.Zero(t, ebs.size)
+	require.Empty(t, ebs.entries)
+}
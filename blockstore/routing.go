@@ -0,0 +1,362 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Route selects the index of the child blockstore a block belongs to. size
+// is the size of the block, or -1 when it is not known (reads, deletes). A
+// Route may return -1 when it cannot decide without the size, in which case
+// all the children are searched.
+type Route func(c cid.Cid, size int) int
+
+// RouteByCodec returns a Route sending blocks with the given CID codecs to the
+// mapped child, and all others to def.
+func RouteByCodec(codecs map[uint64]int, def int) Route {
+	return func(c cid.Cid, _ int) int {
+		if i, ok := codecs[c.Type()]; ok {
+			return i
+		}
+		return def
+	}
+}
+
+// RouteByMultihash returns a Route sending blocks with the given multihash
+// function codes to the mapped child, and all others to def.
+func RouteByMultihash(hashes map[uint64]int, def int) Route {
+	return func(c cid.Cid, _ int) int {
+		if i, ok := hashes[c.Prefix().MhType]; ok {
+			return i
+		}
+		return def
+	}
+}
+
+// RouteBySize returns a Route sending blocks smaller than threshold bytes to
+// the small child and the others to the large child.
+func RouteBySize(threshold, small, large int) Route {
+	return func(_ cid.Cid, size int) int {
+		switch {
+		case size < 0:
+			return -1
+		case size < threshold:
+			return small
+		default:
+			return large
+		}
+	}
+}
+
+// RoutingBlockstore dispatches blocks to several child blockstores according
+// to a Route. This allows, for example, to keep small dag-pb nodes on fast
+// storage and large raw leaves on cheaper disks.
+//
+// Blocks are written to the child selected by the Route. Since the Route may
+// change over time, or may not be able to decide without the block size,
+// reads fall back to every other child when the block is not found in the
+// selected one, and deletes remove the block from all children.
+type RoutingBlockstore struct {
+	route    Route
+	children []Blockstore
+}
+
+var (
+	_ Blockstore = (*RoutingBlockstore)(nil)
+	_ Viewer     = (*RoutingBlockstore)(nil)
+)
+
+// NewRoutingBlockstore returns a RoutingBlockstore dispatching to children
+// with the given Route.
+func NewRoutingBlockstore(route Route, children ...Blockstore) (*RoutingBlockstore, error) {
+	if route == nil {
+		return nil, errors.New("routing blockstore needs a route")
+	}
+	if len(children) == 0 {
+		return nil, errors.New("routing blockstore needs at least one child blockstore")
+	}
+	return &RoutingBlockstore{route: route, children: children}, nil
+}
+
+// target returns the child a block of the given size should be written to.
+func (b *RoutingBlockstore) target(c cid.Cid, size int) (int, error) {
+	i := b.route(c, size)
+	if i < 0 || i >= len(b.children) {
+		return -1, fmt.Errorf("route returned invalid child %d for %s", i, c)
+	}
+	return i, nil
+}
+
+// lookup calls f on the children, starting with the one selected by the
+// route, until f returns true or an error other than ipld.ErrNotFound.
+func (b *RoutingBlockstore) lookup(k cid.Cid, f func(Blockstore) (bool, error)) error {
+	first := b.route(k, -1)
+	if first >= 0 && first < len(b.children) {
+		found, err := f(b.children[first])
+		if found || (err != nil && !ipld.IsNotFound(err)) {
+			return err
+		}
+	}
+	for i, child := range b.children {
+		if i == first {
+			continue
+		}
+		found, err := f(child)
+		if found || (err != nil && !ipld.IsNotFound(err)) {
+			return err
+		}
+	}
+	return ipld.ErrNotFound{Cid: k}
+}
+
+func (b *RoutingBlockstore) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	var blk blocks.Block
+	err := b.lookup(k, func(child Blockstore) (bool, error) {
+		var err error
+		blk, err = child.Get(ctx, k)
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blk, nil
+}
+
+func (b *RoutingBlockstore) View(ctx context.Context, k cid.Cid, callback func([]byte) error) error {
+	return b.lookup(k, func(child Blockstore) (bool, error) {
+		var err error
+		if v, ok := child.(Viewer); ok {
+			var found bool
+			err = v.View(ctx, k, func(data []byte) error {
+				found = true
+				return callback(data)
+			})
+			return found, err
+		}
+		blk, err := child.Get(ctx, k)
+		if err != nil {
+			return false, err
+		}
+		return true, callback(blk.RawData())
+	})
+}
+
+func (b *RoutingBlockstore) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	err := b.lookup(k, func(child Blockstore) (bool, error) {
+		return child.Has(ctx, k)
+	})
+	if ipld.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *RoutingBlockstore) GetSize(ctx context.Context, k cid.Cid) (int, error) {
+	size := -1
+	err := b.lookup(k, func(child Blockstore) (bool, error) {
+		var err error
+		size, err = child.GetSize(ctx, k)
+		return err == nil, err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+func (b *RoutingBlockstore) Put(ctx context.Context, block blocks.Block) error {
+	i, err := b.target(block.Cid(), len(block.RawData()))
+	if err != nil {
+		return err
+	}
+	return b.children[i].Put(ctx, block)
+}
+
+func (b *RoutingBlockstore) PutMany(ctx context.Context, bs []blocks.Block) error {
+	if len(b.children) == 1 {
+		return b.children[0].PutMany(ctx, bs)
+	}
+	groups := make([][]blocks.Block, len(b.children))
+	for _, block := range bs {
+		i, err := b.target(block.Cid(), len(block.RawData()))
+		if err != nil {
+			return err
+		}
+		groups[i] = append(groups[i], block)
+	}
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := b.children[i].PutMany(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBlock removes the block from all the children.
+func (b *RoutingBlockstore) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	var errs []error
+	for _, child := range b.children {
+		if err := child.DeleteBlock(ctx, k); err != nil && !ipld.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AllKeysChan returns the keys of all the children, one child after the
+// other. A block stored in more than one child is returned more than once.
+func (b *RoutingBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	chans := make([]<-chan cid.Cid, len(b.children))
+	for i, child := range b.children {
+		ch, err := child.AllKeysChan(ctx)
+		if err != nil {
+			return nil, err
+		}
+		chans[i] = ch
+	}
+
+	output := make(chan cid.Cid)
+	go func() {
+		defer close(output)
+		for _, ch := range chans {
+			for k := range ch {
+				select {
+				case output <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return output, nil
+}
+
+func (b *RoutingBlockstore) HashOnRead(enabled bool) {
+	for _, child := range b.children {
+		child.HashOnRead(enabled)
+	}
+}
+
+// ErrRebalanceNeedsKeys is returned by Rebalance when it is called without
+// keys and the Route depends on the CID codec, which the keys listed by the
+// children do not carry.
+var ErrRebalanceNeedsKeys = errors.New("rebalancing with a route depending on the CID codec needs the keys with their codec")
+
+// RebalanceProgress reports the progress of Rebalance.
+type RebalanceProgress struct {
+	// Scanned is the number of blocks considered so far.
+	Scanned int
+	// Moved is the number of blocks written to a different child.
+	Moved int
+}
+
+// Rebalance moves blocks to the child selected by the current Route, for
+// instance after adding a child or changing the routing rule. Blocks are
+// written to their new child before being removed from the others.
+//
+// keys lists the blocks to consider. When it is nil, the keys of every child
+// are used. Most blockstores do not preserve the CID codec, so AllKeysChan
+// returns Raw CIDs: with a Route depending on the codec, Rebalance stops with
+// ErrRebalanceNeedsKeys before moving such a block, and the CIDs must be
+// provided with their codec, for instance by walking the DAGs of interest.
+//
+// progress, when not nil, is called after every block.
+func (b *RoutingBlockstore) Rebalance(ctx context.Context, keys <-chan cid.Cid, progress func(RebalanceProgress)) (RebalanceProgress, error) {
+	var p RebalanceProgress
+	listed := keys == nil
+	if listed {
+		// Stop listing the keys when returning early.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		var err error
+		keys, err = b.AllKeysChan(ctx)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	for k := range keys {
+		if listed && b.routeDependsOnCodec(k) {
+			return p, ErrRebalanceNeedsKeys
+		}
+		moved, err := b.rebalanceBlock(ctx, k)
+		if err != nil {
+			return p, fmt.Errorf("rebalancing %s: %w", k, err)
+		}
+		p.Scanned++
+		if moved {
+			p.Moved++
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}
+	return p, ctx.Err()
+}
+
+// routeDependsOnCodec returns whether the route of k, a CID listed by a
+// child, changes with the codec of the CID.
+func (b *RoutingBlockstore) routeDependsOnCodec(k cid.Cid) bool {
+	h := k.Hash()
+	want := b.route(cid.NewCidV1(cid.Raw, h), -1)
+	for _, codec := range []uint64{cid.DagProtobuf, cid.DagCBOR, cid.DagJSON} {
+		if b.route(cid.NewCidV1(codec, h), -1) != want {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *RoutingBlockstore) rebalanceBlock(ctx context.Context, k cid.Cid) (bool, error) {
+	holders := make([]bool, len(b.children))
+	var blk blocks.Block
+	for i, child := range b.children {
+		has, err := child.Has(ctx, k)
+		if err != nil {
+			return false, err
+		}
+		if !has {
+			continue
+		}
+		holders[i] = true
+		if blk == nil {
+			if blk, err = child.Get(ctx, k); err != nil {
+				return false, err
+			}
+		}
+	}
+	if blk == nil {
+		// Deleted since it was listed.
+		return false, nil
+	}
+
+	target, err := b.target(k, len(blk.RawData()))
+	if err != nil {
+		return false, err
+	}
+
+	var moved bool
+	if !holders[target] {
+		if err := b.children[target].Put(ctx, blk); err != nil {
+			return false, err
+		}
+		moved = true
+	}
+	for i, held := range holders {
+		if held && i != target {
+			if err := b.children[i].DeleteBlock(ctx, k); err != nil {
+				return moved, err
+			}
+		}
+	}
+	return moved, nil
+}
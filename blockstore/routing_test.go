@@ -0,0 +1,138 @@
+package blockstore
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestChildren(n int) []Blockstore {
+	children := make([]Blockstore, n)
+	for i := range children {
+		children[i] = NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	}
+	return children
+}
+
+func requireChildHas(t *testing.T, child Blockstore, c cid.Cid, expected bool) {
+	t.Helper()
+	has, err := child.Has(context.Background(), c)
+	require.NoError(t, err)
+	require.Equal(t, expected, has)
+}
+
+func TestRoutingBlockstoreBySize(t *testing.T) {
+	ctx := context.Background()
+	children := newTestChildren(2)
+	rbs, err := NewRoutingBlockstore(RouteBySize(64, 0, 1), children...)
+	require.NoError(t, err)
+
+	small := sizedBlock(t, 10, 1)
+	large := sizedBlock(t, 100, 2)
+	require.NoError(t, rbs.PutMany(ctx, []blocks.Block{small, large}))
+
+	requireChildHas(t, children[0], small.Cid(), true)
+	requireChildHas(t, children[1], small.Cid(), false)
+	requireChildHas(t, children[0], large.Cid(), false)
+	requireChildHas(t, children[1], large.Cid(), true)
+
+	// Reads do not know the size and search all children.
+	for _, b := range []blocks.Block{small, large} {
+		got, err := rbs.Get(ctx, b.Cid())
+		require.NoError(t, err)
+		require.Equal(t, b.RawData(), got.RawData())
+
+		size, err := rbs.GetSize(ctx, b.Cid())
+		require.NoError(t, err)
+		require.Equal(t, len(b.RawData()), size)
+
+		var viewed []byte
+		require.NoError(t, rbs.View(ctx, b.Cid(), func(data []byte) error {
+			viewed = append([]byte(nil), data...)
+			return nil
+		}))
+		require.Equal(t, b.RawData(), viewed)
+	}
+
+	keys, err := rbs.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var n int
+	for range keys {
+		n++
+	}
+	require.Equal(t, 2, n)
+
+	require.NoError(t, rbs.DeleteBlock(ctx, large.Cid()))
+	has, err := rbs.Has(ctx, large.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+	_, err = rbs.Get(ctx, large.Cid())
+	require.True(t, ipld.IsNotFound(err))
+}
+
+func TestRoutingBlockstoreRebalance(t *testing.T) {
+	ctx := context.Background()
+	children := newTestChildren(2)
+
+	dagpb := sizedBlock(t, 10, 1)
+	raw, err := blocks.NewBlockWithCid([]byte("raw leaf"), cid.NewCidV1(cid.Raw, mustPrefixSum(t, []byte("raw leaf")).Hash()))
+	require.NoError(t, err)
+
+	// Everything starts in the first child.
+	require.NoError(t, children[0].PutMany(ctx, []blocks.Block{dagpb, raw}))
+
+	rbs, err := NewRoutingBlockstore(RouteByCodec(map[uint64]int{cid.DagProtobuf: 0}, 1), children...)
+	require.NoError(t, err)
+
+	keys := make(chan cid.Cid, 2)
+	keys <- dagpb.Cid()
+	keys <- raw.Cid()
+	close(keys)
+
+	var last RebalanceProgress
+	p, err := rbs.Rebalance(ctx, keys, func(p RebalanceProgress) { last = p })
+	require.NoError(t, err)
+	require.Equal(t, RebalanceProgress{Scanned: 2, Moved: 1}, p)
+	require.Equal(t, p, last)
+
+	requireChildHas(t, children[0], dagpb.Cid(), true)
+	requireChildHas(t, children[1], dagpb.Cid(), false)
+	requireChildHas(t, children[0], raw.Cid(), false)
+	requireChildHas(t, children[1], raw.Cid(), true)
+
+	// The codec is the hint for reads.
+	got, err := rbs.Get(ctx, raw.Cid())
+	require.NoError(t, err)
+	require.Equal(t, raw.RawData(), got.RawData())
+}
+
+func TestRoutingBlockstoreRebalanceNeedsKeys(t *testing.T) {
+	ctx := context.Background()
+	children := newTestChildren(2)
+
+	dagpb := sizedBlock(t, 10, 1)
+	require.NoError(t, children[0].Put(ctx, dagpb))
+
+	// The listed keys are Raw CIDs, which would move the dag-pb block to
+	// the default child.
+	rbs, err := NewRoutingBlockstore(RouteByCodec(map[uint64]int{cid.DagProtobuf: 0}, 1), children...)
+	require.NoError(t, err)
+	_, err = rbs.Rebalance(ctx, nil, nil)
+	require.ErrorIs(t, err, ErrRebalanceNeedsKeys)
+	requireChildHas(t, children[0], dagpb.Cid(), true)
+	requireChildHas(t, children[1], dagpb.Cid(), false)
+
+	// Routes which do not depend on the codec can use the listed keys.
+	rbs, err = NewRoutingBlockstore(RouteBySize(64, 1, 0), children...)
+	require.NoError(t, err)
+	p, err := rbs.Rebalance(ctx, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, RebalanceProgress{Scanned: 1, Moved: 1}, p)
+	requireChildHas(t, children[1], dagpb.Cid(), true)
+}
@@ -0,0 +1,368 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/boxo/exchange"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsns "github.com/ipfs/go-datastore/namespace"
+	ipld "github.com/ipfs/go-ipld-format"
+	metrics "github.com/ipfs/go-metrics-interface"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ScrubPrefix namespaces the scrubber state (checkpoint and quarantined
+// blocks) in its datastore.
+var ScrubPrefix = ds.NewKey("scrub")
+
+var (
+	scrubCheckpointKey = ds.NewKey("checkpoint")
+	scrubQuarantineKey = ds.NewKey("quarantine")
+)
+
+// defaultCheckpointEvery is the default number of blocks verified between
+// two checkpoints.
+const defaultCheckpointEvery = 1000
+
+// ScrubberOpts wraps options for NewScrubber().
+type ScrubberOpts struct {
+	// BlocksPerSecond limits the number of blocks verified per second. Zero
+	// means no limit.
+	BlocksPerSecond float64
+	// BytesPerSecond limits the number of bytes read per second. Zero means
+	// no limit.
+	BytesPerSecond float64
+	// Interval is the pause between two full passes over the blockstore.
+	// Zero means that Run returns after a single pass.
+	Interval time.Duration
+	// CheckpointEvery is the number of blocks verified between two
+	// checkpoints of the scrubbing position.
+	CheckpointEvery int
+	// Quarantine makes the scrubber move corrupted blocks out of the
+	// blockstore into its datastore, where they can be inspected.
+	Quarantine bool
+	// Fetcher, when set, is used to fetch a valid copy of corrupted blocks.
+	// The corrupted copy is removed from the blockstore first.
+	Fetcher exchange.Fetcher
+	// FetchTimeout bounds the time spent fetching a single block.
+	FetchTimeout time.Duration
+	// OnCorrupt, when set, is called for every corrupted block found.
+	OnCorrupt func(CorruptBlock)
+}
+
+// DefaultScrubberOpts returns a ScrubberOpts initialized with default values.
+func DefaultScrubberOpts() ScrubberOpts {
+	return ScrubberOpts{
+		BlocksPerSecond: 100,
+		BytesPerSecond:  10 << 20,
+		Interval:        24 * time.Hour,
+		CheckpointEvery: defaultCheckpointEvery,
+		FetchTimeout:    time.Minute,
+	}
+}
+
+// CorruptBlock describes a block whose content does not match its CID.
+type CorruptBlock struct {
+	Cid cid.Cid
+	// Err is the verification error.
+	Err error
+	// Quarantined is true when the corrupted block was moved out of the
+	// blockstore.
+	Quarantined bool
+	// Repaired is true when a valid copy was fetched and stored.
+	Repaired bool
+	// RepairErr is set when fetching or storing a valid copy failed.
+	RepairErr error
+}
+
+// Scrubber periodically verifies that the blocks of a Blockstore match their
+// CIDs. Unlike HashOnRead, it also finds corruption in blocks that are
+// seldom read.
+type Scrubber struct {
+	bs    Blockstore
+	state ds.Datastore
+	opts  ScrubberOpts
+
+	scrubbed     metrics.Counter
+	corrupted    metrics.Counter
+	repaired     metrics.Counter
+	unverifiable metrics.Counter
+}
+
+// NewScrubber returns a Scrubber for bs. Its checkpoints and quarantined
+// blocks are stored in dstore under ScrubPrefix.
+func NewScrubber(ctx context.Context, bs Blockstore, dstore ds.Datastore, opts ScrubberOpts) (*Scrubber, error) {
+	if opts.BlocksPerSecond < 0 || opts.BytesPerSecond < 0 || opts.Interval < 0 {
+		return nil, errors.New("scrubber rate limits and interval cannot be negative")
+	}
+	if opts.CheckpointEvery <= 0 {
+		opts.CheckpointEvery = defaultCheckpointEvery
+	}
+
+	ctx = metrics.CtxSubScope(ctx, "bs.scrub")
+
+	return &Scrubber{
+		bs:           bs,
+		state:        dsns.Wrap(dstore, ScrubPrefix),
+		opts:         opts,
+		scrubbed:     metrics.NewCtx(ctx, "blocks_total", "Number of blocks verified by the scrubber").Counter(),
+		corrupted:    metrics.NewCtx(ctx, "corrupted_total", "Number of corrupted blocks found by the scrubber").Counter(),
+		repaired:     metrics.NewCtx(ctx, "repaired_total", "Number of corrupted blocks replaced with a valid copy").Counter(),
+		unverifiable: metrics.NewCtx(ctx, "unverifiable_total", "Number of blocks skipped because their hash function is not available").Counter(),
+	}, nil
+}
+
+// Run scrubs the blockstore until ctx is done, pausing opts.Interval between
+// passes, or once if the interval is zero. A pass interrupted by a restart is
+// resumed from the last checkpoint when the blockstore lists its keys in a
+// stable order, and started over otherwise.
+func (s *Scrubber) Run(ctx context.Context) error {
+	for {
+		if err := s.pass(ctx); err != nil {
+			return err
+		}
+		if s.opts.Interval == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(s.opts.Interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pass verifies every block once, resuming from the checkpoint if any.
+func (s *Scrubber) pass(ctx context.Context) error {
+	checkpoint, err := s.state.Get(ctx, scrubCheckpointKey)
+	switch {
+	case errors.Is(err, ds.ErrNotFound):
+		checkpoint = nil
+	case err != nil:
+		return err
+	}
+
+	resumed, err := s.scan(ctx, checkpoint)
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil && !resumed {
+		// The checkpointed key was not listed again, we cannot know which
+		// keys were skipped: start over.
+		logger.Infof("scrubber checkpoint not found, starting a new pass")
+		if _, err := s.scan(ctx, nil); err != nil {
+			return err
+		}
+	}
+	return s.state.Delete(ctx, scrubCheckpointKey)
+}
+
+// scan verifies the blocks listed after the checkpointed key. It returns
+// whether the checkpointed key was found.
+func (s *Scrubber) scan(ctx context.Context, checkpoint []byte) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys, err := s.bs.AllKeysChan(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	skipping := checkpoint != nil
+	p := newPacer(s.opts.BlocksPerSecond, s.opts.BytesPerSecond)
+	var sinceCheckpoint int
+	for k := range keys {
+		if skipping {
+			if string(k.Hash()) == string(checkpoint) {
+				skipping = false
+			}
+			continue
+		}
+
+		size, err := s.verify(ctx, k)
+		if err != nil {
+			return true, err
+		}
+
+		sinceCheckpoint++
+		if sinceCheckpoint >= s.opts.CheckpointEvery {
+			if err := s.state.Put(ctx, scrubCheckpointKey, k.Hash()); err != nil {
+				return true, err
+			}
+			sinceCheckpoint = 0
+		}
+
+		if err := p.wait(ctx, size); err != nil {
+			return true, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+	return !skipping, nil
+}
+
+// verify rehashes the block and handles it if it is corrupted. It returns
+// the number of bytes read.
+func (s *Scrubber) verify(ctx context.Context, k cid.Cid) (int, error) {
+	if k.Prefix().MhType == mh.IDENTITY {
+		return 0, nil
+	}
+	// Blocks hashed with a function which is not registered in this build
+	// cannot be checked, which does not make them corrupted. Check before
+	// reading so that a HashOnRead blockstore does not fail the pass.
+	if _, err := k.Prefix().Sum(nil); err != nil {
+		s.skipUnverifiable(k, err)
+		return 0, nil
+	}
+
+	blk, err := s.bs.Get(ctx, k)
+	switch {
+	case ipld.IsNotFound(err):
+		// Removed since listed.
+		return 0, nil
+	case errors.Is(err, ErrHashMismatch):
+		// HashOnRead is enabled on the blockstore.
+		s.scrubbed.Inc()
+		return 0, s.handleCorrupt(ctx, k, nil, err)
+	case err != nil:
+		return 0, err
+	}
+	s.scrubbed.Inc()
+
+	data := blk.RawData()
+	rehashed, err := k.Prefix().Sum(data)
+	if err != nil {
+		s.skipUnverifiable(k, err)
+		return len(data), nil
+	}
+	if !rehashed.Equals(k) {
+		return len(data), s.handleCorrupt(ctx, k, data, ErrHashMismatch)
+	}
+	return len(data), nil
+}
+
+// skipUnverifiable records a block that the scrubber cannot rehash.
+func (s *Scrubber) skipUnverifiable(k cid.Cid, err error) {
+	s.unverifiable.Inc()
+	logger.Warnf("scrubber cannot verify block %s: %s", k, err)
+}
+
+func (s *Scrubber) handleCorrupt(ctx context.Context, k cid.Cid, data []byte, verifyErr error) error {
+	s.corrupted.Inc()
+	logger.Errorf("scrubber found corrupted block %s: %s", k, verifyErr)
+
+	report := CorruptBlock{Cid: k, Err: verifyErr}
+
+	// The content is not available when the blockstore rehashes on read.
+	if s.opts.Quarantine && data != nil {
+		if err := s.state.Put(ctx, scrubQuarantineKey.Child(dshelp.MultihashToDsKey(k.Hash())), data); err != nil {
+			return err
+		}
+		report.Quarantined = true
+	}
+
+	if report.Quarantined || s.opts.Fetcher != nil {
+		if err := s.bs.DeleteBlock(ctx, k); err != nil {
+			return fmt.Errorf("removing corrupted block %s: %w", k, err)
+		}
+	}
+
+	if s.opts.Fetcher != nil {
+		report.RepairErr = s.repair(ctx, k)
+		report.Repaired = report.RepairErr == nil
+		if report.Repaired {
+			s.repaired.Inc()
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	if s.opts.OnCorrupt != nil {
+		s.opts.OnCorrupt(report)
+	}
+	return nil
+}
+
+func (s *Scrubber) repair(ctx context.Context, k cid.Cid) error {
+	fetchCtx := ctx
+	if s.opts.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(ctx, s.opts.FetchTimeout)
+		defer cancel()
+	}
+	blk, err := s.opts.Fetcher.GetBlock(fetchCtx, k)
+	if err != nil {
+		return err
+	}
+	rehashed, err := k.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !rehashed.Equals(k) {
+		return ErrHashMismatch
+	}
+	return s.bs.Put(ctx, blk)
+}
+
+// Quarantined returns the content of a block quarantined by the scrubber.
+func (s *Scrubber) Quarantined(ctx context.Context, k cid.Cid) ([]byte, error) {
+	data, err := s.state.Get(ctx, scrubQuarantineKey.Child(dshelp.MultihashToDsKey(k.Hash())))
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, ipld.ErrNotFound{Cid: k}
+	}
+	return data, err
+}
+
+// pacer enforces the scrubbing rate limits.
+type pacer struct {
+	blocksPerSecond float64
+	bytesPerSecond  float64
+
+	start  time.Time
+	blocks float64
+	bytes  float64
+}
+
+func newPacer(blocksPerSecond, bytesPerSecond float64) *pacer {
+	return &pacer{
+		blocksPerSecond: blocksPerSecond,
+		bytesPerSecond:  bytesPerSecond,
+		start:           time.Now(),
+	}
+}
+
+// wait accounts for a block of the given size and sleeps as long as needed
+// to stay under the limits.
+func (p *pacer) wait(ctx context.Context, size int) error {
+	p.blocks++
+	p.bytes += float64(size)
+
+	var target time.Duration
+	if p.blocksPerSecond > 0 {
+		target = time.Duration(p.blocks / p.blocksPerSecond * float64(time.Second))
+	}
+	if p.bytesPerSecond > 0 {
+		if t := time.Duration(p.bytes / p.bytesPerSecond * float64(time.Second)); t > target {
+			target = t
+		}
+	}
+
+	delay := target - time.Since(p.start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,176 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// sortedKeysBlockstore lists its keys in a stable order, like most
+// datastores do.
+type sortedKeysBlockstore struct {
+	Blockstore
+}
+
+func (b sortedKeysBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	ch, err := b.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []cid.Cid
+	for k := range ch {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b cid.Cid) int { return bytes.Compare(a.Hash(), b.Hash()) })
+	out := make(chan cid.Cid, len(keys))
+	for _, k := range keys {
+		out <- k
+	}
+	close(out)
+	return out, nil
+}
+
+type mapFetcher map[string]blocks.Block
+
+func (f mapFetcher) GetBlock(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	return f[string(c.Hash())], nil
+}
+
+func (f mapFetcher) GetBlocks(context.Context, []cid.Cid) (<-chan blocks.Block, error) {
+	panic("not implemented")
+}
+
+// corrupt overwrites the content of b in the datastore.
+func corrupt(t *testing.T, dstore ds.Datastore, b blocks.Block) {
+	t.Helper()
+	key := BlockPrefix.Child(dshelp.MultihashToDsKey(b.Cid().Hash()))
+	require.NoError(t, dstore.Put(context.Background(), key, []byte("garbage")))
+}
+
+func TestScrubberRepairsAndQuarantines(t *testing.T) {
+	ctx := context.Background()
+	dstore := syncds.MutexWrap(ds.NewMapDatastore())
+	bs := NewBlockstore(dstore)
+
+	var blks []blocks.Block
+	for i := range 5 {
+		b := sizedBlock(t, 64, byte(i))
+		blks = append(blks, b)
+		require.NoError(t, bs.Put(ctx, b))
+	}
+	bad := blks[2]
+	corrupt(t, dstore, bad)
+
+	var reports []CorruptBlock
+	opts := ScrubberOpts{
+		Quarantine: true,
+		Fetcher:    mapFetcher{string(bad.Cid().Hash()): bad},
+		OnCorrupt:  func(c CorruptBlock) { reports = append(reports, c) },
+	}
+	s, err := NewScrubber(ctx, bs, syncds.MutexWrap(ds.NewMapDatastore()), opts)
+	require.NoError(t, err)
+	require.NoError(t, s.Run(ctx))
+
+	require.Len(t, reports, 1)
+	require.Equal(t, bad.Cid().Hash(), reports[0].Cid.Hash())
+	require.ErrorIs(t, reports[0].Err, ErrHashMismatch)
+	require.True(t, reports[0].Quarantined)
+	require.True(t, reports[0].Repaired)
+	require.NoError(t, reports[0].RepairErr)
+
+	got, err := bs.Get(ctx, bad.Cid())
+	require.NoError(t, err)
+	require.Equal(t, bad.RawData(), got.RawData())
+
+	quarantined, err := s.Quarantined(ctx, bad.Cid())
+	require.NoError(t, err)
+	require.Equal(t, []byte("garbage"), quarantined)
+}
+
+func TestScrubberResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dstore := syncds.MutexWrap(ds.NewMapDatastore())
+	bs := sortedKeysBlockstore{NewBlockstore(dstore)}
+
+	var blks []blocks.Block
+	for i := range 6 {
+		b := sizedBlock(t, 64, byte(i))
+		blks = append(blks, b)
+		require.NoError(t, bs.Put(ctx, b))
+	}
+	slices.SortFunc(blks, func(a, b blocks.Block) int { return bytes.Compare(a.Cid().Hash(), b.Cid().Hash()) })
+	// One corrupted block before the checkpoint, one after.
+	corrupt(t, dstore, blks[1])
+	corrupt(t, dstore, blks[4])
+
+	state := syncds.MutexWrap(ds.NewMapDatastore())
+	require.NoError(t, state.Put(ctx, ScrubPrefix.Child(scrubCheckpointKey), blks[2].Cid().Hash()))
+
+	var reports []CorruptBlock
+	s, err := NewScrubber(ctx, bs, state, ScrubberOpts{
+		CheckpointEvery: 1,
+		OnCorrupt:       func(c CorruptBlock) { reports = append(reports, c) },
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Run(ctx))
+
+	require.Len(t, reports, 1)
+	require.Equal(t, blks[4].Cid().Hash(), reports[0].Cid.Hash())
+	require.False(t, reports[0].Quarantined)
+	require.False(t, reports[0].Repaired)
+
+	// Without quarantine nor fetcher, corrupted blocks are only reported.
+	has, err := bs.Has(ctx, blks[4].Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// The pass is complete, the checkpoint is cleared.
+	_, err = state.Get(ctx, ScrubPrefix.Child(scrubCheckpointKey))
+	require.ErrorIs(t, err, ds.ErrNotFound)
+
+	// A new pass starts from the beginning.
+	reports = nil
+	require.NoError(t, s.Run(ctx))
+	require.Len(t, reports, 2)
+}
+
+func TestScrubberSkipsUnverifiableBlocks(t *testing.T) {
+	ctx := context.Background()
+	dstore := syncds.MutexWrap(ds.NewMapDatastore())
+	bs := NewBlockstore(dstore)
+
+	// A multihash function which is not registered cannot be checked.
+	h, err := mh.Encode(make([]byte, 32), 0x300000)
+	require.NoError(t, err)
+	b, err := blocks.NewBlockWithCid([]byte("unverifiable"), cid.NewCidV1(cid.Raw, h))
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, b))
+
+	var reports []CorruptBlock
+	s, err := NewScrubber(ctx, bs, syncds.MutexWrap(ds.NewMapDatastore()), ScrubberOpts{
+		Quarantine: true,
+		Fetcher:    mapFetcher{},
+		OnCorrupt:  func(c CorruptBlock) { reports = append(reports, c) },
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Run(ctx))
+	require.Empty(t, reports)
+
+	has, err := bs.Has(ctx, b.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// Same with HashOnRead, which cannot hash the block either.
+	bs.HashOnRead(true)
+	require.NoError(t, s.Run(ctx))
+	require.Empty(t, reports)
+}
@@ -0,0 +1,218 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	mdag "github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-test/random"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/stretchr/testify/require"
+)
+
+type testRepo struct {
+	bs    blockstore.Blockstore
+	bserv blockservice.BlockService
+	dserv ipld.DAGService
+}
+
+func newTestRepo() *testRepo {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bserv := blockservice.New(bs, offline.Exchange(bs))
+	return &testRepo{bs: bs, bserv: bserv, dserv: mdag.NewDAGService(bserv)}
+}
+
+// addDAG adds a root linking to two intermediate nodes sharing a raw leaf.
+// It returns the root and all the CIDs of the DAG.
+func (r *testRepo) addDAG(t *testing.T) (cid.Cid, []cid.Cid) {
+	t.Helper()
+	ctx := context.Background()
+	leaf := mdag.NewRawNode(random.Bytes(64))
+	require.NoError(t, r.dserv.Add(ctx, leaf))
+
+	var nodes []ipld.Node
+	for range 2 {
+		nd := mdag.NodeWithData(random.Bytes(16))
+		require.NoError(t, nd.AddNodeLink("leaf", leaf))
+		require.NoError(t, r.dserv.Add(ctx, nd))
+		nodes = append(nodes, nd)
+	}
+
+	root := mdag.NodeWithData(random.Bytes(16))
+	for i, nd := range nodes {
+		require.NoError(t, root.AddNodeLink(string(rune('a'+i)), nd))
+	}
+	require.NoError(t, r.dserv.Add(ctx, root))
+
+	return root.Cid(), []cid.Cid{root.Cid(), nodes[0].Cid(), nodes[1].Cid(), leaf.Cid()}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepo()
+	root, all := src.addDAG(t)
+
+	var buf bytes.Buffer
+	var exported []Progress
+	p, err := Export(ctx, src.bserv, []cid.Cid{root}, &buf, WithExportProgress(func(p Progress) {
+		exported = append(exported, p)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 4, p.Blocks)
+	require.Equal(t, 0, p.Duplicates)
+	require.Equal(t, p, exported[len(exported)-1])
+
+	dst := newTestRepo()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	pinner, err := dspinner.New(ctx, dstore, dst.dserv)
+	require.NoError(t, err)
+
+	var imported int
+	res, err := Import(ctx, &buf, dst.bs, pinner, WithImportProgress(func(Progress) { imported++ }))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{root}, res.Roots)
+	require.Equal(t, p, res.Progress)
+	require.Equal(t, 4, imported)
+
+	for _, c := range all {
+		has, err := dst.bs.Has(ctx, c)
+		require.NoError(t, err)
+		require.True(t, has, "%s should have been imported", c)
+	}
+	_, pinned, err := pinner.IsPinned(ctx, root)
+	require.NoError(t, err)
+	require.True(t, pinned)
+}
+
+func TestExportDuplicates(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepo()
+	root, _ := src.addDAG(t)
+
+	var buf bytes.Buffer
+	p, err := Export(ctx, src.bserv, []cid.Cid{root}, &buf, WithDuplicates(true))
+	require.NoError(t, err)
+	// The leaf is reached twice.
+	require.Equal(t, 5, p.Blocks)
+
+	res, err := Import(ctx, &buf, newTestRepo().bs, nil)
+	require.NoError(t, err)
+	require.Equal(t, 4, res.Blocks)
+	require.Equal(t, 1, res.Duplicates)
+}
+
+func TestExportScopeBlockCARv2(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepo()
+	root, _ := src.addDAG(t)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.car"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	p, err := Export(ctx, src.bserv, []cid.Cid{root}, f, WithScope(ScopeBlock), WithCARv2(true))
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Blocks)
+
+	_, err = f.Seek(0, 0)
+	require.NoError(t, err)
+	br, err := carv2.NewBlockReader(f)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), br.Version)
+	blk, err := br.Next()
+	require.NoError(t, err)
+	require.Equal(t, root, blk.Cid())
+
+	// CARv2 needs random access.
+	_, err = Export(ctx, src.bserv, []cid.Cid{root}, &bytes.Buffer{}, WithCARv2(true))
+	require.Error(t, err)
+}
+
+func TestImportRejectsCorruptBlock(t *testing.T) {
+	ctx := context.Background()
+	good := mdag.NewRawNode([]byte("good"))
+	bad := mdag.NewRawNode([]byte("bad"))
+
+	var buf bytes.Buffer
+	cw, err := storage.NewWritable(&buf, []cid.Cid{good.Cid()}, carv2.WriteAsCarV1(true))
+	require.NoError(t, err)
+	require.NoError(t, cw.Put(ctx, good.Cid().KeyString(), good.RawData()))
+	require.NoError(t, cw.Put(ctx, bad.Cid().KeyString(), []byte("tampered")))
+
+	dst := newTestRepo()
+	_, err = Import(ctx, &buf, dst.bs, nil, WithBatchSize(1))
+	require.ErrorIs(t, err, ErrHashMismatch)
+
+	has, err := dst.bs.Has(ctx, bad.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+// countingLocker counts the pin locks held.
+type countingLocker struct {
+	blockstore.GCLocker
+	held atomic.Int32
+}
+
+func (l *countingLocker) PinLock(ctx context.Context) blockstore.Unlocker {
+	u := l.GCLocker.PinLock(ctx)
+	l.held.Add(1)
+	return unlockFunc(func(ctx context.Context) {
+		l.held.Add(-1)
+		u.Unlock(ctx)
+	})
+}
+
+type unlockFunc func(context.Context)
+
+func (f unlockFunc) Unlock(ctx context.Context) { f(ctx) }
+
+// pinLockedBlockstore fails writes made without a pin lock.
+type pinLockedBlockstore struct {
+	blockstore.GCBlockstore
+	locker *countingLocker
+}
+
+func (b pinLockedBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if b.locker.held.Load() == 0 {
+		return errors.New("write without pin lock")
+	}
+	return b.GCBlockstore.PutMany(ctx, blks)
+}
+
+func TestImportHoldsPinLock(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepo()
+	root, _ := src.addDAG(t)
+
+	var buf bytes.Buffer
+	_, err := Export(ctx, src.bserv, []cid.Cid{root}, &buf)
+	require.NoError(t, err)
+
+	dst := newTestRepo()
+	locker := &countingLocker{GCLocker: blockstore.NewGCLocker()}
+	bs := pinLockedBlockstore{blockstore.NewGCBlockstore(dst.bs, locker), locker}
+	pinner, err := dspinner.New(ctx, dssync.MutexWrap(ds.NewMapDatastore()), dst.dserv)
+	require.NoError(t, err)
+
+	_, err = Import(ctx, &buf, bs, pinner, WithBatchSize(1))
+	require.NoError(t, err)
+
+	// The lock is released once the roots are pinned.
+	require.Zero(t, locker.held.Load())
+}
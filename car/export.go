@@ -0,0 +1,261 @@
+// Package car imports and exports DAGs as Content Addressable aRchives.
+//
+// Export writes the blocks of one or more DAGs, fetched through a
+// [blockservice.BlockService], to a CARv1 stream or a CARv2 file. Import
+// reads a CAR into a [blockstore.Blockstore], verifying every block against
+// its CID, and optionally pins its roots.
+package car
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/blockservice"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Scope selects the blocks exported for each root, following the dag-scope
+// parameter of the trustless gateway specification.
+type Scope string
+
+const (
+	// ScopeAll exports the whole DAG under each root.
+	ScopeAll Scope = "all"
+	// ScopeEntity exports the blocks needed to read the UnixFS entity at each
+	// root: all the blocks of a file, or the directory node and its direct
+	// children. Non-UnixFS roots are exported alone.
+	ScopeEntity Scope = "entity"
+	// ScopeBlock exports only the root blocks.
+	ScopeBlock Scope = "block"
+)
+
+// Progress reports the progress of an export or an import.
+type Progress struct {
+	// Blocks is the number of blocks written so far.
+	Blocks int
+	// Bytes is the size of the data of those blocks.
+	Bytes uint64
+	// Duplicates is the number of blocks skipped because they were already
+	// written.
+	Duplicates int
+}
+
+type exportOptions struct {
+	scope      Scope
+	selector   datamodel.Node
+	v2         bool
+	duplicates bool
+	progress   func(Progress)
+}
+
+// ExportOption configures Export.
+type ExportOption func(*exportOptions)
+
+// WithScope sets the blocks exported for each root. The default is ScopeAll.
+func WithScope(scope Scope) ExportOption {
+	return func(o *exportOptions) {
+		o.scope = scope
+	}
+}
+
+// WithSelector sets an IPLD selector used to traverse each root instead of
+// the scope. UnixFS reification is available to the selector.
+func WithSelector(sel datamodel.Node) ExportOption {
+	return func(o *exportOptions) {
+		o.selector = sel
+	}
+}
+
+// WithCARv2 makes Export write a CARv2 with an index instead of a CARv1. The
+// writer must then implement io.WriterAt.
+func WithCARv2(enabled bool) ExportOption {
+	return func(o *exportOptions) {
+		o.v2 = enabled
+	}
+}
+
+// WithDuplicates makes Export write a block every time the traversal reaches
+// it, as in the dups=y parameter of the trustless gateway specification. By
+// default every block is written once.
+func WithDuplicates(enabled bool) ExportOption {
+	return func(o *exportOptions) {
+		o.duplicates = enabled
+	}
+}
+
+// WithExportProgress sets a function called after every block written.
+func WithExportProgress(f func(Progress)) ExportOption {
+	return func(o *exportOptions) {
+		o.progress = f
+	}
+}
+
+// Export writes the DAGs under roots to w as a CAR, fetching blocks through
+// bs. Blocks are written in traversal order, so a CARv1 can be streamed to
+// the client while it is produced. It returns the final progress.
+func Export(ctx context.Context, bs blockservice.BlockService, roots []cid.Cid, w io.Writer, opts ...ExportOption) (Progress, error) {
+	o := exportOptions{scope: ScopeAll}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if len(roots) == 0 {
+		return Progress{}, errors.New("cannot export a CAR without roots")
+	}
+
+	sel, err := o.compileSelector()
+	if err != nil {
+		return Progress{}, err
+	}
+
+	cw, err := storage.NewWritable(w, roots,
+		carv2.WriteAsCarV1(!o.v2),
+		carv2.AllowDuplicatePuts(o.duplicates),
+	)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	e := &exporter{
+		ctx:      ctx,
+		getter:   blockservice.NewSession(ctx, bs),
+		cw:       cw,
+		opts:     o,
+		selector: sel,
+	}
+	e.lsys = cidlink.DefaultLinkSystem()
+	e.lsys.StorageReadOpener = e.open
+	unixfsnode.AddUnixFSReificationToLinkSystem(&e.lsys)
+
+	for _, root := range roots {
+		if err := e.export(root); err != nil {
+			return e.progress, fmt.Errorf("exporting %s: %w", root, err)
+		}
+	}
+	return e.progress, cw.Finalize()
+}
+
+func (o *exportOptions) compileSelector() (selector.Selector, error) {
+	switch {
+	case o.selector != nil:
+		return selector.CompileSelector(o.selector)
+	case o.scope == ScopeAll:
+		return selector.CompileSelector(unixfsnode.ExploreAllRecursivelySelector.Node())
+	case o.scope == ScopeEntity:
+		return selector.CompileSelector(unixfsnode.MatchUnixFSEntitySelector.Node())
+	case o.scope == ScopeBlock:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown dag scope %q", o.scope)
+	}
+}
+
+type exporter struct {
+	ctx      context.Context
+	getter   blockservice.BlockGetter
+	cw       storage.WritableCar
+	lsys     ipld.LinkSystem
+	opts     exportOptions
+	selector selector.Selector
+	progress Progress
+}
+
+// prototypeChooser decodes dag-pb nodes with their schema so UnixFS
+// reification works, and everything else as basic nodes.
+var prototypeChooser = dagpb.AddSupportToChooser(func(_ ipld.Link, lnkCtx ipld.LinkContext) (ipld.NodePrototype, error) {
+	if tlnkNd, ok := lnkCtx.LinkNode.(schema.TypedLinkNode); ok {
+		return tlnkNd.LinkTargetNodePrototype(), nil
+	}
+	return basicnode.Prototype.Any, nil
+})
+
+func (e *exporter) export(root cid.Cid) error {
+	lctx := ipld.LinkContext{Ctx: e.ctx}
+	lnk := cidlink.Link{Cid: root}
+
+	if e.selector == nil {
+		_, err := e.lsys.LoadRaw(lctx, lnk)
+		return err
+	}
+
+	np, err := prototypeChooser(lnk, lctx)
+	if err != nil {
+		return err
+	}
+	nd, err := e.lsys.Load(lctx, lnk, np)
+	if err != nil {
+		return err
+	}
+
+	progress := traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:                            e.ctx,
+			LinkSystem:                     e.lsys,
+			LinkTargetNodePrototypeChooser: prototypeChooser,
+			LinkVisitOnlyOnce:              !e.opts.duplicates,
+		},
+	}
+	return progress.WalkMatching(nd, e.selector, unixfsnode.BytesConsumingMatcher)
+}
+
+// open fetches the block and writes it to the CAR before handing it to the
+// traversal.
+func (e *exporter) open(_ ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+	cl, ok := lnk.(cidlink.Link)
+	if !ok {
+		return nil, fmt.Errorf("invalid link type for loading: %v", lnk)
+	}
+	c := cl.Cid
+
+	blk, err := e.getter.GetBlock(e.ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	data := blk.RawData()
+
+	// Identity blocks are not stored in the CAR.
+	if c.Prefix().MhType == mh.IDENTITY {
+		return bytes.NewReader(data), nil
+	}
+
+	if !e.opts.duplicates {
+		has, err := e.cw.Has(e.ctx, c.KeyString())
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			e.progress.Duplicates++
+			e.report()
+			return bytes.NewReader(data), nil
+		}
+	}
+
+	if err := e.cw.Put(e.ctx, c.KeyString(), data); err != nil {
+		return nil, err
+	}
+	e.progress.Blocks++
+	e.progress.Bytes += uint64(len(data))
+	e.report()
+	return bytes.NewReader(data), nil
+}
+
+func (e *exporter) report() {
+	if e.opts.progress != nil {
+		e.opts.progress(e.progress)
+	}
+}
@@ -0,0 +1,178 @@
+package car
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/verifcid"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// ErrHashMismatch is returned by Import when the data of a block does not
+// match its CID.
+var ErrHashMismatch = errors.New("block data does not match its CID")
+
+// defaultImportBatchSize is the default number of blocks written to the
+// blockstore at once.
+const defaultImportBatchSize = 256
+
+type importOptions struct {
+	pinName   string
+	batchSize int
+	progress  func(Progress)
+}
+
+// ImportOption configures Import.
+type ImportOption func(*importOptions)
+
+// WithPinName sets the name of the pins created for the roots.
+func WithPinName(name string) ImportOption {
+	return func(o *importOptions) {
+		o.pinName = name
+	}
+}
+
+// WithBatchSize sets the number of blocks written to the blockstore at once.
+func WithBatchSize(n int) ImportOption {
+	return func(o *importOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithImportProgress sets a function called after every block read.
+func WithImportProgress(f func(Progress)) ImportOption {
+	return func(o *importOptions) {
+		o.progress = f
+	}
+}
+
+// ImportResult is the outcome of Import.
+type ImportResult struct {
+	// Roots are the roots listed in the CAR header.
+	Roots []cid.Cid
+	Progress
+}
+
+// Import reads a CARv1 or CARv2 from r and writes its blocks to bs. Every
+// block is checked against its CID, which must use an allowed hash function,
+// and the import fails on the first invalid block. Blocks appearing more than
+// once in the CAR are written once and counted as duplicates.
+//
+// When pn is not nil, the roots are pinned recursively once all the blocks
+// are written, which requires the CAR to hold the complete DAGs (or the
+// pinner to be able to fetch the missing blocks). If bs is a
+// [blockstore.GCBlockstore], its pin lock is held from the first write until
+// the roots are pinned, so that a garbage collection cannot remove the
+// imported blocks in between.
+func Import(ctx context.Context, r io.Reader, bs blockstore.Blockstore, pn pin.Pinner, opts ...ImportOption) (ImportResult, error) {
+	o := importOptions{batchSize: defaultImportBatchSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize <= 0 {
+		o.batchSize = 1
+	}
+
+	if gcbs, ok := bs.(blockstore.GCBlockstore); ok && pn != nil {
+		defer gcbs.PinLock(ctx).Unlock(ctx)
+	}
+
+	// Blocks are verified below to report a typed error.
+	br, err := carv2.NewBlockReader(r, carv2.WithTrustedCAR(true))
+	if err != nil {
+		return ImportResult{}, err
+	}
+	res := ImportResult{Roots: br.Roots}
+
+	seen := make(map[string]struct{})
+	batch := make([]blocks.Block, 0, o.batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := bs.PutMany(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		blk, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		if err := verifyBlock(blk); err != nil {
+			return res, err
+		}
+
+		// Blockstores are keyed by multihash.
+		key := string(blk.Cid().Hash())
+		if _, ok := seen[key]; ok {
+			res.Duplicates++
+		} else {
+			seen[key] = struct{}{}
+			batch = append(batch, blk)
+			res.Blocks++
+			res.Bytes += uint64(len(blk.RawData()))
+			if len(batch) >= o.batchSize {
+				if err := flush(); err != nil {
+					return res, err
+				}
+			}
+		}
+		if o.progress != nil {
+			o.progress(res.Progress)
+		}
+	}
+	if err := flush(); err != nil {
+		return res, err
+	}
+
+	if pn == nil {
+		return res, nil
+	}
+	return res, pinRoots(ctx, bs, pn, res.Roots, o.pinName)
+}
+
+func verifyBlock(blk blocks.Block) error {
+	c := blk.Cid()
+	if err := verifcid.ValidateCid(verifcid.DefaultAllowlist, c); err != nil {
+		return fmt.Errorf("block %s: %w", c, err)
+	}
+	rehashed, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return fmt.Errorf("block %s: %w", c, err)
+	}
+	if !rehashed.Equals(c) {
+		return fmt.Errorf("block %s: %w", c, ErrHashMismatch)
+	}
+	return nil
+}
+
+func pinRoots(ctx context.Context, bs blockstore.Blockstore, pn pin.Pinner, roots []cid.Cid, name string) error {
+	dserv := merkledag.NewDAGService(blockservice.New(bs, nil))
+	for _, root := range roots {
+		nd, err := dserv.Get(ctx, root)
+		if err != nil {
+			return fmt.Errorf("pinning root %s: %w", root, err)
+		}
+		if err := pn.Pin(ctx, nd, true, name); err != nil {
+			return fmt.Errorf("pinning root %s: %w", root, err)
+		}
+	}
+	return pn.Flush(ctx)
+}
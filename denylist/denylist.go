@@ -0,0 +1,474 @@
+// Package denylist implements content blocking based on denylists in the
+// [compact denylist format].
+//
+// A [Denylist] is loaded from one or more files, which are reloaded when they
+// change, and can be enforced by the gateway (see gateway.Config.Denylist),
+// by a blockservice (see [Denylist.CheckCid] and
+// blockservice.WithContentBlocker) and by the bitswap server (see
+// [Denylist.PeerBlockRequestFilter]).
+//
+// The following rules are supported, one per line after the optional header
+// ending with a "---" line:
+//
+//	/ipfs/<cid>              block the CID, in any encoding, and all paths under it
+//	/ipfs/<cid>/<path>       block the path
+//	/ipfs/<cid>/<path>*      block the paths starting with <path>
+//	/ipns/<name>             block the IPNS name or DNSLink and all paths under it
+//	/ipns/<name>/<path>      block the path, a trailing "*" blocks a prefix
+//	//<sha256 hash>          block double-hashed content, see below
+//	!<rule>                  allow content blocked by other rules
+//
+// Lines starting with "#" are comments. Double-hashed entries are a sha2-256
+// [multihash], in base58btc, or the hex-encoded sha2-256 digest, of one of:
+//
+//   - the base58btc multihash of a CID, which blocks the CID
+//   - "<CIDv1 base32>/<path>", which blocks the path. For backwards
+//     compatibility with the legacy badbits list, "<CIDv1 base32>/" blocks the
+//     CID itself
+//   - "<name>" or "<name>/<path>", which block IPNS names and paths under them
+//
+// [compact denylist format]: https://specs.ipfs.tech/compact-denylist-format/
+// [multihash]: https://multiformats.io/multihash/
+package denylist
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("denylist")
+
+// ErrContentBlocked is matched, using [errors.Is], by the errors returned for
+// blocked content.
+var ErrContentBlocked = errors.New("content is blocked")
+
+// BlockedError is returned for content blocked by a [Denylist].
+type BlockedError struct {
+	// Content is the blocked CID or path.
+	Content string
+}
+
+// Error returns a message compatible with the errors of other content
+// blocking implementations, which the gateway turns into HTTP 410 Gone.
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("%s is blocked and cannot be provided", e.Content)
+}
+
+func (e *BlockedError) Is(err error) bool {
+	return err == ErrContentBlocked
+}
+
+// Denylist decides whether content is blocked. It is safe for concurrent use.
+type Denylist struct {
+	files []string
+
+	rules atomic.Pointer[ruleSet]
+
+	// reloadLk serializes reloads and protects stamps.
+	reloadLk sync.Mutex
+	stamps   map[string]fileStamp
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// New returns a [Denylist] enforcing the rules of all the given files. The
+// files are checked for changes every reloadInterval until ctx is done or the
+// denylist is closed. A zero interval disables reloading,
+// [Denylist.Reload] can then be called manually.
+func New(ctx context.Context, reloadInterval time.Duration, files ...string) (*Denylist, error) {
+	d := &Denylist{
+		files:   files,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval <= 0 {
+		close(d.done)
+		return d, nil
+	}
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := d.Reload(); err != nil {
+					log.Errorw("reloading denylist", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			case <-d.closing:
+				return
+			}
+		}
+	}()
+	return d, nil
+}
+
+// Parse returns a [Denylist] enforcing the rules read from r, which is never
+// reloaded.
+func Parse(r io.Reader) (*Denylist, error) {
+	rs := newRuleSet()
+	if err := rs.parse(r, "denylist"); err != nil {
+		return nil, err
+	}
+	d := &Denylist{
+		stamps:  make(map[string]fileStamp),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	close(d.done)
+	d.rules.Store(rs)
+	return d, nil
+}
+
+// Reload reloads the files if any of them changed since the last load. When
+// a file cannot be read, the previous rules stay in force.
+func (d *Denylist) Reload() error {
+	d.reloadLk.Lock()
+	defer d.reloadLk.Unlock()
+
+	stamps := make(map[string]fileStamp, len(d.files))
+	changed := d.stamps == nil
+	for _, name := range d.files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		stamps[name] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		if old, ok := d.stamps[name]; !ok || old.size != info.Size() || !old.modTime.Equal(info.ModTime()) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	rs := newRuleSet()
+	for _, name := range d.files {
+		if err := rs.parseFile(name); err != nil {
+			return err
+		}
+	}
+	d.rules.Store(rs)
+	d.stamps = stamps
+	log.Debugw("loaded denylist", "files", len(d.files), "rules", rs.count)
+	return nil
+}
+
+// Close stops reloading the files.
+func (d *Denylist) Close() error {
+	d.closeOnce.Do(func() { close(d.closing) })
+	<-d.done
+	return nil
+}
+
+// CheckCid returns a [*BlockedError] if c is blocked. Only the rules blocking
+// whole CIDs apply, since the path used to reach c is unknown.
+func (d *Denylist) CheckCid(c cid.Cid) error {
+	if d.rules.Load().cidBlocked(c) {
+		return &BlockedError{Content: c.String()}
+	}
+	return nil
+}
+
+// CheckPath returns a [*BlockedError] if p is blocked: either its root CID or
+// name, or the path itself.
+func (d *Denylist) CheckPath(p path.Path) error {
+	rs := d.rules.Load()
+	segments := p.Segments()
+	if len(segments) < 2 {
+		return nil
+	}
+	subpath := strings.Join(segments[2:], "/")
+
+	var blocked bool
+	switch p.Namespace() {
+	case path.IPFSNamespace:
+		c, err := cid.Decode(segments[1])
+		if err != nil {
+			return nil
+		}
+		blocked = rs.cidBlocked(c) || rs.cidPathBlocked(c, subpath)
+	case path.IPNSNamespace:
+		blocked = rs.nameBlocked(segments[1], subpath)
+	}
+	if blocked {
+		return &BlockedError{Content: p.String()}
+	}
+	return nil
+}
+
+// PeerBlockRequestFilter can be passed to bitswap.WithPeerBlockRequestFilter
+// to refuse serving blocked CIDs to other peers.
+func (d *Denylist) PeerBlockRequestFilter(_ peer.ID, c cid.Cid) bool {
+	return d.CheckCid(c) == nil
+}
+
+// ruleSet is an immutable set of rules, replaced as a whole on reload.
+type ruleSet struct {
+	block, allow rules
+	count        int
+}
+
+type rules struct {
+	// cids are the multihashes of the blocked CIDs.
+	cids map[string]struct{}
+	// cidPaths are the path rules, by multihash.
+	cidPaths map[string][]pathRule
+	// names are the path rules for IPNS names. A whole name is blocked by a
+	// prefix rule with an empty path.
+	names map[string][]pathRule
+	// hashes are the sha2-256 digests of double-hashed entries.
+	hashes map[string]struct{}
+}
+
+type pathRule struct {
+	path   string
+	prefix bool
+}
+
+func (r pathRule) match(p string) bool {
+	if r.prefix {
+		return strings.HasPrefix(p, r.path)
+	}
+	return p == r.path
+}
+
+func newRules() rules {
+	return rules{
+		cids:     make(map[string]struct{}),
+		cidPaths: make(map[string][]pathRule),
+		names:    make(map[string][]pathRule),
+		hashes:   make(map[string]struct{}),
+	}
+}
+
+func newRuleSet() *ruleSet {
+	return &ruleSet{block: newRules(), allow: newRules()}
+}
+
+func (rs *ruleSet) parseFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rs.parse(f, name)
+}
+
+// parse adds the rules read from r. Invalid rules are logged and skipped so
+// that a single bad line does not disable the whole list.
+func (rs *ruleSet) parse(r io.Reader, name string) error {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	headerEnd := -1
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "---" && headerEnd < 0 {
+			headerEnd = len(lines)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+
+	for n, line := range lines[headerEnd+1:] {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := rs.add(line); err != nil {
+			log.Warnw("skipping invalid denylist rule", "file", name, "line", headerEnd+n+2, "error", err)
+			continue
+		}
+		rs.count++
+	}
+	return nil
+}
+
+func (rs *ruleSet) add(line string) error {
+	r := &rs.block
+	if rest, ok := strings.CutPrefix(line, "!"); ok {
+		r = &rs.allow
+		line = rest
+	}
+
+	if hash, ok := strings.CutPrefix(line, "//"); ok {
+		digest, err := decodeDoubleHash(hash)
+		if err != nil {
+			return err
+		}
+		r.hashes[string(digest)] = struct{}{}
+		return nil
+	}
+
+	p, err := path.NewPath(line)
+	if err != nil {
+		return err
+	}
+	segments := p.Segments()
+	rule := pathRule{path: strings.Join(segments[2:], "/")}
+	rule.path, rule.prefix = strings.CutSuffix(rule.path, "*")
+
+	switch p.Namespace() {
+	case path.IPFSNamespace:
+		c, err := cid.Decode(segments[1])
+		if err != nil {
+			return err
+		}
+		mh := string(c.Hash())
+		if rule.path == "" && !rule.prefix {
+			r.cids[mh] = struct{}{}
+		} else {
+			r.cidPaths[mh] = append(r.cidPaths[mh], rule)
+		}
+	case path.IPNSNamespace:
+		name := normalizeName(segments[1])
+		if rule.path == "" {
+			rule.prefix = true
+		}
+		r.names[name] = append(r.names[name], rule)
+	default:
+		return fmt.Errorf("unsupported namespace %q", p.Namespace())
+	}
+	return nil
+}
+
+// decodeDoubleHash returns the sha2-256 digest of a double-hashed entry.
+func decodeDoubleHash(s string) ([]byte, error) {
+	if len(s) == 2*sha256.Size {
+		if digest, err := hex.DecodeString(s); err == nil {
+			return digest, nil
+		}
+	}
+	mh, err := multihash.FromB58String(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid double-hashed entry: %w", err)
+	}
+	dmh, err := multihash.Decode(mh)
+	if err != nil {
+		return nil, err
+	}
+	if dmh.Code != multihash.SHA2_256 {
+		return nil, fmt.Errorf("unsupported double-hash function %s", dmh.Name)
+	}
+	return dmh.Digest, nil
+}
+
+// normalizeName returns the canonical representation of IPNS names, so that
+// they are matched whatever their encoding, and lowercases DNSLink names.
+func normalizeName(name string) string {
+	if n, err := ipns.NameFromString(name); err == nil {
+		return n.String()
+	}
+	return strings.ToLower(name)
+}
+
+func doubleHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return string(sum[:])
+}
+
+func cidV1String(c cid.Cid) string {
+	if c.Version() == 0 {
+		c = cid.NewCidV1(cid.DagProtobuf, c.Hash())
+	}
+	s, _ := c.StringOfBase(multibase.Base32)
+	return s
+}
+
+func (r *rules) cidMatch(c cid.Cid) bool {
+	if _, ok := r.cids[string(c.Hash())]; ok {
+		return true
+	}
+	if len(r.hashes) == 0 {
+		return false
+	}
+	for _, s := range []string{c.Hash().B58String(), cidV1String(c) + "/"} {
+		if _, ok := r.hashes[doubleHash(s)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rules) cidPathMatch(c cid.Cid, subpath string) bool {
+	if subpath == "" {
+		return false
+	}
+	for _, rule := range r.cidPaths[string(c.Hash())] {
+		if rule.match(subpath) {
+			return true
+		}
+	}
+	if len(r.hashes) > 0 {
+		if _, ok := r.hashes[doubleHash(cidV1String(c)+"/"+subpath)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rules) nameMatch(name, subpath string) bool {
+	for _, rule := range r.names[normalizeName(name)] {
+		if rule.match(subpath) {
+			return true
+		}
+	}
+	if len(r.hashes) == 0 {
+		return false
+	}
+	if _, ok := r.hashes[doubleHash(name)]; ok {
+		return true
+	}
+	if subpath != "" {
+		if _, ok := r.hashes[doubleHash(name+"/"+subpath)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *ruleSet) cidBlocked(c cid.Cid) bool {
+	return rs.block.cidMatch(c) && !rs.allow.cidMatch(c)
+}
+
+func (rs *ruleSet) cidPathBlocked(c cid.Cid, subpath string) bool {
+	return rs.block.cidPathMatch(c, subpath) && !rs.allow.cidPathMatch(c, subpath)
+}
+
+func (rs *ruleSet) nameBlocked(name, subpath string) bool {
+	return rs.block.nameMatch(name, subpath) && !rs.allow.nameMatch(name, subpath)
+}
@@ -0,0 +1,107 @@
+package denylist
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-test/random"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func mustPath(t *testing.T, s string) path.Path {
+	p, err := path.NewPath(s)
+	require.NoError(t, err)
+	return p
+}
+
+func TestDenylist(t *testing.T) {
+	cids := random.Cids(6)
+	blocked, dir, hashed, legacy, allowed, other := cids[0], cids[1], cids[2], cids[3], cids[4], cids[5]
+
+	mh, err := multihash.Sum([]byte(hashed.Hash().B58String()), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(cidV1String(legacy) + "/secret"))
+
+	dl, err := Parse(strings.NewReader(strings.Join([]string{
+		"version: 1",
+		"name: test",
+		"---",
+		"# comment",
+		"/ipfs/" + blocked.String(),
+		"/ipfs/" + dir.String() + "/a/b",
+		"/ipfs/" + dir.String() + "/prefix*",
+		"//" + mh.B58String(),
+		"//" + hex.EncodeToString(sum[:]),
+		"/ipfs/" + allowed.String(),
+		"!/ipfs/" + allowed.String(),
+		"/ipns/Example.com",
+		"/ipns/example.org/private*",
+		"/ipfs/invalid-cid",
+	}, "\n")))
+	require.NoError(t, err)
+
+	// Whole CIDs, whatever their encoding.
+	require.ErrorIs(t, dl.CheckCid(blocked), ErrContentBlocked)
+	require.ErrorIs(t, dl.CheckCid(cid.NewCidV1(cid.Raw, blocked.Hash())), ErrContentBlocked)
+	require.ErrorIs(t, dl.CheckPath(mustPath(t, "/ipfs/"+blocked.String()+"/any/path")), ErrContentBlocked)
+	require.ErrorContains(t, dl.CheckCid(blocked), "blocked and cannot be provided")
+	require.NoError(t, dl.CheckCid(other))
+	require.NoError(t, dl.CheckCid(allowed))
+	require.False(t, dl.PeerBlockRequestFilter("", blocked))
+	require.True(t, dl.PeerBlockRequestFilter("", other))
+
+	// Paths.
+	require.NoError(t, dl.CheckCid(dir))
+	require.NoError(t, dl.CheckPath(mustPath(t, "/ipfs/"+dir.String()+"/a")))
+	require.ErrorIs(t, dl.CheckPath(mustPath(t, "/ipfs/"+dir.String()+"/a/b")), ErrContentBlocked)
+	require.NoError(t, dl.CheckPath(mustPath(t, "/ipfs/"+dir.String()+"/a/b/c")))
+	require.ErrorIs(t, dl.CheckPath(mustPath(t, "/ipfs/"+dir.String()+"/prefix-and-more/c")), ErrContentBlocked)
+
+	// Double-hashed entries.
+	require.ErrorIs(t, dl.CheckCid(hashed), ErrContentBlocked)
+	require.NoError(t, dl.CheckCid(legacy))
+	require.ErrorIs(t, dl.CheckPath(mustPath(t, "/ipfs/"+legacy.String()+"/secret")), ErrContentBlocked)
+
+	// IPNS names.
+	require.ErrorIs(t, dl.CheckPath(mustPath(t, "/ipns/example.com")), ErrContentBlocked)
+	require.ErrorIs(t, dl.CheckPath(mustPath(t, "/ipns/example.com/file")), ErrContentBlocked)
+	require.NoError(t, dl.CheckPath(mustPath(t, "/ipns/example.org")))
+	require.ErrorIs(t, dl.CheckPath(mustPath(t, "/ipns/example.org/private/file")), ErrContentBlocked)
+}
+
+func TestDenylistReload(t *testing.T) {
+	c := random.Cids(1)[0]
+	file := filepath.Join(t.TempDir(), "list.deny")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	dl, err := New(context.Background(), 0, file)
+	require.NoError(t, err)
+	defer dl.Close()
+	require.NoError(t, dl.CheckCid(c))
+
+	require.NoError(t, os.WriteFile(file, []byte("/ipfs/"+c.String()+"\n"), 0o644))
+	require.NoError(t, dl.Reload())
+	require.ErrorIs(t, dl.CheckCid(c), ErrContentBlocked)
+
+	// The previous rules stay in force when the file disappears.
+	require.NoError(t, os.Remove(file))
+	require.Error(t, dl.Reload())
+	require.ErrorIs(t, dl.CheckCid(c), ErrContentBlocked)
+
+	// Changes are picked up in the background.
+	require.NoError(t, os.WriteFile(file, []byte("# empty\n"), 0o644))
+	dl, err = New(context.Background(), 10*time.Millisecond, file)
+	require.NoError(t, err)
+	defer dl.Close()
+	require.NoError(t, os.WriteFile(file, []byte("/ipfs/"+c.String()+"\n"), 0o644))
+	require.Eventually(t, func() bool { return dl.CheckCid(c) != nil }, 5*time.Second, 10*time.Millisecond)
+}
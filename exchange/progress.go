@@ -0,0 +1,98 @@
+package exchange
+
+import (
+	"context"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// SessionProgress is implemented by the sessions of exchanges which report
+// how a download is doing. The [Fetcher] returned by
+// [SessionExchange.NewSession] can be type-asserted to it.
+type SessionProgress interface {
+	// Stats returns a snapshot of the progress of the session.
+	Stats() SessionStats
+	// Events returns a channel of progress events. The channel is closed
+	// when ctx is done or when the session shuts down. Events are dropped
+	// when the receiver does not keep up, so use Stats for exact counts.
+	Events(ctx context.Context) <-chan SessionEvent
+}
+
+// SessionStats is a snapshot of the progress of a session.
+type SessionStats struct {
+	// Peers is the number of peers in the session.
+	Peers int
+	// WantsPending is the number of wants which were requested but not sent
+	// to any peer yet.
+	WantsPending int
+	// WantsLive is the number of wants which were sent to peers and are
+	// waiting for a block.
+	WantsLive int
+	// BlocksReceived is the number of wanted blocks received from peers.
+	BlocksReceived uint64
+	// BytesReceived is the total size of the wanted blocks received from
+	// peers.
+	BytesReceived uint64
+	// DupBlocksReceived is the number of blocks which the session received
+	// more than once, before it cancelled the want.
+	DupBlocksReceived uint64
+	// HavesReceived and DontHavesReceived count the HAVE and DONT_HAVE
+	// responses for the wants of the session.
+	HavesReceived     uint64
+	DontHavesReceived uint64
+	// AverageLatency is the average time between sending a want and
+	// receiving the block.
+	AverageLatency time.Duration
+}
+
+// DupRate returns the fraction of the received blocks which were duplicates.
+func (s SessionStats) DupRate() float64 {
+	total := s.BlocksReceived + s.DupBlocksReceived
+	if total == 0 {
+		return 0
+	}
+	return float64(s.DupBlocksReceived) / float64(total)
+}
+
+// SessionEventType is the kind of a [SessionEvent].
+type SessionEventType int
+
+const (
+	// SessionBlockReceived is sent when a wanted block is received.
+	SessionBlockReceived SessionEventType = iota
+	// SessionDuplicateBlock is sent when a block which was already received
+	// arrives from another peer.
+	SessionDuplicateBlock
+	// SessionPeerAdded is sent when a peer joins the session.
+	SessionPeerAdded
+	// SessionPeerRemoved is sent when a peer leaves the session.
+	SessionPeerRemoved
+)
+
+func (t SessionEventType) String() string {
+	switch t {
+	case SessionBlockReceived:
+		return "block-received"
+	case SessionDuplicateBlock:
+		return "duplicate-block"
+	case SessionPeerAdded:
+		return "peer-added"
+	case SessionPeerRemoved:
+		return "peer-removed"
+	default:
+		return "unknown"
+	}
+}
+
+// SessionEvent describes a change in the progress of a session.
+type SessionEvent struct {
+	Type SessionEventType
+	// Peer is the peer which sent the block, or joined or left the session.
+	Peer peer.ID
+	// Cid is the CID of the block, for block events.
+	Cid cid.Cid
+	// Size is the size of the block, for SessionBlockReceived events.
+	Size int
+}
@@ -2,12 +2,15 @@ package files
 
 import (
 	"archive/tar"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
 	"path"
 	"strings"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 var ErrUnixFSPathOutsideRoot = errors.New("relative UnixFS paths outside the root are not allowed, use CAR instead")
@@ -17,6 +20,8 @@ type TarWriter struct {
 	baseDirSet bool
 	baseDir    string
 	format     tar.Format
+	// compressor, when set, compresses the archive and is closed with it.
+	compressor io.WriteCloser
 }
 
 // NewTarWriter wraps given io.Writer into a new tar writer
@@ -27,6 +32,31 @@ func NewTarWriter(w io.Writer) (*TarWriter, error) {
 	}, nil
 }
 
+// NewGzipTarWriter wraps given io.Writer into a new tar writer compressing the
+// archive with gzip.
+func NewGzipTarWriter(w io.Writer) (*TarWriter, error) {
+	return newCompressedTarWriter(gzip.NewWriter(w))
+}
+
+// NewZstdTarWriter wraps given io.Writer into a new tar writer compressing the
+// archive with zstd.
+func NewZstdTarWriter(w io.Writer) (*TarWriter, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return newCompressedTarWriter(zw)
+}
+
+func newCompressedTarWriter(compressor io.WriteCloser) (*TarWriter, error) {
+	tw, err := NewTarWriter(compressor)
+	if err != nil {
+		return nil, err
+	}
+	tw.compressor = compressor
+	return tw, nil
+}
+
 func (w *TarWriter) writeDir(f Directory, fpath string) error {
 	if err := w.writeHeader(f, fpath, 0); err != nil {
 		return err
@@ -101,9 +131,16 @@ func (w *TarWriter) WriteFile(nd Node, fpath string) error {
 	}
 }
 
-// Close closes the tar writer.
+// Close closes the tar writer, and the compressor of compressed archives. It
+// does not close the underlying writer.
 func (w *TarWriter) Close() error {
-	return w.TarW.Close()
+	err := w.TarW.Close()
+	if w.compressor != nil {
+		if cerr := w.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
 func (w *TarWriter) writeHeader(n Node, fpath string, size int64) error {
@@ -2,10 +2,14 @@ package files
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
 	"testing"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestTarWriter(t *testing.T) {
@@ -162,3 +166,51 @@ func TestTarWriterFailsFileOutsideRootWithBaseDir(t *testing.T) {
 		t.Errorf("unexpected error, wanted: %v; got: %v", ErrUnixFSPathOutsideRoot, err)
 	}
 }
+
+func TestCompressedTarWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		newWriter func(io.Writer) (*TarWriter, error)
+		newReader func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", NewGzipTarWriter, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"zstd", NewZstdTarWriter, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw, err := tc.newWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.WriteFile(NewBytesFile([]byte(text)), "file.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := tc.newReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(zr)
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name != "file.txt" {
+				t.Errorf("got wrong name: %s", hdr.Name)
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != text {
+				t.Errorf("got wrong content: %q", content)
+			}
+			if _, err := tr.Next(); err != io.EOF {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package files
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// ZipWriter writes nodes to a zip archive. Entries are streamed, the archive
+// never needs to be held in memory.
+//
+// The unix permissions and modification time of the nodes are stored in the
+// external attributes and the extended timestamp extra field of the entries.
+type ZipWriter struct {
+	ZipW       *zip.Writer
+	baseDirSet bool
+	baseDir    string
+}
+
+// NewZipWriter wraps given io.Writer into a new zip writer
+func NewZipWriter(w io.Writer) (*ZipWriter, error) {
+	return &ZipWriter{
+		ZipW: zip.NewWriter(w),
+	}, nil
+}
+
+// WriteFile adds a node to the archive.
+func (w *ZipWriter) WriteFile(nd Node, fpath string) error {
+	if !w.baseDirSet {
+		w.baseDirSet = true // Use a variable for this as baseDir may be an empty string.
+		w.baseDir = fpath
+	}
+
+	if !validateTarFilePath(w.baseDir, fpath) {
+		return ErrUnixFSPathOutsideRoot
+	}
+
+	switch nd := nd.(type) {
+	case *Symlink:
+		hdr := w.header(nd, fpath, os.ModeSymlink)
+		zw, err := w.ZipW.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(zw, nd.Target)
+		return err
+	case File:
+		return w.writeFile(nd, fpath)
+	case Directory:
+		return w.writeDir(nd, fpath)
+	default:
+		return fmt.Errorf("file type %T is not supported", nd)
+	}
+}
+
+func (w *ZipWriter) writeDir(f Directory, fpath string) error {
+	// The root directory of an archive without base directory has no entry.
+	if fpath != "" {
+		if _, err := w.ZipW.CreateHeader(w.header(f, fpath+"/", os.ModeDir)); err != nil {
+			return err
+		}
+	}
+
+	it := f.Entries()
+	for it.Next() {
+		if err := w.WriteFile(it.Node(), path.Join(fpath, it.Name())); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func (w *ZipWriter) writeFile(f File, fpath string) error {
+	hdr := w.header(f, fpath, 0)
+	hdr.Method = zip.Deflate
+	if size, err := f.Size(); err == nil && size >= 0 {
+		hdr.UncompressedSize64 = uint64(size)
+	}
+
+	zw, err := w.ZipW.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, f); err != nil {
+		return err
+	}
+	return w.ZipW.Flush()
+}
+
+func (w *ZipWriter) header(n Node, fpath string, typ os.FileMode) *zip.FileHeader {
+	hdr := &zip.FileHeader{
+		Name:   fpath,
+		Method: zip.Store,
+	}
+	hdr.SetMode(typ | UnixPermsToModePerms(UnixPermsOrDefault(n)))
+
+	if m := n.ModTime(); m.IsZero() {
+		hdr.Modified = time.Now()
+	} else {
+		hdr.Modified = m
+	}
+	return hdr
+}
+
+// Close finishes writing the archive. It does not close the underlying
+// writer.
+func (w *ZipWriter) Close() error {
+	return w.ZipW.Close()
+}
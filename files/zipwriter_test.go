@@ -0,0 +1,82 @@
+package files
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZipWriter(t *testing.T) {
+	tf := NewMapDirectory(map[string]Node{
+		"file.txt": NewBytesFile([]byte(text)),
+		"boop": NewMapStatDirectory(map[string]Node{
+			"a.txt": NewBytesFile([]byte("bleep")),
+		}, &mockFileInfo{name: "", mode: 0o750, mtime: time.Unix(1600000000, 0)}),
+		"beep.txt": NewBytesStatFile([]byte("beep"),
+			&mockFileInfo{name: "beep.txt", size: 4, mode: 0o766, mtime: time.Unix(1604320500, 0)}),
+		"boop-sl": NewSymlinkFile("boop", time.Unix(1600050000, 0)),
+	})
+
+	var buf bytes.Buffer
+	zw, err := NewZipWriter(&buf)
+	require.NoError(t, err)
+	require.NoError(t, zw.WriteFile(tf, "root"))
+	require.NoError(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	type entry struct {
+		name    string
+		mode    os.FileMode
+		mtime   time.Time
+		content string
+	}
+	var entries []entry
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		entries = append(entries, entry{f.Name, f.Mode(), f.Modified, string(content)})
+	}
+
+	require.Len(t, entries, 6)
+	check := func(e entry, name string, mode os.FileMode, mtime time.Time, content string) {
+		t.Helper()
+		require.Equal(t, name, e.name)
+		require.Equal(t, mode, e.mode)
+		if !mtime.IsZero() {
+			require.True(t, mtime.Equal(e.mtime), "got wrong timestamp: %s != %s", e.mtime, mtime)
+		}
+		require.Equal(t, content, e.content)
+	}
+	check(entries[0], "root/", os.ModeDir|0o755, time.Time{}, "")
+	check(entries[1], "root/beep.txt", 0o766, time.Unix(1604320500, 0), "beep")
+	check(entries[2], "root/boop/", os.ModeDir|0o750, time.Unix(1600000000, 0), "")
+	check(entries[3], "root/boop/a.txt", 0o644, time.Time{}, "bleep")
+	check(entries[4], "root/boop-sl", os.ModeSymlink|0o777, time.Unix(1600050000, 0), "boop")
+	check(entries[5], "root/file.txt", 0o644, time.Time{}, text)
+}
+
+func TestZipWriterFailsFileOutsideRoot(t *testing.T) {
+	tf := NewMapDirectory(map[string]Node{
+		"boop": NewMapDirectory(map[string]Node{
+			"../../a.txt": NewBytesFile([]byte("bleep")),
+		}),
+	})
+
+	zw, err := NewZipWriter(io.Discard)
+	require.NoError(t, err)
+	defer zw.Close()
+	if err = zw.WriteFile(tf, ""); !errors.Is(err, ErrUnixFSPathOutsideRoot) {
+		t.Errorf("unexpected error, wanted: %v; got: %v", ErrUnixFSPathOutsideRoot, err)
+	}
+}
@@ -0,0 +1,329 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	// AccessTokenQueryParam is the query parameter of signed URLs, which
+	// carries a bearer token as specified by RFC 6750.
+	AccessTokenQueryParam = "access_token"
+
+	// accessTokenCookie is the cookie storing the token of a signed URL on
+	// subdomain and DNSLink gateways, so that the resources loaded by the
+	// content are authorized too.
+	accessTokenCookie = "ipfs-access-token"
+)
+
+// Authorizer decides whether requests may access content, see
+// [Config.Authorizer].
+type Authorizer interface {
+	// Authorize returns the grant of the request r for the content path p, or
+	// an error when access is refused. Errors are returned to the client with
+	// HTTP 401 Unauthorized, unless they carry another status code, see
+	// [NewErrorStatusCode].
+	Authorize(r *http.Request, p path.Path) (*Grant, error)
+}
+
+// AuthorizerFunc is a function implementing [Authorizer].
+type AuthorizerFunc func(r *http.Request, p path.Path) (*Grant, error)
+
+func (f AuthorizerFunc) Authorize(r *http.Request, p path.Path) (*Grant, error) {
+	return f(r, p)
+}
+
+// Grant is the access granted to an authorized request.
+type Grant struct {
+	// Formats are the allowed response formats, by their ?format= name, such
+	// as "raw" or "car". The empty string is the default, deserialized,
+	// response format. All formats are allowed when Formats is nil.
+	Formats []string
+
+	// Expiry is when the grant expires. On subdomain and DNSLink gateways,
+	// the token of signed URLs is stored in a cookie of the origin until then.
+	Expiry time.Time
+}
+
+func (g *Grant) allowsFormat(responseFormat string) bool {
+	return g.Formats == nil || slices.Contains(g.Formats, responseFormatToFormatParam[responseFormat])
+}
+
+// BearerToken returns the bearer token of r from, in order of precedence, the
+// Authorization header, the [AccessTokenQueryParam] query parameter of signed
+// URLs, or the cookie set by the gateway for signed URLs.
+func BearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if token := r.URL.Query().Get(AccessTokenQueryParam); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(accessTokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// Capability is the access granted by a token of an [HMACAuthorizer].
+type Capability struct {
+	// Scope is the content path, such as /ipfs/{cid} or /ipns/{name}/dir,
+	// the token grants access to, along with the paths below it. CIDs match
+	// regardless of their version and multibase.
+	Scope string
+
+	// Expiry is when the token expires. Tokens do not expire when it is zero.
+	Expiry time.Time
+
+	// Formats are the allowed response formats, see [Grant.Formats].
+	Formats []string
+}
+
+// capabilityClaims is the JSON encoding of a [Capability] in tokens.
+type capabilityClaims struct {
+	Scope   string   `json:"scope"`
+	Expiry  int64    `json:"exp,omitempty"`
+	Formats []string `json:"fmt,omitempty"`
+}
+
+// HMACAuthorizer is an [Authorizer] validating tokens signed with a shared
+// key. Tokens carry a [Capability] and are made of its JSON encoding and its
+// HMAC-SHA256, both base64url encoded and separated by a dot.
+type HMACAuthorizer struct {
+	key []byte
+	now func() time.Time
+}
+
+var _ Authorizer = (*HMACAuthorizer)(nil)
+
+// NewHMACAuthorizer returns an [HMACAuthorizer] signing and validating tokens
+// with key.
+func NewHMACAuthorizer(key []byte) *HMACAuthorizer {
+	return &HMACAuthorizer{key: slices.Clone(key), now: time.Now}
+}
+
+// Sign returns a token granting c.
+func (a *HMACAuthorizer) Sign(c Capability) (string, error) {
+	if _, err := path.NewPath(c.Scope); err != nil {
+		return "", fmt.Errorf("invalid scope: %w", err)
+	}
+	claims := capabilityClaims{Scope: c.Scope, Formats: c.Formats}
+	if !c.Expiry.IsZero() {
+		claims.Expiry = c.Expiry.Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+	return enc + "." + base64.RawURLEncoding.EncodeToString(a.mac(enc)), nil
+}
+
+// SignURL returns a copy of u with a token granting c in the
+// [AccessTokenQueryParam] query parameter.
+func (a *HMACAuthorizer) SignURL(u *url.URL, c Capability) (*url.URL, error) {
+	token, err := a.Sign(c)
+	if err != nil {
+		return nil, err
+	}
+	signed := *u
+	query := signed.Query()
+	query.Set(AccessTokenQueryParam, token)
+	signed.RawQuery = query.Encode()
+	return &signed, nil
+}
+
+func (a *HMACAuthorizer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// Authorize validates the bearer token of r, see [BearerToken], and checks
+// that its scope includes p.
+func (a *HMACAuthorizer) Authorize(r *http.Request, p path.Path) (*Grant, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, NewErrorStatusCode(errors.New("missing access token"), http.StatusUnauthorized)
+	}
+	c, err := a.verify(token)
+	if err != nil {
+		return nil, NewErrorStatusCode(err, http.StatusUnauthorized)
+	}
+	if !c.Expiry.IsZero() && a.now().After(c.Expiry) {
+		return nil, NewErrorStatusCode(errors.New("access token expired"), http.StatusUnauthorized)
+	}
+	scope, err := path.NewPath(c.Scope)
+	if err != nil {
+		return nil, NewErrorStatusCode(fmt.Errorf("invalid access token scope: %w", err), http.StatusUnauthorized)
+	}
+	if !pathHasPrefix(p, scope) {
+		return nil, NewErrorStatusCode(fmt.Errorf("access token does not grant access to %s", p), http.StatusForbidden)
+	}
+	return &Grant{Formats: c.Formats, Expiry: c.Expiry}, nil
+}
+
+func (a *HMACAuthorizer) verify(token string) (*Capability, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errors.New("malformed access token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, a.mac(payload)) {
+		return nil, errors.New("invalid access token signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed access token: %w", err)
+	}
+	var claims capabilityClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, fmt.Errorf("malformed access token: %w", err)
+	}
+	c := &Capability{Scope: claims.Scope, Formats: claims.Formats}
+	if claims.Expiry != 0 {
+		c.Expiry = time.Unix(claims.Expiry, 0)
+	}
+	return c, nil
+}
+
+// pathHasPrefix returns whether p is prefix, or a path below it. The root
+// CIDs of /ipfs/ paths are compared by multihash and codec, and IPNS names by
+// their canonical form.
+func pathHasPrefix(p, prefix path.Path) bool {
+	segs, prefixSegs := p.Segments(), prefix.Segments()
+	if len(prefixSegs) > len(segs) || segs[0] != prefixSegs[0] {
+		return false
+	}
+	if !sameRoot(segs[0], segs[1], prefixSegs[1]) {
+		return false
+	}
+	for n := 2; n < len(prefixSegs); n++ {
+		if segs[n] != prefixSegs[n] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRoot(ns, a, b string) bool {
+	if a == b {
+		return true
+	}
+	switch ns {
+	case path.IPFSNamespace:
+		ca, errA := cid.Decode(a)
+		cb, errB := cid.Decode(b)
+		return errA == nil && errB == nil && ca.Type() == cb.Type() && bytes.Equal(ca.Hash(), cb.Hash())
+	case path.IPNSNamespace:
+		na, errA := ipns.NameFromString(a)
+		nb, errB := ipns.NameFromString(b)
+		if errA == nil && errB == nil {
+			return na.Equal(nb)
+		}
+		return strings.EqualFold(a, b)
+	}
+	return false
+}
+
+// authorizer returns the authorizer of the gateway hostname of r.
+func (i *handler) authorizer(r *http.Request) Authorizer {
+	if gw, ok := i.publicGateway(r); ok && gw.Authorizer != nil {
+		return gw.Authorizer
+	}
+	return i.config.Authorizer
+}
+
+// authorizeRequest checks that r may access contentPath in its response
+// format. Authorized responses must not be stored by shared caches, so the
+// returned writer marks them as private.
+func (i *handler) authorizeRequest(w http.ResponseWriter, r *http.Request, authorizer Authorizer, contentPath path.Path) (http.ResponseWriter, bool) {
+	grant, err := authorizer.Authorize(r, contentPath)
+	if err != nil {
+		var esc *ErrorStatusCode
+		if !errors.As(err, &esc) || esc.StatusCode == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ipfs-gateway"`)
+		}
+		i.webError(w, r, err, http.StatusUnauthorized)
+		return w, false
+	}
+	if grant == nil {
+		grant = &Grant{}
+	}
+
+	// Invalid formats are refused later on.
+	if responseFormat, _, err := customResponseFormat(r); err == nil && !grant.allowsFormat(responseFormat) {
+		err := fmt.Errorf("access token does not allow the %q response format", responseFormat)
+		i.webError(w, r, err, http.StatusForbidden)
+		return w, false
+	}
+
+	// Subdomain and DNSLink gateways give each site its own origin, the token
+	// of signed URLs is kept in a cookie of the origin for the resources
+	// loaded by the site.
+	if token := r.URL.Query().Get(AccessTokenQueryParam); token != "" && hasOriginIsolation(r) {
+		cookie := &http.Cookie{
+			Name:     accessTokenCookie,
+			Value:    token,
+			Path:     "/",
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		if !grant.Expiry.IsZero() {
+			cookie.Expires = grant.Expiry
+		}
+		http.SetCookie(w, cookie)
+	}
+
+	return &privateResponseWriter{ResponseWriter: w}, true
+}
+
+// privateResponseWriter replaces the public directive of the Cache-Control
+// header of responses with private.
+type privateResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *privateResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		directives := []string{"private"}
+		for _, d := range strings.Split(w.Header().Get("Cache-Control"), ",") {
+			d = strings.TrimSpace(d)
+			if d != "" && !strings.EqualFold(d, "public") && !strings.EqualFold(d, "private") {
+				directives = append(directives, d)
+			}
+		}
+		w.Header().Set("Cache-Control", strings.Join(directives, ", "))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *privateResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *privateResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMACAuthorizer(t *testing.T) {
+	a := NewHMACAuthorizer([]byte("secret"))
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	root := cid.MustParse("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	rootV1 := cid.NewCidV1(root.Type(), root.Hash())
+
+	authorize := func(token, p string) (*Grant, error) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		contentPath, err := path.NewPath(p)
+		require.NoError(t, err)
+		return a.Authorize(r, contentPath)
+	}
+	status := func(err error) int {
+		var esc *ErrorStatusCode
+		require.ErrorAs(t, err, &esc)
+		return esc.StatusCode
+	}
+
+	token, err := a.Sign(Capability{Scope: "/ipfs/" + root.String() + "/dir", Expiry: now.Add(time.Hour), Formats: []string{"raw", "car"}})
+	require.NoError(t, err)
+
+	grant, err := authorize(token, "/ipfs/"+root.String()+"/dir/file")
+	require.NoError(t, err)
+	require.Equal(t, []string{"raw", "car"}, grant.Formats)
+	require.Equal(t, now.Add(time.Hour).Unix(), grant.Expiry.Unix())
+
+	// CIDs match regardless of their version, as in subdomain requests.
+	_, err = authorize(token, "/ipfs/"+rootV1.String()+"/dir")
+	require.NoError(t, err)
+
+	_, err = authorize(token, "/ipfs/"+root.String()+"/dir2")
+	require.Equal(t, http.StatusForbidden, status(err))
+	_, err = authorize(token, "/ipfs/"+root.String())
+	require.Equal(t, http.StatusForbidden, status(err))
+
+	_, err = authorize("", "/ipfs/"+root.String()+"/dir")
+	require.Equal(t, http.StatusUnauthorized, status(err))
+	_, err = authorize(token+"x", "/ipfs/"+root.String()+"/dir")
+	require.Equal(t, http.StatusUnauthorized, status(err))
+	_, err = authorize(token, "/ipfs/"+root.String()+"/dir")
+	require.NoError(t, err)
+
+	other, err := NewHMACAuthorizer([]byte("other")).Sign(Capability{Scope: "/ipfs/" + root.String()})
+	require.NoError(t, err)
+	_, err = authorize(other, "/ipfs/"+root.String())
+	require.Equal(t, http.StatusUnauthorized, status(err))
+
+	now = now.Add(2 * time.Hour)
+	_, err = authorize(token, "/ipfs/"+root.String()+"/dir")
+	require.Equal(t, http.StatusUnauthorized, status(err))
+
+	_, err = a.Sign(Capability{Scope: "not a path"})
+	require.Error(t, err)
+
+	u, err := a.SignURL(&url.URL{Path: "/ipfs/" + root.String(), RawQuery: "format=car"}, Capability{Scope: "/ipfs/" + root.String()})
+	require.NoError(t, err)
+	require.Equal(t, "car", u.Query().Get("format"))
+	require.NotEmpty(t, u.Query().Get(AccessTokenQueryParam))
+}
+
+func TestHandlerAuthorization(t *testing.T) {
+	backend, root := newMockBackend(t, "fixtures.car")
+	a := NewHMACAuthorizer([]byte("secret"))
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		Authorizer:            a,
+		PublicGateways: map[string]*PublicGateway{
+			"example.com": {
+				Paths:                 []string{"/ipfs", "/ipns"},
+				UseSubdomains:         true,
+				DeserializedResponses: true,
+			},
+			"public.example.com": {
+				Paths:                 []string{"/ipfs", "/ipns"},
+				DeserializedResponses: true,
+				Authorizer: AuthorizerFunc(func(r *http.Request, p path.Path) (*Grant, error) {
+					return nil, nil
+				}),
+			},
+		},
+	})
+	contentPath := "/ipfs/" + root.String() + "/"
+
+	token, err := a.Sign(Capability{Scope: "/ipfs/" + root.String(), Formats: []string{"", "raw"}})
+	require.NoError(t, err)
+
+	t.Run("Missing token", func(t *testing.T) {
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+contentPath, nil))
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		require.NotEmpty(t, res.Header.Get("WWW-Authenticate"))
+	})
+
+	t.Run("Bearer token", func(t *testing.T) {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+contentPath, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Contains(t, res.Header.Get("Cache-Control"), "private")
+		require.NotContains(t, res.Header.Get("Cache-Control"), "public")
+	})
+
+	t.Run("Response formats", func(t *testing.T) {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+contentPath+"?format=raw", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		req = mustNewRequest(t, http.MethodGet, ts.URL+contentPath+"?format=car", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res = mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("Signed URLs on subdomain gateways", func(t *testing.T) {
+		signed, err := a.SignURL(&url.URL{Path: contentPath}, Capability{Scope: "/ipfs/" + root.String()})
+		require.NoError(t, err)
+
+		// The redirect to the subdomain keeps the token.
+		req := mustNewRequest(t, http.MethodGet, ts.URL+signed.String(), nil)
+		req.Host = "example.com"
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusMovedPermanently, res.StatusCode)
+		loc, err := url.Parse(res.Header.Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, signed.Query().Get(AccessTokenQueryParam), loc.Query().Get(AccessTokenQueryParam))
+
+		req = mustNewRequest(t, http.MethodGet, ts.URL+"/?"+loc.RawQuery, nil)
+		req.Host = loc.Host
+		res = mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		cookies := res.Cookies()
+		require.Len(t, cookies, 1)
+		require.True(t, cookies[0].HttpOnly)
+
+		// The resources loaded by the site are authorized by the cookie.
+		req = mustNewRequest(t, http.MethodGet, ts.URL+"/", nil)
+		req.Host = loc.Host
+		res = mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		req.AddCookie(cookies[0])
+		res = mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Per hostname authorizer", func(t *testing.T) {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+contentPath, nil)
+		req.Host = "public.example.com"
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
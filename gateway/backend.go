@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"time"
 
@@ -23,7 +24,8 @@ type backendOptions struct {
 	vs routing.ValueStore
 
 	// Only used by [BlocksBackend]:
-	r resolver.Resolver
+	r              resolver.Resolver
+	requestBudgets bool
 
 	// Only used by [CarBackend]:
 	promRegistry    prometheus.Registerer
@@ -64,6 +66,16 @@ func WithPrometheusRegistry(reg prometheus.Registerer) BackendOption {
 	}
 }
 
+// WithRequestBudgets makes [BlocksBackend] charge the blocks it reads to the
+// budget of the request, see [Config.RateLimiter]. Use it when the gateway
+// has a RateLimiter with data limits.
+func WithRequestBudgets() BackendOption {
+	return func(opts *backendOptions) error {
+		opts.requestBudgets = true
+		return nil
+	}
+}
+
 const DefaultGetBlockTimeout = time.Second * 60
 
 // WithGetBlockTimeout sets a custom timeout when getting blocks from the
@@ -173,3 +185,45 @@ func newRemoteHTTPClient() *http.Client {
 		Transport: otelhttp.NewTransport(transport),
 	}
 }
+
+// remoteGateways sends GET requests to remote gateways. The response is
+// returned whatever its status code.
+type remoteGateways interface {
+	get(ctx context.Context, urlPath string, header http.Header) (*http.Response, error)
+}
+
+var (
+	_ remoteGateways = (*randomGateways)(nil)
+	_ remoteGateways = (*RemotePool)(nil)
+)
+
+// randomGateways sends each request to a random gateway.
+type randomGateways struct {
+	httpClient *http.Client
+	gatewayURL []string
+	rand       *rand.Rand
+}
+
+func newRandomGateways(gatewayURL []string, httpClient *http.Client) *randomGateways {
+	if httpClient == nil {
+		httpClient = newRemoteHTTPClient()
+	}
+
+	return &randomGateways{
+		httpClient: httpClient,
+		gatewayURL: gatewayURL,
+		rand:       rand.New(rand.NewSource(time.Now().Unix())),
+	}
+}
+
+func (g *randomGateways) get(ctx context.Context, urlPath string, header http.Header) (*http.Response, error) {
+	urlStr := g.gatewayURL[g.rand.Intn(len(g.gatewayURL))] + urlPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return g.httpClient.Do(req)
+}
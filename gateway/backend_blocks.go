@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 
 	"github.com/ipfs/boxo/blockservice"
@@ -16,7 +17,9 @@ import (
 	bsfetcher "github.com/ipfs/boxo/fetcher/impl/blockservice"
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
 	ufile "github.com/ipfs/boxo/ipld/unixfs/file"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
 	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/boxo/path/resolver"
@@ -65,6 +68,10 @@ func NewBlocksBackend(blockService blockservice.BlockService, opts ...BackendOpt
 		}
 	}
 
+	if compiledOptions.requestBudgets {
+		blockService = newBudgetBlockService(blockService)
+	}
+
 	// Setup the DAG services, which use the CAR block store.
 	dagService := merkledag.NewDAGService(blockService)
 
@@ -113,6 +120,24 @@ func NewRemoteBlocksBackend(gatewayURL []string, httpClient *http.Client, opts .
 	return NewBlocksBackend(blockService, append(opts, WithValueStore(valueStore))...)
 }
 
+// NewRemoteBlocksBackendFromPool creates a new [BlocksBackend] like
+// [NewRemoteBlocksBackend], which sends its requests to the gateways of the
+// given [RemotePool].
+func NewRemoteBlocksBackendFromPool(pool *RemotePool, opts ...BackendOption) (*BlocksBackend, error) {
+	blockStore, err := NewRemoteBlockstoreFromPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	valueStore, err := NewRemoteValueStoreFromPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	blockService := blockservice.New(blockStore, offline.Exchange(blockStore))
+	return NewBlocksBackend(blockService, append(opts, WithValueStore(valueStore))...)
+}
+
 func (bb *BlocksBackend) Get(ctx context.Context, path path.ImmutablePath, ranges ...ByteRange) (ContentPathMetadata, *GetResponse, error) {
 	md, nd, err := bb.getNode(ctx, path)
 	if err != nil {
@@ -174,6 +199,11 @@ func (bb *BlocksBackend) Get(ctx context.Context, path path.ImmutablePath, range
 		if sz < 0 {
 			return ContentPathMetadata{}, nil, errors.New("directory cumulative DAG size cannot be negative")
 		}
+		if after, ok := ctx.Value(dirListingCursorKey{}).(string); ok {
+			resp := NewGetResponseFromDirectoryListing(uint64(sz), bb.listDirectoryAfter(ctx, nd, after), nil)
+			resp.directoryMetadata.afterCursor = true
+			return md, resp, nil
+		}
 		return md, NewGetResponseFromDirectoryListing(uint64(sz), dir.EnumLinksAsync(ctx), nil), nil
 	}
 	if file, ok := f.(files.File); ok {
@@ -281,6 +311,11 @@ func (bb *BlocksBackend) Head(ctx context.Context, path path.ImmutablePath) (Con
 // https://ipld.io/specs/transport/car/carv1/#number-of-roots
 var emptyRoot = []cid.Cid{cid.MustParse("bafkqaaa")}
 
+// SupportsCarRanges implements [WithCarRanges].
+func (bb *BlocksBackend) SupportsCarRanges() bool {
+	return true
+}
+
 func (bb *BlocksBackend) GetCAR(ctx context.Context, p path.ImmutablePath, params CarParams) (ContentPathMetadata, io.ReadCloser, error) {
 	pathMetadata, resolveErr := bb.ResolvePath(ctx, p)
 	if resolveErr != nil {
@@ -486,61 +521,29 @@ func walkGatewaySimpleSelector(ctx context.Context, lastCid cid.Cid, terminalBlk
 				return err
 			}
 
-			// Get the entity range. If it's empty, assume the defaults (whole file).
-			entityRange := params.Range
-			if entityRange == nil {
-				entityRange = &DagByteRange{
-					From: 0,
-				}
+			// Get the entity ranges. If there are none, assume the defaults (whole file).
+			ranges, err := resolveDagByteRanges(params.entityRanges(), func() (int64, error) {
+				return f.Seek(0, io.SeekEnd)
+			})
+			if err != nil {
+				return err
 			}
 
-			from := entityRange.From
-
-			// If we're starting to read based on the end of the file, find out where that is.
-			var fileLength int64
-			foundFileLength := false
-			if entityRange.From < 0 {
-				fileLength, err = f.Seek(0, io.SeekEnd)
-				if err != nil {
+			for _, rng := range ranges {
+				if _, err := f.Seek(rng.From, io.SeekStart); err != nil {
 					return err
 				}
-				from = fileLength + entityRange.From
-				if from < 0 {
-					from = 0
+				// If we're reading until the end of the file then do it
+				if rng.To == nil {
+					_, err = io.Copy(io.Discard, f)
+				} else {
+					_, err = io.CopyN(io.Discard, f, 1+*rng.To-rng.From)
 				}
-				foundFileLength = true
-			}
-
-			// If we're reading until the end of the file then do it
-			if entityRange.To == nil {
-				if _, err := f.Seek(from, io.SeekStart); err != nil {
+				if err != nil {
 					return err
 				}
-				_, err = io.Copy(io.Discard, f)
-				return err
-			}
-
-			to := *entityRange.To
-			if (*entityRange.To) < 0 {
-				if !foundFileLength {
-					fileLength, err = f.Seek(0, io.SeekEnd)
-					if err != nil {
-						return err
-					}
-				}
-				to = fileLength + *entityRange.To
-			}
-
-			numToRead := 1 + to - from
-			if numToRead < 0 {
-				return errors.New("tried to read less than zero bytes")
 			}
-
-			if _, err := f.Seek(from, io.SeekStart); err != nil {
-				return err
-			}
-			_, err = io.CopyN(io.Discard, f, numToRead)
-			return err
+			return nil
 		default:
 			// Not a supported type, so we're done
 			return nil
@@ -636,6 +639,68 @@ func (bb *BlocksBackend) IsCached(ctx context.Context, p path.Path) bool {
 	return has
 }
 
+// listDirectoryAfter enumerates the links of the directory nd in a stable
+// order, starting after the link named after. Only the shards of HAMT
+// directories holding the enumerated links are fetched.
+func (bb *BlocksBackend) listDirectoryAfter(ctx context.Context, nd format.Node, after string) <-chan unixfs.LinkResult {
+	out := make(chan unixfs.LinkResult)
+	go func() {
+		defer close(out)
+
+		err := forEachDirectoryLinkAfter(ctx, bb.dagService, nd, after, func(l *format.Link) error {
+			select {
+			case out <- unixfs.LinkResult{Link: l}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			err = errDirListingCursorNotFound
+		}
+		if err != nil && ctx.Err() == nil {
+			select {
+			case out <- unixfs.LinkResult{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
+
+func forEachDirectoryLinkAfter(ctx context.Context, dserv format.DAGService, nd format.Node, after string, f func(*format.Link) error) error {
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return errors.New("not a UnixFS directory")
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return err
+	}
+	if fsn.Type() == unixfs.THAMTShard {
+		shard, err := hamt.NewHamtFromDag(dserv, nd)
+		if err != nil {
+			return err
+		}
+		return shard.ForEachLinkAfter(ctx, after, f)
+	}
+
+	skipping := after != ""
+	for _, l := range nd.Links() {
+		if skipping {
+			skipping = l.Name != after
+			continue
+		}
+		if err := f(l); err != nil {
+			return err
+		}
+	}
+	if skipping {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
 var _ WithContextHint = (*BlocksBackend)(nil)
 
 func (bb *BlocksBackend) WrapContextForRequest(ctx context.Context) context.Context {
@@ -116,6 +116,23 @@ func NewRemoteCarBackend(gatewayURL []string, httpClient *http.Client, opts ...B
 	return NewCarBackend(carFetcher, append(opts, WithValueStore(valueStore))...)
 }
 
+// NewRemoteCarBackendFromPool creates a new [CarBackend] like
+// [NewRemoteCarBackend], which sends its requests to the gateways of the given
+// [RemotePool].
+func NewRemoteCarBackendFromPool(pool *RemotePool, opts ...BackendOption) (*CarBackend, error) {
+	carFetcher, err := NewRemoteCarFetcherFromPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	valueStore, err := NewRemoteValueStoreFromPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCarBackend(carFetcher, append(opts, WithValueStore(valueStore))...)
+}
+
 func registerCarBackendMetrics(promReg prometheus.Registerer) *CarBackendMetrics {
 	// make sure we have functional registry
 	if promReg == nil {
@@ -937,9 +954,16 @@ func (api *CarBackend) ResolvePath(ctx context.Context, p path.ImmutablePath) (C
 	return md, nil
 }
 
+// SupportsCarRanges implements [WithCarRanges].
+func (api *CarBackend) SupportsCarRanges() bool {
+	return true
+}
+
 func (api *CarBackend) GetCAR(ctx context.Context, p path.ImmutablePath, params CarParams) (ContentPathMetadata, io.ReadCloser, error) {
 	numRanges := "0"
-	if params.Range != nil {
+	if len(params.Ranges) > 1 {
+		numRanges = "many"
+	} else if params.Range != nil {
 		numRanges = "1"
 	}
 	api.metrics.carParamsMetric.With(prometheus.Labels{"dagScope": string(params.Scope), "entityRanges": numRanges}).Inc()
@@ -954,97 +978,130 @@ func (api *CarBackend) GetCAR(ctx context.Context, p path.ImmutablePath, params
 		return ContentPathMetadata{}, nil, fmt.Errorf("unsupported dag order %q", params.Order)
 	}
 
+	// Gateways accept a single entity-bytes range, so the blocks of each
+	// range are fetched with their own request, and written to the same CAR.
+	fetches := []CarParams{params}
+	if len(params.Ranges) > 1 {
+		fetches = make([]CarParams, len(params.Ranges))
+		for n := range params.Ranges {
+			fetches[n] = params
+			fetches[n].Range = &params.Ranges[n]
+			fetches[n].Ranges = nil
+		}
+	}
+
 	r, w := io.Pipe()
 	go func() {
-		numBlocksSent := 0
-		var cw storage.WritableCar
-		var blockBuffer []blocks.Block
-		err = api.fetchCAR(ctx, p, params, func(_ path.ImmutablePath, reader io.Reader) error {
-			numBlocksThisCall := 0
-			gb, err := carToLinearBlockGetter(ctx, reader, api.getBlockTimeout, api.metrics)
-			if err != nil {
-				return err
+		cw := &carRangesWriter{w: w}
+		for _, params := range fetches {
+			err := api.fetchCARRange(ctx, p, params, cw)
+			if err != nil || cw.err != nil {
+				// io.PipeWriter.CloseWithError always returns nil.
+				_ = w.CloseWithError(errors.Join(cw.err, err))
+				return
 			}
-			teeBlock := func(ctx context.Context, c cid.Cid) (blocks.Block, error) {
-				blk, err := gb(ctx, c)
-				if err != nil {
-					return nil, err
-				}
-				if numBlocksThisCall >= numBlocksSent {
-					if cw == nil {
-						blockBuffer = append(blockBuffer, blk)
-					} else {
-						err = cw.Put(ctx, blk.Cid().KeyString(), blk.RawData())
-						if err != nil {
-							return nil, fmt.Errorf("error writing car block: %w", err)
-						}
-					}
-					numBlocksSent++
-				}
-				numBlocksThisCall++
-				return blk, nil
-			}
-			l := getCarLinksystem(teeBlock)
+		}
+		_ = w.Close()
+	}()
+
+	return ContentPathMetadata{
+		PathSegmentRoots: []cid.Cid{rootCid},
+		LastSegment:      path.FromCid(rootCid),
+		ContentType:      "",
+	}, r, nil
+}
 
-			var isNotFound bool
+// carRangesWriter writes the blocks of the responses to the requests for the
+// ranges of a CAR request to a single CAR.
+type carRangesWriter struct {
+	w           io.Writer
+	cw          storage.WritableCar
+	blockBuffer []blocks.Block
+	// err is set when the CAR cannot be written, which is not retried.
+	err error
+}
 
-			// First resolve the path since we always need to.
-			md, terminalBlk, err := resolvePathWithRootsAndBlock(ctx, p, l)
+// fetchCARRange fetches the blocks of params, which has at most one range,
+// and writes them to cw, whose CAR is started with the roots of the first
+// response.
+func (api *CarBackend) fetchCARRange(ctx context.Context, p path.ImmutablePath, params CarParams, cw *carRangesWriter) error {
+	numBlocksSent := 0
+	return api.fetchCAR(ctx, p, params, func(_ path.ImmutablePath, reader io.Reader) error {
+		numBlocksThisCall := 0
+		gb, err := carToLinearBlockGetter(ctx, reader, api.getBlockTimeout, api.metrics)
+		if err != nil {
+			return err
+		}
+		teeBlock := func(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+			blk, err := gb(ctx, c)
 			if err != nil {
-				if isErrNotFound(err) {
-					isNotFound = true
+				return nil, err
+			}
+			if numBlocksThisCall >= numBlocksSent {
+				if cw.cw == nil {
+					cw.blockBuffer = append(cw.blockBuffer, blk)
 				} else {
-					return err
+					err = cw.cw.Put(ctx, blk.Cid().KeyString(), blk.RawData())
+					if err != nil {
+						return nil, fmt.Errorf("error writing car block: %w", err)
+					}
 				}
+				numBlocksSent++
 			}
+			numBlocksThisCall++
+			return blk, nil
+		}
+		l := getCarLinksystem(teeBlock)
 
-			if len(md.LastSegmentRemainder) > 0 {
-				return nil
+		var isNotFound bool
+
+		// First resolve the path since we always need to.
+		md, terminalBlk, err := resolvePathWithRootsAndBlock(ctx, p, l)
+		if err != nil {
+			if isErrNotFound(err) {
+				isNotFound = true
+			} else {
+				return err
 			}
+		}
 
-			if cw == nil {
-				var roots []cid.Cid
-				if isNotFound {
-					roots = emptyRoot
-				} else {
-					roots = []cid.Cid{md.LastSegment.RootCid()}
-				}
+		if len(md.LastSegmentRemainder) > 0 {
+			return nil
+		}
 
-				cw, err = storage.NewWritable(w, roots, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(params.Duplicates.Bool()))
-				if err != nil {
-					// io.PipeWriter.CloseWithError always returns nil.
-					_ = w.CloseWithError(err)
-					return nil
-				}
-				for _, blk := range blockBuffer {
-					err = cw.Put(ctx, blk.Cid().KeyString(), blk.RawData())
-					if err != nil {
-						_ = w.CloseWithError(fmt.Errorf("error writing car block: %w", err))
-						return nil
-					}
-				}
-				blockBuffer = nil
+		if cw.cw == nil {
+			var roots []cid.Cid
+			if isNotFound {
+				roots = emptyRoot
+			} else {
+				roots = []cid.Cid{md.LastSegment.RootCid()}
 			}
 
-			if !isNotFound {
-				params.Duplicates = DuplicateBlocksIncluded
-				err = walkGatewaySimpleSelector(ctx, terminalBlk.Cid(), terminalBlk, []string{}, params, l)
+			cw.cw, err = storage.NewWritable(cw.w, roots, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(params.Duplicates.Bool()))
+			if err != nil {
+				cw.err = err
+				return nil
+			}
+			for _, blk := range cw.blockBuffer {
+				err = cw.cw.Put(ctx, blk.Cid().KeyString(), blk.RawData())
 				if err != nil {
-					return err
+					cw.err = fmt.Errorf("error writing car block: %w", err)
+					return nil
 				}
 			}
+			cw.blockBuffer = nil
+		}
 
-			return nil
-		})
-
-		_ = w.CloseWithError(err)
-	}()
+		if !isNotFound {
+			params.Duplicates = DuplicateBlocksIncluded
+			err = walkGatewaySimpleSelector(ctx, terminalBlk.Cid(), terminalBlk, []string{}, params, l)
+			if err != nil {
+				return err
+			}
+		}
 
-	return ContentPathMetadata{
-		PathSegmentRoots: []cid.Cid{rootCid},
-		LastSegment:      path.FromCid(rootCid),
-		ContentType:      "",
-	}, r, nil
+		return nil
+	})
 }
 
 func getRootCid(imPath path.ImmutablePath) (cid.Cid, error) {
@@ -5,12 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/ipfs/boxo/path"
 )
@@ -23,9 +20,7 @@ type CarFetcher interface {
 }
 
 type remoteCarFetcher struct {
-	httpClient *http.Client
-	gatewayURL []string
-	rand       *rand.Rand
+	gateways remoteGateways
 }
 
 // NewRemoteCarFetcher returns a [CarFetcher] that is backed by one or more gateways
@@ -39,28 +34,24 @@ func NewRemoteCarFetcher(gatewayURL []string, httpClient *http.Client) (CarFetch
 		return nil, errors.New("missing gateway URLs to which to proxy")
 	}
 
-	if httpClient == nil {
-		httpClient = newRemoteHTTPClient()
+	return &remoteCarFetcher{gateways: newRandomGateways(gatewayURL, httpClient)}, nil
+}
+
+// NewRemoteCarFetcherFromPool returns a [CarFetcher] like [NewRemoteCarFetcher],
+// which sends its requests to the gateways of the given [RemotePool].
+func NewRemoteCarFetcherFromPool(pool *RemotePool) (CarFetcher, error) {
+	if pool == nil {
+		return nil, errors.New("missing remote gateway pool")
 	}
 
-	return &remoteCarFetcher{
-		gatewayURL: gatewayURL,
-		httpClient: httpClient,
-		rand:       rand.New(rand.NewSource(time.Now().Unix())),
-	}, nil
+	return &remoteCarFetcher{gateways: pool}, nil
 }
 
 func (ps *remoteCarFetcher) Fetch(ctx context.Context, path path.ImmutablePath, params CarParams, cb DataCallback) error {
 	url := contentPathToCarUrl(path, params)
 
-	urlStr := fmt.Sprintf("%s%s", ps.getRandomGatewayURL(), url.String())
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
-	if err != nil {
-		return err
-	}
-	log.Debugw("car fetch", "url", req.URL)
-	req.Header.Set("Accept", "application/vnd.ipld.car;order=dfs;dups=y")
-	resp, err := ps.httpClient.Do(req)
+	log.Debugw("car fetch", "path", url)
+	resp, err := ps.gateways.get(ctx, url.String(), http.Header{"Accept": {"application/vnd.ipld.car;order=dfs;dups=y"}})
 	if err != nil {
 		return err
 	}
@@ -77,16 +68,15 @@ func (ps *remoteCarFetcher) Fetch(ctx context.Context, path path.ImmutablePath,
 
 	err = cb(path, resp.Body)
 	if err != nil {
+		if ctx.Err() == nil && isInvalidRemoteData(err) {
+			reportInvalidResponse(resp)
+		}
 		resp.Body.Close()
 		return err
 	}
 	return resp.Body.Close()
 }
 
-func (ps *remoteCarFetcher) getRandomGatewayURL() string {
-	return ps.gatewayURL[ps.rand.Intn(len(ps.gatewayURL))]
-}
-
 // contentPathToCarUrl returns an URL that allows retrieval of specified resource
 // from a trustless gateway that implements IPIP-402
 func contentPathToCarUrl(path path.ImmutablePath, params CarParams) *url.URL {
@@ -106,13 +96,7 @@ func carParamsToString(params CarParams) string {
 	}
 	if params.Range != nil {
 		paramsBuilder.WriteString("&entity-bytes=")
-		paramsBuilder.WriteString(strconv.FormatInt(params.Range.From, 10))
-		paramsBuilder.WriteString(":")
-		if params.Range.To != nil {
-			paramsBuilder.WriteString(strconv.FormatInt(*params.Range.To, 10))
-		} else {
-			paramsBuilder.WriteString("*")
-		}
+		paramsBuilder.WriteString(params.Range.String())
 	}
 	return paramsBuilder.String()
 }
@@ -14,12 +14,17 @@ import (
 	"time"
 
 	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	chunker "github.com/ipfs/boxo/chunker"
 	"github.com/ipfs/boxo/exchange/offline"
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/ipld/merkledag"
 	unixfile "github.com/ipfs/boxo/ipld/unixfs/file"
+	"github.com/ipfs/boxo/ipld/unixfs/importer"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	carv2 "github.com/ipld/go-car/v2"
 	carbs "github.com/ipld/go-car/v2/blockstore"
 	"github.com/ipld/go-car/v2/storage"
@@ -966,6 +971,73 @@ func TestCarBackendGetCAR(t *testing.T) {
 	require.ErrorIs(t, err, io.EOF)
 }
 
+func TestCarBackendGetCARMultipleRanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bs, offline.Exchange(bs))
+	blocksBackend, err := NewBlocksBackend(bsrv)
+	require.NoError(t, err)
+
+	// A file of 16 leaves of 256 bytes.
+	data := make([]byte, 16*256)
+	for n := range data {
+		data[n] = byte(n / 256)
+	}
+	nd, err := importer.BuildDagFromReader(merkledag.NewDAGService(bsrv), chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+
+	// The upstream gateway only accepts a single entity-bytes range, as
+	// specified by the trustless gateway specification.
+	var ranges []string
+	upstream := newTestServer(t, blocksBackend)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.URL.Query()["entity-bytes"]
+		if len(rng) != 1 || strings.Contains(rng[0], ",") {
+			http.Error(w, "invalid entity-bytes", http.StatusBadRequest)
+			return
+		}
+		ranges = append(ranges, rng[0])
+		upstream.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	fetcher, err := NewRemoteCarFetcher([]string{s.URL}, nil)
+	require.NoError(t, err)
+	backend, err := NewCarBackend(fetcher)
+	require.NoError(t, err)
+
+	carBlocks := func(t *testing.T, backend IPFSBackend, params CarParams) []cid.Cid {
+		_, carReader, err := backend.GetCAR(ctx, path.FromCid(nd.Cid()), params)
+		require.NoError(t, err)
+		defer carReader.Close()
+		br, err := carv2.NewBlockReader(carReader)
+		require.NoError(t, err)
+		var cids []cid.Cid
+		for {
+			blk, err := br.Next()
+			if err == io.EOF {
+				return cids
+			}
+			require.NoError(t, err)
+			cids = append(cids, blk.Cid())
+		}
+	}
+
+	to := func(n int64) *int64 { return &n }
+	params := CarParams{
+		Scope:  DagScopeEntity,
+		Ranges: []DagByteRange{{From: 0, To: to(255)}, {From: -256}},
+	}
+
+	// The root and the leaves of the ranges.
+	cids := carBlocks(t, backend, params)
+	require.Len(t, cids, 3)
+	require.Equal(t, []string{"0:255", "-256:*"}, ranges)
+	require.ElementsMatch(t, carBlocks(t, blocksBackend, params), cids)
+}
+
 func TestCarBackendPassthroughErrors(t *testing.T) {
 	t.Run("PathTraversalError", func(t *testing.T) {
 		pathTraversalTest := func(t *testing.T, traversal func(ctx context.Context, p path.ImmutablePath, backend *CarBackend) error) {
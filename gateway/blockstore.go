@@ -5,10 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"sync/atomic"
-	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 	blockstore "github.com/ipfs/boxo/blockstore"
@@ -140,10 +138,8 @@ func (l *cacheBlockStore) HashOnRead(enabled bool) {
 }
 
 type remoteBlockstore struct {
-	httpClient *http.Client
-	gatewayURL []string
-	rand       *rand.Rand
-	validate   bool
+	gateways remoteGateways
+	validate bool
 }
 
 // NewRemoteBlockstore creates a new [blockstore.Blockstore] that is backed by one
@@ -157,29 +153,33 @@ func NewRemoteBlockstore(gatewayURL []string, httpClient *http.Client) (blocksto
 		return nil, errors.New("missing remote block backend URL")
 	}
 
-	if httpClient == nil {
-		httpClient = newRemoteHTTPClient()
+	return newRemoteBlockstore(newRandomGateways(gatewayURL, httpClient)), nil
+}
+
+// NewRemoteBlockstoreFromPool creates a new [blockstore.Blockstore] like
+// [NewRemoteBlockstore], which sends its requests to the gateways of the given
+// [RemotePool].
+func NewRemoteBlockstoreFromPool(pool *RemotePool) (blockstore.Blockstore, error) {
+	if pool == nil {
+		return nil, errors.New("missing remote gateway pool")
 	}
 
+	return newRemoteBlockstore(pool), nil
+}
+
+func newRemoteBlockstore(gateways remoteGateways) *remoteBlockstore {
 	return &remoteBlockstore{
-		gatewayURL: gatewayURL,
-		httpClient: httpClient,
-		rand:       rand.New(rand.NewSource(time.Now().Unix())),
+		gateways: gateways,
 		// Enables block validation by default. Important since we are
 		// proxying block requests to untrusted gateways.
 		validate: true,
-	}, nil
+	}
 }
 
 func (ps *remoteBlockstore) fetch(ctx context.Context, c cid.Cid) (blocks.Block, error) {
-	urlStr := fmt.Sprintf("%s/ipfs/%s?format=raw", ps.getRandomGatewayURL(), c)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
-	if err != nil {
-		return nil, err
-	}
-	log.Debugw("raw fetch", "url", req.URL)
-	req.Header.Set("Accept", "application/vnd.ipld.raw")
-	resp, err := ps.httpClient.Do(req)
+	urlPath := fmt.Sprintf("/ipfs/%s?format=raw", c)
+	log.Debugw("raw fetch", "path", urlPath)
+	resp, err := ps.gateways.get(ctx, urlPath, http.Header{"Accept": {"application/vnd.ipld.raw"}})
 	if err != nil {
 		return nil, err
 	}
@@ -191,15 +191,16 @@ func (ps *remoteBlockstore) fetch(ctx context.Context, c cid.Cid) (blocks.Block,
 
 	rb, err := io.ReadAll(resp.Body)
 	if err != nil {
+		if ctx.Err() == nil {
+			reportInvalidResponse(resp)
+		}
 		return nil, err
 	}
 
 	if ps.validate {
 		nc, err := c.Prefix().Sum(rb)
-		if err != nil {
-			return nil, blocks.ErrWrongHash
-		}
-		if !nc.Equals(c) {
+		if err != nil || !nc.Equals(c) {
+			reportInvalidResponse(resp)
 			return nil, blocks.ErrWrongHash
 		}
 	}
@@ -250,7 +251,3 @@ func (c *remoteBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, err
 func (c *remoteBlockstore) DeleteBlock(context.Context, cid.Cid) error {
 	return util.ErrNotImplemented
 }
-
-func (ps *remoteBlockstore) getRandomGatewayURL() string {
-	return ps.gatewayURL[ps.rand.Intn(len(ps.gatewayURL))]
-}
@@ -0,0 +1,402 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/util"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// CarDirBlockstore is a read-only [blockstore.Blockstore] serving the blocks
+// of all the CAR files of a directory. It can be passed to [NewBlocksBackend]
+// through a [blockservice.BlockService] to serve a collection of CARs.
+//
+// The index of every CAR is loaded in memory and merged into a single
+// multihash to (file, offset) index. CARv2 files with an iterable index (the
+// default multihash-sorted index) are loaded quickly, other files are indexed
+// by reading them once. Blocks are read from the files on demand, so the CARs
+// themselves are never loaded in memory.
+//
+// The directory is rescanned periodically: new, modified and removed CARs are
+// picked up without restarting.
+type CarDirBlockstore struct {
+	dir    string
+	rehash atomic.Bool
+
+	// reloadLk serializes reloads.
+	reloadLk sync.Mutex
+
+	lk    sync.RWMutex
+	files map[string]*carFile
+	index map[string]carBlockLocation
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+var _ blockstore.Blockstore = (*CarDirBlockstore)(nil)
+
+type carFile struct {
+	path    string
+	f       *os.File
+	size    int64
+	modTime time.Time
+	roots   []cid.Cid
+	// dataOffset is the position of the CARv1 payload in the file, to which
+	// the offsets of idx are relative.
+	dataOffset uint64
+	idx        index.IterableIndex
+}
+
+type carBlockLocation struct {
+	file *carFile
+	// offset is the position of the block section in the file.
+	offset uint64
+}
+
+// NewCarDirBlockstore returns a [CarDirBlockstore] serving the files with a
+// .car extension in dir. The directory is rescanned every reloadInterval
+// until ctx is done or the blockstore is closed. A zero interval disables
+// reloading, [CarDirBlockstore.Reload] can then be called manually.
+func NewCarDirBlockstore(ctx context.Context, dir string, reloadInterval time.Duration) (*CarDirBlockstore, error) {
+	bs := &CarDirBlockstore{
+		dir:     dir,
+		files:   make(map[string]*carFile),
+		index:   make(map[string]carBlockLocation),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := bs.Reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval <= 0 {
+		close(bs.done)
+		return bs, nil
+	}
+
+	go func() {
+		defer close(bs.done)
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := bs.Reload(); err != nil {
+					log.Errorw("reloading CAR directory", "dir", dir, "error", err)
+				}
+			case <-ctx.Done():
+				return
+			case <-bs.closing:
+				return
+			}
+		}
+	}()
+	return bs, nil
+}
+
+// Reload rescans the directory. Files that cannot be read are logged and
+// skipped, so that one bad CAR does not prevent serving the others.
+func (bs *CarDirBlockstore) Reload() error {
+	bs.reloadLk.Lock()
+	defer bs.reloadLk.Unlock()
+
+	entries, err := os.ReadDir(bs.dir)
+	if err != nil {
+		return err
+	}
+
+	bs.lk.RLock()
+	current := bs.files
+	bs.lk.RUnlock()
+
+	files := make(map[string]*carFile, len(entries))
+	var changed []*carFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".car") {
+			continue
+		}
+		p := filepath.Join(bs.dir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			// Removed since listed.
+			continue
+		}
+
+		if cf, ok := current[p]; ok && cf.size == info.Size() && cf.modTime.Equal(info.ModTime()) {
+			files[p] = cf
+			continue
+		}
+
+		cf, err := openCarFile(p, info)
+		if err != nil {
+			log.Errorw("skipping CAR file", "path", p, "error", err)
+			continue
+		}
+		files[p] = cf
+		changed = append(changed, cf)
+	}
+
+	var removed []*carFile
+	for p, cf := range current {
+		if files[p] != cf {
+			removed = append(removed, cf)
+		}
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	// Index the files in a stable order so that blocks present in several
+	// CARs are always served from the same one.
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	idx := make(map[string]carBlockLocation)
+	for _, p := range paths {
+		cf := files[p]
+		err := cf.idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+			if _, ok := idx[string(mh)]; !ok {
+				idx[string(mh)] = carBlockLocation{file: cf, offset: cf.dataOffset + offset}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("indexing %s: %w", p, err)
+		}
+	}
+
+	bs.lk.Lock()
+	bs.files = files
+	bs.index = idx
+	bs.lk.Unlock()
+
+	// Reads hold the read lock, no one uses the removed files anymore.
+	for _, cf := range removed {
+		_ = cf.f.Close()
+	}
+	log.Debugw("reloaded CAR directory", "dir", bs.dir, "files", len(files), "blocks", len(idx))
+	return nil
+}
+
+func openCarFile(p string, info os.FileInfo) (*carFile, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := readCarFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	cf.path = p
+	cf.size = info.Size()
+	cf.modTime = info.ModTime()
+	return cf, nil
+}
+
+func readCarFile(f *os.File) (*carFile, error) {
+	r, err := carv2.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := r.Roots()
+	if err != nil {
+		return nil, err
+	}
+	cf := &carFile{f: f, roots: roots}
+	if r.Version == 2 {
+		cf.dataOffset = r.Header.DataOffset
+		if r.Header.HasIndex() {
+			ir, err := r.IndexReader()
+			if err != nil {
+				return nil, err
+			}
+			idx, err := index.ReadFrom(ir)
+			if err != nil {
+				return nil, err
+			}
+			if iterable, ok := idx.(index.IterableIndex); ok {
+				cf.idx = iterable
+				return cf, nil
+			}
+		}
+	}
+
+	// No index or an index that cannot be listed: generate one.
+	dr, err := r.DataReader()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := carv2.GenerateIndex(dr)
+	if err != nil {
+		return nil, err
+	}
+	iterable, ok := idx.(index.IterableIndex)
+	if !ok {
+		return nil, fmt.Errorf("generated index %s is not iterable", idx.Codec())
+	}
+	cf.idx = iterable
+	return cf, nil
+}
+
+// Roots returns the roots of all the CARs served.
+func (bs *CarDirBlockstore) Roots() []cid.Cid {
+	bs.lk.RLock()
+	defer bs.lk.RUnlock()
+	var roots []cid.Cid
+	for _, cf := range bs.files {
+		roots = append(roots, cf.roots...)
+	}
+	return roots
+}
+
+// Close stops reloading and closes the CAR files.
+func (bs *CarDirBlockstore) Close() error {
+	bs.closeOnce.Do(func() { close(bs.closing) })
+	<-bs.done
+
+	bs.reloadLk.Lock()
+	defer bs.reloadLk.Unlock()
+	bs.lk.Lock()
+	defer bs.lk.Unlock()
+
+	var errs []error
+	for _, cf := range bs.files {
+		errs = append(errs, cf.f.Close())
+	}
+	bs.files = map[string]*carFile{}
+	bs.index = map[string]carBlockLocation{}
+	return errors.Join(errs...)
+}
+
+// readSection reads the section of c. When withData is false, only the size
+// of the data is returned.
+func (bs *CarDirBlockstore) readSection(c cid.Cid, withData bool) ([]byte, int, error) {
+	bs.lk.RLock()
+	defer bs.lk.RUnlock()
+
+	loc, ok := bs.index[string(c.Hash())]
+	if !ok {
+		return nil, -1, format.ErrNotFound{Cid: c}
+	}
+
+	sr := io.NewSectionReader(loc.file.f, int64(loc.offset), loc.file.size-int64(loc.offset))
+	br := bufio.NewReaderSize(sr, 128)
+	sectionLen, err := varint.ReadUvarint(br)
+	if err != nil {
+		return nil, -1, fmt.Errorf("reading %s from %s: %w", c, loc.file.path, err)
+	}
+	if sectionLen > carv2.DefaultMaxAllowedSectionSize {
+		return nil, -1, fmt.Errorf("reading %s from %s: section too large", c, loc.file.path)
+	}
+	cidLen, sectionCid, err := cid.CidFromReader(br)
+	if err != nil {
+		return nil, -1, fmt.Errorf("reading %s from %s: %w", c, loc.file.path, err)
+	}
+	if string(sectionCid.Hash()) != string(c.Hash()) || uint64(cidLen) > sectionLen {
+		return nil, -1, fmt.Errorf("reading %s from %s: index points to %s", c, loc.file.path, sectionCid)
+	}
+	size := int(sectionLen) - cidLen
+	if !withData {
+		return nil, size, nil
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, -1, fmt.Errorf("reading %s from %s: %w", c, loc.file.path, err)
+	}
+	return data, size, nil
+}
+
+func (bs *CarDirBlockstore) Has(_ context.Context, c cid.Cid) (bool, error) {
+	bs.lk.RLock()
+	defer bs.lk.RUnlock()
+	_, ok := bs.index[string(c.Hash())]
+	return ok, nil
+}
+
+func (bs *CarDirBlockstore) Get(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	if !c.Defined() {
+		return nil, format.ErrNotFound{Cid: c}
+	}
+	data, _, err := bs.readSection(c, true)
+	if err != nil {
+		return nil, err
+	}
+	if bs.rehash.Load() {
+		rbcid, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+		if !rbcid.Equals(c) {
+			return nil, blockstore.ErrHashMismatch
+		}
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (bs *CarDirBlockstore) GetSize(_ context.Context, c cid.Cid) (int, error) {
+	_, size, err := bs.readSection(c, false)
+	return size, err
+}
+
+// AllKeysChan returns the keys of all the blocks served, as raw CIDs.
+func (bs *CarDirBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	bs.lk.RLock()
+	keys := make([]cid.Cid, 0, len(bs.index))
+	for mh := range bs.index {
+		keys = append(keys, cid.NewCidV1(cid.Raw, multihash.Multihash(mh)))
+	}
+	bs.lk.RUnlock()
+
+	output := make(chan cid.Cid)
+	go func() {
+		defer close(output)
+		for _, k := range keys {
+			select {
+			case output <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+func (bs *CarDirBlockstore) HashOnRead(enabled bool) {
+	bs.rehash.Store(enabled)
+}
+
+func (bs *CarDirBlockstore) Put(context.Context, blocks.Block) error {
+	return util.ErrNotImplemented
+}
+
+func (bs *CarDirBlockstore) PutMany(context.Context, []blocks.Block) error {
+	return util.ErrNotImplemented
+}
+
+func (bs *CarDirBlockstore) DeleteBlock(context.Context, cid.Cid) error {
+	return util.ErrNotImplemented
+}
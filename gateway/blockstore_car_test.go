@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func newRawBlock(t *testing.T, data string) blocks.Block {
+	t.Helper()
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid([]byte(data), cid.NewCidV1(cid.Raw, mh))
+	require.NoError(t, err)
+	return blk
+}
+
+func writeTestCar(t *testing.T, p string, v1 bool, blks ...blocks.Block) {
+	t.Helper()
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+
+	cw, err := storage.NewWritable(f, []cid.Cid{blks[0].Cid()}, carv2.WriteAsCarV1(v1))
+	require.NoError(t, err)
+	for _, blk := range blks {
+		require.NoError(t, cw.Put(context.Background(), blk.Cid().KeyString(), blk.RawData()))
+	}
+	require.NoError(t, cw.Finalize())
+}
+
+func requireCarDirHas(t *testing.T, bs *CarDirBlockstore, blk blocks.Block) {
+	t.Helper()
+	ctx := context.Background()
+
+	got, err := bs.Get(ctx, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk.RawData(), got.RawData())
+
+	size, err := bs.GetSize(ctx, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, len(blk.RawData()), size)
+}
+
+func TestCarDirBlockstore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	a, b, c := newRawBlock(t, "a"), newRawBlock(t, "bb"), newRawBlock(t, "ccc")
+	writeTestCar(t, filepath.Join(dir, "one.car"), false, a, b)
+	writeTestCar(t, filepath.Join(dir, "two.car"), true, c)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o644))
+
+	bs, err := NewCarDirBlockstore(ctx, dir, 0)
+	require.NoError(t, err)
+	defer bs.Close()
+
+	bs.HashOnRead(true)
+	for _, blk := range []blocks.Block{a, b, c} {
+		requireCarDirHas(t, bs, blk)
+	}
+	require.ElementsMatch(t, []cid.Cid{a.Cid(), c.Cid()}, bs.Roots())
+
+	keys, err := bs.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var n int
+	for range keys {
+		n++
+	}
+	require.Equal(t, 3, n)
+
+	// Hot reload: new files are served, removed ones are not anymore.
+	d := newRawBlock(t, "dddd")
+	writeTestCar(t, filepath.Join(dir, "three.car"), false, d)
+	require.NoError(t, os.Remove(filepath.Join(dir, "two.car")))
+	require.NoError(t, bs.Reload())
+
+	requireCarDirHas(t, bs, d)
+	requireCarDirHas(t, bs, a)
+	_, err = bs.Get(ctx, c.Cid())
+	require.True(t, format.IsNotFound(err))
+	has, err := bs.Has(ctx, c.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.Error(t, bs.Put(ctx, c))
+}
+
+func TestCarDirBlockstoreSkipsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := newRawBlock(t, "a")
+	writeTestCar(t, filepath.Join(dir, "good.car"), false, a)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.car"), []byte("not a car"), 0o644))
+
+	bs, err := NewCarDirBlockstore(context.Background(), dir, 0)
+	require.NoError(t, err)
+	defer bs.Close()
+	requireCarDirHas(t, bs, a)
+}
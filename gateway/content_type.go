@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"bytes"
+	"mime"
+	gopath "path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// ContentTypeSniffLen is the maximum number of bytes at the beginning of
+// files given to [ContentTypeDetector] implementations.
+const ContentTypeSniffLen = 3072
+
+// ContentTypeDetector determines the Content-Type of the UnixFS files served by
+// the gateway, see [Config.ContentTypeDetector].
+type ContentTypeDetector interface {
+	// DetectContentType returns the media type of the file name, or "" when it
+	// is unknown. head returns the beginning of the file, up to
+	// [ContentTypeSniffLen] bytes, which is read when head is first called.
+	// It returns nil when the beginning of the file is not available, such as
+	// for range requests that do not start at zero, and when the backend
+	// provides the content type, see [ContentPathMetadata].
+	DetectContentType(name string, head func() []byte) string
+}
+
+// ContentTypeDetectorFunc is a function implementing [ContentTypeDetector].
+type ContentTypeDetectorFunc func(name string, head func() []byte) string
+
+func (f ContentTypeDetectorFunc) DetectContentType(name string, head func() []byte) string {
+	return f(name, head)
+}
+
+// ContentTypeChain returns a [ContentTypeDetector] returning the first media
+// type found by detectors, in order.
+func ContentTypeChain(detectors ...ContentTypeDetector) ContentTypeDetector {
+	return ContentTypeDetectorFunc(func(name string, head func() []byte) string {
+		for _, d := range detectors {
+			if ctype := d.DetectContentType(name, head); ctype != "" {
+				return ctype
+			}
+		}
+		return ""
+	})
+}
+
+// ExtensionContentTypes is a [ContentTypeDetector] mapping file extensions,
+// such as ".wasm", to media types. Extensions are case-insensitive.
+type ExtensionContentTypes map[string]string
+
+func (t ExtensionContentTypes) DetectContentType(name string, _ func() []byte) string {
+	ext := gopath.Ext(name)
+	if ext == "" {
+		return ""
+	}
+	if ctype, ok := t[ext]; ok {
+		return ctype
+	}
+	for e, ctype := range t {
+		if strings.EqualFold(e, ext) {
+			return ctype
+		}
+	}
+	return ""
+}
+
+// SystemContentTypes is a [ContentTypeDetector] mapping file extensions to
+// media types with [mime.TypeByExtension], which uses the MIME type tables of
+// the system along with a small built-in table.
+var SystemContentTypes ContentTypeDetector = ContentTypeDetectorFunc(func(name string, _ func() []byte) string {
+	return mime.TypeByExtension(gopath.Ext(name))
+})
+
+// MagicNumber identifies files of ContentType by the Magic bytes found at
+// Offset.
+type MagicNumber struct {
+	ContentType string
+	Offset      int
+	Magic       []byte
+}
+
+// MagicContentTypes is a [ContentTypeDetector] matching the beginning of files
+// against magic numbers, in order, before falling back to the signature
+// database of [github.com/gabriel-vasile/mimetype]. Files that are not
+// recognized are text/plain when they are valid UTF-8, and
+// application/octet-stream otherwise.
+type MagicContentTypes []MagicNumber
+
+func (m MagicContentTypes) DetectContentType(_ string, head func() []byte) string {
+	data := head()
+	if data == nil {
+		return ""
+	}
+	for _, n := range m {
+		if len(data) >= n.Offset+len(n.Magic) && bytes.Equal(data[n.Offset:n.Offset+len(n.Magic)], n.Magic) {
+			return n.ContentType
+		}
+	}
+	return mimetype.Detect(data).String()
+}
+
+// DefaultMagicNumbers are the magic numbers of the formats missing from the
+// signature database used by [MagicContentTypes].
+var DefaultMagicNumbers = []MagicNumber{
+	// CARv2 pragma.
+	{ContentType: carResponseFormat, Magic: []byte{0x0a, 0xa1, 0x67, 'v', 'e', 'r', 's', 'i', 'o', 'n', 0x02}},
+	// CARv1 header, a DAG-CBOR map whose first key is "roots", after its
+	// length in a one or two byte varint.
+	{ContentType: carResponseFormat, Offset: 1, Magic: []byte{0xa2, 0x65, 'r', 'o', 'o', 't', 's'}},
+	{ContentType: carResponseFormat, Offset: 2, Magic: []byte{0xa2, 0x65, 'r', 'o', 'o', 't', 's'}},
+}
+
+// DefaultContentTypeDetector is the [ContentTypeDetector] used when
+// [Config.ContentTypeDetector] is not set. It maps file extensions with
+// [SystemContentTypes], then matches the beginning of files against
+// [DefaultMagicNumbers] with [MagicContentTypes].
+var DefaultContentTypeDetector = ContentTypeChain(SystemContentTypes, MagicContentTypes(DefaultMagicNumbers))
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentTypeDetectors(t *testing.T) {
+	t.Parallel()
+
+	head := func(data []byte) func() []byte {
+		return func() []byte { return data }
+	}
+	carv1 := append([]byte{0x38}, []byte("\xa2eroots\x81\xd8*X#")...)
+	carv2 := []byte("\x0a\xa1gversion\x02")
+
+	ext := ExtensionContentTypes{".car": "application/vnd.ipld.car", ".MP4": "video/mp4"}
+	require.Equal(t, "application/vnd.ipld.car", ext.DetectContentType("a.CAR", nil))
+	require.Equal(t, "video/mp4", ext.DetectContentType("a.mp4", nil))
+	require.Equal(t, "", ext.DetectContentType("a.txt", nil))
+	require.Equal(t, "", ext.DetectContentType("car", nil))
+
+	magic := MagicContentTypes(DefaultMagicNumbers)
+	require.Equal(t, "application/vnd.ipld.car", magic.DetectContentType("file", head(carv1)))
+	require.Equal(t, "application/vnd.ipld.car", magic.DetectContentType("file", head(carv2)))
+	require.Equal(t, "application/wasm", magic.DetectContentType("file", head([]byte("\x00asm\x01\x00\x00\x00"))))
+	require.Equal(t, "", magic.DetectContentType("file", head(nil)))
+
+	chain := ContentTypeChain(ext, ContentTypeDetectorFunc(func(name string, _ func() []byte) string {
+		return "text/x-" + name
+	}))
+	require.Equal(t, "video/mp4", chain.DetectContentType("a.mp4", nil))
+	require.Equal(t, "text/x-a", chain.DetectContentType("a", nil))
+}
+
+func TestServeFileContentType(t *testing.T) {
+	t.Parallel()
+
+	backend, root := newUnixFSDirBackend(t, map[string][]byte{
+		"app.wasm":     []byte("\x00asm\x01\x00\x00\x00"),
+		"dag":          append([]byte{0x38}, []byte("\xa2eroots\x81\xd8*X#")...),
+		"notes.custom": []byte("some notes"),
+		"page":         []byte("<!DOCTYPE html><html><body>hello</body></html>"),
+	})
+
+	contentType := func(t *testing.T, url string, root cid.Cid, name string, rangeHeader string) string {
+		req := mustNewRequest(t, http.MethodGet, url+"/ipfs/"+root.String()+"/"+name, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		res := mustDoWithoutRedirect(t, req)
+		defer res.Body.Close()
+		require.Contains(t, []int{http.StatusOK, http.StatusPartialContent}, res.StatusCode)
+		return res.Header.Get("Content-Type")
+	}
+
+	t.Run("Default detector", func(t *testing.T) {
+		ts := newTestServerWithConfig(t, backend, Config{DeserializedResponses: true}).URL
+		require.Equal(t, "application/wasm", contentType(t, ts, root, "app.wasm", ""))
+		require.Equal(t, "application/vnd.ipld.car", contentType(t, ts, root, "dag", ""))
+		require.Equal(t, "text/html", contentType(t, ts, root, "page", ""))
+		require.Equal(t, "text/plain; charset=utf-8", contentType(t, ts, root, "notes.custom", ""))
+	})
+
+	t.Run("Custom extensions and detector", func(t *testing.T) {
+		ts := newTestServerWithConfig(t, backend, Config{
+			DeserializedResponses: true,
+			ContentTypes:          map[string]string{".custom": "text/markdown"},
+			ContentTypeDetector: ContentTypeChain(SystemContentTypes, ContentTypeDetectorFunc(func(name string, head func() []byte) string {
+				if data := head(); data != nil && bytes.HasPrefix(data, []byte("<!DOCTYPE")) {
+					return "application/xhtml+xml"
+				}
+				return "application/x-unknown"
+			})),
+		}).URL
+		require.Equal(t, "text/markdown", contentType(t, ts, root, "notes.custom", ""))
+		require.Equal(t, "application/xhtml+xml", contentType(t, ts, root, "page", ""))
+		require.Equal(t, "application/x-unknown", contentType(t, ts, root, "dag", ""))
+
+		// The beginning of the file is not available to detectors with range
+		// requests that do not start at zero.
+		require.Equal(t, "application/x-unknown", contentType(t, ts, root, "page", "bytes=2-"))
+	})
+}
@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/ipfs/boxo/denylist"
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+)
+
+// denylistBackend enforces a [denylist.Denylist] on the paths requested from
+// the wrapped [IPFSBackend] and on the CIDs and paths they resolve to.
+type denylistBackend struct {
+	backend  IPFSBackend
+	denylist *denylist.Denylist
+}
+
+var (
+	_ IPFSBackend     = (*denylistBackend)(nil)
+	_ WithContextHint = (*denylistBackend)(nil)
+	_ WithCarRanges   = (*denylistBackend)(nil)
+)
+
+func newDenylistBackend(backend IPFSBackend, dl *denylist.Denylist) *denylistBackend {
+	return &denylistBackend{backend: backend, denylist: dl}
+}
+
+// checkMetadata checks every CID of the resolved path, and the path to its
+// last segment.
+func (b *denylistBackend) checkMetadata(md ContentPathMetadata) error {
+	for _, c := range md.PathSegmentRoots {
+		if err := b.denylist.CheckCid(c); err != nil {
+			return err
+		}
+	}
+	if md.LastSegment.RootCid().Defined() {
+		return b.denylist.CheckPath(md.LastSegment)
+	}
+	return nil
+}
+
+// check checks the requested path and, when resolution succeeded, its
+// metadata. closer is closed when the content is blocked.
+func (b *denylistBackend) check(md ContentPathMetadata, closer io.Closer, err error) (ContentPathMetadata, error) {
+	if err != nil {
+		return md, err
+	}
+	if err := b.checkMetadata(md); err != nil {
+		if closer != nil {
+			_ = closer.Close()
+		}
+		return ContentPathMetadata{}, err
+	}
+	return md, nil
+}
+
+func (b *denylistBackend) Get(ctx context.Context, p path.ImmutablePath, ranges ...ByteRange) (ContentPathMetadata, *GetResponse, error) {
+	if err := b.denylist.CheckPath(p); err != nil {
+		return ContentPathMetadata{}, nil, err
+	}
+	md, resp, err := b.backend.Get(ctx, p, ranges...)
+	var closer io.Closer
+	if resp != nil {
+		closer = resp
+	}
+	md, err = b.check(md, closer, err)
+	if err != nil {
+		return md, nil, err
+	}
+	return md, resp, nil
+}
+
+func (b *denylistBackend) GetAll(ctx context.Context, p path.ImmutablePath) (ContentPathMetadata, files.Node, error) {
+	if err := b.denylist.CheckPath(p); err != nil {
+		return ContentPathMetadata{}, nil, err
+	}
+	md, n, err := b.backend.GetAll(ctx, p)
+	md, err = b.check(md, n, err)
+	if err != nil {
+		return md, nil, err
+	}
+	return md, n, nil
+}
+
+func (b *denylistBackend) GetBlock(ctx context.Context, p path.ImmutablePath) (ContentPathMetadata, files.File, error) {
+	if err := b.denylist.CheckPath(p); err != nil {
+		return ContentPathMetadata{}, nil, err
+	}
+	md, f, err := b.backend.GetBlock(ctx, p)
+	md, err = b.check(md, f, err)
+	if err != nil {
+		return md, nil, err
+	}
+	return md, f, nil
+}
+
+func (b *denylistBackend) Head(ctx context.Context, p path.ImmutablePath) (ContentPathMetadata, *HeadResponse, error) {
+	if err := b.denylist.CheckPath(p); err != nil {
+		return ContentPathMetadata{}, nil, err
+	}
+	md, resp, err := b.backend.Head(ctx, p)
+	var closer io.Closer
+	if resp != nil {
+		closer = resp
+	}
+	md, err = b.check(md, closer, err)
+	if err != nil {
+		return md, nil, err
+	}
+	return md, resp, nil
+}
+
+func (b *denylistBackend) ResolvePath(ctx context.Context, p path.ImmutablePath) (ContentPathMetadata, error) {
+	if err := b.denylist.CheckPath(p); err != nil {
+		return ContentPathMetadata{}, err
+	}
+	md, err := b.backend.ResolvePath(ctx, p)
+	return b.check(md, nil, err)
+}
+
+func (b *denylistBackend) GetCAR(ctx context.Context, p path.ImmutablePath, params CarParams) (ContentPathMetadata, io.ReadCloser, error) {
+	if err := b.denylist.CheckPath(p); err != nil {
+		return ContentPathMetadata{}, nil, err
+	}
+	md, rc, err := b.backend.GetCAR(ctx, p, params)
+	md, err = b.check(md, rc, err)
+	if err != nil {
+		return md, nil, err
+	}
+	return md, rc, nil
+}
+
+func (b *denylistBackend) IsCached(ctx context.Context, p path.Path) bool {
+	return b.backend.IsCached(ctx, p)
+}
+
+func (b *denylistBackend) GetIPNSRecord(ctx context.Context, c cid.Cid) ([]byte, error) {
+	name, err := ipns.NameFromCid(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.denylist.CheckPath(name.AsPath()); err != nil {
+		return nil, err
+	}
+	return b.backend.GetIPNSRecord(ctx, c)
+}
+
+func (b *denylistBackend) ResolveMutable(ctx context.Context, p path.Path) (path.ImmutablePath, time.Duration, time.Time, error) {
+	if err := b.denylist.CheckPath(p); err != nil {
+		return path.ImmutablePath{}, 0, time.Time{}, err
+	}
+	ip, ttl, lastMod, err := b.backend.ResolveMutable(ctx, p)
+	if err != nil {
+		return ip, ttl, lastMod, err
+	}
+	if err := b.denylist.CheckPath(ip); err != nil {
+		return path.ImmutablePath{}, 0, time.Time{}, err
+	}
+	return ip, ttl, lastMod, nil
+}
+
+func (b *denylistBackend) GetDNSLinkRecord(ctx context.Context, hostname string) (path.Path, error) {
+	if p, err := path.NewPath("/ipns/" + hostname); err == nil {
+		if err := b.denylist.CheckPath(p); err != nil {
+			return nil, err
+		}
+	}
+	p, err := b.backend.GetDNSLinkRecord(ctx, hostname)
+	if err != nil {
+		return p, err
+	}
+	if err := b.denylist.CheckPath(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (b *denylistBackend) SupportsCarRanges() bool {
+	return supportsCarRanges(b.backend)
+}
+
+func (b *denylistBackend) WrapContextForRequest(ctx context.Context) context.Context {
+	if withCtxWrap, ok := b.backend.(WithContextHint); ok {
+		return withCtxWrap.WrapContextForRequest(ctx)
+	}
+	return ctx
+}
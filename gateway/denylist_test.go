@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/denylist"
+	"github.com/ipfs/boxo/path"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenylist(t *testing.T) {
+	backend, root := newMockBackend(t, "fixtures.car")
+
+	p, err := path.Join(path.FromCid(root), "subdir", "fnord")
+	require.NoError(t, err)
+	fnord, err := backend.resolvePathNoRootsReturned(context.Background(), p)
+	require.NoError(t, err)
+	backend.namesys["/ipns/example.com"] = newMockNamesysItem(path.FromCid(root), 0)
+	backend.namesys["/ipns/other.example.com"] = newMockNamesysItem(path.FromCid(root), 0)
+
+	dl, err := denylist.Parse(strings.NewReader(strings.Join([]string{
+		"version: 1",
+		"---",
+		"/ipfs/" + fnord.RootCid().String(),
+		"/ipfs/" + root.String() + "/empty-dir",
+		"/ipns/example.com",
+	}, "\n")))
+	require.NoError(t, err)
+	ts := newTestServerWithConfig(t, backend, Config{DeserializedResponses: true, Denylist: dl})
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/ipfs/" + root.String() + "/", http.StatusOK},
+		{"/ipfs/" + root.String() + "/subdir/fnord", http.StatusGone},
+		{"/ipfs/" + fnord.RootCid().String(), http.StatusGone},
+		{"/ipfs/" + fnord.RootCid().String() + "?format=car", http.StatusGone},
+		{"/ipfs/" + root.String() + "/empty-dir/", http.StatusGone},
+		{"/ipns/example.com/", http.StatusGone},
+		{"/ipns/other.example.com/", http.StatusOK},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+test.path, nil))
+			require.Equal(t, test.status, res.StatusCode)
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ipfs/boxo/denylist"
 	"github.com/ipfs/boxo/gateway/assets"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/boxo/path/resolver"
@@ -253,6 +254,9 @@ func isErrNotFound(err error) bool {
 
 // isErrContentBlocked returns true for content filtering system errors
 func isErrContentBlocked(err error) bool {
+	if errors.Is(err, denylist.ErrContentBlocked) {
+		return true
+	}
 	// TODO: we match error message to avoid pulling nopfs as a dependency
 	// Ref. https://github.com/ipfs-shipyard/nopfs/blob/cde3b5ba964c13e977f4a95f3bd8ca7d7710fbda/status.go#L87-L89
 	return strings.Contains(err.Error(), "blocked and cannot be provided")
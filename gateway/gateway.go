@@ -1,14 +1,18 @@
 package gateway
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ipfs/boxo/denylist"
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/gateway/assets"
 	"github.com/ipfs/boxo/ipld/unixfs"
@@ -48,6 +52,68 @@ type Config struct {
 	// directory listings, DAG previews and errors. These will be displayed to the
 	// right of "About IPFS" and "Install IPFS".
 	Menu []assets.MenuItem
+
+	// ResponseCache, when set, stores the responses to requests for immutable
+	// content paths so that they can be served again without using the
+	// backend. See [NewMemoryResponseCache] and [NewDiskResponseCache].
+	ResponseCache ResponseCache
+
+	// RateLimiter, when set, is consulted before serving every request. Refused
+	// requests receive an HTTP 429 Too Many Requests response with a
+	// Retry-After header. See [NewClientRateLimiter].
+	RateLimiter RateLimiter
+
+	// Denylist, when set, is enforced during path resolution: requests for
+	// blocked content, whether the content path itself or any CID or IPNS
+	// name met while resolving it, receive an HTTP 410 Gone response.
+	Denylist *denylist.Denylist
+
+	// Authorizer, when set, is consulted before serving every request, and
+	// before accessing the backend. It can be overridden per FQDN in
+	// PublicGateways. See [NewHMACAuthorizer].
+	Authorizer Authorizer
+
+	// Writable, when set, enables PUT, POST and DELETE requests that import
+	// files and edit directories. See [WritableConfig].
+	Writable *WritableConfig
+
+	// ContentTypes maps file extensions, such as ".wasm", to the Content-Type
+	// of the UnixFS files served with them. It takes precedence over
+	// ContentTypeDetector.
+	ContentTypes map[string]string
+
+	// ContentTypeDetector determines the Content-Type of UnixFS files whose
+	// extension is not in ContentTypes. Defaults to
+	// [DefaultContentTypeDetector]. See [ContentTypeChain] to combine
+	// detectors.
+	ContentTypeDetector ContentTypeDetector
+
+	// IPNSWatchInterval is the interval between the resolutions of the IPNS
+	// names watched by clients with Server-Sent Events, requested with the
+	// Accept: text/event-stream header or ?format=ipns-events on /ipns/{name}.
+	// Every name is resolved once per interval, whatever the number of
+	// clients watching it. Defaults to one minute.
+	IPNSWatchInterval time.Duration
+
+	// MaxIPNSWatchedNames is the number of IPNS names which can be watched at
+	// the same time. Streams for other names are refused with HTTP 503
+	// Service Unavailable. Defaults to 1000.
+	MaxIPNSWatchedNames int
+
+	// MaxIPNSEventStreamsPerClient is the number of IPNS event streams which
+	// a client, identified by ClientKey, can have open at the same time.
+	// Further streams are refused with HTTP 429 Too Many Requests. Defaults
+	// to 16.
+	MaxIPNSEventStreamsPerClient int
+
+	// ClientKey identifies the client of a request for the per-client limits
+	// which are not enforced by the RateLimiter, such as
+	// MaxIPNSEventStreamsPerClient. Defaults to the ClientKey of the
+	// [ClientRateLimiterConfig] when RateLimiter was returned by
+	// [NewClientRateLimiter], and to [ClientIP] otherwise. Behind a reverse
+	// proxy, which all requests come from, it should identify the clients
+	// with a header set by the proxy.
+	ClientKey func(*http.Request) string
 }
 
 // PublicGateway is the specification of an IPFS Public Gateway.
@@ -86,15 +152,36 @@ type PublicGateway struct {
 	// DeserializedResponses configures this gateway to support returning data
 	// in deserialized format. This setting overrides the global setting.
 	DeserializedResponses bool
+
+	// Authorizer, when set, is consulted before serving every request to this
+	// gateway. This setting overrides the global setting.
+	Authorizer Authorizer
 }
 
 type CarParams struct {
-	Range      *DagByteRange
+	Range *DagByteRange
+	// Ranges are the entity-bytes ranges of requests for more than one range,
+	// in which case Range is nil. The CAR contains the blocks needed for all
+	// of them, each block once unless duplicates are included. They are only
+	// passed to backends implementing [WithCarRanges].
+	Ranges     []DagByteRange
 	Scope      DagScope
 	Order      DagOrder
 	Duplicates DuplicateBlocksPolicy
 }
 
+// entityRanges returns the requested entity-bytes ranges, or the whole entity
+// when none is requested.
+func (p CarParams) entityRanges() []DagByteRange {
+	if len(p.Ranges) > 0 {
+		return p.Ranges
+	}
+	if p.Range != nil {
+		return []DagByteRange{*p.Range}
+	}
+	return []DagByteRange{{From: 0}}
+}
+
 // DagByteRange describes a range request within a UnixFS file. "From" and
 // "To" mostly follow the [HTTP Byte Range] Request semantics:
 //
@@ -147,6 +234,85 @@ func NewDagByteRange(rangeStr string) (DagByteRange, error) {
 	}, nil
 }
 
+// String returns the range in the from:to syntax of the entity-bytes
+// parameter.
+func (r DagByteRange) String() string {
+	to := "*"
+	if r.To != nil {
+		to = strconv.FormatInt(*r.To, 10)
+	}
+	return strconv.FormatInt(r.From, 10) + ":" + to
+}
+
+// resolveDagByteRanges returns the ranges with offsets from the start of the
+// entity, sorted, and with overlapping and adjacent ranges merged so that the
+// blocks they need are traversed once. To is nil for ranges up to the end of
+// the entity. size is only called for ranges relative to the end.
+func resolveDagByteRanges(ranges []DagByteRange, size func() (int64, error)) ([]DagByteRange, error) {
+	var entitySize *int64
+	getSize := func() (int64, error) {
+		if entitySize == nil {
+			s, err := size()
+			if err != nil {
+				return 0, err
+			}
+			entitySize = &s
+		}
+		return *entitySize, nil
+	}
+
+	resolved := make([]DagByteRange, 0, len(ranges))
+	for _, rng := range ranges {
+		from := rng.From
+		if from < 0 {
+			s, err := getSize()
+			if err != nil {
+				return nil, err
+			}
+			from = max(s+from, 0)
+		}
+
+		var to *int64
+		if rng.To != nil {
+			t := *rng.To
+			if t < 0 {
+				s, err := getSize()
+				if err != nil {
+					return nil, err
+				}
+				t = s + t
+			}
+			if 1+t-from < 0 {
+				return nil, errors.New("tried to read less than zero bytes")
+			}
+			to = &t
+		}
+		resolved = append(resolved, DagByteRange{From: from, To: to})
+	}
+
+	slices.SortFunc(resolved, func(a, b DagByteRange) int {
+		return cmp.Compare(a.From, b.From)
+	})
+
+	merged := make([]DagByteRange, 0, len(resolved))
+	for _, rng := range resolved {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.To == nil {
+				continue
+			}
+			if rng.From <= *last.To+1 {
+				if rng.To == nil || *rng.To > *last.To {
+					last.To = rng.To
+				}
+				continue
+			}
+		}
+		merged = append(merged, rng)
+	}
+	return merged, nil
+}
+
 // DagScope describes the scope of the requested DAG, as per the [Trustless Gateway]
 // specification.
 //
@@ -256,6 +422,9 @@ type directoryMetadata struct {
 	dagSize uint64
 	entries <-chan unixfs.LinkResult
 	closeFn func() error
+	// afterCursor is set when entries start after the cursor of a directory
+	// listing request, see withDirListingCursor.
+	afterCursor bool
 }
 
 func NewGetResponseFromReader(file io.ReadCloser, fullFileSize int64) *GetResponse {
@@ -388,6 +557,21 @@ type WithContextHint interface {
 	WrapContextForRequest(context.Context) context.Context
 }
 
+// WithCarRanges is implemented by the [IPFSBackend]s which serve the
+// [CarParams.Ranges] of CAR requests for several entity-bytes ranges. Such
+// requests are refused with HTTP 400 Bad Request when the backend does not
+// support them.
+type WithCarRanges interface {
+	// SupportsCarRanges returns true when GetCAR serves CarParams.Ranges.
+	SupportsCarRanges() bool
+}
+
+// supportsCarRanges returns true when backend serves CarParams.Ranges.
+func supportsCarRanges(backend IPFSBackend) bool {
+	b, ok := backend.(WithCarRanges)
+	return ok && b.SupportsCarRanges()
+}
+
 // RequestContextKey is a type representing a [context.Context] value key.
 type RequestContextKey string
 
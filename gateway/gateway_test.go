@@ -184,6 +184,7 @@ func TestHeaders(t *testing.T) {
 		test(carResponseFormat, dirPath, `W/"%s.car.7of9u8ojv38vd"`, rootCID) // ETags of CARs on a Path have the root CID in the Etag and hashed information to derive the correct Etag of the full request.
 		test(rawResponseFormat, dirPath, `"%s.raw"`, dirCID)
 		test(tarResponseFormat, dirPath, `W/"%s.x-tar"`, dirCID)
+		test(zipResponseFormat, dirPath, `W/"%s.zip"`, dirCID)
 
 		test("", hamtFilePath, `"%s"`, hamtFileCID)
 		test("text/html", hamtFilePath, `"%s"`, hamtFileCID)
@@ -531,7 +532,7 @@ func TestHeaders(t *testing.T) {
 		runTest("DNSLink gateway with default format", "/empty-dir/", "", dnslinkGatewayHost, "")
 
 		for responseFormat, formatParam := range responseFormatToFormatParam {
-			if responseFormat == ipnsRecordResponseFormat {
+			if responseFormat == ipnsRecordResponseFormat || responseFormat == eventStreamResponseFormat {
 				continue
 			}
 
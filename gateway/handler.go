@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/ipfs/boxo/gateway/assets"
 	"github.com/ipfs/boxo/ipns"
 	"github.com/ipfs/boxo/path"
@@ -61,6 +62,16 @@ type handler struct {
 	tarStreamFailMetric          *prometheus.HistogramVec
 	jsoncborDocumentGetMetric    *prometheus.HistogramVec
 	ipnsRecordGetMetric          *prometheus.HistogramVec
+
+	// response cache and rate limiting metrics
+	responseCacheMetric *prometheus.CounterVec
+	rateLimitedMetric   *prometheus.CounterVec
+
+	// parsed _headers files by root CID
+	headersFiles *lru.Cache[cid.Cid, []headersRule]
+
+	// IPNS names watched by event streams
+	ipnsWatchers *ipnsWatchers
 }
 
 // NewHandler returns an [http.Handler] that provides the functionality
@@ -145,26 +156,34 @@ func (i *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodOptions:
 		i.optionsHandler(w, r)
 		return
+	case http.MethodPut, http.MethodPost, http.MethodDelete:
+		if i.config.Writable != nil {
+			i.writeHandler(w, r)
+			return
+		}
 	}
 
-	addAllowHeader(w)
+	i.addAllowHeader(w)
 
 	errmsg := "Method " + r.Method + " not allowed: read only access"
 	http.Error(w, errmsg, http.StatusMethodNotAllowed)
 }
 
 func (i *handler) optionsHandler(w http.ResponseWriter, r *http.Request) {
-	addAllowHeader(w)
+	i.addAllowHeader(w)
 	// OPTIONS is a noop request that is used by the browsers to check if server accepts
 	// cross-site XMLHttpRequest, which is indicated by the presence of CORS headers:
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Access_control_CORS#Preflighted_requests
 }
 
 // addAllowHeader sets Allow header with supported HTTP methods
-func addAllowHeader(w http.ResponseWriter) {
+func (i *handler) addAllowHeader(w http.ResponseWriter) {
 	w.Header().Add("Allow", http.MethodGet)
 	w.Header().Add("Allow", http.MethodHead)
 	w.Header().Add("Allow", http.MethodOptions)
+	if i.config.Writable != nil {
+		addWritableAllowHeader(w)
+	}
 }
 
 type requestData struct {
@@ -229,6 +248,43 @@ func (i *handler) getOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	// The denylist is also enforced by the backend during path resolution,
+	// this check prevents serving blocked paths from the response cache.
+	if i.config.Denylist != nil {
+		if err := i.config.Denylist.CheckPath(contentPath); err != nil {
+			i.webError(w, r, err, http.StatusGone)
+			return
+		}
+	}
+
+	if i.config.RateLimiter != nil {
+		var ok bool
+		if r, ok = i.admitRequest(w, r, i.requestClass(r, contentPath)); !ok {
+			return
+		}
+	}
+
+	if authorizer := i.authorizer(r); authorizer != nil {
+		var ok bool
+		if w, ok = i.authorizeRequest(w, r, authorizer, contentPath); !ok {
+			return
+		}
+	}
+
+	if i.config.ResponseCache != nil {
+		if key := responseCacheKey(r, contentPath); key != "" {
+			if i.serveCachedResponse(w, r, key) {
+				success = true
+				return
+			}
+			if r.Method == http.MethodGet {
+				rec := &responseRecorder{ResponseWriter: w}
+				defer i.storeResponse(r.Context(), key, rec)
+				w = rec
+			}
+		}
+	}
+
 	if i.handleOnlyIfCached(w, r, contentPath) {
 		return
 	}
@@ -270,6 +326,13 @@ func (i *handler) getOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// IPNS event streams watch the non-resolved mutable path too.
+	if responseFormat == eventStreamResponseFormat {
+		logger.Debugw("serving ipns events", "path", contentPath)
+		success = i.serveIpnsEvents(r.Context(), w, r, rq)
+		return
+	}
+
 	if contentPath.Mutable() {
 		rq.immutablePath, rq.ttl, rq.lastMod, err = i.backend.ResolveMutable(r.Context(), contentPath)
 		if err != nil {
@@ -295,6 +358,15 @@ func (i *handler) getOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Custom headers of UnixFS sites, which are applied to HTTP 304 Not
+	// Modified responses too. Like _redirects, they require origin isolation.
+	if isWebRequest(responseFormat) && hasOriginIsolation(r) {
+		var ok bool
+		if w, ok = i.serveHeadersFileIfPresent(w, r, rq); !ok {
+			return
+		}
+	}
+
 	// Detect when If-None-Match HTTP header allows returning HTTP 304 Not Modified.
 	if i.handleIfNoneMatch(w, r, rq) {
 		return
@@ -307,13 +379,15 @@ func (i *handler) getOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Support custom response formats passed via ?format or Accept HTTP header
 	switch responseFormat {
-	case "", jsonResponseFormat, cborResponseFormat:
+	case "":
 		success = i.serveDefaults(r.Context(), w, r, rq)
+	case jsonResponseFormat, cborResponseFormat:
+		success = i.serveDefaults(withDirListingCursor(r.Context(), r), w, r, rq)
 	case rawResponseFormat:
 		logger.Debugw("serving raw block", "path", contentPath)
 		success = i.serveRawBlock(r.Context(), w, r, rq)
-	case tarResponseFormat:
-		logger.Debugw("serving tar file", "path", contentPath)
+	case tarResponseFormat, tarGzipResponseFormat, tarZstdResponseFormat, zipResponseFormat:
+		logger.Debugw("serving archive", "path", contentPath, "format", responseFormat)
 		success = i.serveTAR(r.Context(), w, r, rq)
 	case dagJsonResponseFormat, dagCborResponseFormat:
 		logger.Debugw("serving codec", "path", contentPath)
@@ -328,6 +402,18 @@ func (i *handler) getOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 // are allowed on the specified hostname, or globally. Host-specific rules
 // override global config.
 func (i *handler) isDeserializedResponsePossible(r *http.Request) bool {
+	// If the gateway is defined, return whatever is set.
+	if gw, ok := i.publicGateway(r); ok {
+		return gw.DeserializedResponses
+	}
+
+	// Otherwise, the default.
+	return i.config.DeserializedResponses
+}
+
+// publicGateway returns the configuration of the gateway hostname of r, if
+// it is defined in PublicGateways.
+func (i *handler) publicGateway(r *http.Request) (*PublicGateway, bool) {
 	// Get the value from HTTP Host header
 	host := r.Host
 
@@ -342,13 +428,8 @@ func (i *handler) isDeserializedResponsePossible(r *http.Request) bool {
 		host = xHost
 	}
 
-	// If the gateway is defined, return whatever is set.
-	if gw, ok := i.config.PublicGateways[host]; ok {
-		return gw.DeserializedResponses
-	}
-
-	// Otherwise, the default.
-	return i.config.DeserializedResponses
+	gw, ok := i.config.PublicGateways[host]
+	return gw, ok
 }
 
 // isTrustlessRequest returns true if the responseFormat and contentPath allow
@@ -366,7 +447,7 @@ func (i *handler) isTrustlessRequest(contentPath path.Path, responseFormat strin
 
 	if contentPath.Namespace() == path.IPNSNamespace {
 		// TODO: only ipns records allowed until https://github.com/ipfs/specs/issues/369 is resolved
-		if responseFormat != ipnsRecordResponseFormat {
+		if responseFormat != ipnsRecordResponseFormat && responseFormat != eventStreamResponseFormat {
 			return false
 		}
 
@@ -588,7 +669,7 @@ func getEtag(r *http.Request, cid cid.Cid, responseFormat string) string {
 	case carResponseFormat, ipnsRecordResponseFormat:
 		// CARs and IPNS Record ETags are handled differently, in their respective handler.
 		return ""
-	case tarResponseFormat:
+	case tarResponseFormat, tarGzipResponseFormat, tarZstdResponseFormat, zipResponseFormat:
 		// Weak Etag W/ for formats that we can't guarantee byte-for-byte identical
 		// responses, but still want to benefit from HTTP Caching.
 		prefix = "W/" + prefix
@@ -608,11 +689,16 @@ const (
 	rawResponseFormat        = "application/vnd.ipld.raw"
 	carResponseFormat        = "application/vnd.ipld.car"
 	tarResponseFormat        = "application/x-tar"
+	tarGzipResponseFormat    = "application/gzip"
+	tarZstdResponseFormat    = "application/zstd"
+	zipResponseFormat        = "application/zip"
 	jsonResponseFormat       = "application/json"
 	cborResponseFormat       = "application/cbor"
 	dagJsonResponseFormat    = "application/vnd.ipld.dag-json"
 	dagCborResponseFormat    = "application/vnd.ipld.dag-cbor"
 	ipnsRecordResponseFormat = "application/vnd.ipfs.ipns-record"
+
+	eventStreamResponseFormat = "text/event-stream"
 )
 
 var (
@@ -620,11 +706,15 @@ var (
 		"raw":         rawResponseFormat,
 		"car":         carResponseFormat,
 		"tar":         tarResponseFormat,
+		"tar.gz":      tarGzipResponseFormat,
+		"tar.zst":     tarZstdResponseFormat,
+		"zip":         zipResponseFormat,
 		"json":        jsonResponseFormat,
 		"cbor":        cborResponseFormat,
 		"dag-json":    dagJsonResponseFormat,
 		"dag-cbor":    dagCborResponseFormat,
 		"ipns-record": ipnsRecordResponseFormat,
+		"ipns-events": eventStreamResponseFormat,
 	}
 
 	responseFormatToFormatParam = map[string]string{}
@@ -652,8 +742,12 @@ func customResponseFormat(r *http.Request) (mediaType string, params map[string]
 			if strings.HasPrefix(accept, "application/vnd.ipld") ||
 				strings.HasPrefix(accept, "application/vnd.ipfs") ||
 				strings.HasPrefix(accept, tarResponseFormat) ||
+				strings.HasPrefix(accept, tarGzipResponseFormat) ||
+				strings.HasPrefix(accept, tarZstdResponseFormat) ||
+				strings.HasPrefix(accept, zipResponseFormat) ||
 				strings.HasPrefix(accept, jsonResponseFormat) ||
-				strings.HasPrefix(accept, cborResponseFormat) {
+				strings.HasPrefix(accept, cborResponseFormat) ||
+				strings.HasPrefix(accept, eventStreamResponseFormat) {
 				mediatype, params, err := mime.ParseMediaType(accept)
 				if err != nil {
 					return "", nil, err
@@ -752,7 +846,12 @@ func (i *handler) handleIfNoneMatch(w http.ResponseWriter, r *http.Request, rq *
 		dirEtag := getDirListingEtag(pathCid)
 		dagEtag := getDagIndexEtag(pathCid)
 
-		if etagMatch(ifNoneMatch, cidEtag, dirEtag, dagEtag) {
+		etags := []string{cidEtag, dirEtag, dagEtag}
+		if rq.responseFormat == jsonResponseFormat || rq.responseFormat == cborResponseFormat {
+			etags = append(etags, getDirListingDataEtag(r, pathCid, rq.responseFormat))
+		}
+
+		if etagMatch(ifNoneMatch, etags...) {
 			// Finish early if client already has a matching Etag
 			w.WriteHeader(http.StatusNotModified)
 			return true
@@ -25,6 +25,10 @@ const (
 	carVersionKey             = "car-version"
 	carDuplicatesKey          = "car-dups"
 	carOrderKey               = "car-order"
+
+	// maxCarEntityRanges is the maximum number of entity-bytes ranges of a
+	// request.
+	maxCarEntityRanges = 64
 )
 
 // serveCAR returns a CAR stream for specific DAG+selector
@@ -40,6 +44,10 @@ func (i *handler) serveCAR(ctx context.Context, w http.ResponseWriter, r *http.R
 		i.webError(w, r, err, http.StatusBadRequest)
 		return false
 	}
+	if len(params.Ranges) > 1 && !supportsCarRanges(i.backend) {
+		i.webError(w, r, errors.New("several application/vnd.ipld.car entity-bytes ranges are not supported"), http.StatusBadRequest)
+		return false
+	}
 
 	rootCid, lastSegment, err := getCarRootCidAndLastSegment(rq.immutablePath)
 	if err != nil {
@@ -119,17 +127,32 @@ func (i *handler) serveCAR(ctx context.Context, w http.ResponseWriter, r *http.R
 func buildCarParams(r *http.Request, contentTypeParams map[string]string) (CarParams, error) {
 	// URL query parameters
 	queryParams := r.URL.Query()
-	rangeStr, hasRange := queryParams.Get(carRangeBytesKey), queryParams.Has(carRangeBytesKey)
+	hasRange := queryParams.Has(carRangeBytesKey)
 	scopeStr, hasScope := queryParams.Get(carTerminalElementTypeKey), queryParams.Has(carTerminalElementTypeKey)
 
 	params := CarParams{}
 	if hasRange {
-		rng, err := NewDagByteRange(rangeStr)
-		if err != nil {
-			err = fmt.Errorf("invalid application/vnd.ipld.car entity-bytes URL parameter: %w", err)
-			return CarParams{}, err
+		// Several ranges can be requested, separated by commas or in
+		// separate parameters.
+		var ranges []DagByteRange
+		for _, v := range queryParams[carRangeBytesKey] {
+			for _, rangeStr := range strings.Split(v, ",") {
+				rng, err := NewDagByteRange(rangeStr)
+				if err != nil {
+					err = fmt.Errorf("invalid application/vnd.ipld.car entity-bytes URL parameter: %w", err)
+					return CarParams{}, err
+				}
+				ranges = append(ranges, rng)
+			}
+		}
+		if len(ranges) > maxCarEntityRanges {
+			return CarParams{}, fmt.Errorf("too many application/vnd.ipld.car entity-bytes ranges: %d, the maximum is %d", len(ranges), maxCarEntityRanges)
+		}
+		if len(ranges) == 1 {
+			params.Range = &ranges[0]
+		} else {
+			params.Ranges = ranges
 		}
-		params.Range = &rng
 	}
 
 	if hasScope {
@@ -267,14 +290,18 @@ func getCarEtag(imPath path.ImmutablePath, params CarParams, rootCid cid.Cid) st
 		h.WriteString("\x00dups=y")
 	}
 
-	if params.Range != nil {
-		if params.Range.From != 0 || params.Range.To != nil {
+	if params.Range != nil || len(params.Ranges) > 0 {
+		ranges := params.entityRanges()
+		for _, rng := range ranges {
+			if len(ranges) == 1 && rng.From == 0 && rng.To == nil {
+				continue
+			}
 			h.WriteString("\x00range=")
 			var b [8]byte
-			binary.LittleEndian.PutUint64(b[:], uint64(params.Range.From))
+			binary.LittleEndian.PutUint64(b[:], uint64(rng.From))
 			h.Write(b[:])
-			if params.Range.To != nil {
-				binary.LittleEndian.PutUint64(b[:], uint64(*params.Range.To))
+			if rng.To != nil {
+				binary.LittleEndian.PutUint64(b[:], uint64(*rng.To))
 				h.Write(b[:])
 			}
 		}
@@ -1,12 +1,25 @@
 package gateway
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	chunker "github.com/ipfs/boxo/chunker"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs/importer"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	carv2 "github.com/ipld/go-car/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -75,6 +88,27 @@ func TestCarParams(t *testing.T) {
 		}
 	})
 
+	t.Run("multiple entity-bytes ranges", func(t *testing.T) {
+		t.Parallel()
+
+		r := mustNewRequest(t, http.MethodGet, "http://example.com/?entity-bytes=0:99,-100:*&entity-bytes=200:299", nil)
+		params, err := buildCarParams(r, map[string]string{})
+		require.NoError(t, err)
+		require.Len(t, params.Ranges, 3)
+		require.Nil(t, params.Range)
+		require.Equal(t, "0:99", params.Ranges[0].String())
+		require.Equal(t, "-100:*", params.Ranges[1].String())
+		require.Equal(t, "200:299", params.Ranges[2].String())
+
+		r = mustNewRequest(t, http.MethodGet, "http://example.com/?entity-bytes=0:99,abc", nil)
+		_, err = buildCarParams(r, map[string]string{})
+		require.Error(t, err)
+
+		r = mustNewRequest(t, http.MethodGet, "http://example.com/?entity-bytes="+strings.Repeat("0:1,", maxCarEntityRanges)+"0:1", nil)
+		_, err = buildCarParams(r, map[string]string{})
+		require.Error(t, err)
+	})
+
 	t.Run("buildCarParams from Accept header: order and dups parsing", func(t *testing.T) {
 		t.Parallel()
 
@@ -190,6 +224,14 @@ func TestGetCarEtag(t *testing.T) {
 		require.NotEqual(t, noRange, withRange)
 	})
 
+	t.Run("Etags with different entity-bytes ranges are different", func(t *testing.T) {
+		t.Parallel()
+
+		one := getCarEtag(imPath, CarParams{Range: &DagByteRange{From: 1}}, cid)
+		many := getCarEtag(imPath, CarParams{Ranges: []DagByteRange{{From: 1}, {From: 0}}}, cid)
+		require.NotEqual(t, one, many)
+	})
+
 	t.Run("Etags with different dag-scope are different", func(t *testing.T) {
 		t.Parallel()
 
@@ -198,3 +240,98 @@ func TestGetCarEtag(t *testing.T) {
 		require.NotEqual(t, a, b)
 	})
 }
+
+func TestResolveDagByteRanges(t *testing.T) {
+	t.Parallel()
+
+	to := func(n int64) *int64 { return &n }
+	size := func() (int64, error) { return 1000, nil }
+
+	tests := []struct {
+		ranges   []DagByteRange
+		expected []DagByteRange
+		hasError bool
+	}{
+		{[]DagByteRange{{From: 0}}, []DagByteRange{{From: 0}}, false},
+		{[]DagByteRange{{From: 500, To: to(599)}, {From: 0, To: to(99)}}, []DagByteRange{{From: 0, To: to(99)}, {From: 500, To: to(599)}}, false},
+		// Overlapping and adjacent ranges are merged.
+		{[]DagByteRange{{From: 0, To: to(99)}, {From: 50, To: to(199)}, {From: 200, To: to(299)}}, []DagByteRange{{From: 0, To: to(299)}}, false},
+		{[]DagByteRange{{From: 0, To: to(99)}, {From: 10, To: to(19)}}, []DagByteRange{{From: 0, To: to(99)}}, false},
+		{[]DagByteRange{{From: 100}, {From: 500, To: to(599)}}, []DagByteRange{{From: 100}}, false},
+		// Ranges relative to the end are resolved.
+		{[]DagByteRange{{From: -100}, {From: 0, To: to(-901)}}, []DagByteRange{{From: 0, To: to(99)}, {From: 900}}, false},
+		{[]DagByteRange{{From: -2000, To: to(9)}}, []DagByteRange{{From: 0, To: to(9)}}, false},
+		{[]DagByteRange{{From: 500, To: to(-900)}}, nil, true},
+	}
+	for _, test := range tests {
+		ranges, err := resolveDagByteRanges(test.ranges, size)
+		if test.hasError {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, test.expected, ranges)
+	}
+
+	// The size is only needed for ranges relative to the end.
+	_, err := resolveDagByteRanges([]DagByteRange{{From: 0, To: to(10)}, {From: 20}}, func() (int64, error) {
+		return 0, errors.New("size requested")
+	})
+	require.NoError(t, err)
+}
+
+func TestCarMultipleEntityRanges(t *testing.T) {
+	t.Parallel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bs, offline.Exchange(bs))
+	dserv := merkledag.NewDAGService(bsrv)
+	backend, err := NewBlocksBackend(bsrv)
+	require.NoError(t, err)
+
+	// A file of 16 leaves of 256 bytes.
+	data := make([]byte, 16*256)
+	for n := range data {
+		data[n] = byte(n / 256)
+	}
+	nd, err := importer.BuildDagFromReader(dserv, chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+	ts := newTestServer(t, backend)
+
+	carBlocks := func(t *testing.T, query string) []cid.Cid {
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+nd.Cid().String()+"?format=car&dag-scope=entity&"+query, nil))
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		br, err := carv2.NewBlockReader(res.Body)
+		require.NoError(t, err)
+		var cids []cid.Cid
+		for {
+			blk, err := br.Next()
+			if err == io.EOF {
+				return cids
+			}
+			require.NoError(t, err)
+			cids = append(cids, blk.Cid())
+		}
+	}
+
+	// The root and the leaves of the ranges.
+	require.Len(t, carBlocks(t, "entity-bytes=0:255,1024:1279"), 3)
+	require.Len(t, carBlocks(t, "entity-bytes=1024:1279&entity-bytes=-256:*"), 3)
+
+	// Blocks needed by several ranges are sent once.
+	overlapping := carBlocks(t, "entity-bytes=0:300,200:511,0:10")
+	require.Len(t, overlapping, 3)
+	require.ElementsMatch(t, overlapping, carBlocks(t, "entity-bytes=0:511"))
+
+	t.Run("Backends without WithCarRanges refuse several ranges", func(t *testing.T) {
+		ts := newTestServer(t, struct{ IPFSBackend }{backend})
+		get := func(query string) int {
+			res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+nd.Cid().String()+"?format=car&dag-scope=entity&"+query, nil))
+			defer res.Body.Close()
+			return res.StatusCode
+		}
+		require.Equal(t, http.StatusBadRequest, get("entity-bytes=0:255,1024:1279"))
+		require.Equal(t, http.StatusOK, get("entity-bytes=0:255"))
+	})
+}
@@ -0,0 +1,341 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// defaultIPNSWatchInterval is the interval between the resolutions of
+	// watched IPNS names when [Config.IPNSWatchInterval] is not set.
+	defaultIPNSWatchInterval = time.Minute
+
+	// ipnsEventsKeepAlive is the interval between the comments sent to keep
+	// idle event streams open through proxies.
+	ipnsEventsKeepAlive = 30 * time.Second
+
+	// ipnsWatchResolveTimeout bounds the resolutions of watched IPNS names.
+	ipnsWatchResolveTimeout = 30 * time.Second
+
+	// defaultMaxIPNSWatchedNames is the number of IPNS names which can be
+	// watched when [Config.MaxIPNSWatchedNames] is not set.
+	defaultMaxIPNSWatchedNames = 1000
+
+	// defaultMaxIPNSEventStreamsPerClient is the number of streams of a
+	// client when [Config.MaxIPNSEventStreamsPerClient] is not set.
+	defaultMaxIPNSEventStreamsPerClient = 16
+)
+
+var (
+	errTooManyIPNSWatchedNames = errors.New("too many IPNS names are watched, try again later")
+	errTooManyIPNSEventStreams = errors.New("too many IPNS event streams are open by this client")
+)
+
+// ipnsUpdate is the event sent to the clients watching an IPNS name when a
+// record with a higher sequence number is found:
+//
+//	id: 42
+//	event: update
+//	data: {"name": "k51...", "value": "/ipfs/bafy...", "sequence": 42, "validity": "2024-01-01T00:00:00Z", "ttl": 300, "record": "CpQBL2lw..."}
+//
+// The ttl is in seconds and the record is the signed IPNS record, base64
+// encoded, which clients can verify.
+type ipnsUpdate struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Sequence uint64    `json:"sequence"`
+	Validity time.Time `json:"validity"`
+	TTL      int64     `json:"ttl,omitempty"`
+	Record   []byte    `json:"record"`
+}
+
+// serveIpnsEvents serves a stream of Server-Sent Events with the updates of
+// the IPNS name of rq. The stream starts with the current record, unless the
+// Last-Event-ID header has its sequence number or a higher one.
+func (i *handler) serveIpnsEvents(ctx context.Context, w http.ResponseWriter, r *http.Request, rq *requestData) bool {
+	ctx, span := spanTrace(ctx, "Handler.ServeIPNSEvents", trace.WithAttributes(attribute.String("path", rq.contentPath.String())))
+	defer span.End()
+
+	segments := rq.contentPath.Segments()
+	if rq.contentPath.Namespace() != path.IPNSNamespace || len(segments) != 2 {
+		err := fmt.Errorf("%s is not an IPNS name", rq.contentPath.String())
+		i.webError(w, r, err, http.StatusBadRequest)
+		return false
+	}
+	name, err := ipns.NameFromString(segments[1])
+	if err != nil {
+		err = fmt.Errorf("only IPNS names with signed records can be watched: %w", err)
+		i.webError(w, r, err, http.StatusBadRequest)
+		return false
+	}
+
+	var lastSequence uint64
+	hasLastSequence := false
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if lastSequence, err = strconv.ParseUint(id, 10, 64); err != nil {
+			i.webError(w, r, fmt.Errorf("invalid Last-Event-ID: %w", err), http.StatusBadRequest)
+			return false
+		}
+		hasLastSequence = true
+	}
+
+	// The current record is fetched before the response starts so that
+	// resolution errors get a status code.
+	current, err := i.ipnsWatchers.fetch(ctx, name)
+	if err != nil {
+		i.webError(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+
+	var updates <-chan *ipnsUpdate
+	if r.Method != http.MethodHead {
+		var unsubscribe func()
+		updates, unsubscribe, err = i.ipnsWatchers.subscribe(name, i.clientKey(r), current)
+		if err != nil {
+			status := http.StatusServiceUnavailable
+			if errors.Is(err, errTooManyIPNSEventStreams) {
+				status = http.StatusTooManyRequests
+			}
+			i.webError(w, r, err, status)
+			return false
+		}
+		defer unsubscribe()
+	}
+
+	w.Header().Set("Content-Type", eventStreamResponseFormat)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	rc := http.NewResponseController(w)
+	send := func(u *ipnsUpdate) error {
+		if hasLastSequence && u.Sequence <= lastSequence {
+			return nil
+		}
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: update\ndata: %s\n\n", u.Sequence, data); err != nil {
+			return err
+		}
+		lastSequence, hasLastSequence = u.Sequence, true
+		return rc.Flush()
+	}
+
+	// Headers are flushed right away, as the first event may only come with
+	// the next update for resuming clients.
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return false
+	}
+	if err := send(current); err != nil {
+		return false
+	}
+
+	keepAlive := time.NewTicker(ipnsEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case u := <-updates:
+			if err := send(u); err != nil {
+				return false
+			}
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return false
+			}
+			if err := rc.Flush(); err != nil {
+				return false
+			}
+		}
+	}
+}
+
+// fetchIpnsUpdate fetches and validates the current record of name.
+func fetchIpnsUpdate(ctx context.Context, backend IPFSBackend, name ipns.Name) (*ipnsUpdate, error) {
+	raw, err := backend.GetIPNSRecord(ctx, name.Cid())
+	if err != nil {
+		return nil, err
+	}
+	rec, err := ipns.UnmarshalRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := ipns.ValidateWithName(rec, name); err != nil {
+		return nil, err
+	}
+	value, err := rec.Value()
+	if err != nil {
+		return nil, err
+	}
+	seq, err := rec.Sequence()
+	if err != nil {
+		return nil, err
+	}
+	validity, err := rec.Validity()
+	if err != nil {
+		return nil, err
+	}
+	u := &ipnsUpdate{
+		Name:     name.String(),
+		Value:    value.String(),
+		Sequence: seq,
+		Validity: validity.UTC(),
+		Record:   raw,
+	}
+	if ttl, err := rec.TTL(); err == nil {
+		u.TTL = int64(ttl.Seconds())
+	}
+	return u, nil
+}
+
+// ipnsWatchers resolves the IPNS names watched by event streams periodically,
+// once for all the streams watching the same name.
+type ipnsWatchers struct {
+	backend             IPFSBackend
+	interval            time.Duration
+	maxNames            int
+	maxStreamsPerClient int
+
+	mu       sync.Mutex
+	watchers map[string]*ipnsWatcher
+	// clients is the number of streams of each client.
+	clients map[string]int
+}
+
+type ipnsWatcher struct {
+	last   *ipnsUpdate
+	subs   map[chan *ipnsUpdate]struct{}
+	cancel context.CancelFunc
+}
+
+func newIPNSWatchers(backend IPFSBackend, interval time.Duration, maxNames, maxStreamsPerClient int) *ipnsWatchers {
+	if interval <= 0 {
+		interval = defaultIPNSWatchInterval
+	}
+	if maxNames <= 0 {
+		maxNames = defaultMaxIPNSWatchedNames
+	}
+	if maxStreamsPerClient <= 0 {
+		maxStreamsPerClient = defaultMaxIPNSEventStreamsPerClient
+	}
+	return &ipnsWatchers{
+		backend:             backend,
+		interval:            interval,
+		maxNames:            maxNames,
+		maxStreamsPerClient: maxStreamsPerClient,
+		watchers:            map[string]*ipnsWatcher{},
+		clients:             map[string]int{},
+	}
+}
+
+// fetch fetches the current record of name, giving up after
+// ipnsWatchResolveTimeout.
+func (ws *ipnsWatchers) fetch(ctx context.Context, name ipns.Name) (*ipnsUpdate, error) {
+	ctx, cancel := context.WithTimeout(ctx, ipnsWatchResolveTimeout)
+	defer cancel()
+	return fetchIpnsUpdate(ctx, ws.backend, name)
+}
+
+// subscribe returns a channel receiving the updates of name with a higher
+// sequence number than current, and a function to stop receiving them. It
+// fails when client has too many streams, or too many names are watched.
+func (ws *ipnsWatchers) subscribe(name ipns.Name, client string, current *ipnsUpdate) (<-chan *ipnsUpdate, func(), error) {
+	key := name.String()
+	ch := make(chan *ipnsUpdate, 1)
+
+	ws.mu.Lock()
+	if ws.clients[client] >= ws.maxStreamsPerClient {
+		ws.mu.Unlock()
+		return nil, nil, errTooManyIPNSEventStreams
+	}
+	w, ok := ws.watchers[key]
+	if !ok {
+		if len(ws.watchers) >= ws.maxNames {
+			ws.mu.Unlock()
+			return nil, nil, errTooManyIPNSWatchedNames
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		w = &ipnsWatcher{subs: map[chan *ipnsUpdate]struct{}{}, cancel: cancel}
+		ws.watchers[key] = w
+		go ws.watch(ctx, name, w)
+	}
+	ws.clients[client]++
+	w.subs[ch] = struct{}{}
+	if !w.publish(current) && w.last.Sequence > current.Sequence {
+		ch <- w.last
+	}
+	ws.mu.Unlock()
+
+	return ch, func() {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		delete(w.subs, ch)
+		if len(w.subs) == 0 {
+			w.cancel()
+			delete(ws.watchers, key)
+		}
+		if ws.clients[client]--; ws.clients[client] == 0 {
+			delete(ws.clients, client)
+		}
+	}, nil
+}
+
+func (ws *ipnsWatchers) watch(ctx context.Context, name ipns.Name, w *ipnsWatcher) {
+	ticker := time.NewTicker(ws.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		u, err := ws.fetch(ctx, name)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Debugw("failed to resolve watched IPNS name", "name", name, "error", err)
+			}
+			continue
+		}
+
+		ws.mu.Lock()
+		w.publish(u)
+		ws.mu.Unlock()
+	}
+}
+
+// publish sends u to the subscribers when its sequence number is higher than
+// that of the last update, and returns whether it did. The lock of the
+// watchers must be held.
+func (w *ipnsWatcher) publish(u *ipnsUpdate) bool {
+	if w.last != nil && u.Sequence <= w.last.Sequence {
+		return false
+	}
+	w.last = u
+	for ch := range w.subs {
+		// Slow subscribers only get the latest update.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- u
+	}
+	return true
+}
@@ -0,0 +1,234 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// recordBackend serves the IPNS records published with publish.
+type recordBackend struct {
+	IPFSBackend
+
+	mu      sync.Mutex
+	records map[cid.Cid][]byte
+}
+
+func (b *recordBackend) GetIPNSRecord(ctx context.Context, c cid.Cid) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rec, ok := b.records[c]; ok {
+		return rec, nil
+	}
+	return nil, NewErrorStatusCode(errors.New("record not found"), http.StatusNotFound)
+}
+
+func (b *recordBackend) publish(t *testing.T, sk crypto.PrivKey, p path.Path, seq uint64) {
+	rec, err := ipns.NewRecord(sk, p, seq, time.Now().Add(time.Hour), time.Minute)
+	require.NoError(t, err)
+	raw, err := ipns.MarshalRecord(rec)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.records[ipns.NameFromPeer(pid).Cid()] = raw
+}
+
+func TestIpnsEvents(t *testing.T) {
+	t.Parallel()
+
+	mock, root := newMockBackend(t, "fixtures.car")
+	backend := &recordBackend{IPFSBackend: mock, records: map[cid.Cid][]byte{}}
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		IPNSWatchInterval:     10 * time.Millisecond,
+	})
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+	backend.publish(t, sk, path.FromCid(root), 1)
+
+	type event struct {
+		id     string
+		update ipnsUpdate
+	}
+	subscribe := func(t *testing.T, lastEventID string) (<-chan event, *http.Response) {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+"/ipns/"+name.String(), nil)
+		req.Header.Set("Accept", "text/event-stream")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		res := mustDoWithoutRedirect(t, req.WithContext(ctx))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		events := make(chan event, 8)
+		go func() {
+			defer close(events)
+			var ev event
+			s := bufio.NewScanner(res.Body)
+			for s.Scan() {
+				switch line := s.Text(); {
+				case strings.HasPrefix(line, "id: "):
+					ev.id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "data: "):
+					if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.update) != nil {
+						return
+					}
+				case line == "" && ev.id != "":
+					events <- ev
+					ev = event{}
+				}
+			}
+		}()
+		return events, res
+	}
+	next := func(t *testing.T, events <-chan event) event {
+		select {
+		case ev, ok := <-events:
+			require.True(t, ok, "event stream closed")
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return event{}
+		}
+	}
+
+	events, _ := subscribe(t, "")
+	ev := next(t, events)
+	require.Equal(t, "1", ev.id)
+	require.Equal(t, name.String(), ev.update.Name)
+	require.Equal(t, "/ipfs/"+root.String(), ev.update.Value)
+	require.Equal(t, uint64(1), ev.update.Sequence)
+	require.Equal(t, int64(60), ev.update.TTL)
+	rec, err := ipns.UnmarshalRecord(ev.update.Record)
+	require.NoError(t, err)
+	require.NoError(t, ipns.ValidateWithName(rec, name))
+
+	// Resuming streams only receive newer records.
+	resumed, _ := subscribe(t, "1")
+
+	backend.publish(t, sk, path.FromCid(root), 2)
+	require.Equal(t, "2", next(t, events).id)
+	require.Equal(t, "2", next(t, resumed).id)
+
+	// Records with a lower sequence number are ignored.
+	backend.publish(t, sk, path.FromCid(root), 1)
+	backend.publish(t, sk, path.FromCid(root), 3)
+	require.Equal(t, "3", next(t, events).id)
+
+	t.Run("Only IPNS names with records", func(t *testing.T) {
+		for _, p := range []string{"/ipns/example.com", "/ipns/" + name.String() + "/sub", "/ipfs/" + root.String()} {
+			req := mustNewRequest(t, http.MethodGet, ts.URL+p, nil)
+			req.Header.Set("Accept", "text/event-stream")
+			res := mustDoWithoutRedirect(t, req)
+			require.Equal(t, http.StatusBadRequest, res.StatusCode, p)
+		}
+	})
+}
+
+func TestIpnsWatchers(t *testing.T) {
+	t.Parallel()
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+
+	ws := newIPNSWatchers(&recordBackend{records: map[cid.Cid][]byte{}}, time.Hour, 1, 2)
+	updates1, unsubscribe1, err := ws.subscribe(name, "a", &ipnsUpdate{Sequence: 1})
+	require.NoError(t, err)
+	_, unsubscribe2, err := ws.subscribe(name, "a", &ipnsUpdate{Sequence: 2})
+	require.NoError(t, err)
+	require.Len(t, ws.watchers, 1)
+
+	// Newer records of subscribers are sent to the other subscribers.
+	require.Equal(t, uint64(2), (<-updates1).Sequence)
+
+	// Clients have a limited number of streams.
+	_, _, err = ws.subscribe(name, "a", &ipnsUpdate{Sequence: 1})
+	require.ErrorIs(t, err, errTooManyIPNSEventStreams)
+
+	// Late subscribers with an older record receive the latest one.
+	updates3, unsubscribe3, err := ws.subscribe(name, "b", &ipnsUpdate{Sequence: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), (<-updates3).Sequence)
+
+	// A limited number of names is watched.
+	sk2, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid2, err := peer.IDFromPrivateKey(sk2)
+	require.NoError(t, err)
+	_, _, err = ws.subscribe(ipns.NameFromPeer(pid2), "c", &ipnsUpdate{Sequence: 1})
+	require.ErrorIs(t, err, errTooManyIPNSWatchedNames)
+
+	unsubscribe1()
+	unsubscribe2()
+	require.Len(t, ws.watchers, 1)
+	require.NotContains(t, ws.clients, "a")
+	unsubscribe3()
+	require.Empty(t, ws.watchers)
+	require.Empty(t, ws.clients)
+
+	_, unsubscribe4, err := ws.subscribe(ipns.NameFromPeer(pid2), "c", &ipnsUpdate{Sequence: 1})
+	require.NoError(t, err)
+	unsubscribe4()
+}
+
+func TestIpnsEventsStreamsPerClient(t *testing.T) {
+	t.Parallel()
+
+	mock, root := newMockBackend(t, "fixtures.car")
+	backend := &recordBackend{IPFSBackend: mock, records: map[cid.Cid][]byte{}}
+	// All the requests come from the same address, as behind a proxy.
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses:        true,
+		MaxIPNSEventStreamsPerClient: 1,
+		ClientKey: func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		},
+	})
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+	backend.publish(t, sk, path.FromCid(root), 1)
+
+	subscribe := func(client string) int {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+"/ipns/"+name.String(), nil)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("X-Client", client)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		res := mustDoWithoutRedirect(t, req.WithContext(ctx))
+		return res.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, subscribe("a"))
+	require.Equal(t, http.StatusTooManyRequests, subscribe("a"))
+	require.Equal(t, http.StatusOK, subscribe("b"))
+}
@@ -3,6 +3,7 @@ package gateway
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -13,8 +14,28 @@ import (
 
 var unixEpochTime = time.Unix(0, 0)
 
+// archiveWriter is implemented by the writers of the archive response formats.
+type archiveWriter interface {
+	WriteFile(nd files.Node, fpath string) error
+	Close() error
+}
+
+// archiveFormats maps the archive response formats to the file extension and
+// writer constructor of their archives.
+var archiveFormats = map[string]struct {
+	extension string
+	newWriter func(io.Writer) (archiveWriter, error)
+}{
+	tarResponseFormat:     {".tar", func(w io.Writer) (archiveWriter, error) { return files.NewTarWriter(w) }},
+	tarGzipResponseFormat: {".tar.gz", func(w io.Writer) (archiveWriter, error) { return files.NewGzipTarWriter(w) }},
+	tarZstdResponseFormat: {".tar.zst", func(w io.Writer) (archiveWriter, error) { return files.NewZstdTarWriter(w) }},
+	zipResponseFormat:     {".zip", func(w io.Writer) (archiveWriter, error) { return files.NewZipWriter(w) }},
+}
+
+// serveTAR streams UnixFS files and directories as an archive in the response
+// format: TAR, compressed TAR or ZIP.
 func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.Request, rq *requestData) bool {
-	ctx, span := spanTrace(ctx, "Handler.ServeTAR", trace.WithAttributes(attribute.String("path", rq.immutablePath.String())))
+	ctx, span := spanTrace(ctx, "Handler.ServeTAR", trace.WithAttributes(attribute.String("path", rq.immutablePath.String()), attribute.String("format", rq.responseFormat)))
 	defer span.End()
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -31,21 +52,22 @@ func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.R
 	rootCid := pathMetadata.LastSegment.RootCid()
 
 	// Set Cache-Control and read optional Last-Modified time
-	modtime := addCacheControlHeaders(w, r, rq.contentPath, rq.ttl, rq.lastMod, rootCid, tarResponseFormat)
+	modtime := addCacheControlHeaders(w, r, rq.contentPath, rq.ttl, rq.lastMod, rootCid, rq.responseFormat)
+	archiveFormat := archiveFormats[rq.responseFormat]
 
 	// Set Content-Disposition
 	var name string
 	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
 		name = urlFilename
 	} else {
-		name = rootCid.String() + ".tar"
+		name = rootCid.String() + archiveFormat.extension
 	}
 	setContentDispositionHeader(w, name, "attachment")
 
-	// Construct the TAR writer
-	tarw, err := files.NewTarWriter(w)
+	// Construct the archive writer
+	tarw, err := archiveFormat.newWriter(w)
 	if err != nil {
-		i.webError(w, r, fmt.Errorf("could not build tar writer: %w", err), http.StatusInternalServerError)
+		i.webError(w, r, fmt.Errorf("could not build archive writer: %w", err), http.StatusInternalServerError)
 		return false
 	}
 	defer tarw.Close()
@@ -57,10 +79,10 @@ func (i *handler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.R
 		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
 	}
 
-	w.Header().Set("Content-Type", tarResponseFormat)
+	w.Header().Set("Content-Type", rq.responseFormat)
 	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
 
-	// The TAR has a top-level directory (or file) named by the CID.
+	// The archive has a top-level directory (or file) named by the CID.
 	if err := tarw.WriteFile(file, rootCid.String()); err != nil {
 		// Update fail metric
 		i.tarStreamFailMetric.WithLabelValues(rq.contentPath.Namespace()).Observe(time.Since(rq.begin).Seconds())
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveResponseFormats(t *testing.T) {
+	ts, _, root := newTestServerAndNode(t, "unixfs-dir-with-mode-mtime.car")
+	file := root.String() + "/dir3/file2"
+
+	tarNames := func(t *testing.T, r io.Reader) []string {
+		var names []string
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return names
+			}
+			require.NoError(t, err)
+			names = append(names, hdr.Name)
+		}
+	}
+
+	for _, tc := range []struct {
+		format      string
+		contentType string
+		extension   string
+		names       func(t *testing.T, body []byte) []string
+	}{
+		{"tar", tarResponseFormat, ".tar", func(t *testing.T, body []byte) []string {
+			return tarNames(t, bytes.NewReader(body))
+		}},
+		{"tar.gz", tarGzipResponseFormat, ".tar.gz", func(t *testing.T, body []byte) []string {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			require.NoError(t, err)
+			return tarNames(t, zr)
+		}},
+		{"tar.zst", tarZstdResponseFormat, ".tar.zst", func(t *testing.T, body []byte) []string {
+			zr, err := zstd.NewReader(bytes.NewReader(body))
+			require.NoError(t, err)
+			defer zr.Close()
+			return tarNames(t, zr)
+		}},
+		{"zip", zipResponseFormat, ".zip", func(t *testing.T, body []byte) []string {
+			zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+			require.NoError(t, err)
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+				if f.Name == file {
+					// UnixFS mode and mtime are preserved.
+					require.Equal(t, os.FileMode(0o644), f.Mode())
+					require.Equal(t, time.Date(2022, 6, 13, 22, 18, 12, 0, time.UTC), f.Modified.UTC())
+				}
+			}
+			return names
+		}},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			test := func(t *testing.T, req *http.Request) {
+				res := mustDoWithoutRedirect(t, req)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.Equal(t, tc.contentType, res.Header.Get("Content-Type"))
+				require.Contains(t, res.Header.Get("Content-Disposition"), root.String()+tc.extension)
+				body, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				require.Contains(t, tc.names(t, body), file)
+			}
+
+			t.Run("Format parameter", func(t *testing.T) {
+				test(t, mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String()+"?format="+tc.format, nil))
+			})
+
+			t.Run("Accept header", func(t *testing.T) {
+				req := mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String(), nil)
+				req.Header.Set("Accept", tc.contentType)
+				test(t, req)
+			})
+		})
+	}
+}
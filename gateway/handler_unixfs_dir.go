@@ -26,6 +26,12 @@ func (i *handler) serveDirectory(ctx context.Context, w http.ResponseWriter, r *
 	ctx, span := spanTrace(ctx, "Handler.ServeDirectory", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
 	defer span.End()
 
+	// Machine-readable listings do not depend on the URL and never serve
+	// index.html.
+	if rq.responseFormat == jsonResponseFormat || rq.responseFormat == cborResponseFormat {
+		return i.serveDirectoryListing(ctx, w, r, resolvedPath, rq, directoryMetadata)
+	}
+
 	// WithHostname might have constructed an IPNS/IPFS path using the Host header.
 	// In this case, we need the original path for constructing redirects and links
 	// that match the requested URL.
@@ -143,14 +149,7 @@ func (i *handler) serveDirectory(ctx context.Context, w http.ResponseWriter, r *
 	w.Header().Set("Etag", dirEtag)
 
 	// Set Cache-Control
-	if rq.ttl > 0 {
-		// Use known TTL from IPNS Record or DNSLink TXT Record
-		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=2678400", int(rq.ttl.Seconds())))
-	} else if !rq.contentPath.Mutable() {
-		// Cache for 1 week, serve stale cache for up to a month
-		// (style of generated HTML may change, should not be cached forever)
-		w.Header().Set("Cache-Control", "public, max-age=604800, stale-while-revalidate=2678400")
-	}
+	setDirListingCacheControl(w, rq)
 
 	if r.Method == http.MethodHead {
 		rq.logger.Debug("return as request's HTTP method is HEAD")
@@ -230,6 +229,19 @@ func (i *handler) serveDirectory(ctx context.Context, w http.ResponseWriter, r *
 	return true
 }
 
+// setDirListingCacheControl sets the Cache-Control header of generated
+// directory listings.
+func setDirListingCacheControl(w http.ResponseWriter, rq *requestData) {
+	if rq.ttl > 0 {
+		// Use known TTL from IPNS Record or DNSLink TXT Record
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=2678400", int(rq.ttl.Seconds())))
+	} else if !rq.contentPath.Mutable() {
+		// Cache for 1 week, serve stale cache for up to a month
+		// (style of generated listings may change, should not be cached forever)
+		w.Header().Set("Cache-Control", "public, max-age=604800, stale-while-revalidate=2678400")
+	}
+}
+
 func getDirListingEtag(dirCid cid.Cid) string {
 	return `"DirIndex-` + assets.AssetHash + `_CID-` + dirCid.String() + `"`
 }
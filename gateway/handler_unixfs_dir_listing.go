@@ -0,0 +1,297 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	pb "github.com/ipfs/boxo/ipld/unixfs/pb"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mc "github.com/multiformats/go-multicodec"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// defaultDirListingLimit is the number of entries of a directory listing
+	// page when the limit query parameter is not set.
+	defaultDirListingLimit = 1000
+	// maxDirListingLimit is the largest accepted limit query parameter.
+	maxDirListingLimit = 10000
+	// dirListingConcurrency is the number of entries whose metadata is
+	// fetched concurrently.
+	dirListingConcurrency = 16
+)
+
+// errDirListingCursorNotFound is returned when the cursor of a directory
+// listing request is not the name of an entry of the directory.
+var errDirListingCursorNotFound = errors.New("cursor is not an entry of the directory")
+
+// dirListingCursorKey is the context key of the cursor of a directory listing
+// request, see withDirListingCursor.
+type dirListingCursorKey struct{}
+
+// withDirListingCursor asks the backend to list the entries of directories in
+// a stable order, starting after the cursor of r. [BlocksBackend] then only
+// fetches the HAMT shards holding the entries of the requested page, and marks
+// the listing with [directoryMetadata.afterCursor].
+func withDirListingCursor(ctx context.Context, r *http.Request) context.Context {
+	cursor, _, err := dirListingParams(r)
+	if err != nil {
+		// Refused by serveDirectoryListing.
+		return ctx
+	}
+	return context.WithValue(ctx, dirListingCursorKey{}, cursor)
+}
+
+// dirListingEntry describes an entry of a directory listing.
+type dirListingEntry struct {
+	name  string
+	cid   cid.Cid
+	size  uint64
+	typ   string
+	mode  uint32
+	mtime time.Time
+}
+
+// serveDirectoryListing serves a page of the listing of a UnixFS directory in
+// the JSON or CBOR response format:
+//
+//	{
+//	  "cid": "bafy...",
+//	  "size": 1234,
+//	  "entries": [
+//	    {"name": "a.txt", "cid": "bafk...", "size": 5, "type": "file", "mode": 420, "mtime": "2022-06-13T22:17:22Z"}
+//	  ],
+//	  "next": "YS50eHQ"
+//	}
+//
+// The size of files is their content size, and the cumulative size of their
+// DAG for other entries. Mode and mtime are only set when they are present in
+// the UnixFS metadata of the entry. Pages have up to ?limit= entries, next is
+// present when there are more entries and can be passed as ?cursor= to fetch
+// the next page. A cursor which is not an entry of the directory is refused
+// with HTTP 400.
+func (i *handler) serveDirectoryListing(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath path.ImmutablePath, rq *requestData, directoryMetadata *directoryMetadata) bool {
+	ctx, span := spanTrace(ctx, "Handler.ServeDirectoryListing")
+	defer span.End()
+
+	cursor, limit, err := dirListingParams(r)
+	if err != nil {
+		i.webError(w, r, err, http.StatusBadRequest)
+		return false
+	}
+
+	w.Header().Set("Content-Type", rq.responseFormat)
+	w.Header().Set("Etag", getDirListingDataEtag(r, resolvedPath.RootCid(), rq.responseFormat))
+	setDirListingCacheControl(w, rq)
+	addContentLocation(r, w, rq)
+
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	// Skip the entries up to the cursor, unless the backend listed the
+	// entries after it, then take a page and check whether there are more.
+	var (
+		page    []dirListingEntry
+		hasMore bool
+	)
+	skipping := cursor != "" && !directoryMetadata.afterCursor
+	for l := range directoryMetadata.entries {
+		if errors.Is(l.Err, errDirListingCursorNotFound) {
+			i.webError(w, r, l.Err, http.StatusBadRequest)
+			return false
+		}
+		if l.Err != nil {
+			i.webError(w, r, l.Err, http.StatusInternalServerError)
+			return false
+		}
+		if skipping {
+			skipping = l.Link.Name != cursor
+			continue
+		}
+		if len(page) == limit {
+			hasMore = true
+			break
+		}
+		page = append(page, dirListingEntry{name: l.Link.Name, cid: l.Link.Cid, size: l.Link.Size})
+	}
+	if skipping {
+		i.webError(w, r, errDirListingCursorNotFound, http.StatusBadRequest)
+		return false
+	}
+
+	if err := fetchDirListingMetadata(ctx, i.backend, page); err != nil {
+		i.webError(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+
+	var next string
+	if hasMore {
+		next = base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1].name))
+	}
+	nd, err := buildDirListingNode(resolvedPath.RootCid(), directoryMetadata.dagSize, page, next)
+	if err != nil {
+		i.webError(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+
+	codec := mc.Json
+	if rq.responseFormat == cborResponseFormat {
+		codec = mc.Cbor
+	}
+	encoder, err := multicodec.LookupEncoder(uint64(codec))
+	if err != nil {
+		i.webError(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+	if err := encoder(nd, w); err != nil {
+		_, _ = w.Write([]byte(fmt.Sprintf("error during body generation: %v", err)))
+		return false
+	}
+
+	i.unixfsGenDirListingGetMetric.WithLabelValues(rq.contentPath.Namespace()).Observe(time.Since(rq.begin).Seconds())
+	return true
+}
+
+// dirListingParams returns the name of the entry after which the listing
+// starts, and the number of entries to list.
+func dirListingParams(r *http.Request) (string, int, error) {
+	query := r.URL.Query()
+
+	var cursor string
+	if c := query.Get("cursor"); c != "" {
+		name, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid cursor: %w", err)
+		}
+		cursor = string(name)
+	}
+
+	limit := defaultDirListingLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxDirListingLimit {
+			return "", 0, fmt.Errorf("invalid limit %q, must be between 1 and %d", l, maxDirListingLimit)
+		}
+	}
+	return cursor, limit, nil
+}
+
+// fetchDirListingMetadata sets the type, mode, mtime and file size of the
+// entries from their root block. Raw blocks are files and are not fetched.
+func fetchDirListingMetadata(ctx context.Context, backend IPFSBackend, entries []dirListingEntry) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(dirListingConcurrency)
+	for n := range entries {
+		e := &entries[n]
+		g.Go(func() error {
+			return fetchEntryMetadata(ctx, backend, e)
+		})
+	}
+	return g.Wait()
+}
+
+// fetchEntryMetadata sets the type, mode, mtime and file size of the entry
+// from its root block.
+func fetchEntryMetadata(ctx context.Context, backend IPFSBackend, e *dirListingEntry) error {
+	switch e.cid.Prefix().Codec {
+	case cid.Raw:
+		e.typ = "file"
+		return nil
+	case cid.DagProtobuf:
+	default:
+		e.typ = "unknown"
+		return nil
+	}
+
+	_, f, err := backend.GetBlock(ctx, path.FromCid(e.cid))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	pn, err := merkledag.DecodeProtobuf(data)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", e.cid, err)
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", e.cid, err)
+	}
+
+	switch fsn.Type() {
+	case pb.Data_File, pb.Data_Raw:
+		e.typ = "file"
+		e.size = fsn.FileSize()
+	case pb.Data_Directory, pb.Data_HAMTShard:
+		e.typ = "directory"
+	case pb.Data_Symlink:
+		e.typ = "symlink"
+		e.size = uint64(len(fsn.Data()))
+	default:
+		e.typ = "unknown"
+	}
+	if mode := fsn.Mode(); mode != 0 {
+		e.mode = files.ModePermsToUnixPerms(mode)
+	}
+	e.mtime = fsn.ModTime()
+	return nil
+}
+
+func buildDirListingNode(dirCid cid.Cid, dagSize uint64, entries []dirListingEntry, next string) (datamodel.Node, error) {
+	return qp.BuildMap(basicnode.Prototype.Any, -1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "cid", qp.String(dirCid.String()))
+		qp.MapEntry(ma, "size", qp.Int(int64(dagSize)))
+		qp.MapEntry(ma, "entries", qp.List(int64(len(entries)), func(la datamodel.ListAssembler) {
+			for _, e := range entries {
+				qp.ListEntry(la, qp.Map(-1, func(ma datamodel.MapAssembler) {
+					qp.MapEntry(ma, "name", qp.String(e.name))
+					qp.MapEntry(ma, "cid", qp.String(e.cid.String()))
+					qp.MapEntry(ma, "size", qp.Int(int64(e.size)))
+					qp.MapEntry(ma, "type", qp.String(e.typ))
+					if e.mode != 0 {
+						qp.MapEntry(ma, "mode", qp.Int(int64(e.mode)))
+					}
+					if !e.mtime.IsZero() {
+						qp.MapEntry(ma, "mtime", qp.String(e.mtime.UTC().Format(time.RFC3339Nano)))
+					}
+				}))
+			}
+		}))
+		if next != "" {
+			qp.MapEntry(ma, "next", qp.String(next))
+		}
+	})
+}
+
+// getDirListingDataEtag returns the Etag of a JSON or CBOR directory listing
+// page, which depends on the pagination parameters.
+func getDirListingDataEtag(r *http.Request, dirCid cid.Cid, responseFormat string) string {
+	query := r.URL.Query()
+	etag := `"DirListing_CID-` + dirCid.String() + "." + responseFormatToFormatParam[responseFormat]
+	if cursor, limit := query.Get("cursor"), query.Get("limit"); cursor != "" || limit != "" {
+		h := xxhash.New()
+		_, _ = h.WriteString("cursor=" + cursor + "\x00limit=" + limit)
+		etag += "." + strconv.FormatUint(h.Sum64(), 32)
+	}
+	return etag + `"`
+}
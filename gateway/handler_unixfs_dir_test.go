@@ -2,11 +2,15 @@ package gateway
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"io"
 	"net/http"
 	"testing"
 
 	"github.com/ipfs/boxo/path"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/stretchr/testify/require"
 )
 
@@ -88,3 +92,131 @@ func TestIPNSHostnameBacklinks(t *testing.T) {
 	require.Contains(t, s, "<a href=\"/foo%3F%20%23%3C%27/bar/file.txt\">", "expected file in directory listing")
 	require.Contains(t, s, k3.RootCid().String(), "expected hash in directory listing")
 }
+
+func TestDirectoryListingData(t *testing.T) {
+	ts, _, root := newTestServerAndNode(t, "unixfs-dir-with-mode-mtime.car")
+
+	type entry struct {
+		Name  string `json:"name"`
+		Cid   string `json:"cid"`
+		Size  uint64 `json:"size"`
+		Type  string `json:"type"`
+		Mode  uint32 `json:"mode"`
+		Mtime string `json:"mtime"`
+	}
+	type listing struct {
+		Cid     string  `json:"cid"`
+		Entries []entry `json:"entries"`
+		Next    string  `json:"next"`
+	}
+	list := func(t *testing.T, query string) listing {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String()+"/"+query, nil)
+		req.Header.Set("Accept", jsonResponseFormat)
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, jsonResponseFormat, res.Header.Get("Content-Type"))
+		require.NotEmpty(t, res.Header.Get("Etag"))
+		var l listing
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&l))
+		require.Equal(t, root.String(), l.Cid)
+		return l
+	}
+
+	all := list(t, "")
+	require.Empty(t, all.Next)
+	require.Len(t, all.Entries, 4)
+	require.Equal(t, entry{
+		Name:  "dir1",
+		Cid:   all.Entries[0].Cid,
+		Size:  all.Entries[0].Size,
+		Type:  "directory",
+		Mode:  0o755,
+		Mtime: "2022-06-13T22:18:42Z",
+	}, all.Entries[0])
+	require.Equal(t, "file1", all.Entries[3].Name)
+	require.Equal(t, "file", all.Entries[3].Type)
+	require.Equal(t, uint32(0o644), all.Entries[3].Mode)
+
+	// Pages follow each other.
+	var paged []entry
+	query := "?limit=3"
+	for {
+		l := list(t, query)
+		paged = append(paged, l.Entries...)
+		if l.Next == "" {
+			break
+		}
+		query = "?limit=3&cursor=" + l.Next
+	}
+	require.Equal(t, all.Entries, paged)
+
+	t.Run("CBOR", func(t *testing.T) {
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String()+"/?format=cbor&limit=1", nil))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, cborResponseFormat, res.Header.Get("Content-Type"))
+		nb := basicnode.Prototype.Any.NewBuilder()
+		require.NoError(t, dagcbor.Decode(nb, res.Body))
+		entries, err := nb.Build().LookupByString("entries")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), entries.Length())
+	})
+
+	t.Run("Invalid limit", func(t *testing.T) {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String()+"/?format=json&limit=0", nil)
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("Unknown cursor", func(t *testing.T) {
+		cursor := base64.RawURLEncoding.EncodeToString([]byte("missing"))
+		req := mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String()+"/?format=json&cursor="+cursor, nil)
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestDirectoryListingDataHAMT(t *testing.T) {
+	ts, _, root := newTestServerAndNode(t, "directory-with-multilayer-hamt-and-multiblock-files.car")
+
+	type listing struct {
+		Entries []struct {
+			Name string `json:"name"`
+		} `json:"entries"`
+		Next string `json:"next"`
+	}
+	list := func(t *testing.T, query string) (listing, int) {
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String()+"/hamtDir/?format=json"+query, nil))
+		var l listing
+		if res.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&l))
+		}
+		return l, res.StatusCode
+	}
+
+	all, status := list(t, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, all.Next)
+	require.NotEmpty(t, all.Entries)
+
+	// Sharded directories are listed in a stable order, page by page.
+	var paged []string
+	query := "&limit=1"
+	for {
+		l, status := list(t, query)
+		require.Equal(t, http.StatusOK, status)
+		for _, e := range l.Entries {
+			paged = append(paged, e.Name)
+		}
+		if l.Next == "" {
+			break
+		}
+		query = "&limit=1&cursor=" + l.Next
+	}
+	require.Len(t, paged, len(all.Entries))
+	for n, e := range all.Entries {
+		require.Equal(t, e.Name, paged[n])
+	}
+
+	_, status = list(t, "&cursor="+base64.RawURLEncoding.EncodeToString([]byte("missing")))
+	require.Equal(t, http.StatusBadRequest, status)
+}
@@ -4,13 +4,10 @@ import (
 	"bytes"
 	"context"
 	"io"
-	"mime"
 	"net/http"
-	gopath "path"
 	"strings"
 	"time"
 
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/ipfs/boxo/path"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -37,29 +34,23 @@ func (i *handler) serveFile(ctx context.Context, w http.ResponseWriter, r *http.
 		// "most correct" we can be without doing that.
 		ctype = "inode/symlink"
 	} else {
-		ctype = mime.TypeByExtension(gopath.Ext(name))
+		// The beginning of the file is only read when a detector needs it, and
+		// is then put in front of the original reader. Fixes https://github.com/ipfs/kubo/issues/7252
+		var head fileHead
+		if returnRangeStartsAtZero && fileContentType == "" && fileBytes != nil {
+			head.r = fileBytes
+		}
+		ctype = i.contentTypeDetector().DetectContentType(name, head.bytes)
+		if head.err != nil {
+			http.Error(w, "cannot detect content-type: "+head.err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if head.data != nil {
+			content = io.MultiReader(bytes.NewReader(head.data), fileBytes)
+		}
 		if ctype == "" {
 			ctype = fileContentType
 		}
-		if ctype == "" && returnRangeStartsAtZero {
-			// uses https://github.com/gabriel-vasile/mimetype library to determine the content type.
-			// Fixes https://github.com/ipfs/kubo/issues/7252
-
-			// We read from a TeeReader into a buffer and then put the buffer in front of the original reader to
-			// simulate the behavior of being able to read from the start and then seek back to the beginning while
-			// only having a Reader and not a ReadSeeker
-			var buf bytes.Buffer
-			tr := io.TeeReader(fileBytes, &buf)
-
-			mimeType, err := mimetype.DetectReader(tr)
-			if err != nil {
-				http.Error(w, "cannot detect content-type: "+err.Error(), http.StatusInternalServerError)
-				return false
-			}
-
-			ctype = mimeType.String()
-			content = io.MultiReader(&buf, fileBytes)
-		}
 		// Strip the encoding from the HTML Content-Type header and let the
 		// browser figure it out.
 		//
@@ -84,3 +75,39 @@ func (i *handler) serveFile(ctx context.Context, w http.ResponseWriter, r *http.
 
 	return dataSent
 }
+
+// contentTypeDetector returns the detector of the Content-Type of UnixFS files,
+// see [Config.ContentTypes] and [Config.ContentTypeDetector].
+func (i *handler) contentTypeDetector() ContentTypeDetector {
+	detector := i.config.ContentTypeDetector
+	if detector == nil {
+		detector = DefaultContentTypeDetector
+	}
+	if len(i.config.ContentTypes) == 0 {
+		return detector
+	}
+	return ContentTypeChain(ExtensionContentTypes(i.config.ContentTypes), detector)
+}
+
+// fileHead reads the beginning of a file for [ContentTypeDetector]s.
+type fileHead struct {
+	r    io.Reader
+	data []byte
+	err  error
+}
+
+func (h *fileHead) bytes() []byte {
+	if h.r == nil {
+		return nil
+	}
+	if h.data == nil && h.err == nil {
+		buf := make([]byte, ContentTypeSniffLen)
+		n, err := io.ReadFull(h.r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			h.err = err
+			return nil
+		}
+		h.data = buf[:n]
+	}
+	return h.data
+}
@@ -0,0 +1,230 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/boxo/path"
+	redirects "github.com/ipfs/go-ipfs-redirects-file"
+	"github.com/ucarion/urlpath"
+	"golang.org/x/net/http/httpguts"
+)
+
+// A `_headers` file at the root of a UnixFS site sets custom response headers
+// per path, such as Content-Security-Policy, CORS or caching headers, in the
+// format used by Netlify:
+//
+//	# Comment
+//	/path/:placeholder/*
+//	  Header-Name: value
+//
+// Paths are matched like the `from` paths of `_redirects` rules, and the
+// headers of every matching path are set on the response, replacing those set
+// by the gateway. Headers that the gateway relies on for correctness or
+// security, see protectedHeaders, cannot be set. As for `_redirects`, the
+// file is only used when the request has origin isolation.
+
+// maxHeadersFileSize is the maximum size of `_headers` files.
+const maxHeadersFileSize = redirects.MaxFileSizeInBytes
+
+// headersFileCacheSize is the number of sites whose parsed `_headers` rules
+// are cached. Roots are immutable, so cached rules never need invalidation.
+const headersFileCacheSize = 128
+
+// protectedHeaders are the response headers which `_headers` files cannot
+// set, by their canonical name.
+var protectedHeaders = map[string]struct{}{
+	"Accept-Ranges":             {},
+	"Content-Encoding":          {},
+	"Content-Length":            {},
+	"Content-Location":          {},
+	"Content-Range":             {},
+	"Etag":                      {},
+	"Last-Modified":             {},
+	"Location":                  {},
+	"Service-Worker-Allowed":    {},
+	"Set-Cookie":                {},
+	"Strict-Transport-Security": {},
+	"Trailer":                   {},
+	"Transfer-Encoding":         {},
+	"Www-Authenticate":          {},
+	"X-Ipfs-Path":               {},
+	"X-Ipfs-Roots":              {},
+}
+
+type headersRule struct {
+	path    urlpath.Path
+	headers http.Header
+}
+
+// parseHeadersFile parses the rules of a `_headers` file. Protected headers
+// are ignored.
+func parseHeadersFile(r io.Reader) ([]headersRule, error) {
+	limiter := &io.LimitedReader{R: r, N: maxHeadersFileSize + 1}
+	s := bufio.NewScanner(limiter)
+	var (
+		rules  []headersRule
+		lineNo int
+	)
+	for s.Scan() {
+		lineNo++
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Paths start at the beginning of lines, and their headers are
+		// indented below them.
+		if line[0] != ' ' && line[0] != '\t' {
+			if !strings.HasPrefix(trimmed, "/") {
+				return nil, fmt.Errorf("line %d: path must begin with '/'", lineNo)
+			}
+			rules = append(rules, headersRule{
+				path:    urlpath.New(trimHeadersPath(trimmed)),
+				headers: http.Header{},
+			})
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("line %d: header must follow a path", lineNo)
+		}
+		name, value, ok := strings.Cut(trimmed, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("line %d: invalid header %q", lineNo, trimmed)
+		}
+		if _, ok := protectedHeaders[http.CanonicalHeaderKey(name)]; ok {
+			continue
+		}
+		rules[len(rules)-1].headers.Add(name, value)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if limiter.N == 0 {
+		return nil, fmt.Errorf("_headers file size cannot exceed %d bytes", maxHeadersFileSize)
+	}
+	return rules, nil
+}
+
+// trimHeadersPath removes the trailing slash of paths other than the root, so
+// that directories match with or without it.
+func trimHeadersPath(p string) string {
+	if p == "/" {
+		return p
+	}
+	return strings.TrimSuffix(p, "/")
+}
+
+// serveHeadersFileIfPresent returns a writer setting the headers of the rules
+// of the `_headers` file of the site of rq that match the requested path.
+func (i *handler) serveHeadersFileIfPresent(w http.ResponseWriter, r *http.Request, rq *requestData) (http.ResponseWriter, bool) {
+	rules, err := i.getHeadersRules(r.Context(), rq.immutablePath)
+	if err != nil {
+		err = fmt.Errorf("trouble processing _headers file: %w", err)
+		i.webError(w, r, err, http.StatusInternalServerError)
+		return w, false
+	}
+
+	// All paths start with /ip(f|n)s/<root>/, so match the path after that.
+	segments := rq.contentPath.Segments()
+	urlPath := trimHeadersPath("/" + strings.Join(segments[2:], "/"))
+
+	headers := http.Header{}
+	for _, rule := range rules {
+		if _, ok := rule.path.Match(urlPath); !ok {
+			continue
+		}
+		for name, values := range rule.headers {
+			headers[name] = append(headers[name], values...)
+		}
+	}
+	if len(headers) == 0 {
+		return w, true
+	}
+	rq.logger.Debugw("using _headers", "path", rq.contentPath, "headers", len(headers))
+	return &headersResponseWriter{ResponseWriter: w, headers: headers}, true
+}
+
+// getHeadersRules returns the rules of the `_headers` file at the root of p,
+// or none when there is no such file.
+func (i *handler) getHeadersRules(ctx context.Context, p path.ImmutablePath) ([]headersRule, error) {
+	root := p.RootCid()
+	if rules, ok := i.headersFiles.Get(root); ok {
+		return rules, nil
+	}
+
+	headersPath, err := path.Join(path.FromCid(root), "_headers")
+	if err != nil {
+		return nil, err
+	}
+	imHeadersPath, err := path.NewImmutablePath(headersPath)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := i.fetchHeadersRules(ctx, imHeadersPath)
+	if err != nil {
+		return nil, err
+	}
+	i.headersFiles.Add(root, rules)
+	return rules, nil
+}
+
+func (i *handler) fetchHeadersRules(ctx context.Context, headersPath path.ImmutablePath) ([]headersRule, error) {
+	// As for _redirects, path resolution failures mean that there is no
+	// _headers file.
+	_, getResp, err := i.backend.Get(ctx, headersPath)
+	if err != nil {
+		if isErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer getResp.Close()
+
+	if getResp.bytes == nil {
+		return nil, errors.New("_headers is not a file")
+	}
+	rules, err := parseHeadersFile(getResp.bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse _headers: %w", err)
+	}
+	return rules, nil
+}
+
+// headersResponseWriter sets the headers of `_headers` rules on the response,
+// replacing those set by the gateway.
+type headersResponseWriter struct {
+	http.ResponseWriter
+	headers     http.Header
+	wroteHeader bool
+}
+
+func (w *headersResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		for name, values := range w.headers {
+			w.Header()[name] = values
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headersResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headersResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeadersFile(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseHeadersFile(strings.NewReader(`# Site headers
+/*
+  X-Frame-Options: DENY
+  Etag: "forged"
+
+/assets/:name/*
+	Cache-Control: public, max-age=3600
+	Access-Control-Allow-Origin: *
+	Access-Control-Allow-Origin: https://example.com
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, http.Header{"X-Frame-Options": {"DENY"}}, rules[0].headers)
+	require.Equal(t, http.Header{
+		"Cache-Control":               {"public, max-age=3600"},
+		"Access-Control-Allow-Origin": {"*", "https://example.com"},
+	}, rules[1].headers)
+
+	for _, invalid := range []string{
+		"  X-Frame-Options: DENY\n",
+		"assets\n  X-Frame-Options: DENY\n",
+		"/\n  X-Frame-Options\n",
+		"/\n  Bad Name: value\n",
+		"/\n" + strings.Repeat("  X-Padding: value\n", maxHeadersFileSize/18+1),
+	} {
+		_, err := parseHeadersFile(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestHeadersFile(t *testing.T) {
+	t.Parallel()
+
+	backend, root := newUnixFSDirBackend(t, map[string][]byte{
+		"_headers": []byte(`/*
+  Content-Security-Policy: default-src 'self'
+  Set-Cookie: session=forged
+  Etag: "forged"
+/index.html
+  Content-Type: text/plain
+/data.json
+  Cache-Control: no-store
+  Access-Control-Allow-Origin: *
+`),
+		"index.html": []byte("<html></html>"),
+		"data.json":  []byte(`{}`),
+	})
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		PublicGateways: map[string]*PublicGateway{
+			"example.com": {
+				Paths:                 []string{"/ipfs", "/ipns"},
+				UseSubdomains:         true,
+				DeserializedResponses: true,
+			},
+		},
+	})
+	host := cid.NewCidV1(root.Type(), root.Hash()).String() + ".ipfs.example.com"
+
+	get := func(t *testing.T, host, p string) *http.Response {
+		req := mustNewRequest(t, http.MethodGet, ts.URL+p, nil)
+		req.Host = host
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		return res
+	}
+
+	t.Run("Matching rules are applied", func(t *testing.T) {
+		res := get(t, host, "/data.json")
+		require.Equal(t, "default-src 'self'", res.Header.Get("Content-Security-Policy"))
+		require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+		require.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+
+		res = get(t, host, "/index.html")
+		require.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+		require.NotEqual(t, "no-store", res.Header.Get("Cache-Control"))
+
+		// Directories are matched without their trailing slash.
+		res = get(t, host, "/")
+		require.Equal(t, "default-src 'self'", res.Header.Get("Content-Security-Policy"))
+	})
+
+	t.Run("Protected headers are not overridden", func(t *testing.T) {
+		res := get(t, host, "/data.json")
+		require.Empty(t, res.Cookies())
+		require.NotEqual(t, `"forged"`, res.Header.Get("Etag"))
+		require.NotEmpty(t, res.Header.Get("Etag"))
+	})
+
+	t.Run("Requires origin isolation", func(t *testing.T) {
+		res := get(t, "", "/ipfs/"+root.String()+"/data.json")
+		require.Empty(t, res.Header.Get("Content-Security-Policy"))
+		require.NotEqual(t, "no-store", res.Header.Get("Cache-Control"))
+	})
+}
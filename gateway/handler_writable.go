@@ -0,0 +1,405 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"strings"
+
+	chunker "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs/importer"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/mfs"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// WriteAuthorizer decides whether write requests are allowed.
+type WriteAuthorizer interface {
+	// AuthorizeWrite returns nil when the PUT, POST or DELETE request r is
+	// allowed. Errors are returned to the client with HTTP 403 Forbidden,
+	// unless they carry another status code, see [NewErrorStatusCode].
+	AuthorizeWrite(r *http.Request) error
+}
+
+// WriteAuthorizerFunc is a function implementing [WriteAuthorizer].
+type WriteAuthorizerFunc func(r *http.Request) error
+
+func (f WriteAuthorizerFunc) AuthorizeWrite(r *http.Request) error {
+	return f(r)
+}
+
+// WritableConfig enables PUT, POST and DELETE requests, see [Config.Writable].
+//
+// PUT and POST on /ipfs/{cid}/{path} import the request body as a UnixFS
+// file at {path}, creating missing parent directories, and DELETE removes the
+// link at {path}. The response is HTTP 201 Created, with the path of the file,
+// or of the parent directory for DELETE, in the new root in the Location
+// header. POST on /ipfs/ imports the body as a new root.
+//
+// Writes to /ipns/{name}/{path} update the MFS root of the name in Roots,
+// which publishes the new root, see [NewIPNSPublishFunc].
+//
+// Like reads, writes are subject to [Config.Denylist], [Config.RateLimiter]
+// and [Config.Authorizer], which checks the written path, or the path of the
+// imported root for POST on /ipfs/. The denylist also checks the CID of the
+// uploaded file. Uploads are kept in memory, and only added to the
+// DAGService once accepted.
+type WritableConfig struct {
+	// DAGService stores the imported files and the updated directories. It
+	// should write to the blockstore of the backend for the new content to be
+	// served by the gateway.
+	DAGService format.DAGService
+
+	// Authorizer is consulted before every write. All writes are refused when
+	// it is nil.
+	Authorizer WriteAuthorizer
+
+	// Roots are the MFS roots of the writable IPNS names. Writes to other
+	// names are refused.
+	Roots map[ipns.Name]*mfs.Root
+
+	// MaxBodySize is the maximum size of uploaded files, which are kept in
+	// memory until they are checked. Zero means no limit.
+	MaxBodySize int64
+}
+
+// NewIPNSPublishFunc returns an [mfs.PubFunc] publishing the root CID of an
+// [mfs.Root] under the IPNS name of sk, for use with [WritableConfig.Roots].
+func NewIPNSPublishFunc(pub namesys.Publisher, sk crypto.PrivKey, opts ...namesys.PublishOption) mfs.PubFunc {
+	return func(ctx context.Context, c cid.Cid) error {
+		return pub.Publish(ctx, sk, path.FromCid(c), opts...)
+	}
+}
+
+// addWritableAllowHeader adds the write methods to the Allow header.
+func addWritableAllowHeader(w http.ResponseWriter) {
+	w.Header().Add("Allow", http.MethodPut)
+	w.Header().Add("Allow", http.MethodPost)
+	w.Header().Add("Allow", http.MethodDelete)
+}
+
+func (i *handler) writeHandler(w http.ResponseWriter, r *http.Request) {
+	wc := i.config.Writable
+
+	// Upload of a new root.
+	newRoot := r.Method == http.MethodPost && strings.TrimSuffix(r.URL.Path, "/") == "/ipfs"
+
+	var (
+		contentPath path.Path
+		err         error
+	)
+	if !newRoot {
+		contentPath, err = path.NewPath(r.URL.Path)
+		if err != nil {
+			i.webError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Writes go through the same checks as reads of contentPath.
+	if i.config.Denylist != nil && contentPath != nil {
+		if err := i.config.Denylist.CheckPath(contentPath); err != nil {
+			i.webError(w, r, err, http.StatusGone)
+			return
+		}
+	}
+	if i.config.RateLimiter != nil {
+		var ok bool
+		if r, ok = i.admitRequest(w, r, DeserializedRequest); !ok {
+			return
+		}
+	}
+	authorizer := i.authorizer(r)
+	if authorizer != nil && contentPath != nil {
+		var ok bool
+		if w, ok = i.authorizeRequest(w, r, authorizer, contentPath); !ok {
+			return
+		}
+	}
+
+	if wc.Authorizer == nil {
+		i.webError(w, r, errors.New("writes are not authorized"), http.StatusForbidden)
+		return
+	}
+	if err := wc.Authorizer.AuthorizeWrite(r); err != nil {
+		i.webError(w, r, err, http.StatusForbidden)
+		return
+	}
+	if wc.MaxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, wc.MaxBodySize)
+	}
+
+	if newRoot {
+		nd, staged, err := stageWriteBody(r)
+		if err != nil {
+			i.webError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if err := i.checkUpload(nd); err != nil {
+			i.webError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		newPath := path.FromCid(nd.Cid())
+		// A new root has no path to authorize before it is imported.
+		if authorizer != nil {
+			var ok bool
+			if w, ok = i.authorizeRequest(w, r, authorizer, newPath); !ok {
+				return
+			}
+		}
+		if err := wc.DAGService.AddMany(r.Context(), staged.list); err != nil {
+			i.webError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		setWriteLocation(w, newPath.String())
+		return
+	}
+
+	segments := contentPath.Segments()
+	subpath := strings.Join(segments[2:], "/")
+	if subpath == "" {
+		i.webError(w, r, errors.New("a path within the root is required"), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		root   *mfs.Root
+		prefix string
+	)
+	switch contentPath.Namespace() {
+	case path.IPFSNamespace:
+		c, err := cid.Decode(segments[1])
+		if err != nil {
+			i.webError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		nd, err := wc.DAGService.Get(r.Context(), c)
+		if err != nil {
+			if format.IsNotFound(err) {
+				err = NewErrorStatusCode(err, http.StatusNotFound)
+			}
+			i.webError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		pn, ok := nd.(*merkledag.ProtoNode)
+		if !ok {
+			i.webError(w, r, fmt.Errorf("%s is not a UnixFS directory", c), http.StatusBadRequest)
+			return
+		}
+		root, err = mfs.NewRoot(r.Context(), wc.DAGService, pn, nil)
+		if err != nil {
+			i.webError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		defer root.Close()
+	case path.IPNSNamespace:
+		name, err := ipns.NameFromString(segments[1])
+		if err != nil {
+			i.webError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		var ok bool
+		root, ok = wc.Roots[name]
+		if !ok {
+			i.webError(w, r, fmt.Errorf("%s is not writable", name), http.StatusForbidden)
+			return
+		}
+		prefix = "/ipns/" + segments[1]
+	}
+
+	dirPath, name := gopath.Split("/" + subpath)
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		err = i.writeFile(r, root, dirPath, name)
+	case http.MethodDelete:
+		err = removeLink(root, dirPath, name)
+		subpath = strings.TrimSuffix(dirPath, "/")
+	}
+	if err != nil {
+		i.webError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if prefix == "" {
+		nd, err := root.GetDirectory().GetNode()
+		if err != nil {
+			i.webError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		prefix = "/ipfs/" + nd.Cid().String()
+	}
+	setWriteLocation(w, gopath.Join(prefix, subpath))
+}
+
+// importWriteBody imports the request body as a UnixFS file, which is only
+// added to the DAGService if the denylist accepts it.
+func (i *handler) importWriteBody(r *http.Request) (format.Node, error) {
+	nd, staged, err := stageWriteBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.checkUpload(nd); err != nil {
+		return nil, err
+	}
+	if err := i.config.Writable.DAGService.AddMany(r.Context(), staged.list); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// stageWriteBody imports the request body as a UnixFS file in memory, so
+// that nothing is stored before the new file is checked.
+func stageWriteBody(r *http.Request) (format.Node, *stagedDAG, error) {
+	staged := &stagedDAG{nodes: make(map[cid.Cid]format.Node)}
+	nd, err := importer.BuildDagFromReader(staged, chunker.DefaultSplitter(r.Body))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, nil, NewErrorStatusCode(err, http.StatusRequestEntityTooLarge)
+		}
+		return nil, nil, err
+	}
+	return nd, staged, nil
+}
+
+// checkUpload checks the uploaded file nd against the denylist.
+func (i *handler) checkUpload(nd format.Node) error {
+	if i.config.Denylist != nil {
+		if err := i.config.Denylist.CheckPath(path.FromCid(nd.Cid())); err != nil {
+			return NewErrorStatusCode(err, http.StatusGone)
+		}
+	}
+	return nil
+}
+
+// stagedDAG is an in-memory [format.DAGService] holding the nodes of an
+// upload until they are committed.
+type stagedDAG struct {
+	nodes map[cid.Cid]format.Node
+	list  []format.Node
+}
+
+func (s *stagedDAG) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	nd, ok := s.nodes[c]
+	if !ok {
+		return nil, format.ErrNotFound{Cid: c}
+	}
+	return nd, nil
+}
+
+func (s *stagedDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	for _, c := range cids {
+		nd, err := s.Get(ctx, c)
+		out <- &format.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (s *stagedDAG) Add(ctx context.Context, nd format.Node) error {
+	if _, ok := s.nodes[nd.Cid()]; !ok {
+		s.nodes[nd.Cid()] = nd
+		s.list = append(s.list, nd)
+	}
+	return nil
+}
+
+func (s *stagedDAG) AddMany(ctx context.Context, nds []format.Node) error {
+	for _, nd := range nds {
+		s.Add(ctx, nd)
+	}
+	return nil
+}
+
+func (s *stagedDAG) Remove(ctx context.Context, c cid.Cid) error {
+	return errors.New("staged nodes cannot be removed")
+}
+
+func (s *stagedDAG) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	return errors.New("staged nodes cannot be removed")
+}
+
+// writeFile imports the request body as the entry name of the directory
+// dirPath, replacing any existing entry. The body is imported before the
+// missing directories are created, so that failed uploads leave the root
+// unchanged.
+func (i *handler) writeFile(r *http.Request, root *mfs.Root, dirPath, name string) error {
+	nd, err := i.importWriteBody(r)
+	if err != nil {
+		return err
+	}
+	if err := mfs.Mkdir(root, dirPath, mfs.MkdirOpts{Mkparents: true}); err != nil {
+		return err
+	}
+	dir, err := lookupWriteDir(root, dirPath)
+	if err != nil {
+		return err
+	}
+
+	// The existing entry is linked again if the new one cannot be added.
+	var old format.Node
+	if fsn, err := dir.Child(name); err == nil {
+		if old, err = fsn.GetNode(); err != nil {
+			return err
+		}
+		if err := dir.Unlink(name); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := dir.AddChild(name, nd); err != nil {
+		if old != nil {
+			if rerr := dir.AddChild(name, old); rerr != nil {
+				return errors.Join(err, rerr)
+			}
+		}
+		return err
+	}
+	return dir.Flush()
+}
+
+// removeLink removes the entry name of the directory dirPath.
+func removeLink(root *mfs.Root, dirPath, name string) error {
+	dir, err := lookupWriteDir(root, dirPath)
+	if err != nil {
+		return err
+	}
+	if err := dir.Unlink(name); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewErrorStatusCode(fmt.Errorf("%s: %w", name, err), http.StatusNotFound)
+		}
+		return err
+	}
+	return dir.Flush()
+}
+
+func lookupWriteDir(root *mfs.Root, dirPath string) (*mfs.Directory, error) {
+	fsn, err := mfs.Lookup(root, dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, NewErrorStatusCode(fmt.Errorf("%s: %w", dirPath, err), http.StatusNotFound)
+		}
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, NewErrorStatusCode(fmt.Errorf("%s is not a directory", dirPath), http.StatusConflict)
+	}
+	return dir, nil
+}
+
+func setWriteLocation(w http.ResponseWriter, p string) {
+	w.Header().Set("Location", (&url.URL{Path: p}).EscapedPath())
+	w.WriteHeader(http.StatusCreated)
+}
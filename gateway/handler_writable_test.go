@@ -0,0 +1,294 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/denylist"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/mfs"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPublisher struct {
+	published chan path.Path
+}
+
+func (p *mockPublisher) Publish(ctx context.Context, sk crypto.PrivKey, value path.Path, options ...namesys.PublishOption) error {
+	p.published <- value
+	return nil
+}
+
+func TestWritableGateway(t *testing.T) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bs, offline.Exchange(bs))
+	dserv := merkledag.NewDAGService(bsrv)
+	backend, err := NewBlocksBackend(bsrv)
+	require.NoError(t, err)
+
+	emptyDir := unixfs.EmptyDirNode()
+	require.NoError(t, dserv.Add(context.Background(), emptyDir))
+
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+	publisher := &mockPublisher{published: make(chan path.Path, 1)}
+	ipnsRoot, err := mfs.NewRoot(context.Background(), dserv, unixfs.EmptyDirNode(), NewIPNSPublishFunc(publisher, sk))
+	require.NoError(t, err)
+	t.Cleanup(func() { ipnsRoot.Close() })
+
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		Writable: &WritableConfig{
+			DAGService: dserv,
+			Authorizer: WriteAuthorizerFunc(func(r *http.Request) error {
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					return NewErrorStatusCodeFromStatus(http.StatusUnauthorized)
+				}
+				return nil
+			}),
+			Roots:       map[ipns.Name]*mfs.Root{name: ipnsRoot},
+			MaxBodySize: 16,
+		},
+	})
+
+	write := func(method, p, body string) *http.Response {
+		req := mustNewRequest(t, method, ts.URL+p, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		return mustDoWithoutRedirect(t, req)
+	}
+	get := func(p string) (int, string) {
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+p, nil))
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+
+	t.Run("PUT imports a file and returns the new root", func(t *testing.T) {
+		res := write(http.MethodPut, "/ipfs/"+emptyDir.Cid().String()+"/a/b.txt", "hello")
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		loc := res.Header.Get("Location")
+		require.True(t, strings.HasPrefix(loc, "/ipfs/"))
+		require.True(t, strings.HasSuffix(loc, "/a/b.txt"))
+		require.NotContains(t, loc, emptyDir.Cid().String())
+
+		status, body := get(loc)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "hello", body)
+
+		// Overwriting replaces the file.
+		res = write(http.MethodPut, loc, "world")
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		_, body = get(res.Header.Get("Location"))
+		require.Equal(t, "world", body)
+
+		// DELETE returns the parent directory in the new root.
+		res = write(http.MethodDelete, res.Header.Get("Location"), "")
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		loc = res.Header.Get("Location")
+		require.True(t, strings.HasSuffix(loc, "/a"))
+		status, _ = get(loc + "/b.txt")
+		require.Equal(t, http.StatusNotFound, status)
+
+		res = write(http.MethodDelete, loc+"/b.txt", "")
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("POST on /ipfs/ imports a new root", func(t *testing.T) {
+		res := write(http.MethodPost, "/ipfs/", "new root")
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		loc := res.Header.Get("Location")
+		c, err := cid.Decode(strings.TrimPrefix(loc, "/ipfs/"))
+		require.NoError(t, err)
+		_, body := get("/ipfs/" + c.String())
+		require.Equal(t, "new root", body)
+	})
+
+	t.Run("Writes to IPNS roots are published", func(t *testing.T) {
+		res := write(http.MethodPut, "/ipns/"+name.String()+"/file.txt", "published")
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		require.Equal(t, "/ipns/"+name.String()+"/file.txt", res.Header.Get("Location"))
+
+		select {
+		case p := <-publisher.published:
+			_, body := get(p.String() + "/file.txt")
+			require.Equal(t, "published", body)
+		case <-time.After(5 * time.Second):
+			t.Fatal("root was not published")
+		}
+
+		other, _, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+		otherPid, err := peer.IDFromPrivateKey(other)
+		require.NoError(t, err)
+		res = write(http.MethodPut, "/ipns/"+ipns.NameFromPeer(otherPid).String()+"/file.txt", "refused")
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("Refused writes", func(t *testing.T) {
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodPut, ts.URL+"/ipfs/"+emptyDir.Cid().String()+"/a.txt", strings.NewReader("hello")))
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = write(http.MethodPut, "/ipfs/"+emptyDir.Cid().String()+"/a.txt", strings.Repeat("a", 17))
+		require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+		// A refused upload does not create the parent directories.
+		res = write(http.MethodPut, "/ipns/"+name.String()+"/new/a.txt", strings.Repeat("a", 17))
+		require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+		_, err := mfs.Lookup(ipnsRoot, "/new")
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		res = write(http.MethodPut, "/ipfs/"+emptyDir.Cid().String(), "hello")
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("Read only gateways refuse writes", func(t *testing.T) {
+		ts := newTestServerWithConfig(t, backend, Config{DeserializedResponses: true})
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodPut, ts.URL+"/ipfs/"+emptyDir.Cid().String()+"/a.txt", strings.NewReader("hello")))
+		require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+		require.NotContains(t, res.Header.Values("Allow"), http.MethodPut)
+	})
+}
+
+func TestWritableGatewayChecks(t *testing.T) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bs, offline.Exchange(bs))
+	dserv := merkledag.NewDAGService(bsrv)
+	backend, err := NewBlocksBackend(bsrv)
+	require.NoError(t, err)
+
+	emptyDir := unixfs.EmptyDirNode()
+	require.NoError(t, dserv.Add(context.Background(), emptyDir))
+	blockedDir := unixfs.EmptyDirNode()
+	require.NoError(t, blockedDir.AddNodeLink("blocked", merkledag.NewRawNode([]byte("blocked"))))
+	require.NoError(t, dserv.Add(context.Background(), blockedDir))
+
+	dl, err := denylist.Parse(strings.NewReader("version: 1\n---\n/ipfs/" + blockedDir.Cid().String() + "\n"))
+	require.NoError(t, err)
+	rl, err := NewClientRateLimiter(ClientRateLimiterConfig{
+		Deserialized: RateLimit{RequestsPerSecond: 0.01, RequestBurst: 2},
+	})
+	require.NoError(t, err)
+	authorizer := NewHMACAuthorizer([]byte("key"))
+	token, err := authorizer.Sign(Capability{Scope: "/ipfs/" + emptyDir.Cid().String()})
+	require.NoError(t, err)
+
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		Denylist:              dl,
+		RateLimiter:           rl,
+		Authorizer:            authorizer,
+		Writable: &WritableConfig{
+			DAGService: dserv,
+			Authorizer: WriteAuthorizerFunc(func(*http.Request) error { return nil }),
+		},
+	})
+	put := func(p string) int {
+		req := mustNewRequest(t, http.MethodPut, ts.URL+p, strings.NewReader("hello"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		return mustDoWithoutRedirect(t, req).StatusCode
+	}
+
+	require.Equal(t, http.StatusCreated, put("/ipfs/"+emptyDir.Cid().String()+"/a.txt"))
+
+	// Outside of the scope of the token.
+	missing := merkledag.NewRawNode([]byte("missing")).Cid()
+	require.Equal(t, http.StatusForbidden, put("/ipfs/"+missing.String()+"/a.txt"))
+
+	require.Equal(t, http.StatusGone, put("/ipfs/"+blockedDir.Cid().String()+"/a.txt"))
+
+	// The request burst is exhausted.
+	require.Equal(t, http.StatusTooManyRequests, put("/ipfs/"+emptyDir.Cid().String()+"/a.txt"))
+}
+
+func TestWritableGatewayMissingRoot(t *testing.T) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bs, offline.Exchange(bs))
+	backend, err := NewBlocksBackend(bsrv)
+	require.NoError(t, err)
+
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		Writable: &WritableConfig{
+			DAGService: merkledag.NewDAGService(bsrv),
+			Authorizer: WriteAuthorizerFunc(func(*http.Request) error { return nil }),
+		},
+	})
+	missing := unixfs.EmptyDirNode()
+	require.NoError(t, missing.AddNodeLink("missing", merkledag.NewRawNode([]byte("missing"))))
+	req := mustNewRequest(t, http.MethodPut, ts.URL+"/ipfs/"+missing.Cid().String()+"/a.txt", strings.NewReader("hello"))
+	require.Equal(t, http.StatusNotFound, mustDoWithoutRedirect(t, req).StatusCode)
+}
+
+func TestWritableGatewayRefusedUploadsAreNotStored(t *testing.T) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bs, offline.Exchange(bs))
+	dserv := merkledag.NewDAGService(bsrv)
+	backend, err := NewBlocksBackend(bsrv)
+	require.NoError(t, err)
+
+	emptyDir := unixfs.EmptyDirNode()
+	require.NoError(t, dserv.Add(context.Background(), emptyDir))
+
+	// The CID of the blocked upload.
+	blocked, _, err := stageWriteBody(mustNewRequest(t, http.MethodPost, "/ipfs/", strings.NewReader("blocked")))
+	require.NoError(t, err)
+	dl, err := denylist.Parse(strings.NewReader("version: 1\n---\n/ipfs/" + blocked.Cid().String() + "\n"))
+	require.NoError(t, err)
+	authorizer := NewHMACAuthorizer([]byte("key"))
+	token, err := authorizer.Sign(Capability{Scope: "/ipfs/" + emptyDir.Cid().String()})
+	require.NoError(t, err)
+
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		Denylist:              dl,
+		Authorizer:            authorizer,
+		Writable: &WritableConfig{
+			DAGService: dserv,
+			Authorizer: WriteAuthorizerFunc(func(*http.Request) error { return nil }),
+		},
+	})
+	write := func(method, p, body string) int {
+		req := mustNewRequest(t, method, ts.URL+p, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		return mustDoWithoutRedirect(t, req).StatusCode
+	}
+	stored := func(body string) bool {
+		nd, _, err := stageWriteBody(mustNewRequest(t, http.MethodPost, "/ipfs/", strings.NewReader(body)))
+		require.NoError(t, err)
+		has, err := bs.Has(context.Background(), nd.Cid())
+		require.NoError(t, err)
+		return has
+	}
+
+	require.Equal(t, http.StatusGone, write(http.MethodPut, "/ipfs/"+emptyDir.Cid().String()+"/a.txt", "blocked"))
+	require.Equal(t, http.StatusGone, write(http.MethodPost, "/ipfs/", "blocked"))
+	require.False(t, stored("blocked"))
+
+	// The token does not cover the new root.
+	require.Equal(t, http.StatusForbidden, write(http.MethodPost, "/ipfs/", "unauthorized"))
+	require.False(t, stored("unauthorized"))
+
+	require.Equal(t, http.StatusCreated, write(http.MethodPut, "/ipfs/"+emptyDir.Cid().String()+"/a.txt", "allowed"))
+	require.True(t, stored("allowed"))
+}
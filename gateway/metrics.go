@@ -5,6 +5,7 @@ import (
 	"io"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/go-cid"
@@ -181,8 +182,13 @@ func (b *ipfsBackendWithMetrics) GetDNSLinkRecord(ctx context.Context, fqdn stri
 var (
 	_ IPFSBackend     = (*ipfsBackendWithMetrics)(nil)
 	_ WithContextHint = (*ipfsBackendWithMetrics)(nil)
+	_ WithCarRanges   = (*ipfsBackendWithMetrics)(nil)
 )
 
+func (b *ipfsBackendWithMetrics) SupportsCarRanges() bool {
+	return supportsCarRanges(b.backend)
+}
+
 func (b *ipfsBackendWithMetrics) WrapContextForRequest(ctx context.Context) context.Context {
 	if withCtxWrap, ok := b.backend.(WithContextHint); ok {
 		return withCtxWrap.WrapContextForRequest(ctx)
@@ -191,6 +197,9 @@ func (b *ipfsBackendWithMetrics) WrapContextForRequest(ctx context.Context) cont
 }
 
 func newHandlerWithMetrics(c *Config, backend IPFSBackend) *handler {
+	if c.Denylist != nil {
+		backend = newDenylistBackend(backend, c.Denylist)
+	}
 	i := &handler{
 		config:  c,
 		backend: newIPFSBackendWithMetrics(backend),
@@ -255,7 +264,21 @@ func newHandlerWithMetrics(c *Config, backend IPFSBackend) *handler {
 			"gw_ipns_record_get_duration_seconds",
 			"The time to GET an entire IPNS Record from the gateway.",
 		),
+		// Response cache: lookups of responses for immutable paths
+		responseCacheMetric: newCounterMetric(
+			"gw_response_cache_lookups",
+			"The number of response cache lookups per result (hit, miss, not_modified).",
+			"result",
+		),
+		// Rate limiting: requests refused or aborted by the RateLimiter
+		rateLimitedMetric: newCounterMetric(
+			"gw_rate_limited_requests",
+			"The number of requests refused or aborted by the rate limiter per request class (trustless, deserialized).",
+			"class",
+		),
 	}
+	i.headersFiles, _ = lru.New[cid.Cid, []headersRule](headersFileCacheSize)
+	i.ipnsWatchers = newIPNSWatchers(i.backend, c.IPNSWatchInterval, c.MaxIPNSWatchedNames, c.MaxIPNSEventStreamsPerClient)
 	return i
 }
 
@@ -281,6 +304,26 @@ func newRequestTypeMetric(name string, help string) *prometheus.CounterVec {
 	return metric
 }
 
+func newCounterMetric(name string, help string, labels ...string) *prometheus.CounterVec {
+	metric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)
+	if err := prometheus.Register(metric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			metric = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			log.Errorf("failed to register ipfs_http_%s: %v", name, err)
+		}
+	}
+	return metric
+}
+
 func newHistogramMetric(name string, help string) *prometheus.HistogramVec {
 	// We can add buckets as a parameter in the future, but for now using static defaults
 	// suggested in https://github.com/ipfs/kubo/issues/8441
@@ -0,0 +1,382 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/boxo/blockservice"
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/boxo/verifcid"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RequestClass distinguishes the requests subject to different rate limits.
+type RequestClass string
+
+const (
+	// TrustlessRequest is a request for a verifiable response: a raw block,
+	// a CAR or an IPNS record.
+	TrustlessRequest RequestClass = "trustless"
+	// DeserializedRequest is a request for any other response, such as a
+	// UnixFS file, a directory listing or a TAR archive.
+	DeserializedRequest RequestClass = "deserialized"
+)
+
+// RateLimiter controls how many requests, and how much data, clients can
+// get from the gateway.
+type RateLimiter interface {
+	// Admit is called before serving a request. It returns the budget of the
+	// request, or an error when the request must be refused, typically an
+	// [ErrorRetryAfter] wrapping [ErrTooManyRequests].
+	Admit(r *http.Request, class RequestClass) (RequestBudget, error)
+}
+
+// RequestBudget accounts the data fetched to serve a request.
+type RequestBudget interface {
+	// Charge accounts blocks and bytes fetched for the request. It returns
+	// an error, which aborts the request, when the budget is exhausted.
+	Charge(blocks int, bytes int) error
+}
+
+type requestBudgetKey struct{}
+
+// ChargeRequestBudget charges the budget of the request being served with ctx,
+// if any. [BlocksBackend] charges every block it reads. Other [IPFSBackend]
+// implementations can call it to enforce the byte and block limits of a
+// [RateLimiter].
+func ChargeRequestBudget(ctx context.Context, blocks int, bytes int) error {
+	budget, ok := ctx.Value(requestBudgetKey{}).(RequestBudget)
+	if !ok || budget == nil {
+		return nil
+	}
+	return budget.Charge(blocks, bytes)
+}
+
+// requestClass returns the class of the read request r for contentPath.
+func (i *handler) requestClass(r *http.Request, contentPath path.Path) RequestClass {
+	if responseFormat, _, err := customResponseFormat(r); err == nil && i.isTrustlessRequest(contentPath, responseFormat) {
+		return TrustlessRequest
+	}
+	return DeserializedRequest
+}
+
+// clientKey identifies the client of r, see [Config.ClientKey].
+func (i *handler) clientKey(r *http.Request) string {
+	if i.config.ClientKey != nil {
+		return i.config.ClientKey(r)
+	}
+	if l, ok := i.config.RateLimiter.(*clientRateLimiter); ok {
+		return l.cfg.ClientKey(r)
+	}
+	return ClientIP(r)
+}
+
+// admitRequest asks the rate limiter whether r can be served, and returns r
+// with its budget attached to the context when it can. Otherwise, it replies
+// with an error and returns false.
+func (i *handler) admitRequest(w http.ResponseWriter, r *http.Request, class RequestClass) (*http.Request, bool) {
+	budget, err := i.config.RateLimiter.Admit(r, class)
+	if err != nil {
+		i.rateLimitedMetric.WithLabelValues(string(class)).Inc()
+		i.webError(w, r, err, http.StatusTooManyRequests)
+		return r, false
+	}
+	if budget == nil {
+		return r, true
+	}
+	budget = &meteredBudget{RequestBudget: budget, class: class, metric: i.rateLimitedMetric}
+	return r.WithContext(context.WithValue(r.Context(), requestBudgetKey{}, budget)), true
+}
+
+// meteredBudget counts the requests aborted because their budget was
+// exhausted.
+type meteredBudget struct {
+	RequestBudget
+	class   RequestClass
+	metric  *prometheus.CounterVec
+	aborted atomic.Bool
+}
+
+func (b *meteredBudget) Charge(blocks int, bytes int) error {
+	err := b.RequestBudget.Charge(blocks, bytes)
+	if err != nil && b.aborted.CompareAndSwap(false, true) {
+		b.metric.WithLabelValues(string(b.class)).Inc()
+	}
+	return err
+}
+
+// RateLimit is a set of token bucket limits. A zero rate disables the
+// corresponding limit. A zero burst defaults to one second worth of tokens.
+type RateLimit struct {
+	RequestsPerSecond float64
+	RequestBurst      int
+	// BytesPerSecond and BlocksPerSecond limit the data fetched to serve the
+	// requests. A request is aborted when it exceeds the remaining burst.
+	BytesPerSecond  float64
+	ByteBurst       int64
+	BlocksPerSecond float64
+	BlockBurst      int64
+}
+
+// ClientRateLimiterConfig configures [NewClientRateLimiter].
+type ClientRateLimiterConfig struct {
+	// Trustless and Deserialized are the limits applied to each client for
+	// the corresponding [RequestClass].
+	Trustless    RateLimit
+	Deserialized RateLimit
+	// ClientKey identifies the client of a request. Defaults to [ClientIP].
+	ClientKey func(*http.Request) string
+	// MaxClients is the number of clients tracked at once. The least recently
+	// seen clients are forgotten beyond. Defaults to 10000.
+	MaxClients int
+}
+
+// ClientIP returns the IP address of the client of r, as seen by the
+// server. Proxy headers such as X-Forwarded-For are not trusted.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientAPIKey returns a function identifying clients by the value of the
+// given header, such as an API key. Rate limiting happens before the request
+// is authorized, so only the keys for which valid returns true identify a
+// client. Requests with a missing or unknown key are identified by
+// [ClientIP], so that sending random keys neither bypasses the limits nor
+// evicts the known clients from the limiter.
+func ClientAPIKey(header string, valid func(key string) bool) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if key := r.Header.Get(header); key != "" && valid(key) {
+			return "key:" + key
+		}
+		return "ip:" + ClientIP(r)
+	}
+}
+
+type clientRateLimiter struct {
+	cfg     ClientRateLimiterConfig
+	clients *lru.Cache[string, *clientBuckets]
+	now     func() time.Time
+}
+
+// NewClientRateLimiter returns a [RateLimiter] enforcing separate token
+// bucket limits for every client, with different limits for trustless and
+// deserialized requests.
+func NewClientRateLimiter(cfg ClientRateLimiterConfig) (RateLimiter, error) {
+	if cfg.ClientKey == nil {
+		cfg.ClientKey = ClientIP
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 10000
+	}
+	clients, err := lru.New[string, *clientBuckets](cfg.MaxClients)
+	if err != nil {
+		return nil, err
+	}
+	return &clientRateLimiter{cfg: cfg, clients: clients, now: time.Now}, nil
+}
+
+func (l *clientRateLimiter) Admit(r *http.Request, class RequestClass) (RequestBudget, error) {
+	limit := l.cfg.Deserialized
+	if class == TrustlessRequest {
+		limit = l.cfg.Trustless
+	}
+
+	key := string(class) + "/" + l.cfg.ClientKey(r)
+	cb, ok := l.clients.Get(key)
+	if !ok {
+		cb = newClientBuckets(limit, l.now)
+		// Another request may have added it concurrently.
+		if prev, found, _ := l.clients.PeekOrAdd(key, cb); found {
+			cb = prev
+		}
+	}
+	return cb, cb.admit()
+}
+
+// clientBuckets holds the token buckets of a client. It is the budget of all
+// the requests of the client.
+type clientBuckets struct {
+	lk       sync.Mutex
+	now      func() time.Time
+	requests tokenBucket
+	bytes    tokenBucket
+	blocks   tokenBucket
+}
+
+func newClientBuckets(limit RateLimit, now func() time.Time) *clientBuckets {
+	start := now()
+	return &clientBuckets{
+		now:      now,
+		requests: newTokenBucket(limit.RequestsPerSecond, float64(limit.RequestBurst), start),
+		bytes:    newTokenBucket(limit.BytesPerSecond, float64(limit.ByteBurst), start),
+		blocks:   newTokenBucket(limit.BlocksPerSecond, float64(limit.BlockBurst), start),
+	}
+}
+
+func (cb *clientBuckets) admit() error {
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+
+	now := cb.now()
+	// Refuse new requests while the data budget is exhausted.
+	wait := max(cb.bytes.wait(0, now), cb.blocks.wait(0, now))
+	if wait == 0 {
+		wait = cb.requests.wait(1, now)
+	}
+	if wait > 0 {
+		return NewErrorRetryAfter(ErrTooManyRequests, wait)
+	}
+	cb.requests.take(1)
+	return nil
+}
+
+func (cb *clientBuckets) Charge(blocks int, bytes int) error {
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+
+	now := cb.now()
+	cb.blocks.take(float64(blocks))
+	cb.bytes.take(float64(bytes))
+	if wait := max(cb.bytes.wait(0, now), cb.blocks.wait(0, now)); wait > 0 {
+		return NewErrorRetryAfter(ErrTooManyRequests, wait)
+	}
+	return nil
+}
+
+// tokenBucket is a token bucket with a capacity of burst tokens refilled at
+// rate tokens per second. The number of tokens can become negative when more
+// is taken than available.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) tokenBucket {
+	if rate > 0 && burst <= 0 {
+		burst = math.Max(1, math.Ceil(rate))
+	}
+	return tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns how long to wait until n tokens can be taken without going
+// below zero.
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	missing := n - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// budgetBlockService charges the blocks read through it to the request
+// budget found in the context. Blocks are read through sessions of the
+// wrapper, whose blockstore does the charging, so that the session embedded in
+// the context of a request by [BlocksBackend.WrapContextForRequest] is used.
+type budgetBlockService struct {
+	blockservice.BlockService
+	bs blockstore.Blockstore
+}
+
+func newBudgetBlockService(bs blockservice.BlockService) *budgetBlockService {
+	return &budgetBlockService{
+		BlockService: bs,
+		bs:           &budgetBlockstore{Blockstore: bs.Blockstore()},
+	}
+}
+
+// Blockstore returns the charging blockstore, which is used by sessions to
+// read local blocks and store fetched ones.
+func (s *budgetBlockService) Blockstore() blockstore.Blockstore {
+	return s.bs
+}
+
+func (s *budgetBlockService) Allowlist() verifcid.Allowlist {
+	if bbs, ok := s.BlockService.(blockservice.BoundedBlockService); ok {
+		return bbs.Allowlist()
+	}
+	return verifcid.DefaultAllowlist
+}
+
+func (s *budgetBlockService) ContentBlocker() blockservice.ContentBlocker {
+	if fbs, ok := s.BlockService.(blockservice.FilteredBlockService); ok {
+		return fbs.ContentBlocker()
+	}
+	return nil
+}
+
+// GetBlock uses the session in ctx, or a new one when there is none.
+func (s *budgetBlockService) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return blockservice.NewSession(ctx, s).GetBlock(ctx, c)
+}
+
+// GetBlocks uses the session in ctx, or a new one when there is none.
+func (s *budgetBlockService) GetBlocks(ctx context.Context, ks []cid.Cid) <-chan blocks.Block {
+	return blockservice.NewSession(ctx, s).GetBlocks(ctx, ks)
+}
+
+// budgetBlockstore charges the blocks read from the blockstore, or fetched
+// from the network and stored, to the request budget found in the context.
+type budgetBlockstore struct {
+	blockstore.Blockstore
+}
+
+func (bs *budgetBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := ChargeRequestBudget(ctx, 1, len(blk.RawData())); err != nil {
+		return nil, err
+	}
+	return blk, nil
+}
+
+func (bs *budgetBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	if err := bs.Blockstore.Put(ctx, blk); err != nil {
+		return err
+	}
+	return ChargeRequestBudget(ctx, 1, len(blk.RawData()))
+}
+
+func (bs *budgetBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if err := bs.Blockstore.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	var size int
+	for _, blk := range blks {
+		size += len(blk.RawData())
+	}
+	return ChargeRequestBudget(ctx, len(blks), size)
+}
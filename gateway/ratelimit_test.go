@@ -0,0 +1,220 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	rl, err := NewClientRateLimiter(ClientRateLimiterConfig{
+		Trustless:    RateLimit{RequestsPerSecond: 1, RequestBurst: 2},
+		Deserialized: RateLimit{BytesPerSecond: 100, ByteBurst: 100},
+		ClientKey: ClientAPIKey("X-Api-Key", func(key string) bool {
+			return key == "a" || key == "b"
+		}),
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	rl.(*clientRateLimiter).now = func() time.Time { return now }
+
+	req := func(key string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil)
+		if key != "" {
+			r.Header.Set("X-Api-Key", key)
+		}
+		return r
+	}
+
+	// Request rate: the burst is served, then clients must wait.
+	for range 2 {
+		_, err := rl.Admit(req("a"), TrustlessRequest)
+		require.NoError(t, err)
+	}
+	_, err = rl.Admit(req("a"), TrustlessRequest)
+	var era *ErrorRetryAfter
+	require.ErrorAs(t, err, &era)
+	require.Equal(t, time.Second, era.RetryAfter)
+	require.ErrorIs(t, err, ErrTooManyRequests)
+
+	// Other clients are not affected.
+	_, err = rl.Admit(req("b"), TrustlessRequest)
+	require.NoError(t, err)
+	_, err = rl.Admit(req(""), TrustlessRequest)
+	require.NoError(t, err)
+
+	// Unknown keys share the bucket of the client IP.
+	_, err = rl.Admit(req("forged-1"), TrustlessRequest)
+	require.NoError(t, err)
+	_, err = rl.Admit(req("forged-2"), TrustlessRequest)
+	require.ErrorIs(t, err, ErrTooManyRequests)
+	require.Equal(t, 3, rl.(*clientRateLimiter).clients.Len())
+
+	now = now.Add(time.Second)
+	_, err = rl.Admit(req("a"), TrustlessRequest)
+	require.NoError(t, err)
+
+	// Data budget: requests exceeding it are aborted, and new ones refused
+	// until it is refilled.
+	budget, err := rl.Admit(req("a"), DeserializedRequest)
+	require.NoError(t, err)
+	require.NoError(t, budget.Charge(1, 60))
+	require.ErrorIs(t, budget.Charge(1, 60), ErrTooManyRequests)
+	_, err = rl.Admit(req("a"), DeserializedRequest)
+	require.ErrorAs(t, err, &era)
+	require.Equal(t, 200*time.Millisecond, era.RetryAfter)
+
+	now = now.Add(time.Second)
+	_, err = rl.Admit(req("a"), DeserializedRequest)
+	require.NoError(t, err)
+}
+
+// newBudgetedBackend returns a [BlocksBackend] charging request budgets for
+// the blocks of a CAR fixture, and the root CID of the fixture.
+func newBudgetedBackend(t *testing.T, fixturesFile string) (IPFSBackend, cid.Cid) {
+	r, err := os.Open(filepath.Join("./testdata", fixturesFile))
+	require.NoError(t, err)
+	blockStore, err := carblockstore.NewReadOnly(r, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		blockStore.Close()
+		r.Close()
+	})
+
+	roots, err := blockStore.Roots()
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+
+	backend, err := NewBlocksBackend(blockservice.New(blockStore, offline.Exchange(blockStore)), WithRequestBudgets())
+	require.NoError(t, err)
+	return backend, roots[0]
+}
+
+func TestHandlerRateLimiting(t *testing.T) {
+	backend, root := newBudgetedBackend(t, "fixtures.car")
+
+	t.Run("Too many requests", func(t *testing.T) {
+		rl, err := NewClientRateLimiter(ClientRateLimiterConfig{
+			Trustless: RateLimit{RequestsPerSecond: 0.01, RequestBurst: 1},
+		})
+		require.NoError(t, err)
+		ts := newTestServerWithConfig(t, backend, Config{DeserializedResponses: true, RateLimiter: rl})
+
+		url := ts.URL + "/ipfs/" + root.String() + "?format=raw"
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		res = mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+		require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		require.Equal(t, "100", res.Header.Get("Retry-After"))
+
+		// Deserialized requests have their own, unlimited, budget.
+		res = mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, ts.URL+"/ipfs/"+root.String()+"/", nil))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Data budget exceeded", func(t *testing.T) {
+		rl, err := NewClientRateLimiter(ClientRateLimiterConfig{
+			Deserialized: RateLimit{BlocksPerSecond: 1, BlockBurst: 1},
+		})
+		require.NoError(t, err)
+		ts := newTestServerWithConfig(t, backend, Config{DeserializedResponses: true, RateLimiter: rl})
+
+		// Listing the directory reads more than one block.
+		url := ts.URL + "/ipfs/" + root.String() + "/"
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+		require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		require.NotEmpty(t, res.Header.Get("Retry-After"))
+	})
+}
+
+// sessionCountingExchange serves blocks from a map and counts the sessions
+// created.
+type sessionCountingExchange struct {
+	blocks   map[cid.Cid]blocks.Block
+	sessions atomic.Int32
+}
+
+func (e *sessionCountingExchange) GetBlock(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, ok := e.blocks[c]
+	if !ok {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	return blk, nil
+}
+
+func (e *sessionCountingExchange) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	out := make(chan blocks.Block, len(ks))
+	for _, c := range ks {
+		if blk, err := e.GetBlock(ctx, c); err == nil {
+			out <- blk
+		}
+	}
+	close(out)
+	return out, nil
+}
+
+func (e *sessionCountingExchange) NotifyNewBlocks(context.Context, ...blocks.Block) error {
+	return nil
+}
+
+func (e *sessionCountingExchange) Close() error {
+	return nil
+}
+
+func (e *sessionCountingExchange) NewSession(context.Context) exchange.Fetcher {
+	e.sessions.Add(1)
+	return e
+}
+
+type countingBudget struct {
+	blocks, bytes int
+}
+
+func (b *countingBudget) Charge(blocks int, bytes int) error {
+	b.blocks += blocks
+	b.bytes += bytes
+	return nil
+}
+
+func TestBudgetBlockServiceUsesRequestSession(t *testing.T) {
+	blk1 := blocks.NewBlock([]byte("one"))
+	blk2 := blocks.NewBlock([]byte("two"))
+	ex := &sessionCountingExchange{blocks: map[cid.Cid]blocks.Block{blk1.Cid(): blk1, blk2.Cid(): blk2}}
+	bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := newBudgetBlockService(blockservice.New(bstore, ex))
+	backend := &BlocksBackend{blockService: bsrv}
+
+	budget := &countingBudget{}
+	ctx := context.WithValue(context.Background(), requestBudgetKey{}, budget)
+	ctx = backend.WrapContextForRequest(ctx)
+
+	_, err := bsrv.GetBlock(ctx, blk1.Cid())
+	require.NoError(t, err)
+	for range bsrv.GetBlocks(ctx, []cid.Cid{blk1.Cid(), blk2.Cid()}) {
+	}
+
+	// Both reads used the session of the request.
+	require.EqualValues(t, 1, ex.sessions.Load())
+	// Each read is charged once: blk1 when fetched, then when read locally,
+	// and blk2 when fetched.
+	require.Equal(t, 3, budget.blocks)
+	require.Equal(t, 3*len("one"), budget.bytes)
+}
@@ -0,0 +1,652 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultRemotePoolHedgeDelay is the default time to wait for the
+	// response of a remote gateway before sending the same request to the
+	// next fastest one.
+	DefaultRemotePoolHedgeDelay = 250 * time.Millisecond
+
+	// DefaultRemotePoolMaxAttempts is the default maximum number of remote
+	// gateways a request is sent to, including hedged requests and retries.
+	DefaultRemotePoolMaxAttempts = 3
+
+	// DefaultRemotePoolEvictAfter is the default number of consecutive
+	// failures after which a remote gateway is evicted from the pool.
+	DefaultRemotePoolEvictAfter = 3
+
+	// DefaultRemotePoolEvictFor is the default duration of the first eviction
+	// of a remote gateway. It doubles with each consecutive eviction.
+	DefaultRemotePoolEvictFor = 30 * time.Second
+
+	// remotePoolMaxEvictShift caps the eviction duration to 16 times the
+	// configured one.
+	remotePoolMaxEvictShift = 4
+
+	// remotePoolEWMAWeight is the weight of new samples in the moving averages
+	// of latencies and error rates.
+	remotePoolEWMAWeight = 0.2
+
+	// remotePoolExploreRate is the probability of trying another healthy
+	// gateway first, so that the latency of slower gateways keeps being
+	// measured and they can win back their place.
+	remotePoolExploreRate = 0.05
+)
+
+// RemotePool is a pool of remote gateways that support the [Trustless Gateway]
+// specification. It keeps track of the latency and error rate of each gateway,
+// sends requests to the fastest healthy ones, hedges them when a gateway is
+// slow to respond, and temporarily evicts gateways that keep failing. This
+// allows a proxy gateway to stay fast when one of its upstream gateways
+// degrades.
+//
+// A RemotePool can be shared by the [blockstore.Blockstore], [CarFetcher] and
+// [routing.ValueStore] created with [NewRemoteBlockstoreFromPool],
+// [NewRemoteCarFetcherFromPool] and [NewRemoteValueStoreFromPool].
+//
+// [Trustless Gateway]: https://specs.ipfs.tech/http-gateways/trustless-gateway/
+type RemotePool struct {
+	httpClient  *http.Client
+	hedgeDelay  time.Duration
+	maxAttempts int
+	evictAfter  int
+	evictFor    time.Duration
+
+	mu        sync.Mutex
+	endpoints []*remoteEndpoint
+	rand      *rand.Rand
+
+	requestsMetric  *prometheus.CounterVec
+	durationMetric  *prometheus.HistogramVec
+	hedgesMetric    prometheus.Counter
+	latencyMetric   *prometheus.GaugeVec
+	errorRateMetric *prometheus.GaugeVec
+	evictedMetric   *prometheus.GaugeVec
+}
+
+type remoteEndpoint struct {
+	url string
+
+	// The following fields are guarded by the lock of the pool.
+	latency             time.Duration
+	errorRate           float64
+	requests            uint64
+	failures            uint64
+	consecutiveFailures int
+	evictions           int
+	evictedUntil        time.Time
+}
+
+// RemoteEndpointStats are the statistics of a remote gateway of a [RemotePool].
+type RemoteEndpointStats struct {
+	// URL is the URL of the remote gateway.
+	URL string
+
+	// Latency is the moving average of the time to the response headers, or
+	// zero when no response has been received yet.
+	Latency time.Duration
+
+	// ErrorRate is the moving average of the rate of failed requests, between
+	// 0 and 1.
+	ErrorRate float64
+
+	// Requests and Failures are the number of requests sent to the remote
+	// gateway, and how many of them failed.
+	Requests, Failures uint64
+
+	// EvictedUntil is the time until which the remote gateway is evicted, or
+	// the zero time when it is not evicted.
+	EvictedUntil time.Time
+}
+
+type remotePoolOptions struct {
+	httpClient  *http.Client
+	hedgeDelay  time.Duration
+	maxAttempts int
+	evictAfter  int
+	evictFor    time.Duration
+	promReg     prometheus.Registerer
+}
+
+// RemotePoolOption configures a [RemotePool].
+type RemotePoolOption func(options *remotePoolOptions) error
+
+// WithRemotePoolHTTPClient sets the [http.Client] used to send requests to the
+// remote gateways.
+func WithRemotePoolHTTPClient(c *http.Client) RemotePoolOption {
+	return func(opts *remotePoolOptions) error {
+		opts.httpClient = c
+		return nil
+	}
+}
+
+// WithRemotePoolHedgeDelay sets the time to wait for the response of a remote
+// gateway before sending the same request to the next fastest one. Defaults to
+// [DefaultRemotePoolHedgeDelay].
+func WithRemotePoolHedgeDelay(d time.Duration) RemotePoolOption {
+	return func(opts *remotePoolOptions) error {
+		if d <= 0 {
+			return errors.New("hedge delay must be positive")
+		}
+		opts.hedgeDelay = d
+		return nil
+	}
+}
+
+// WithRemotePoolMaxAttempts sets the maximum number of remote gateways a
+// request is sent to. Set it to 1 to disable hedging and retries. Defaults to
+// [DefaultRemotePoolMaxAttempts].
+func WithRemotePoolMaxAttempts(n int) RemotePoolOption {
+	return func(opts *remotePoolOptions) error {
+		if n < 1 {
+			return errors.New("max attempts must be at least 1")
+		}
+		opts.maxAttempts = n
+		return nil
+	}
+}
+
+// WithRemotePoolEviction sets the number of consecutive failures after which
+// a remote gateway is evicted, and the duration of its first eviction, which
+// doubles with each consecutive eviction. Defaults to
+// [DefaultRemotePoolEvictAfter] and [DefaultRemotePoolEvictFor].
+func WithRemotePoolEviction(failures int, d time.Duration) RemotePoolOption {
+	return func(opts *remotePoolOptions) error {
+		if failures < 1 {
+			return errors.New("eviction failures must be at least 1")
+		}
+		if d <= 0 {
+			return errors.New("eviction duration must be positive")
+		}
+		opts.evictAfter = failures
+		opts.evictFor = d
+		return nil
+	}
+}
+
+// WithRemotePoolPrometheusRegistry sets the registry of the per-gateway
+// metrics. If nil, [prometheus.DefaultRegisterer] is used.
+func WithRemotePoolPrometheusRegistry(reg prometheus.Registerer) RemotePoolOption {
+	return func(opts *remotePoolOptions) error {
+		opts.promReg = reg
+		return nil
+	}
+}
+
+// NewRemotePool creates a [RemotePool] of the given gateways.
+func NewRemotePool(gatewayURL []string, opts ...RemotePoolOption) (*RemotePool, error) {
+	if len(gatewayURL) == 0 {
+		return nil, errors.New("missing gateway URLs to which to proxy")
+	}
+
+	options := remotePoolOptions{
+		hedgeDelay:  DefaultRemotePoolHedgeDelay,
+		maxAttempts: DefaultRemotePoolMaxAttempts,
+		evictAfter:  DefaultRemotePoolEvictAfter,
+		evictFor:    DefaultRemotePoolEvictFor,
+	}
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, err
+		}
+	}
+	if options.httpClient == nil {
+		options.httpClient = newRemoteHTTPClient()
+	}
+	if options.promReg == nil {
+		options.promReg = prometheus.DefaultRegisterer
+	}
+
+	p := &RemotePool{
+		httpClient:  options.httpClient,
+		hedgeDelay:  options.hedgeDelay,
+		maxAttempts: options.maxAttempts,
+		evictAfter:  options.evictAfter,
+		evictFor:    options.evictFor,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	seen := map[string]struct{}{}
+	for _, u := range gatewayURL {
+		if _, ok := seen[u]; ok {
+			continue
+		}
+		seen[u] = struct{}{}
+		p.endpoints = append(p.endpoints, &remoteEndpoint{url: u})
+	}
+	p.registerMetrics(options.promReg)
+	for _, e := range p.endpoints {
+		p.setEndpointMetrics(e)
+	}
+	return p, nil
+}
+
+// registerMetrics registers the metrics of the pool, or reuses the ones
+// registered by another pool, so that the pools of a gateway report their
+// requests together.
+func (p *RemotePool) registerMetrics(promReg prometheus.Registerer) {
+	p.requestsMetric = registerRemotePoolMetric(promReg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "gw_remote_pool",
+		Name:      "requests_total",
+		Help:      "The number of requests sent to each remote gateway, by result.",
+	}, []string{"endpoint", "result"}))
+
+	p.durationMetric = registerRemotePoolMetric(promReg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ipfs",
+		Subsystem: "gw_remote_pool",
+		Name:      "response_duration_seconds",
+		Help:      "The time to the response headers of each remote gateway.",
+		Buckets:   defaultDurationHistogramBuckets,
+	}, []string{"endpoint"}))
+
+	p.hedgesMetric = registerRemotePoolMetric(promReg, prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "gw_remote_pool",
+		Name:      "hedged_requests_total",
+		Help:      "The number of requests sent to another remote gateway because the previous one was slow to respond.",
+	}))
+
+	// The gauges are set by the pool rather than read from it, so that the
+	// registry does not keep the pool alive.
+	p.latencyMetric = registerRemotePoolMetric(promReg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ipfs",
+		Subsystem: "gw_remote_pool",
+		Name:      "latency_seconds",
+		Help:      "The moving average of the time to the response headers of each remote gateway.",
+	}, []string{"endpoint"}))
+
+	p.errorRateMetric = registerRemotePoolMetric(promReg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ipfs",
+		Subsystem: "gw_remote_pool",
+		Name:      "error_rate",
+		Help:      "The moving average of the rate of failed requests of each remote gateway.",
+	}, []string{"endpoint"}))
+
+	p.evictedMetric = registerRemotePoolMetric(promReg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ipfs",
+		Subsystem: "gw_remote_pool",
+		Name:      "evicted",
+		Help:      "Whether each remote gateway is currently evicted from the pool.",
+	}, []string{"endpoint"}))
+}
+
+// registerRemotePoolMetric registers metric, or returns the metric of the
+// same name registered by another pool.
+func registerRemotePoolMetric[T prometheus.Collector](promReg prometheus.Registerer, metric T) T {
+	if err := promReg.Register(metric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		log.Errorf("failed to register %v: %v", metric, err)
+	}
+	return metric
+}
+
+// Stats returns the statistics of the remote gateways of the pool.
+func (p *RemotePool) Stats() []RemoteEndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]RemoteEndpointStats, len(p.endpoints))
+	for i, e := range p.endpoints {
+		stats[i] = RemoteEndpointStats{
+			URL:       e.url,
+			Latency:   e.latency,
+			ErrorRate: e.errorRate,
+			Requests:  e.requests,
+			Failures:  e.failures,
+		}
+		if now.Before(e.evictedUntil) {
+			stats[i].EvictedUntil = e.evictedUntil
+		}
+	}
+	return stats
+}
+
+// candidates returns the remote gateways to send a request to, fastest first.
+// Evicted gateways are only used when all of them are evicted.
+func (p *RemotePool) candidates() []*remoteEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var healthy, evicted []*remoteEndpoint
+	for _, e := range p.endpoints {
+		if now.Before(e.evictedUntil) {
+			evicted = append(evicted, e)
+		} else {
+			if !e.evictedUntil.IsZero() {
+				e.evictedUntil = time.Time{}
+				p.setEndpointMetrics(e)
+			}
+			healthy = append(healthy, e)
+		}
+	}
+
+	candidates := healthy
+	if len(candidates) == 0 {
+		sort.SliceStable(evicted, func(i, j int) bool {
+			return evicted[i].evictedUntil.Before(evicted[j].evictedUntil)
+		})
+		candidates = evicted
+	} else {
+		prior := p.priorLatency()
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].score(prior) < healthy[j].score(prior)
+		})
+		// Gateways which were never tried are measured first, exploring
+		// the others is only needed once they all are.
+		if len(healthy) > 1 && !healthy[0].untried() && p.rand.Float64() < remotePoolExploreRate {
+			j := 1 + p.rand.Intn(len(healthy)-1)
+			healthy[0], healthy[j] = healthy[j], healthy[0]
+		}
+	}
+
+	if len(candidates) > p.maxAttempts {
+		candidates = candidates[:p.maxAttempts]
+	}
+	return candidates
+}
+
+// priorLatency is the latency assumed for the remote gateways which failed
+// without ever responding successfully: the average latency of the others,
+// or the hedge delay when none has been measured. The lock must be held.
+func (p *RemotePool) priorLatency() time.Duration {
+	var sum time.Duration
+	var n int
+	for _, e := range p.endpoints {
+		if e.latency > 0 {
+			sum += e.latency
+			n++
+		}
+	}
+	if n == 0 {
+		return p.hedgeDelay
+	}
+	return sum / time.Duration(n)
+}
+
+// untried returns whether no request to e has completed yet.
+func (e *remoteEndpoint) untried() bool {
+	return e.requests == 0
+}
+
+// score ranks remote gateways by their latency, or prior when it has not
+// been measured, penalized by their error rate. Gateways which were never
+// tried come first so that they get measured.
+func (e *remoteEndpoint) score(prior time.Duration) float64 {
+	if e.untried() {
+		return 0
+	}
+	latency := e.latency
+	if latency == 0 {
+		latency = prior
+	}
+	return float64(latency) * (1 + 4*e.errorRate)
+}
+
+// record updates the statistics of e with the outcome of a request. latency
+// is zero when no response was received, which is a failure.
+func (p *RemotePool) record(e *remoteEndpoint, latency time.Duration, failed bool) {
+	result := "success"
+	if failed {
+		result = "failure"
+	}
+	p.requestsMetric.WithLabelValues(e.url, result).Inc()
+	if latency > 0 {
+		p.durationMetric.WithLabelValues(e.url).Observe(latency.Seconds())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.setEndpointMetrics(e)
+
+	e.requests++
+	if !failed {
+		// Only successful responses are measured, as errors are often
+		// returned faster.
+		if e.latency == 0 {
+			e.latency = latency
+		} else {
+			e.latency = time.Duration(float64(e.latency)*(1-remotePoolEWMAWeight) + float64(latency)*remotePoolEWMAWeight)
+		}
+		e.errorRate *= 1 - remotePoolEWMAWeight
+		e.consecutiveFailures = 0
+		e.evictions = 0
+		return
+	}
+	p.fail(e, false)
+}
+
+// recordInvalid updates the statistics of e with a response whose body
+// turned out to be truncated or invalid, after its headers were recorded as
+// a success. Such gateways are evicted right away, since their fast responses
+// would otherwise keep them first.
+func (p *RemotePool) recordInvalid(e *remoteEndpoint) {
+	p.requestsMetric.WithLabelValues(e.url, "invalid").Inc()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.setEndpointMetrics(e)
+
+	p.fail(e, true)
+}
+
+// fail accounts for a failed request to e, and evicts e after evictAfter
+// consecutive failures, or right away when evict is true. The lock must be
+// held.
+func (p *RemotePool) fail(e *remoteEndpoint, evict bool) {
+	e.errorRate = e.errorRate*(1-remotePoolEWMAWeight) + remotePoolEWMAWeight
+	e.failures++
+	e.consecutiveFailures++
+	if evict || e.consecutiveFailures >= p.evictAfter {
+		d := p.evictFor << min(e.evictions, remotePoolMaxEvictShift)
+		e.evictions++
+		e.consecutiveFailures = 0
+		e.evictedUntil = time.Now().Add(d)
+		log.Warnw("evicting failing remote gateway", "endpoint", e.url, "duration", d)
+	}
+}
+
+// setEndpointMetrics publishes the statistics of e. The lock must be held.
+func (p *RemotePool) setEndpointMetrics(e *remoteEndpoint) {
+	p.latencyMetric.WithLabelValues(e.url).Set(e.latency.Seconds())
+	p.errorRateMetric.WithLabelValues(e.url).Set(e.errorRate)
+	evicted := 0.0
+	if time.Now().Before(e.evictedUntil) {
+		evicted = 1
+	}
+	p.evictedMetric.WithLabelValues(e.url).Set(evicted)
+}
+
+// isRemoteFailure returns whether the status code of a response means that the
+// remote gateway is unhealthy, rather than that it does not have the content.
+func isRemoteFailure(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
+
+type remoteAttempt struct {
+	endpoint *remoteEndpoint
+	resp     *http.Response
+	err      error
+}
+
+// get sends a GET request for urlPath to the fastest remote gateways. When a
+// gateway does not respond within the hedge delay, the request is also sent to
+// the next fastest one, and when it fails, it is sent to the next one right
+// away. The first successful response is returned, and the other requests are
+// cancelled. When all attempts fail, the last response with an error status is
+// returned, or the last error if there is none.
+func (p *RemotePool) get(ctx context.Context, urlPath string, header http.Header) (*http.Response, error) {
+	candidates := p.candidates()
+	attempts := make(chan remoteAttempt, len(candidates))
+	cancels := make([]context.CancelFunc, len(candidates))
+
+	launch := func(i int) {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		go func(e *remoteEndpoint) {
+			resp, err := p.send(attemptCtx, e, urlPath, header)
+			if resp != nil {
+				resp.Body = &remoteResponseBody{
+					ReadCloser: &cancelOnClose{ReadCloser: resp.Body, cancel: cancel},
+					invalid:    func() { p.recordInvalid(e) },
+				}
+			} else {
+				cancel()
+			}
+			attempts <- remoteAttempt{endpoint: e, resp: resp, err: err}
+		}(candidates[i])
+	}
+
+	launch(0)
+	launched, pending := 1, 1
+	hedge := time.NewTimer(p.hedgeDelay)
+	defer hedge.Stop()
+
+	var (
+		lastResp *http.Response
+		lastErr  error
+	)
+	for pending > 0 {
+		select {
+		case <-hedge.C:
+			if launched < len(candidates) {
+				p.hedgesMetric.Inc()
+				launch(launched)
+				launched++
+				pending++
+				hedge.Reset(p.hedgeDelay)
+			}
+		case a := <-attempts:
+			pending--
+			if a.err == nil && a.resp.StatusCode == http.StatusOK {
+				if lastResp != nil {
+					lastResp.Body.Close()
+				}
+				for i, cancel := range cancels[:launched] {
+					if candidates[i] != a.endpoint {
+						cancel()
+					}
+				}
+				go drainRemoteAttempts(attempts, pending)
+				return a.resp, nil
+			}
+
+			if a.err != nil {
+				lastErr = a.err
+			} else {
+				if lastResp != nil {
+					lastResp.Body.Close()
+				}
+				lastResp = a.resp
+			}
+			if ctx.Err() == nil && launched < len(candidates) {
+				launch(launched)
+				launched++
+				pending++
+				if !hedge.Stop() {
+					select {
+					case <-hedge.C:
+					default:
+					}
+				}
+				hedge.Reset(p.hedgeDelay)
+			}
+		}
+	}
+
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, lastErr
+}
+
+// send sends a GET request for urlPath to e, and records its outcome.
+func (p *RemotePool) send(ctx context.Context, e *remoteEndpoint, urlPath string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url+urlPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	start := time.Now()
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		// Cancelled requests say nothing about the health of the gateway.
+		if ctx.Err() == nil {
+			p.record(e, 0, true)
+		}
+		return nil, fmt.Errorf("remote gateway %s: %w", e.url, err)
+	}
+	p.record(e, time.Since(start), isRemoteFailure(resp.StatusCode))
+	return resp, nil
+}
+
+// drainRemoteAttempts closes the responses of the n attempts which are still
+// pending after another one succeeded.
+func drainRemoteAttempts(attempts <-chan remoteAttempt, n int) {
+	for ; n > 0; n-- {
+		if a := <-attempts; a.resp != nil {
+			a.resp.Body.Close()
+		}
+	}
+}
+
+// remoteResponseBody is the body of a response of a [RemotePool]. invalid
+// reports that the body turned out to be truncated or invalid, see
+// [reportInvalidResponse].
+type remoteResponseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	invalid func()
+}
+
+// reportInvalidResponse tells the remote gateways which returned resp that
+// its body was truncated or did not validate, which is a failure of the
+// gateway which sent it. Responses of other remote gateways are ignored.
+func reportInvalidResponse(resp *http.Response) {
+	if b, ok := resp.Body.(*remoteResponseBody); ok {
+		b.once.Do(b.invalid)
+	}
+}
+
+// isInvalidRemoteData returns whether err, returned while reading the body of
+// a response from a remote gateway, means that the body was truncated or
+// invalid.
+func isInvalidRemoteData(err error) bool {
+	var invalid ErrInvalidResponse
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, blocks.ErrWrongHash) || errors.As(err, &invalid)
+}
+
+// cancelOnClose cancels the context of a request when its response body is
+// closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
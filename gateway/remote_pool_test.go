@@ -0,0 +1,281 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// newRemoteGatewayServer returns a server which responds with status and body
+// after delay, and counts its requests.
+func newRemoteGatewayServer(t *testing.T, delay time.Duration, status int, body string) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func newTestRemotePool(t *testing.T, gatewayURL []string, opts ...RemotePoolOption) *RemotePool {
+	opts = append([]RemotePoolOption{WithRemotePoolPrometheusRegistry(prometheus.NewRegistry())}, opts...)
+	pool, err := NewRemotePool(gatewayURL, opts...)
+	require.NoError(t, err)
+	return pool
+}
+
+func mustGetFromPool(t *testing.T, pool *RemotePool) (int, string) {
+	resp, err := pool.get(context.Background(), "/ipfs/bafkqaaa", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestRemotePool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Slow gateways are hedged", func(t *testing.T) {
+		t.Parallel()
+
+		slow, _ := newRemoteGatewayServer(t, time.Minute, http.StatusOK, "slow")
+		fast, fastRequests := newRemoteGatewayServer(t, 0, http.StatusOK, "fast")
+		pool := newTestRemotePool(t, []string{slow.URL, fast.URL}, WithRemotePoolHedgeDelay(10*time.Millisecond))
+
+		for i := 0; i < 5; i++ {
+			status, body := mustGetFromPool(t, pool)
+			require.Equal(t, http.StatusOK, status)
+			require.Equal(t, "fast", body)
+		}
+		require.EqualValues(t, 5, fastRequests.Load())
+
+		stats := pool.Stats()
+		require.Zero(t, stats[0].Latency)
+		require.NotZero(t, stats[1].Latency)
+	})
+
+	t.Run("Fastest gateways are preferred", func(t *testing.T) {
+		t.Parallel()
+
+		slow, slowRequests := newRemoteGatewayServer(t, 50*time.Millisecond, http.StatusOK, "slow")
+		fast, _ := newRemoteGatewayServer(t, 0, http.StatusOK, "fast")
+		pool := newTestRemotePool(t, []string{slow.URL, fast.URL}, WithRemotePoolHedgeDelay(time.Second))
+
+		// Every gateway is measured before the fastest one is preferred.
+		for i := 0; i < 2; i++ {
+			status, _ := mustGetFromPool(t, pool)
+			require.Equal(t, http.StatusOK, status)
+		}
+		require.EqualValues(t, 1, slowRequests.Load())
+
+		fastBodies := 0
+		for i := 0; i < 20; i++ {
+			if _, body := mustGetFromPool(t, pool); body == "fast" {
+				fastBodies++
+			}
+		}
+		// Some requests may go to the slow gateway to measure it again.
+		require.GreaterOrEqual(t, fastBodies, 15)
+	})
+
+	t.Run("Failing gateways are retried and evicted", func(t *testing.T) {
+		t.Parallel()
+
+		failing, failingRequests := newRemoteGatewayServer(t, 0, http.StatusBadGateway, "failing")
+		healthy, _ := newRemoteGatewayServer(t, 0, http.StatusOK, "healthy")
+		pool := newTestRemotePool(t, []string{failing.URL, healthy.URL},
+			WithRemotePoolHedgeDelay(time.Second),
+			WithRemotePoolEviction(1, time.Hour),
+		)
+
+		for i := 0; i < 10; i++ {
+			status, body := mustGetFromPool(t, pool)
+			require.Equal(t, http.StatusOK, status)
+			require.Equal(t, "healthy", body)
+		}
+		require.EqualValues(t, 1, failingRequests.Load())
+
+		stats := pool.Stats()
+		require.False(t, stats[0].EvictedUntil.IsZero())
+		require.EqualValues(t, 1, stats[0].Failures)
+		require.Greater(t, stats[0].ErrorRate, 0.0)
+		require.True(t, stats[1].EvictedUntil.IsZero())
+		require.Zero(t, stats[1].Failures)
+	})
+
+	t.Run("Evicted gateways are used when all are evicted", func(t *testing.T) {
+		t.Parallel()
+
+		failing, failingRequests := newRemoteGatewayServer(t, 0, http.StatusServiceUnavailable, "failing")
+		pool := newTestRemotePool(t, []string{failing.URL}, WithRemotePoolEviction(1, time.Hour))
+
+		for i := 0; i < 3; i++ {
+			status, _ := mustGetFromPool(t, pool)
+			require.Equal(t, http.StatusServiceUnavailable, status)
+		}
+		require.EqualValues(t, 3, failingRequests.Load())
+	})
+
+	t.Run("Last error response is returned", func(t *testing.T) {
+		t.Parallel()
+
+		missing1, _ := newRemoteGatewayServer(t, 0, http.StatusNotFound, "not found")
+		missing2, _ := newRemoteGatewayServer(t, 0, http.StatusNotFound, "not found")
+		pool := newTestRemotePool(t, []string{missing1.URL, missing2.URL})
+
+		status, body := mustGetFromPool(t, pool)
+		require.Equal(t, http.StatusNotFound, status)
+		require.Equal(t, "not found", body)
+
+		// Missing content does not make gateways unhealthy.
+		for _, s := range pool.Stats() {
+			require.Zero(t, s.Failures)
+		}
+	})
+}
+
+func TestRemoteEndpointScore(t *testing.T) {
+	t.Parallel()
+
+	pool := newTestRemotePool(t, []string{"http://a", "http://b", "http://c"}, WithRemotePoolHedgeDelay(time.Second))
+	untried, failed, measured := pool.endpoints[0], pool.endpoints[1], pool.endpoints[2]
+
+	// Without measured latencies, the hedge delay is assumed.
+	require.Equal(t, time.Second, pool.priorLatency())
+
+	pool.record(failed, 0, true)
+	pool.record(measured, 100*time.Millisecond, false)
+	prior := pool.priorLatency()
+	require.Equal(t, 100*time.Millisecond, prior)
+
+	// Gateways which never responded are ranked after the measured ones,
+	// unless they were never tried.
+	require.Zero(t, untried.score(prior))
+	require.Greater(t, failed.score(prior), measured.score(prior))
+	require.Equal(t, []*remoteEndpoint{untried, measured, failed}, pool.candidates())
+}
+
+func TestRemoteBlockstoreFromPool(t *testing.T) {
+	t.Parallel()
+
+	blk := blocks.NewBlock([]byte("hello"))
+	failing, _ := newRemoteGatewayServer(t, 0, http.StatusInternalServerError, "")
+	healthy, _ := newRemoteGatewayServer(t, 0, http.StatusOK, "hello")
+	reg := prometheus.NewRegistry()
+	pool := newTestRemotePool(t, []string{failing.URL, healthy.URL}, WithRemotePoolPrometheusRegistry(reg))
+
+	bs, err := NewRemoteBlockstoreFromPool(pool)
+	require.NoError(t, err)
+	got, err := bs.Get(context.Background(), blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk.RawData(), got.RawData())
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	endpoints := map[string]struct{}{}
+	for _, f := range families {
+		if f.GetName() != "ipfs_gw_remote_pool_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "endpoint" {
+					endpoints[l.GetValue()] = struct{}{}
+				}
+			}
+		}
+	}
+	require.Len(t, endpoints, 2)
+
+	_, err = NewRemoteBlockstoreFromPool(nil)
+	require.Error(t, err)
+}
+
+func TestRemotePoolsShareMetrics(t *testing.T) {
+	t.Parallel()
+
+	first, _ := newRemoteGatewayServer(t, 0, http.StatusOK, "first")
+	second, _ := newRemoteGatewayServer(t, 0, http.StatusOK, "second")
+	reg := prometheus.NewRegistry()
+	_ = newTestRemotePool(t, []string{first.URL}, WithRemotePoolPrometheusRegistry(reg))
+	pool := newTestRemotePool(t, []string{second.URL}, WithRemotePoolPrometheusRegistry(reg))
+	mustGetFromPool(t, pool)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	requests := map[string]float64{}
+	latencies := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var endpoint string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "endpoint" {
+					endpoint = l.GetValue()
+				}
+			}
+			switch f.GetName() {
+			case "ipfs_gw_remote_pool_requests_total":
+				requests[endpoint] += m.GetCounter().GetValue()
+			case "ipfs_gw_remote_pool_latency_seconds":
+				latencies[endpoint] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	// The requests of the second pool are counted with the metrics
+	// registered by the first one.
+	require.Equal(t, map[string]float64{second.URL: 1}, requests)
+	require.Len(t, latencies, 2)
+	require.Greater(t, latencies[second.URL], 0.0)
+}
+
+func TestRemotePoolInvalidResponses(t *testing.T) {
+	t.Parallel()
+
+	blk := blocks.NewBlock([]byte("hello"))
+	// The fastest gateway responds with data which does not match the CID.
+	invalid, invalidRequests := newRemoteGatewayServer(t, 0, http.StatusOK, "invalid")
+	valid, _ := newRemoteGatewayServer(t, 50*time.Millisecond, http.StatusOK, "hello")
+	pool := newTestRemotePool(t, []string{invalid.URL, valid.URL},
+		WithRemotePoolHedgeDelay(time.Second), WithRemotePoolEviction(10, time.Minute))
+
+	// Measure both gateways, so that the invalid one is the fastest.
+	mustGetFromPool(t, pool)
+	mustGetFromPool(t, pool)
+
+	bs, err := NewRemoteBlockstoreFromPool(pool)
+	require.NoError(t, err)
+	// The invalid gateway is tried first, unless the pool explores.
+	require.Eventually(t, func() bool {
+		_, err := bs.Get(context.Background(), blk.Cid())
+		return errors.Is(err, blocks.ErrWrongHash)
+	}, 5*time.Second, time.Millisecond)
+
+	// It is then evicted, although it responded with 200.
+	n := invalidRequests.Load()
+	got, err := bs.Get(context.Background(), blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk.RawData(), got.RawData())
+	require.Equal(t, n, invalidRequests.Load())
+	for _, st := range pool.Stats() {
+		if st.URL == invalid.URL {
+			require.False(t, st.EvictedUntil.IsZero())
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package gateway
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/boxo/path"
+	cid "github.com/ipfs/go-cid"
+)
+
+// maxCachedResponseSize is the size above which responses are not stored in
+// the [ResponseCache].
+const maxCachedResponseSize = 4 << 20
+
+// CachedResponse is a complete response stored in a [ResponseCache].
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Roots are the CIDs which the content path resolved to, checked
+	// against the denylist when the response is served.
+	Roots []cid.Cid
+}
+
+func (r *CachedResponse) size() int64 {
+	size := int64(len(r.Body))
+	for k, vs := range r.Header {
+		size += int64(len(k))
+		for _, v := range vs {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
+
+// ResponseCache stores responses to requests for immutable /ipfs/ content
+// paths. When configured, the handler serves the responses it finds there,
+// including HTTP 304 Not Modified replies to If-None-Match requests, without
+// using the [IPFSBackend].
+//
+// Only successful GET responses marked immutable by their Cache-Control
+// header, with an ETag and smaller than 4 MiB are stored. The key identifies
+// the content path, the response format and parameters, and the requested
+// range. The CIDs the content path resolved to are stored with the response
+// and checked against [Config.Denylist] whenever it is served.
+type ResponseCache interface {
+	Get(ctx context.Context, key string) (*CachedResponse, bool)
+	Put(ctx context.Context, key string, resp *CachedResponse)
+}
+
+// responseCacheKey returns the key of the response to r in the response
+// cache, or "" when the response cannot be cached.
+func responseCacheKey(r *http.Request, contentPath path.Path) string {
+	if contentPath.Mutable() || r.Header.Get("If-Range") != "" {
+		return ""
+	}
+	responseFormat, formatParams, err := customResponseFormat(r)
+	if err != nil {
+		return ""
+	}
+
+	params := make([]string, 0, len(formatParams))
+	for k, v := range formatParams {
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+
+	// The host is part of the key since the response may depend on it, for
+	// instance when deserialized responses are only enabled for some hosts.
+	return strings.Join([]string{
+		r.Host,
+		contentPath.String(),
+		responseFormat,
+		strings.Join(params, ";"),
+		r.URL.RawQuery,
+		r.Header.Get("Range"),
+	}, "\n")
+}
+
+// serveCachedResponse replies to r from the response cache and returns true
+// if a response was found.
+func (i *handler) serveCachedResponse(w http.ResponseWriter, r *http.Request, key string) bool {
+	resp, ok := i.config.ResponseCache.Get(r.Context(), key)
+	if !ok {
+		i.responseCacheMetric.WithLabelValues("miss").Inc()
+		return false
+	}
+
+	// The content path was checked by the caller, but the CIDs it resolved
+	// to may have been blocked since the response was stored.
+	if i.config.Denylist != nil {
+		for _, c := range resp.Roots {
+			if err := i.config.Denylist.CheckCid(c); err != nil {
+				i.webError(w, r, err, http.StatusGone)
+				return true
+			}
+		}
+	}
+
+	h := w.Header()
+	for k, vs := range resp.Header {
+		h[k] = append([]string(nil), vs...)
+	}
+
+	if etag := resp.Header.Get("Etag"); etag != "" && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		i.responseCacheMetric.WithLabelValues("not_modified").Inc()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	i.responseCacheMetric.WithLabelValues("hit").Inc()
+	w.WriteHeader(resp.StatusCode)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(resp.Body)
+	}
+	return true
+}
+
+// storeResponse stores the response recorded by rec if it can be cached.
+func (i *handler) storeResponse(ctx context.Context, key string, rec *responseRecorder) {
+	if rec.failed || rec.header == nil {
+		return
+	}
+	if rec.code != http.StatusOK && rec.code != http.StatusPartialContent {
+		return
+	}
+	// Errors after the headers were sent are reported in X-Stream-Error.
+	if rec.ResponseWriter.Header().Get("X-Stream-Error") != "" {
+		return
+	}
+	if !strings.Contains(rec.header.Get("Cache-Control"), "immutable") || rec.header.Get("Etag") == "" {
+		return
+	}
+
+	i.config.ResponseCache.Put(ctx, key, &CachedResponse{
+		StatusCode: rec.code,
+		Header:     rec.header,
+		Body:       bytes.Clone(rec.body.Bytes()),
+		Roots:      parseIpfsRoots(rec.header.Get("X-Ipfs-Roots")),
+	})
+}
+
+// parseIpfsRoots returns the CIDs listed in an X-Ipfs-Roots header.
+func parseIpfsRoots(header string) []cid.Cid {
+	var roots []cid.Cid
+	for _, s := range strings.Split(header, ",") {
+		c, err := cid.Decode(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+		roots = append(roots, c)
+	}
+	return roots
+}
+
+// responseRecorder passes a response through while recording it for the
+// response cache.
+type responseRecorder struct {
+	http.ResponseWriter
+	code   int
+	header http.Header
+	body   bytes.Buffer
+	// failed is set when the response cannot be stored, because it is too
+	// large or could not be written completely.
+	failed bool
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.code == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	if err != nil || rec.body.Len()+n > maxCachedResponseSize {
+		rec.failed = true
+		rec.body = bytes.Buffer{}
+	}
+	if !rec.failed {
+		rec.body.Write(p[:n])
+	}
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sizedLRU is a least recently used set of keys bounded by the total size of
+// their values.
+type sizedLRU[V any] struct {
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+	onEvict  func(key string, v V)
+}
+
+type sizedLRUEntry[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+func newSizedLRU[V any](maxBytes int64, onEvict func(string, V)) *sizedLRU[V] {
+	return &sizedLRU[V]{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		onEvict:  onEvict,
+	}
+}
+
+func (c *sizedLRU[V]) get(key string) (V, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*sizedLRUEntry[V]).value, true
+}
+
+// add inserts or replaces key as the most recently used entry and evicts the
+// least recently used ones to stay under the size limit.
+func (c *sizedLRU[V]) add(key string, v V, size int64) {
+	if e, ok := c.items[key]; ok {
+		c.remove(e, false)
+	}
+	c.items[key] = c.ll.PushFront(&sizedLRUEntry[V]{key: key, value: v, size: size})
+	c.size += size
+	for c.size > c.maxBytes && c.ll.Len() > 0 {
+		c.remove(c.ll.Back(), true)
+	}
+}
+
+func (c *sizedLRU[V]) remove(e *list.Element, evicted bool) {
+	entry := c.ll.Remove(e).(*sizedLRUEntry[V])
+	delete(c.items, entry.key)
+	c.size -= entry.size
+	if evicted && c.onEvict != nil {
+		c.onEvict(entry.key, entry.value)
+	}
+}
+
+type memoryResponseCache struct {
+	lk  sync.Mutex
+	lru *sizedLRU[*CachedResponse]
+}
+
+// NewMemoryResponseCache returns a [ResponseCache] keeping up to maxBytes of
+// responses in memory, evicting the least recently used ones.
+func NewMemoryResponseCache(maxBytes int64) ResponseCache {
+	return &memoryResponseCache{lru: newSizedLRU[*CachedResponse](maxBytes, nil)}
+}
+
+func (c *memoryResponseCache) Get(_ context.Context, key string) (*CachedResponse, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.lru.get(key)
+}
+
+func (c *memoryResponseCache) Put(_ context.Context, key string, resp *CachedResponse) {
+	size := resp.size()
+	if size > c.lru.maxBytes {
+		return
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.lru.add(key, resp, size)
+}
+
+type diskResponseCache struct {
+	dir string
+
+	lk  sync.Mutex
+	lru *sizedLRU[struct{}]
+}
+
+// NewDiskResponseCache returns a [ResponseCache] keeping up to maxBytes of
+// responses in files in dir, evicting the least recently used ones. Responses
+// stored by a previous instance are reused.
+func NewDiskResponseCache(dir string, maxBytes int64) (ResponseCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &diskResponseCache{dir: dir}
+	c.lru = newSizedLRU(maxBytes, func(name string, _ struct{}) {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warnf("failed to remove cached response %s: %s", name, err)
+		}
+	})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type existing struct {
+		name string
+		info fs.FileInfo
+	}
+	var files []existing
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), ".tmp-") {
+			// Left over by an interrupted Put.
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, existing{e.Name(), info})
+	}
+	// Add the oldest files first so that they are evicted first.
+	sort.Slice(files, func(a, b int) bool {
+		return files[a].info.ModTime().Before(files[b].info.ModTime())
+	})
+	for _, f := range files {
+		c.lru.add(f.name, struct{}{}, f.info.Size())
+	}
+	return c, nil
+}
+
+func (c *diskResponseCache) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *diskResponseCache) Get(_ context.Context, key string) (*CachedResponse, bool) {
+	name := c.fileName(key)
+	c.lk.Lock()
+	_, ok := c.lru.get(name)
+	c.lk.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	var resp CachedResponse
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&resp); err != nil {
+		log.Warnf("failed to decode cached response %s: %s", name, err)
+		return nil, false
+	}
+	return &resp, true
+}
+
+func (c *diskResponseCache) Put(_ context.Context, key string, resp *CachedResponse) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(resp); err != nil {
+		log.Warnf("failed to encode response: %s", err)
+		return
+	}
+	if int64(buf.Len()) > c.lru.maxBytes {
+		return
+	}
+
+	// Write to a temporary file first so that readers never see a partial
+	// response.
+	name := c.fileName(key)
+	tmp, err := os.CreateTemp(c.dir, ".tmp-")
+	if err != nil {
+		log.Warnf("failed to store response: %s", err)
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		log.Warnf("failed to store response: %s", err)
+		return
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.lru.add(name, struct{}{}, int64(buf.Len()))
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/boxo/denylist"
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	"github.com/stretchr/testify/require"
+)
+
+type countingBackend struct {
+	IPFSBackend
+	calls atomic.Int32
+}
+
+func (cb *countingBackend) GetBlock(ctx context.Context, p path.ImmutablePath) (ContentPathMetadata, files.File, error) {
+	cb.calls.Add(1)
+	return cb.IPFSBackend.GetBlock(ctx, p)
+}
+
+func (cb *countingBackend) ResolvePath(ctx context.Context, p path.ImmutablePath) (ContentPathMetadata, error) {
+	cb.calls.Add(1)
+	return cb.IPFSBackend.ResolvePath(ctx, p)
+}
+
+func testResponseCache(t *testing.T, cache ResponseCache) {
+	mock, root := newMockBackend(t, "fixtures.car")
+	backend := &countingBackend{IPFSBackend: mock}
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		ResponseCache:         cache,
+	})
+	url := ts.URL + "/ipfs/" + root.String() + "?format=raw"
+
+	res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	etag := res.Header.Get("Etag")
+	require.NotEmpty(t, etag)
+	calls := backend.calls.Load()
+	require.NotZero(t, calls)
+
+	// Served again from the cache.
+	res = mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	cached, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, cached)
+	require.Equal(t, etag, res.Header.Get("Etag"))
+	require.Equal(t, immutableCacheControl, res.Header.Get("Cache-Control"))
+	require.Equal(t, calls, backend.calls.Load())
+
+	// If-None-Match is answered from the cache too.
+	req := mustNewRequest(t, http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", etag)
+	res = mustDoWithoutRedirect(t, req)
+	require.Equal(t, http.StatusNotModified, res.StatusCode)
+	require.Equal(t, calls, backend.calls.Load())
+
+	// Ranges are cached separately.
+	req = mustNewRequest(t, http.MethodGet, url, nil)
+	req.Header.Set("Range", "bytes=0-1")
+	res = mustDoWithoutRedirect(t, req)
+	require.Equal(t, http.StatusPartialContent, res.StatusCode)
+	partial, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, body[:2], partial)
+	require.Greater(t, backend.calls.Load(), calls)
+}
+
+func TestMemoryResponseCache(t *testing.T) {
+	testResponseCache(t, NewMemoryResponseCache(1<<20))
+}
+
+func TestDiskResponseCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskResponseCache(dir, 1<<20)
+	require.NoError(t, err)
+	testResponseCache(t, cache)
+
+	// Responses survive restarts.
+	ctx := context.Background()
+	cache.Put(ctx, "key", &CachedResponse{StatusCode: http.StatusOK, Header: http.Header{"Etag": {`"x"`}}, Body: []byte("body")})
+	cache, err = NewDiskResponseCache(dir, 1<<20)
+	require.NoError(t, err)
+	resp, ok := cache.Get(ctx, "key")
+	require.True(t, ok)
+	require.Equal(t, []byte("body"), resp.Body)
+	require.Equal(t, `"x"`, resp.Header.Get("Etag"))
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryResponseCache(10)
+	put := func(key string) {
+		cache.Put(ctx, key, &CachedResponse{StatusCode: http.StatusOK, Body: []byte("12345")})
+	}
+	put("a")
+	put("b")
+	_, ok := cache.Get(ctx, "a")
+	require.True(t, ok)
+	put("c")
+
+	_, ok = cache.Get(ctx, "b")
+	require.False(t, ok)
+	_, ok = cache.Get(ctx, "a")
+	require.True(t, ok)
+}
+
+func TestResponseCacheDenylist(t *testing.T) {
+	backend, root := newMockBackend(t, "fixtures.car")
+	p, err := path.Join(path.FromCid(root), "subdir", "fnord")
+	require.NoError(t, err)
+	fnord, err := backend.resolvePathNoRootsReturned(context.Background(), p)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "denylist")
+	require.NoError(t, os.WriteFile(file, []byte("version: 1\n---\n"), 0o644))
+	dl, err := denylist.New(context.Background(), 0, file)
+	require.NoError(t, err)
+
+	ts := newTestServerWithConfig(t, backend, Config{
+		DeserializedResponses: true,
+		Denylist:              dl,
+		ResponseCache:         NewMemoryResponseCache(1 << 20),
+	})
+	url := ts.URL + "/ipfs/" + root.String() + "/subdir/fnord"
+	res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Blocking the CID of the file also blocks the cached response for the
+	// path of its parent.
+	require.NoError(t, os.WriteFile(file, []byte("version: 1\n---\n/ipfs/"+fnord.RootCid().String()+"\n"), 0o644))
+	require.NoError(t, dl.Reload())
+	res = mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+	require.Equal(t, http.StatusGone, res.StatusCode)
+}
@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
@@ -14,11 +15,18 @@ import (
 	"time"
 
 	"github.com/ipfs/boxo/blockservice"
+	blockstore "github.com/ipfs/boxo/blockstore"
+	chunker "github.com/ipfs/boxo/chunker"
 	offline "github.com/ipfs/boxo/exchange/offline"
 	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs/importer"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	"github.com/ipfs/boxo/namesys"
 	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	carblockstore "github.com/ipld/go-car/v2/blockstore"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/routing"
@@ -259,3 +267,25 @@ func matchPathOrBreadcrumbs(s string, expected string) bool {
 	matched, _ := regexp.MatchString("Index of(\n|\r\n)[\t ]*"+regexp.QuoteMeta(expected), s)
 	return matched
 }
+
+// newUnixFSDirBackend returns a backend with a UnixFS directory of files, and
+// the CID of the directory.
+func newUnixFSDirBackend(t *testing.T, files map[string][]byte) (IPFSBackend, cid.Cid) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bsrv := blockservice.New(bs, offline.Exchange(bs))
+	dserv := merkledag.NewDAGService(bsrv)
+	backend, err := NewBlocksBackend(bsrv)
+	require.NoError(t, err)
+
+	dir := uio.NewDirectory(dserv)
+	for name, data := range files {
+		nd, err := importer.BuildDagFromReader(dserv, chunker.DefaultSplitter(bytes.NewReader(data)))
+		require.NoError(t, err)
+		require.NoError(t, dir.AddChild(ctx, name, nd))
+	}
+	nd, err := dir.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dserv.Add(ctx, nd))
+	return backend, nd.Cid()
+}
@@ -5,19 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/ipfs/boxo/ipns"
 	"github.com/libp2p/go-libp2p/core/routing"
 )
 
 type remoteValueStore struct {
-	httpClient *http.Client
-	gatewayURL []string
-	rand       *rand.Rand
+	gateways remoteGateways
 }
 
 // NewRemoteValueStore creates a new [routing.ValueStore] backed by one or more
@@ -30,15 +26,18 @@ func NewRemoteValueStore(gatewayURL []string, httpClient *http.Client) (routing.
 		return nil, errors.New("missing gateway URLs to which to proxy")
 	}
 
-	if httpClient == nil {
-		httpClient = newRemoteHTTPClient()
+	return &remoteValueStore{gateways: newRandomGateways(gatewayURL, httpClient)}, nil
+}
+
+// NewRemoteValueStoreFromPool creates a new [routing.ValueStore] like
+// [NewRemoteValueStore], which sends its requests to the gateways of the given
+// [RemotePool].
+func NewRemoteValueStoreFromPool(pool *RemotePool) (routing.ValueStore, error) {
+	if pool == nil {
+		return nil, errors.New("missing remote gateway pool")
 	}
 
-	return &remoteValueStore{
-		gatewayURL: gatewayURL,
-		httpClient: httpClient,
-		rand:       rand.New(rand.NewSource(time.Now().Unix())),
-	}, nil
+	return &remoteValueStore{gateways: pool}, nil
 }
 
 func (ps *remoteValueStore) PutValue(context.Context, string, []byte, ...routing.Option) error {
@@ -84,13 +83,8 @@ func (ps *remoteValueStore) SearchValue(ctx context.Context, k string, opts ...r
 }
 
 func (ps *remoteValueStore) fetch(ctx context.Context, name ipns.Name) ([]byte, error) {
-	urlStr := fmt.Sprintf("%s/ipns/%s", ps.getRandomGatewayURL(), name.String())
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Accept", "application/vnd.ipfs.ipns-record")
-	resp, err := ps.httpClient.Do(req)
+	urlPath := fmt.Sprintf("/ipns/%s", name.String())
+	resp, err := ps.gateways.get(ctx, urlPath, http.Header{"Accept": {"application/vnd.ipfs.ipns-record"}})
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +111,3 @@ func (ps *remoteValueStore) fetch(ctx context.Context, name ipns.Name) ([]byte,
 
 	return rb, nil
 }
-
-func (ps *remoteValueStore) getRandomGatewayURL() string {
-	return ps.gatewayURL[ps.rand.Intn(len(ps.gatewayURL))]
-}
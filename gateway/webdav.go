@@ -0,0 +1,543 @@
+package gateway
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	gopath "path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/denylist"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/net/webdav"
+)
+
+// webDAVNamespace is the XML namespace of the UnixFS properties of WebDAV
+// resources: cid, type and, when present in the UnixFS metadata, mode.
+const webDAVNamespace = "https://specs.ipfs.tech/unixfs/"
+
+// webDAVPropfind is the WebDAV method listing the properties of resources.
+const webDAVPropfind = "PROPFIND"
+
+// defaultWebDAVMaxDirEntries is the default number of entries above which
+// directories are not listed.
+const defaultWebDAVMaxDirEntries = maxDirListingLimit
+
+// errWebDAVDirTooLarge is returned when listing a directory with more entries
+// than allowed by [WithWebDAVMaxDirEntries].
+var errWebDAVDirTooLarge = errors.New("directory has too many entries to be listed over WebDAV")
+
+// WebDAVOption configures the handler returned by [NewWebDAVHandler].
+type WebDAVOption func(*webDAVHandler)
+
+// WithWebDAVPrefix sets the URL path prefix under which the handler is
+// mounted, such as "/dav". It is stripped from request paths.
+func WithWebDAVPrefix(prefix string) WebDAVOption {
+	return func(h *webDAVHandler) {
+		h.prefix = prefix
+	}
+}
+
+// WithWebDAVDenylist enforces dl on the served paths and on the CIDs and
+// names they resolve to, like [Config.Denylist].
+func WithWebDAVDenylist(dl *denylist.Denylist) WebDAVOption {
+	return func(h *webDAVHandler) {
+		h.denylist = dl
+	}
+}
+
+// WithWebDAVMaxDirEntries sets the number of entries above which directories
+// are not listed: PROPFIND requests listing them fail with HTTP 507
+// Insufficient Storage. Such directories can be listed page by page by the
+// gateway, see [NewHandler]. Defaults to 10000.
+func WithWebDAVMaxDirEntries(n int) WebDAVOption {
+	return func(h *webDAVHandler) {
+		h.maxDirEntries = n
+	}
+}
+
+type webDAVHandler struct {
+	backend       IPFSBackend
+	prefix        string
+	denylist      *denylist.Denylist
+	locks         webdav.LockSystem
+	maxDirEntries int
+}
+
+// NewWebDAVHandler returns a read-only WebDAV handler serving the /ipfs/ and
+// /ipns/ content trees of backend, so that they can be mounted in file
+// managers. It supports the OPTIONS, GET, HEAD and PROPFIND methods.
+//
+// UnixFS directories, including HAMT-sharded ones, are WebDAV collections and
+// other content is served as files. The UnixFS mtime is the last modified
+// date of resources, and their CID, UnixFS type and mode are exposed as
+// properties in the "https://specs.ipfs.tech/unixfs/" namespace. /ipns/ paths
+// are resolved with every request.
+//
+// PROPFIND requests must have a Depth header of 0 or 1: listing a whole tree
+// is refused with HTTP 403 Forbidden, as allowed by RFC 4918.
+func NewWebDAVHandler(backend IPFSBackend, opts ...WebDAVOption) http.Handler {
+	h := &webDAVHandler{
+		backend:       backend,
+		locks:         webdav.NewMemLS(),
+		maxDirEntries: defaultWebDAVMaxDirEntries,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.denylist != nil {
+		h.backend = newDenylistBackend(h.backend, h.denylist)
+	}
+	return h
+}
+
+func (h *webDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer panicHandler(w)
+
+	switch r.Method {
+	case http.MethodOptions:
+		addWebDAVAllowHeader(w)
+		w.Header().Set("DAV", "1")
+		return
+	case http.MethodGet, http.MethodHead, webDAVPropfind:
+	default:
+		addWebDAVAllowHeader(w)
+		errmsg := "Method " + r.Method + " not allowed: read only access"
+		http.Error(w, errmsg, http.StatusMethodNotAllowed)
+		return
+	}
+
+	depth := r.Header.Get("Depth")
+	if r.Method == webDAVPropfind && (depth == "" || strings.EqualFold(depth, "infinity")) {
+		// A missing Depth header means infinity.
+		writeWebDAVFiniteDepthError(w)
+		return
+	}
+
+	if withCtxWrap, ok := h.backend.(WithContextHint); ok {
+		r = r.WithContext(withCtxWrap.WrapContextForRequest(r.Context()))
+	}
+
+	// The file system caches the metadata of directory entries so that
+	// listing a directory does not resolve every entry again. It is specific
+	// to the request, which sees a consistent snapshot of /ipns/ names.
+	fsys := &webDAVFileSystem{
+		backend:       h.backend,
+		maxDirEntries: h.maxDirEntries,
+		infos:         map[string]*webDAVFileInfo{},
+		dirs:          map[string][]fs.FileInfo{},
+	}
+
+	// Directories are listed before the multistatus response is started, so
+	// that an error can still be returned.
+	if name, ok := strings.CutPrefix(r.URL.Path, h.prefix); ok && r.Method == webDAVPropfind && depth == "1" {
+		if err := fsys.preloadDir(r.Context(), name); errors.Is(err, errWebDAVDirTooLarge) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	dav := &webdav.Handler{
+		Prefix:     h.prefix,
+		FileSystem: fsys,
+		LockSystem: h.locks,
+	}
+	dav.ServeHTTP(w, r)
+}
+
+// writeWebDAVFiniteDepthError refuses a PROPFIND request of infinite depth,
+// see RFC 4918, section 9.1.
+func writeWebDAVFiniteDepthError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = io.WriteString(w, xml.Header+`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+}
+
+func addWebDAVAllowHeader(w http.ResponseWriter) {
+	w.Header().Set("Allow", strings.Join([]string{http.MethodOptions, http.MethodGet, http.MethodHead, webDAVPropfind}, ", "))
+}
+
+// webDAVFileSystem is a read-only [webdav.FileSystem] over an [IPFSBackend].
+type webDAVFileSystem struct {
+	backend       IPFSBackend
+	maxDirEntries int
+
+	mu    sync.Mutex
+	infos map[string]*webDAVFileInfo
+	dirs  map[string][]fs.FileInfo
+}
+
+var _ webdav.FileSystem = (*webDAVFileSystem)(nil)
+
+func (fsys *webDAVFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+}
+
+func (fsys *webDAVFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+}
+
+func (fsys *webDAVFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return &os.PathError{Op: "rename", Path: oldName, Err: os.ErrPermission}
+}
+
+func (fsys *webDAVFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	fi, err := fsys.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &webDAVFile{ctx: ctx, fsys: fsys, name: name, info: fi}, nil
+}
+
+func (fsys *webDAVFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fsys.stat(ctx, name)
+}
+
+func (fsys *webDAVFileSystem) stat(ctx context.Context, name string) (*webDAVFileInfo, error) {
+	name = gopath.Clean("/" + name)
+	switch name {
+	case "/", "/ipfs", "/ipns":
+		// The namespaces are listed, but their content is not.
+		return &webDAVFileInfo{entry: dirListingEntry{name: gopath.Base(name), typ: "directory"}}, nil
+	}
+
+	fsys.mu.Lock()
+	fi, ok := fsys.infos[name]
+	fsys.mu.Unlock()
+	if ok {
+		return fi, nil
+	}
+
+	p, err := path.NewPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	var ip path.ImmutablePath
+	if p.Mutable() {
+		ip, _, _, err = fsys.backend.ResolveMutable(ctx, p)
+	} else {
+		ip, err = path.NewImmutablePath(p)
+	}
+	if err != nil {
+		return nil, webDAVError("stat", name, err)
+	}
+	md, err := fsys.backend.ResolvePath(ctx, ip)
+	if err != nil {
+		return nil, webDAVError("stat", name, err)
+	}
+
+	e := dirListingEntry{name: gopath.Base(name), cid: md.LastSegment.RootCid()}
+	if err := fetchEntryMetadata(ctx, fsys.backend, &e); err != nil {
+		return nil, webDAVError("stat", name, err)
+	}
+	if e.typ != "directory" && e.size == 0 {
+		// Raw blocks and other codecs have no UnixFS file size.
+		_, hr, err := fsys.backend.Head(ctx, path.FromCid(e.cid))
+		if err != nil {
+			return nil, webDAVError("stat", name, err)
+		}
+		hr.Close()
+		e.size = uint64(hr.bytesSize)
+	}
+	if e.mtime.IsZero() {
+		e.mtime = md.ModTime
+	}
+	return &webDAVFileInfo{entry: e}, nil
+}
+
+// webDAVError converts backend errors to the errors of the [os] package
+// which [webdav.Handler] maps to HTTP status codes. These are compared with
+// [os.IsNotExist] and [os.IsPermission], which do not unwrap errors.
+func webDAVError(op, name string, err error) error {
+	switch {
+	case isErrNotFound(err), errors.Is(err, &cid.ErrInvalidCid{}), errors.Is(err, &path.ErrInvalidPath{}):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case isErrContentBlocked(err):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	default:
+		return err
+	}
+}
+
+// webDAVFileInfo describes a resource. Virtual directories, such as /ipfs,
+// have no CID.
+type webDAVFileInfo struct {
+	entry dirListingEntry
+}
+
+var (
+	_ webdav.ETager       = (*webDAVFileInfo)(nil)
+	_ webdav.ContentTyper = (*webDAVFileInfo)(nil)
+)
+
+func (fi *webDAVFileInfo) Name() string { return fi.entry.name }
+func (fi *webDAVFileInfo) Size() int64  { return int64(fi.entry.size) }
+func (fi *webDAVFileInfo) IsDir() bool  { return fi.entry.typ == "directory" }
+func (fi *webDAVFileInfo) Sys() any     { return nil }
+
+func (fi *webDAVFileInfo) Mode() os.FileMode {
+	if fi.IsDir() {
+		if fi.entry.mode == 0 {
+			return os.ModeDir | 0o755
+		}
+		return os.ModeDir | os.FileMode(fi.entry.mode).Perm()
+	}
+	if fi.entry.mode == 0 {
+		return 0o644
+	}
+	return os.FileMode(fi.entry.mode).Perm()
+}
+
+func (fi *webDAVFileInfo) ModTime() time.Time { return fi.entry.mtime }
+
+// ETag returns the CID of the resource, which identifies its content.
+func (fi *webDAVFileInfo) ETag(ctx context.Context) (string, error) {
+	if !fi.entry.cid.Defined() {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + fi.entry.cid.String() + `"`, nil
+}
+
+// ContentType returns the content type of the resource from its extension,
+// so that listing directories does not fetch the content of files.
+func (fi *webDAVFileInfo) ContentType(ctx context.Context) (string, error) {
+	if ctype := mime.TypeByExtension(gopath.Ext(fi.entry.name)); ctype != "" {
+		return ctype, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// webDAVFile is an open resource. The content of files is fetched from the
+// backend when read, starting from the offset set by Seek.
+type webDAVFile struct {
+	ctx  context.Context
+	fsys *webDAVFileSystem
+	name string
+	info *webDAVFileInfo
+
+	offset  int64
+	content io.ReadCloser
+
+	entries []fs.FileInfo
+}
+
+var (
+	_ webdav.File            = (*webDAVFile)(nil)
+	_ webdav.DeadPropsHolder = (*webDAVFile)(nil)
+)
+
+func (f *webDAVFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *webDAVFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.content == nil {
+		if err := f.openContent(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.content.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// openContent fetches the content of the file from the current offset.
+func (f *webDAVFile) openContent() error {
+	_, resp, err := f.fsys.backend.Get(f.ctx, path.FromCid(f.info.entry.cid), ByteRange{From: uint64(f.offset)})
+	if err != nil {
+		return webDAVError("read", f.name, err)
+	}
+	switch {
+	case resp.bytes != nil:
+		f.content = resp.bytes
+		// Only UnixFS files and raw blocks start at the requested offset.
+		if s, ok := resp.bytes.(io.Seeker); ok {
+			if _, err := s.Seek(f.offset, io.SeekStart); err != nil {
+				resp.Close()
+				return err
+			}
+		}
+	case resp.symlink != nil:
+		f.content = io.NopCloser(strings.NewReader(resp.symlink.Target[min(f.offset, int64(len(resp.symlink.Target))):]))
+	default:
+		resp.Close()
+		return &os.PathError{Op: "read", Path: f.name, Err: errors.New("not a file")}
+	}
+	return nil
+}
+
+func (f *webDAVFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	if offset != f.offset && f.content != nil {
+		f.content.Close()
+		f.content = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *webDAVFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+	if f.entries == nil {
+		entries, err := f.fsys.readDir(f.ctx, f.name, f.info)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = entries
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = f.entries[len(f.entries):]
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(f.entries))
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// preloadDir lists name if it is a directory.
+func (fsys *webDAVFileSystem) preloadDir(ctx context.Context, name string) error {
+	fi, err := fsys.stat(ctx, name)
+	if err != nil || !fi.IsDir() {
+		return nil
+	}
+	_, err = fsys.readDir(ctx, name, fi)
+	return err
+}
+
+// readDir lists the directory name, up to maxDirEntries entries, and caches
+// the metadata of its entries in the file system.
+func (fsys *webDAVFileSystem) readDir(ctx context.Context, name string, info *webDAVFileInfo) ([]fs.FileInfo, error) {
+	if !info.entry.cid.Defined() {
+		return []fs.FileInfo{}, nil
+	}
+	name = gopath.Clean("/" + name)
+
+	fsys.mu.Lock()
+	infos, ok := fsys.dirs[name]
+	fsys.mu.Unlock()
+	if ok {
+		return slices.Clone(infos), nil
+	}
+
+	// Stop enumerating the directory when it is too large, and ask for a
+	// stable order, which BlocksBackend enumerates without fetching the
+	// whole directory.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	_, resp, err := fsys.backend.Get(context.WithValue(ctx, dirListingCursorKey{}, ""), path.FromCid(info.entry.cid))
+	if err != nil {
+		return nil, webDAVError("readdir", name, err)
+	}
+	defer resp.Close()
+	if resp.directoryMetadata == nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	var entries []dirListingEntry
+	for l := range resp.directoryMetadata.entries {
+		if l.Err != nil {
+			return nil, webDAVError("readdir", name, l.Err)
+		}
+		if len(entries) == fsys.maxDirEntries {
+			return nil, &os.PathError{Op: "readdir", Path: name, Err: errWebDAVDirTooLarge}
+		}
+		entries = append(entries, dirListingEntry{name: l.Link.Name, cid: l.Link.Cid, size: l.Link.Size})
+	}
+	if err := fetchDirListingMetadata(ctx, fsys.backend, entries); err != nil {
+		return nil, webDAVError("readdir", name, err)
+	}
+
+	infos = make([]fs.FileInfo, len(entries))
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	for n, e := range entries {
+		fi := &webDAVFileInfo{entry: e}
+		infos[n] = fi
+		fsys.infos[gopath.Join(name, e.name)] = fi
+	}
+	fsys.dirs[name] = infos
+	return slices.Clone(infos), nil
+}
+
+func (f *webDAVFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *webDAVFile) Close() error {
+	if f.content != nil {
+		return f.content.Close()
+	}
+	return nil
+}
+
+// DeadProps returns the UnixFS properties of the resource.
+func (f *webDAVFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	e := f.info.entry
+	if !e.cid.Defined() {
+		return nil, nil
+	}
+	props := map[xml.Name]webdav.Property{}
+	add := func(local, value string) {
+		name := xml.Name{Space: webDAVNamespace, Local: local}
+		var b strings.Builder
+		if err := xml.EscapeText(&b, []byte(value)); err != nil {
+			return
+		}
+		props[name] = webdav.Property{XMLName: name, InnerXML: []byte(b.String())}
+	}
+	add("cid", e.cid.String())
+	add("type", e.typ)
+	if e.mode != 0 {
+		add("mode", fmt.Sprintf("%04o", e.mode))
+	}
+	return props, nil
+}
+
+// Patch refuses all property changes.
+func (f *webDAVFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
+}
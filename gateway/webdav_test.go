@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/stretchr/testify/require"
+)
+
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+				ETag          string `xml:"getetag"`
+				Cid           string `xml:"https://specs.ipfs.tech/unixfs/ cid"`
+				Type          string `xml:"https://specs.ipfs.tech/unixfs/ type"`
+				Mode          string `xml:"https://specs.ipfs.tech/unixfs/ mode"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func TestWebDAV(t *testing.T) {
+	propfind := func(t *testing.T, ts *httptest.Server, p, depth string) davMultistatus {
+		req := mustNewRequest(t, webDAVPropfind, ts.URL+p, nil)
+		req.Header.Set("Depth", depth)
+		res := mustDoWithoutRedirect(t, req)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusMultiStatus, res.StatusCode)
+		var ms davMultistatus
+		require.NoError(t, xml.NewDecoder(res.Body).Decode(&ms))
+		return ms
+	}
+
+	t.Run("UnixFS metadata", func(t *testing.T) {
+		backend, root := newMockBackend(t, "unixfs-dir-with-mode-mtime.car")
+		ts := httptest.NewServer(NewWebDAVHandler(backend))
+		t.Cleanup(ts.Close)
+
+		ms := propfind(t, ts, "/ipfs/"+root.String()+"/", "1")
+		require.Len(t, ms.Responses, 5)
+		dir := ms.Responses[0].Propstat[0].Prop
+		require.NotNil(t, dir.ResourceType.Collection)
+		require.Equal(t, root.String(), dir.Cid)
+
+		props := map[string]int{}
+		for n, r := range ms.Responses {
+			props[strings.TrimSuffix(r.Href, "/")] = n
+		}
+		dir1 := ms.Responses[props["/ipfs/"+root.String()+"/dir1"]].Propstat[0].Prop
+		require.NotNil(t, dir1.ResourceType.Collection)
+		require.Equal(t, "directory", dir1.Type)
+		require.Equal(t, "0755", dir1.Mode)
+		require.Equal(t, "Mon, 13 Jun 2022 22:18:42 GMT", dir1.LastModified)
+
+		file1 := ms.Responses[props["/ipfs/"+root.String()+"/file1"]].Propstat[0].Prop
+		require.Nil(t, file1.ResourceType.Collection)
+		require.Equal(t, "file", file1.Type)
+		require.Equal(t, "0644", file1.Mode)
+		require.Equal(t, `"`+file1.Cid+`"`, file1.ETag)
+
+	})
+
+	backend, root := newMockBackend(t, "headers-test.car")
+	backend.namesys["/ipns/example.com"] = newMockNamesysItem(path.FromCid(root), 0)
+	ts := httptest.NewServer(NewWebDAVHandler(backend, WithWebDAVPrefix("/dav")))
+	t.Cleanup(ts.Close)
+
+	t.Run("HAMT directories and IPNS", func(t *testing.T) {
+		ms := propfind(t, ts, "/dav/ipns/example.com/hamt/", "1")
+		require.Greater(t, len(ms.Responses), 256)
+		var found bool
+		for _, r := range ms.Responses {
+			if strings.HasSuffix(r.Href, "/dav/ipns/example.com/hamt/685.txt") {
+				found = true
+				require.Equal(t, `"bafybeigcisqd7m5nf3qmuvjdbakl5bdnh4ocrmacaqkpuh77qjvggmt2sa"`, r.Propstat[0].Prop.ETag)
+			}
+		}
+		require.True(t, found)
+
+		// Files are served with range requests.
+		url := ts.URL + "/dav/ipns/example.com/hamt/685.txt"
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NotEmpty(t, body)
+
+		req := mustNewRequest(t, http.MethodGet, url, nil)
+		req.Header.Set("Range", "bytes=2-")
+		res = mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusPartialContent, res.StatusCode)
+		partial, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, body[2:], partial)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		req := mustNewRequest(t, webDAVPropfind, ts.URL+"/dav/ipfs/"+root.String()+"/missing", nil)
+		req.Header.Set("Depth", "0")
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("Infinite depth", func(t *testing.T) {
+		for _, depth := range []string{"infinity", ""} {
+			req := mustNewRequest(t, webDAVPropfind, ts.URL+"/dav/ipfs/"+root.String()+"/", nil)
+			if depth != "" {
+				req.Header.Set("Depth", depth)
+			}
+			res := mustDoWithoutRedirect(t, req)
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusForbidden, res.StatusCode, depth)
+			require.Contains(t, string(body), "propfind-finite-depth")
+		}
+	})
+
+	t.Run("Directory too large", func(t *testing.T) {
+		ts := httptest.NewServer(NewWebDAVHandler(backend, WithWebDAVMaxDirEntries(10)))
+		t.Cleanup(ts.Close)
+
+		req := mustNewRequest(t, webDAVPropfind, ts.URL+"/ipfs/"+root.String()+"/hamt/", nil)
+		req.Header.Set("Depth", "1")
+		res := mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusInsufficientStorage, res.StatusCode)
+
+		// The directory itself can still be described.
+		req.Header.Set("Depth", "0")
+		res = mustDoWithoutRedirect(t, req)
+		require.Equal(t, http.StatusMultiStatus, res.StatusCode)
+	})
+
+	t.Run("Read only", func(t *testing.T) {
+		res := mustDoWithoutRedirect(t, mustNewRequest(t, http.MethodOptions, ts.URL+"/dav/", nil))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "1", res.Header.Get("DAV"))
+		require.Equal(t, "OPTIONS, GET, HEAD, PROPFIND", res.Header.Get("Allow"))
+
+		for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "PROPPATCH", "LOCK"} {
+			res := mustDoWithoutRedirect(t, mustNewRequest(t, method, ts.URL+"/dav/ipfs/"+root.String()+"/hamt", strings.NewReader("")))
+			require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode, method)
+		}
+	})
+}
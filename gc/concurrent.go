@@ -0,0 +1,220 @@
+package gc
+
+import (
+	"context"
+	"sync"
+
+	bstore "github.com/ipfs/boxo/blockstore"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	cid "github.com/ipfs/go-cid"
+)
+
+// maxConcurrentGreyRounds is the number of times blocks written during the
+// mark phase are scanned before taking the GC lock for the final scan. Each
+// round should be shorter than the previous one.
+const maxConcurrentGreyRounds = 3
+
+// ConcurrentGC performs a mark and sweep garbage collection like GC, but only
+// holds the GC lock for short periods of time, so that pinning operations
+// (imports, MFS flushes...) can make progress while the collection runs.
+//
+// The collection proceeds as follows:
+//
+//  1. With the GC lock held, a GCTracker is registered on bs and the current
+//     pins are listed.
+//  2. Without the lock, the DAGs of those pins are marked. Meanwhile, blocks
+//     written or read through bs are kept, and written blocks are scanned for
+//     links, like in a tri-color collector.
+//  3. With the GC lock held again, pins added during the mark phase and
+//     remaining written blocks are marked.
+//  4. Without the lock, unmarked blocks are removed. Each removal is atomic
+//     with respect to concurrent accesses through bs: a block read or written
+//     during the sweep is kept.
+//
+// Blocks written during the sweep are not scanned for links. Callers
+// creating DAGs that link to blocks they have neither read nor written
+// should hold the pin lock while doing so, as required by GCLocker.
+//
+// Blocks accessed during the collection are tracked in memory, in addition to
+// the LiveSet.
+func ConcurrentGC(ctx context.Context, bs bstore.TrackingGCBlockstore, pn pin.Pinner, walk Walker, opts ...Option) <-chan Result {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.liveSet == nil {
+		o.liveSet = NewMapSet()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	output := make(chan Result, 128)
+
+	t := &tracker{touched: NewMapSet()}
+	// Reads done by the collector itself do not need to be tracked.
+	ctx = context.WithValue(ctx, trackerKey{}, t)
+
+	unlocker := bs.GCLock(ctx)
+	bs.SetGCTracker(t)
+	roots, err := pinnedRoots(ctx, pn)
+	unlocker.Unlock(ctx)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+		defer bs.SetGCTracker(nil)
+
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		m := newMarker(ctx, walk, o.liveSet, t.touched, output)
+		err := t.mark(m, bs, pn, roots, o.bestEffortRoots)
+		if err == nil {
+			err = m.err()
+		}
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		sweep(ctx, bs, o.dryRun, output, func(k cid.Cid) (bool, error) {
+			t.lk.Lock()
+			defer t.lk.Unlock()
+			if o.liveSet.Has(k) || t.touched.Has(k) {
+				return false, nil
+			}
+			if o.dryRun {
+				return true, nil
+			}
+			return true, bs.DeleteBlock(ctx, k)
+		})
+	}()
+
+	return output
+}
+
+// pinnedRoots returns the roots of the recursive and internal pins.
+func pinnedRoots(ctx context.Context, pn pin.Pinner) (*cid.Set, error) {
+	roots := cid.NewSet()
+	for sp := range pn.RecursiveKeys(ctx, false) {
+		if sp.Err != nil {
+			return nil, sp.Err
+		}
+		roots.Add(sp.Pin.Key)
+	}
+	for sp := range pn.InternalPins(ctx, false) {
+		if sp.Err != nil {
+			return nil, sp.Err
+		}
+		roots.Add(sp.Pin.Key)
+	}
+	return roots, ctx.Err()
+}
+
+// tracker implements bstore.GCTracker. It records the blocks accessed during
+// a concurrent collection, and queues the written ones so that their links
+// are marked.
+type tracker struct {
+	// lk is held for writing while a block is being removed, so that a
+	// block accessed concurrently is either kept or already gone.
+	lk      sync.RWMutex
+	touched LiveSet
+
+	greyLk   sync.Mutex
+	grey     []cid.Cid
+	sweeping bool
+}
+
+var _ bstore.GCTracker = (*tracker)(nil)
+
+// trackerKey is the context key set on the requests issued by the collector.
+type trackerKey struct{}
+
+func (t *tracker) Written(ctx context.Context, cids ...cid.Cid) {
+	if ctx.Value(trackerKey{}) == t {
+		return
+	}
+	t.lk.RLock()
+	for _, c := range cids {
+		t.touched.Add(c)
+	}
+	t.lk.RUnlock()
+
+	t.greyLk.Lock()
+	if !t.sweeping {
+		t.grey = append(t.grey, cids...)
+	}
+	t.greyLk.Unlock()
+}
+
+func (t *tracker) Read(ctx context.Context, c cid.Cid) {
+	if ctx.Value(trackerKey{}) == t {
+		return
+	}
+	t.lk.RLock()
+	t.touched.Add(c)
+	t.lk.RUnlock()
+}
+
+// takeGrey returns the blocks written since the last call. When final is
+// set, blocks written afterwards are no longer queued.
+func (t *tracker) takeGrey(final bool) []cid.Cid {
+	t.greyLk.Lock()
+	defer t.greyLk.Unlock()
+	grey := t.grey
+	t.grey = nil
+	if final {
+		t.sweeping = true
+	}
+	return grey
+}
+
+func (t *tracker) scanGrey(m *marker, grey []cid.Cid) error {
+	for _, c := range grey {
+		if err := m.walkChildren(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mark runs the concurrent mark phase followed by the final, locked, one.
+func (t *tracker) mark(m *marker, bs bstore.GCLocker, pn pin.Pinner, roots *cid.Set, bestEffortRoots []cid.Cid) error {
+	err := roots.ForEach(func(c cid.Cid) error {
+		return m.walkRoot(c, false)
+	})
+	if err != nil {
+		return err
+	}
+	for _, root := range bestEffortRoots {
+		if err := m.walkRoot(root, true); err != nil {
+			return err
+		}
+	}
+
+	for range maxConcurrentGreyRounds {
+		grey := t.takeGrey(false)
+		if len(grey) == 0 {
+			break
+		}
+		if err := t.scanGrey(m, grey); err != nil {
+			return err
+		}
+	}
+
+	unlocker := bs.GCLock(m.ctx)
+	defer unlocker.Unlock(m.ctx)
+
+	if err := m.markPins(pn, nil, roots.Has); err != nil {
+		return err
+	}
+	return t.scanGrey(m, t.takeGrey(true))
+}
@@ -0,0 +1,437 @@
+// Package gc implements a mark-and-sweep garbage collector for blockstores.
+//
+// The collector takes the GC lock of a [blockstore.GCBlockstore], computes
+// the set of live blocks from the pins known to a [pin.Pinner] and removes
+// every other block from the blockstore.
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	bstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/fetcher"
+	fetcherhelpers "github.com/ipfs/boxo/fetcher/helpers"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log/v2"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+var log = logging.Logger("gc")
+
+// defaultVisitedCacheSize is the number of recently visited CIDs remembered
+// to prune the traversal when the LiveSet is not exact.
+const defaultVisitedCacheSize = 1 << 20
+
+var (
+	// ErrCannotFetchAllLinks is returned as the last Result in the GC output
+	// channel if there was an error creating the marked set because of a
+	// problem when finding descendants.
+	ErrCannotFetchAllLinks = errors.New("garbage collection aborted: could not retrieve some links")
+
+	// ErrCannotDeleteSomeBlocks is returned when removing blocks marked for
+	// deletion fails as the last Result in GC output channel.
+	ErrCannotDeleteSomeBlocks = errors.New("garbage collection incomplete: could not delete some blocks")
+)
+
+// Result represents an incremental output from a garbage collection
+// run. It contains either an error, or the cid of a removed object.
+type Result struct {
+	KeyRemoved cid.Cid
+	Error      error
+}
+
+// CannotFetchLinksError provides detailed information about which links
+// could not be fetched and can appear as a Result in the GC output channel.
+type CannotFetchLinksError struct {
+	Key cid.Cid
+	Err error
+}
+
+// Error implements the error interface for this type with a useful
+// message.
+func (e *CannotFetchLinksError) Error() string {
+	return fmt.Sprintf("could not retrieve links for %s: %s", e.Key, e.Err)
+}
+
+func (e *CannotFetchLinksError) Unwrap() error {
+	return e.Err
+}
+
+// CannotDeleteBlockError provides detailed information about which
+// blocks could not be deleted and can appear as a Result in the GC output
+// channel.
+type CannotDeleteBlockError struct {
+	Key cid.Cid
+	Err error
+}
+
+// Error implements the error interface for this type with a
+// useful message.
+func (e *CannotDeleteBlockError) Error() string {
+	return fmt.Sprintf("could not remove %s: %s", e.Key, e.Err)
+}
+
+func (e *CannotDeleteBlockError) Unwrap() error {
+	return e.Err
+}
+
+// Walker enumerates the CIDs of every block reachable from root, including
+// root itself, by calling visit. When visit returns false the descendants of
+// that CID do not need to be explored.
+//
+// Walkers should keep going when the links of a node cannot be read and
+// report the error once done, so that as much of the DAG as possible is
+// marked for best-effort roots.
+type Walker func(ctx context.Context, root cid.Cid, visit func(cid.Cid) bool) error
+
+// DAGWalker returns a Walker that uses the given NodeGetter (usually an
+// offline DAGService) to discover links.
+func DAGWalker(ng ipld.NodeGetter) Walker {
+	getLinks := merkledag.GetLinksWithDAG(ng)
+	return func(ctx context.Context, root cid.Cid, visit func(cid.Cid) bool) error {
+		var (
+			errLk    sync.Mutex
+			firstErr error
+		)
+		err := merkledag.Walk(ctx, getLinks, root, visit,
+			merkledag.Concurrent(),
+			merkledag.OnError(func(c cid.Cid, err error) error {
+				errLk.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errLk.Unlock()
+				return nil
+			}))
+		if err != nil {
+			return err
+		}
+		return firstErr
+	}
+}
+
+// FetcherWalker returns a Walker that traverses the DAG with sessions from the
+// given fetcher.Factory. This allows walking through any codec supported by
+// go-ipld-prime. Fetcher traversals cannot be pruned below the root, so
+// shared subgraphs of distinct pins are traversed more than once, and they
+// stop at the first block that cannot be loaded.
+func FetcherWalker(f fetcher.Factory) Walker {
+	return func(ctx context.Context, root cid.Cid, visit func(cid.Cid) bool) error {
+		if !visit(root) {
+			return nil
+		}
+		session := f.NewSession(ctx)
+		return fetcherhelpers.BlockAll(ctx, session, cidlink.Link{Cid: root}, fetcherhelpers.OnUniqueBlocks(func(res fetcherhelpers.BlockResult) error {
+			visit(res.Link.(cidlink.Link).Cid)
+			return nil
+		}))
+	}
+}
+
+type options struct {
+	dryRun          bool
+	bestEffortRoots []cid.Cid
+	liveSet         LiveSet
+}
+
+// Option configures a garbage collection run.
+type Option func(*options)
+
+// WithDryRun makes the collector report the blocks it would remove without
+// actually removing them.
+func WithDryRun(dryRun bool) Option {
+	return func(o *options) {
+		o.dryRun = dryRun
+	}
+}
+
+// WithBestEffortRoots adds roots whose reachable blocks are kept when they
+// are available locally. Unlike pins, missing descendants of these roots do
+// not abort the collection. This is typically used for the MFS root.
+func WithBestEffortRoots(roots ...cid.Cid) Option {
+	return func(o *options) {
+		o.bestEffortRoots = append(o.bestEffortRoots, roots...)
+	}
+}
+
+// WithLiveSet sets the LiveSet used to record reachable blocks during the mark
+// phase. It defaults to an exact in-memory set (see NewMapSet). Use
+// NewBloomSet to bound memory use on very large repositories.
+func WithLiveSet(s LiveSet) Option {
+	return func(o *options) {
+		o.liveSet = s
+	}
+}
+
+// GC performs a mark and sweep garbage collection of the blocks in the given
+// blockstore.
+//
+// It takes the GC lock for the whole run, then marks all blocks reachable from
+// the recursive, direct and internal pins of pn (and from the best-effort
+// roots, if any) using walk. Finally, it removes every block from bs which was
+// not marked.
+//
+// The returned channel yields a Result for every removed block (or every
+// block that would be removed in dry-run mode) and for every error
+// encountered. It is closed when the collection finishes or ctx is cancelled.
+func GC(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, walk Walker, opts ...Option) <-chan Result {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.liveSet == nil {
+		o.liveSet = NewMapSet()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	unlocker := bs.GCLock(ctx)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+		defer unlocker.Unlock(ctx)
+
+		err := Mark(ctx, pn, walk, o.liveSet, o.bestEffortRoots, output)
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		Sweep(ctx, bs, o.liveSet, o.dryRun, output)
+	}()
+
+	return output
+}
+
+// Mark adds every block reachable from the pins of pn and from
+// bestEffortRoots to live. Errors fetching links of pinned DAGs are sent to
+// output as CannotFetchLinksError and cause ErrCannotFetchAllLinks to be
+// returned. Errors below best-effort roots are ignored.
+func Mark(ctx context.Context, pn pin.Pinner, walk Walker, live LiveSet, bestEffortRoots []cid.Cid, output chan<- Result) error {
+	m := newMarker(ctx, walk, live, live, output)
+	if err := m.markPins(pn, bestEffortRoots, nil); err != nil {
+		return err
+	}
+	return m.err()
+}
+
+// Sweep removes every block of bs that is not in live, sending a Result for
+// each of them to output. When dryRun is true, blocks are reported but not
+// removed.
+func Sweep(ctx context.Context, bs bstore.Blockstore, live LiveSet, dryRun bool, output chan<- Result) {
+	sweep(ctx, bs, dryRun, output, func(k cid.Cid) (bool, error) {
+		if live.Has(k) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, bs.DeleteBlock(ctx, k)
+	})
+}
+
+// sweep calls collect for every key in bs. collect returns whether the block
+// is garbage, and the error that happened removing it, if any.
+func sweep(ctx context.Context, bs bstore.Blockstore, dryRun bool, output chan<- Result, collect func(cid.Cid) (bool, error)) {
+	keychan, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		select {
+		case output <- Result{Error: err}:
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	var removed, failed int
+	for k := range keychan {
+		garbage, err := collect(k)
+		if !garbage {
+			continue
+		}
+		if err != nil {
+			failed++
+			select {
+			case output <- Result{Error: &CannotDeleteBlockError{Key: k, Err: err}}:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		removed++
+		select {
+		case output <- Result{KeyRemoved: k}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	log.Infof("garbage collection removed %d blocks (dry-run: %t)", removed, dryRun)
+
+	if failed > 0 {
+		select {
+		case output <- Result{Error: ErrCannotDeleteSomeBlocks}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// marker holds the state of a mark phase.
+type marker struct {
+	ctx context.Context
+	// live holds the walked blocks. All the descendants of a block in live
+	// are either in live or about to be walked.
+	live LiveSet
+	// keep holds blocks that are kept without walking their descendants,
+	// like direct pins.
+	keep   LiveSet
+	walk   Walker
+	visit  func(cid.Cid) bool
+	output chan<- Result
+	failed bool
+}
+
+func newMarker(ctx context.Context, walk Walker, live, keep LiveSet, output chan<- Result) *marker {
+	return &marker{
+		ctx:    ctx,
+		live:   live,
+		keep:   keep,
+		walk:   walk,
+		visit:  visitor(live),
+		output: output,
+	}
+}
+
+// markPins walks the recursive and internal pins of pn and the given
+// best-effort roots, then marks the direct pins. Recursive pins for which
+// skip returns true are not walked again.
+func (m *marker) markPins(pn pin.Pinner, bestEffortRoots []cid.Cid, skip func(cid.Cid) bool) error {
+	for sp := range pn.RecursiveKeys(m.ctx, false) {
+		if sp.Err != nil {
+			return sp.Err
+		}
+		if skip != nil && skip(sp.Pin.Key) {
+			continue
+		}
+		if err := m.walkRoot(sp.Pin.Key, false); err != nil {
+			return err
+		}
+	}
+
+	for _, root := range bestEffortRoots {
+		if err := m.walkRoot(root, true); err != nil {
+			return err
+		}
+	}
+
+	for sp := range pn.InternalPins(m.ctx, false) {
+		if sp.Err != nil {
+			return sp.Err
+		}
+		if err := m.walkRoot(sp.Pin.Key, false); err != nil {
+			return err
+		}
+	}
+
+	// Direct pins are marked last: when keep and live are the same set, a
+	// direct pin marked earlier would prune the walk of a DAG containing it.
+	for sp := range pn.DirectKeys(m.ctx, false) {
+		if sp.Err != nil {
+			return sp.Err
+		}
+		m.keep.Add(sp.Pin.Key)
+	}
+	return m.ctx.Err()
+}
+
+// walkRoot marks all the blocks reachable from root. Failures are reported
+// to the output channel, unless bestEffort is set. The returned error is only
+// set when the context is done.
+func (m *marker) walkRoot(root cid.Cid, bestEffort bool) error {
+	return m.walkWith(root, bestEffort, m.visit)
+}
+
+// walkChildren walks the descendants of root even if root was already
+// marked. Failures are ignored, as root may be part of an incomplete DAG.
+func (m *marker) walkChildren(root cid.Cid) error {
+	first := true
+	return m.walkWith(root, true, func(c cid.Cid) bool {
+		if first && c.Equals(root) {
+			first = false
+			m.live.Add(c)
+			return true
+		}
+		return m.visit(c)
+	})
+}
+
+func (m *marker) walkWith(root cid.Cid, bestEffort bool, visit func(cid.Cid) bool) error {
+	err := m.walk(m.ctx, root, visit)
+	if err == nil {
+		return nil
+	}
+	if m.ctx.Err() != nil {
+		return m.ctx.Err()
+	}
+	if bestEffort {
+		// Keep the root itself even if its DAG is incomplete.
+		m.keep.Add(root)
+		log.Debugf("ignoring error walking best-effort root %s: %s", root, err)
+		return nil
+	}
+	m.failed = true
+	select {
+	case m.output <- Result{Error: &CannotFetchLinksError{Key: root, Err: err}}:
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	}
+	return nil
+}
+
+// err returns ErrCannotFetchAllLinks if some pinned DAG could not be fully
+// walked.
+func (m *marker) err() error {
+	if m.failed {
+		return ErrCannotFetchAllLinks
+	}
+	return nil
+}
+
+// visitor returns the visit function used to walk the DAGs during the mark
+// phase. With an exact LiveSet, the set itself is used to avoid walking the
+// same subgraph twice. Otherwise a false positive would prune a subgraph
+// that was never marked, so a bounded cache of recently visited CIDs is used
+// instead.
+func visitor(live LiveSet) func(cid.Cid) bool {
+	if live.Exact() {
+		return func(c cid.Cid) bool {
+			if live.Has(c) {
+				return false
+			}
+			live.Add(c)
+			return true
+		}
+	}
+
+	visited, _ := lru.New[string, struct{}](defaultVisitedCacheSize)
+	return func(c cid.Cid) bool {
+		live.Add(c)
+		seen, _ := visited.ContainsOrAdd(string(c.Hash()), struct{}{})
+		return !seen
+	}
+}
@@ -0,0 +1,266 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/blockservice"
+	bstore "github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	bsfetcher "github.com/ipfs/boxo/fetcher/impl/blockservice"
+	mdag "github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-test/random"
+	"github.com/stretchr/testify/require"
+)
+
+type testRepo struct {
+	bs    bstore.GCBlockstore
+	dserv ipld.DAGService
+	bserv blockservice.BlockService
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	bserv := blockservice.New(bs, offline.Exchange(bs))
+	return &testRepo{
+		bs:    bs,
+		dserv: mdag.NewDAGService(bserv),
+		bserv: bserv,
+	}
+}
+
+func (r *testRepo) addNode(t *testing.T, children ...ipld.Node) *mdag.ProtoNode {
+	t.Helper()
+	nd := new(mdag.ProtoNode)
+	nd.SetData(random.Bytes(32))
+	for _, c := range children {
+		require.NoError(t, nd.AddNodeLink(c.Cid().String(), c))
+	}
+	require.NoError(t, r.dserv.Add(context.Background(), nd))
+	return nd
+}
+
+func (r *testRepo) addRaw(t *testing.T) ipld.Node {
+	t.Helper()
+	nd := mdag.NewRawNode(random.Bytes(64))
+	require.NoError(t, r.dserv.Add(context.Background(), nd))
+	return nd
+}
+
+func collect(t *testing.T, out <-chan Result) ([]cid.Cid, []error) {
+	t.Helper()
+	var removed []cid.Cid
+	var errs []error
+	for res := range out {
+		if res.Error != nil {
+			errs = append(errs, res.Error)
+			continue
+		}
+		removed = append(removed, res.KeyRemoved)
+	}
+	return removed, errs
+}
+
+func requireHas(t *testing.T, bs bstore.Blockstore, c cid.Cid, expected bool) {
+	t.Helper()
+	has, err := bs.Has(context.Background(), c)
+	require.NoError(t, err)
+	require.Equal(t, expected, has, "unexpected presence of %s", c)
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	pn, err := dspinner.New(ctx, dssync.MutexWrap(ds.NewMapDatastore()), r.dserv)
+	require.NoError(t, err)
+
+	leafA := r.addRaw(t)
+	leafB := r.addRaw(t)
+	shared := r.addNode(t, leafA)
+	root := r.addNode(t, shared, leafB)
+	require.NoError(t, pn.Pin(ctx, root, true, ""))
+
+	direct := r.addNode(t, r.addRaw(t))
+	require.NoError(t, pn.Pin(ctx, direct, false, ""))
+
+	orphanLeaf := r.addRaw(t)
+	unpinned := r.addNode(t, shared, orphanLeaf)
+	directChild := direct.Links()[0].Cid
+	require.NoError(t, pn.Flush(ctx))
+
+	live := []cid.Cid{root.Cid(), shared.Cid(), leafA.Cid(), leafB.Cid(), direct.Cid()}
+	dead := []cid.Cid{unpinned.Cid(), orphanLeaf.Cid(), directChild}
+
+	t.Run("dry-run", func(t *testing.T) {
+		removed, errs := collect(t, GC(ctx, r.bs, pn, DAGWalker(r.dserv), WithDryRun(true)))
+		require.Empty(t, errs)
+		require.ElementsMatch(t, hashes(dead), hashes(removed))
+		for _, c := range append(live, dead...) {
+			requireHas(t, r.bs, c, true)
+		}
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		removed, errs := collect(t, GC(ctx, r.bs, pn, DAGWalker(r.dserv)))
+		require.Empty(t, errs)
+		require.ElementsMatch(t, hashes(dead), hashes(removed))
+		for _, c := range live {
+			requireHas(t, r.bs, c, true)
+		}
+		for _, c := range dead {
+			requireHas(t, r.bs, c, false)
+		}
+	})
+}
+
+func TestGCBestEffortRoots(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	pn, err := dspinner.New(ctx, dssync.MutexWrap(ds.NewMapDatastore()), r.dserv)
+	require.NoError(t, err)
+
+	missing := mdag.NewRawNode(random.Bytes(64))
+	kept := r.addRaw(t)
+	mfsRoot := r.addNode(t, missing, kept)
+	garbage := r.addRaw(t)
+
+	removed, errs := collect(t, GC(ctx, r.bs, pn, DAGWalker(r.dserv), WithBestEffortRoots(mfsRoot.Cid())))
+	require.Empty(t, errs)
+	require.ElementsMatch(t, hashes([]cid.Cid{garbage.Cid()}), hashes(removed))
+	requireHas(t, r.bs, mfsRoot.Cid(), true)
+	requireHas(t, r.bs, kept.Cid(), true)
+}
+
+func TestGCAbortsOnMissingPinnedBlock(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	pn, err := dspinner.New(ctx, dssync.MutexWrap(ds.NewMapDatastore()), r.dserv)
+	require.NoError(t, err)
+
+	child := r.addNode(t)
+	root := r.addNode(t, child)
+	require.NoError(t, pn.Pin(ctx, root, true, ""))
+	require.NoError(t, r.bs.DeleteBlock(ctx, child.Cid()))
+	garbage := r.addRaw(t)
+
+	removed, errs := collect(t, GC(ctx, r.bs, pn, DAGWalker(r.dserv)))
+	require.Empty(t, removed)
+	require.Len(t, errs, 2)
+	var fetchErr *CannotFetchLinksError
+	require.ErrorAs(t, errs[0], &fetchErr)
+	require.True(t, errors.Is(errs[1], ErrCannotFetchAllLinks))
+	requireHas(t, r.bs, garbage.Cid(), true)
+}
+
+func TestGCWithFetcherAndBloomSet(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	pn, err := dspinner.New(ctx, dssync.MutexWrap(ds.NewMapDatastore()), r.dserv)
+	require.NoError(t, err)
+
+	leaf := r.addRaw(t)
+	root := r.addNode(t, r.addNode(t, leaf))
+	require.NoError(t, pn.Pin(ctx, root, true, ""))
+
+	var garbage []cid.Cid
+	for range 10 {
+		garbage = append(garbage, r.addRaw(t).Cid())
+	}
+
+	set, err := NewBloomSet(1000, 0.0001)
+	require.NoError(t, err)
+	walker := FetcherWalker(bsfetcher.NewFetcherConfig(r.bserv))
+	removed, errs := collect(t, GC(ctx, r.bs, pn, walker, WithLiveSet(set)))
+	require.Empty(t, errs)
+	require.ElementsMatch(t, hashes(garbage), hashes(removed))
+	requireHas(t, r.bs, root.Cid(), true)
+	requireHas(t, r.bs, leaf.Cid(), true)
+}
+
+func hashes(cids []cid.Cid) []string {
+	out := make([]string, len(cids))
+	for i, c := range cids {
+		out[i] = c.Hash().String()
+	}
+	return out
+}
+
+func TestConcurrentGC(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	pn, err := dspinner.New(ctx, dssync.MutexWrap(ds.NewMapDatastore()), r.dserv)
+	require.NoError(t, err)
+
+	leaf := r.addRaw(t)
+	root := r.addNode(t, leaf)
+	require.NoError(t, pn.Pin(ctx, root, true, ""))
+
+	garbage := r.addRaw(t)
+	// linkedLater becomes reachable from a block written during the mark phase.
+	linkedLater := r.addRaw(t)
+	// pinnedLater's DAG is pinned during the mark phase without being read.
+	pinnedLaterChild := r.addRaw(t)
+	pinnedLater := r.addNode(t, pinnedLaterChild)
+
+	var written *mdag.ProtoNode
+	var once sync.Once
+	// hookErr is set on the collector goroutine, and checked once the
+	// collection is over.
+	var hookErr error
+	walk := DAGWalker(r.dserv)
+	hooked := func(wctx context.Context, c cid.Cid, visit func(cid.Cid) bool) error {
+		once.Do(func() {
+			// The pin lock must be available while marking.
+			unlocker := r.bs.PinLock(ctx)
+			defer unlocker.Unlock(ctx)
+
+			written = new(mdag.ProtoNode)
+			if hookErr = written.AddRawLink("later", &ipld.Link{Cid: linkedLater.Cid()}); hookErr != nil {
+				return
+			}
+			if hookErr = r.dserv.Add(ctx, written); hookErr != nil {
+				return
+			}
+			hookErr = pn.PinWithMode(ctx, pinnedLater.Cid(), pin.Recursive, "")
+		})
+		return walk(wctx, c, visit)
+	}
+
+	out := ConcurrentGC(ctx, r.bs.(bstore.TrackingGCBlockstore), pn, hooked)
+	timeout := time.After(10 * time.Second)
+	var removed []cid.Cid
+	var errs []error
+	for done := false; !done; {
+		select {
+		case res, ok := <-out:
+			switch {
+			case !ok:
+				done = true
+			case res.Error != nil:
+				errs = append(errs, res.Error)
+			default:
+				removed = append(removed, res.KeyRemoved)
+			}
+		case <-timeout:
+			t.Fatal("concurrent GC did not finish, pin lock was probably held")
+		}
+	}
+
+	require.NoError(t, hookErr)
+	require.Empty(t, errs)
+	require.ElementsMatch(t, hashes([]cid.Cid{garbage.Cid()}), hashes(removed))
+	for _, c := range []cid.Cid{root.Cid(), leaf.Cid(), written.Cid(), linkedLater.Cid(), pinnedLater.Cid(), pinnedLaterChild.Cid()} {
+		requireHas(t, r.bs, c, true)
+	}
+}
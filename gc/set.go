@@ -0,0 +1,83 @@
+package gc
+
+import (
+	"sync"
+
+	bloom "github.com/ipfs/bbloom"
+	cid "github.com/ipfs/go-cid"
+)
+
+// LiveSet holds the blocks that were found to be reachable during the mark
+// phase. Blocks are identified by their multihash, since this is how
+// blockstores key them: two CIDs with different codecs but the same multihash
+// refer to the same stored block.
+//
+// Implementations must be safe for concurrent use.
+type LiveSet interface {
+	// Add marks the given CID as live.
+	Add(cid.Cid)
+
+	// Has returns true if the CID may have been marked as live. False
+	// positives are acceptable (they only cause garbage to be retained),
+	// false negatives are not.
+	Has(cid.Cid) bool
+
+	// Exact returns true if Has never returns false positives. Only exact
+	// sets can be used to prune the DAG traversal during marking.
+	Exact() bool
+}
+
+type mapSet struct {
+	lk  sync.RWMutex
+	set map[string]struct{}
+}
+
+// NewMapSet returns an exact LiveSet backed by a map. Memory use grows
+// linearly with the number of live blocks.
+func NewMapSet() LiveSet {
+	return &mapSet{set: make(map[string]struct{})}
+}
+
+func (s *mapSet) Add(c cid.Cid) {
+	s.lk.Lock()
+	s.set[string(c.Hash())] = struct{}{}
+	s.lk.Unlock()
+}
+
+func (s *mapSet) Has(c cid.Cid) bool {
+	s.lk.RLock()
+	_, ok := s.set[string(c.Hash())]
+	s.lk.RUnlock()
+	return ok
+}
+
+func (s *mapSet) Exact() bool { return true }
+
+type bloomSet struct {
+	bloom *bloom.Bloom
+}
+
+// NewBloomSet returns a probabilistic LiveSet backed by a bloom filter sized
+// for expectedKeys entries at the given false-positive rate (e.g. 0.001).
+//
+// A false positive keeps an unreachable block around until a later
+// collection, it never causes a live block to be removed. This trades a small
+// amount of retained garbage for a fixed memory footprint, which makes it
+// suitable for repositories holding hundreds of millions of blocks.
+func NewBloomSet(expectedKeys uint64, falsePositiveRate float64) (LiveSet, error) {
+	bl, err := bloom.New(float64(expectedKeys), falsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	return &bloomSet{bloom: bl}, nil
+}
+
+func (s *bloomSet) Add(c cid.Cid) {
+	s.bloom.AddTS(c.Hash())
+}
+
+func (s *bloomSet) Has(c cid.Cid) bool {
+	return s.bloom.HasTS(c.Hash())
+}
+
+func (s *bloomSet) Exact() bool { return false }
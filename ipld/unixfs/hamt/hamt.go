@@ -404,6 +404,65 @@ func (ds *Shard) ForEachLink(ctx context.Context, f func(*ipld.Link) error) erro
 	})
 }
 
+// ForEachLinkAfter calls f for the links of the Shard in a stable order,
+// starting after the link named after, or at the first link when after is
+// empty. Unlike ForEachLink, only the shards leading to after and the ones
+// following it are loaded, which allows listing a large directory page by
+// page. It returns os.ErrNotExist when there is no link named after.
+func (ds *Shard) ForEachLinkAfter(ctx context.Context, after string, f func(*ipld.Link) error) error {
+	cb := func(sv *Shard) error {
+		lnk := *sv.val
+		lnk.Name = sv.key
+		return f(&lnk)
+	}
+	if after == "" {
+		return ds.walkTrie(ctx, cb)
+	}
+	return ds.walkTrieAfter(ctx, newHashBits(after), after, cb)
+}
+
+// walkTrieAfter is like walkTrie but starts after the value with the given
+// key. Children are ordered by the bits of the hash of their keys, so the
+// path to the value is followed and the children before it are skipped.
+func (ds *Shard) walkTrieAfter(ctx context.Context, hv *hashBits, key string, cb func(*Shard) error) error {
+	childIndex, err := hv.Next(ds.tableSizeLg2)
+	if err != nil {
+		return err
+	}
+	if !ds.childer.has(childIndex) {
+		return os.ErrNotExist
+	}
+
+	start := ds.childer.sliceIndex(childIndex)
+	child, err := ds.childer.get(ctx, start)
+	if err != nil {
+		return err
+	}
+	if child.isValueNode() {
+		if child.key != key {
+			return os.ErrNotExist
+		}
+	} else if err := child.walkTrieAfter(ctx, hv, key, cb); err != nil {
+		return err
+	}
+
+	for i := start + 1; i < ds.childer.length(); i++ {
+		c, err := ds.childer.get(ctx, i)
+		if err != nil {
+			return err
+		}
+		if c.isValueNode() {
+			err = cb(c)
+		} else {
+			err = c.walkTrie(ctx, cb)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // EnumLinksAsync returns a channel which will receive Links in the directory
 // as they are enumerated, where order is not guaranteed
 func (ds *Shard) EnumLinksAsync(ctx context.Context) <-chan format.LinkResult {
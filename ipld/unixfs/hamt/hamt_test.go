@@ -777,3 +777,66 @@ func TestHamtNilLinkAndShard(t *testing.T) {
 		t.Fatal("nextShard should be nil")
 	}
 }
+
+func TestForEachLinkAfter(t *testing.T) {
+	ds := mdtest.Mock()
+	_, s, err := makeDir(ds, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(after string, limit int) ([]string, error) {
+		// Reload the shard for every page, like a new request would.
+		nds, err := NewHamtFromDag(ds, nd)
+		if err != nil {
+			return nil, err
+		}
+		errDone := errors.New("done")
+		var names []string
+		err = nds.ForEachLinkAfter(ctx, after, func(l *ipld.Link) error {
+			names = append(names, l.Name)
+			if len(names) == limit {
+				return errDone
+			}
+			return nil
+		})
+		if err != nil && err != errDone {
+			return nil, err
+		}
+		return names, nil
+	}
+
+	all, err := list("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1000 {
+		t.Fatalf("expected 1000 links, got %d", len(all))
+	}
+
+	var paged []string
+	for after := ""; ; {
+		page, err := list(after, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, page...)
+		if len(page) < 7 {
+			break
+		}
+		after = page[len(page)-1]
+	}
+	if !slices.Equal(all, paged) {
+		t.Fatal("pages do not follow each other")
+	}
+
+	if _, err := list("notfound", 1); err != os.ErrNotExist {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+}
@@ -272,6 +272,15 @@ func (ps *PeeringService) ListPeers() []peer.AddrInfo {
 	return out
 }
 
+// HasPeer returns whether a peer is in the peering service.
+func (ps *PeeringService) HasPeer(id peer.ID) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	_, ok := ps.peers[id]
+	return ok
+}
+
 // RemovePeer removes a peer from the peering service. This function may be
 // safely called at any time: before the service is started, while running, or
 // after it stops.
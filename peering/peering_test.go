@@ -40,6 +40,7 @@ func TestPeeringService(t *testing.T) {
 	// peer 1 -> 2
 	ps1.AddPeer(peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
 	require.Contains(t, ps1.ListPeers(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	require.True(t, ps1.HasPeer(h2.ID()))
 
 	// We haven't started so we shouldn't have any peers.
 	require.Never(t, func() bool {
@@ -111,6 +112,7 @@ func TestPeeringService(t *testing.T) {
 	// Unprotect 2 from 1.
 	ps1.RemovePeer(h2.ID())
 	require.NotContains(t, ps1.ListPeers(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	require.False(t, ps1.HasPeer(h2.ID()))
 
 	// Trim connections.
 	h1.ConnManager().TrimOpenConns(ctx)